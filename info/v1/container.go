@@ -836,8 +836,90 @@ type AcceleratorStats struct {
 type PerfStat struct {
 	PerfValue
 
-	// CPU that perf event was measured on.
+	// CPU that perf event was measured on. It is the lowest CPU of the
+	// physical core if perf events are reported per physical core and
+	// PerfStatAllCPUs for total of the event over all CPUs.
 	Cpu int `json:"cpu"`
+
+	// Physical core that perf event was measured on as identified in
+	// machine topology. It is zero if CPU is missing in the topology and
+	// omitted from JSON when zero, which decodes to the same value.
+	Core int `json:"core,omitempty"`
+
+	// Delta indicates that Value is an increase of perf event since
+	// the previous measurement instead of cumulative value.
+	Delta bool `json:"delta,omitempty"`
+
+	// StartTime is the time when counting of perf event started. Cumulative
+	// values are counted since then and not since the container start. It
+	// is nil if the time is not known.
+	StartTime *time.Time `json:"start_time,omitempty"`
+
+	// Frequency of the CPU in kHz at the time of measurement. It is
+	// reported only if enabled in perf events configuration.
+	Frequency uint64 `json:"frequency,omitempty"`
+
+	// Fraction of time since the previous measurement that SMT siblings
+	// of the CPU were busy, running tasks of any container or of the host.
+	// It is reported only if enabled in perf events configuration.
+	SiblingBusy float64 `json:"sibling_busy,omitempty"`
+
+	// SMTContended indicates that SMT siblings of the CPU were busy at
+	// least half of the time since the previous measurement, so Value may
+	// be skewed by tasks sharing the physical core.
+	SMTContended bool `json:"smt_contended,omitempty"`
+
+	// Distribution of increases of perf event between consecutive
+	// measurements. It is reported only if enabled in perf events
+	// configuration.
+	Histogram []PerfHistogramBucket `json:"histogram,omitempty"`
+}
+
+// PerfStatAllCPUs is Cpu and Core of perf stat that sums up values of the
+// event measured on all CPUs.
+const PerfStatAllCPUs = -1
+
+// PerfHistogramBucket counts increases of perf event that are not greater
+// than UpperBound and greater than UpperBound of the previous bucket.
+type PerfHistogramBucket struct {
+	UpperBound uint64 `json:"upper_bound"`
+	Count      uint64 `json:"count"`
+}
+
+// PerfMultiplexing estimates how core perf events of a container are
+// multiplexed on a CPU. It is derived from scaling ratios of the groups
+// and not measured directly.
+type PerfMultiplexing struct {
+	// CPU that perf events were measured on.
+	Cpu int `json:"cpu"`
+
+	// Number of groups of perf events measured on the CPU.
+	Groups int `json:"groups"`
+
+	// Average scaling ratio of the groups, i.e. average fraction of time
+	// that a group was counted for.
+	AverageScalingRatio float64 `json:"average_scaling_ratio"`
+
+	// Estimated number of groups that time of the CPU is sliced between
+	// in round-robin manner: 1 means that all the groups are counted all
+	// the time, 2 that each group is counted half of the time, and so on.
+	// It is 0 if none of the groups has been counted.
+	MultiplexGroups float64 `json:"multiplex_groups"`
+}
+
+// PerfDerivedMetric is a metric computed from values of core perf events
+// measured on the same CPU, e.g. a level 1 category of Top-down
+// Microarchitecture Analysis.
+type PerfDerivedMetric struct {
+	// Name of the metric, e.g. frontend_bound.
+	Name string `json:"name"`
+
+	// CPU that perf events were measured on, or PerfStatAllCPUs for
+	// totals over all CPUs.
+	Cpu int `json:"cpu"`
+
+	// Value of the metric, e.g. fraction of pipeline slots.
+	Value float64 `json:"value"`
 }
 
 type PerfValue struct {
@@ -855,10 +937,67 @@ type PerfValue struct {
 	// consideration.
 	Value uint64 `json:"value"`
 
+	// RawValue is value of the counter as read from OS, before it is
+	// scaled. Unlike Value it is cumulative even if deltas are reported.
+	RawValue uint64 `json:"raw_value,omitempty"`
+
 	// Name is human readable name of an event.
 	Name string `json:"name"`
+
+	// Errored indicates that the event is in error state, e.g. it is pinned
+	// and could not be scheduled, and Value is meaningless.
+	Errored bool `json:"errored,omitempty"`
+
+	// Reopened indicates that the event has been opened again since the
+	// previous measurement, so its value does not continue the previous
+	// one and rates should not be computed across it. It is set when
+	// kernel assigned id of the event has changed and on the first
+	// measurement after cAdvisor reopened events of the container.
+	Reopened bool `json:"reopened,omitempty"`
+
+	// Paused indicates that counting of perf events was paused for the
+	// whole process when the event was read, so its value has not changed
+	// since it was paused and rates should not be computed from it.
+	Paused bool `json:"paused,omitempty"`
+
+	// Confidence summarizes quality of Value based on ScalingRatio. It is
+	// reported only if enabled in perf events configuration.
+	Confidence PerfConfidence `json:"confidence,omitempty"`
+
+	// TimeEnabled is time in nanoseconds that the event was enabled since
+	// counting started. For events of a container it only advances while
+	// tasks of the container run on the CPU.
+	TimeEnabled uint64 `json:"time_enabled,omitempty"`
+
+	// TimeRunning is time in nanoseconds that the event was counted since
+	// counting started, out of TimeEnabled. It is lower than TimeEnabled
+	// when the event is multiplexed and Value is scaled up from the time
+	// it was counted.
+	TimeRunning uint64 `json:"time_running,omitempty"`
+
+	// ConvertedValue is Value converted to a domain specific quantity,
+	// e.g. energy, by conversion registered for the event. It is reported
+	// only for events that have conversion registered.
+	ConvertedValue float64 `json:"converted_value,omitempty"`
+
+	// Unit of ConvertedValue, e.g. "joules".
+	Unit string `json:"unit,omitempty"`
 }
 
+// PerfConfidence is quality of perf event value derived from its scaling
+// ratio.
+type PerfConfidence string
+
+const (
+	// Event has been counted (almost) all the time it was enabled.
+	PerfConfidenceHigh PerfConfidence = "high"
+	// Event has been multiplexed and its value is extrapolated.
+	PerfConfidenceMedium PerfConfidence = "medium"
+	// Event has been counted for small fraction of time, not at all or
+	// it is in error state, so its value is unreliable.
+	PerfConfidenceLow PerfConfidence = "low"
+)
+
 // MemoryBandwidthStats corresponds to MBM (Memory Bandwidth Monitoring).
 // See: https://01.org/cache-monitoring-technology
 // See: https://www.kernel.org/doc/Documentation/x86/intel_rdt_ui.txt
@@ -868,6 +1007,28 @@ type MemoryBandwidthStats struct {
 
 	// The 'mbm_local_bytes'.
 	LocalBytes uint64 `json:"mbm_local_bytes,omitempty"`
+
+	// CPUs of the monitoring domain (L3 cache, usually NUMA node) that
+	// the statistics come from. Empty if not known.
+	CPUs []int `json:"cpus,omitempty"`
+
+	// Values of MBM events other than 'mbm_total_bytes' and
+	// 'mbm_local_bytes' that the platform exposes, by resctrl event name.
+	Events map[string]uint64 `json:"mbm_events,omitempty"`
+
+	// Increase of 'mbm_total_bytes' per second since the previous
+	// measurement. It is reported only if enabled.
+	TotalBytesPerSecond uint64 `json:"mbm_total_bytes_per_second,omitempty"`
+
+	// Increase of 'mbm_local_bytes' per second since the previous
+	// measurement. It is reported only if enabled.
+	LocalBytesPerSecond uint64 `json:"mbm_local_bytes_per_second,omitempty"`
+
+	// Estimate of last level cache misses per second derived from
+	// increase of 'mbm_local_bytes' assuming that each miss fetches one
+	// 64 byte cache line from local memory. It is not measured directly
+	// and it is reported only if enabled.
+	EstimatedCacheLineFetchesPerSecond uint64 `json:"estimated_cache_line_fetches_per_second,omitempty"`
 }
 
 // CacheStats corresponds to CMT (Cache Monitoring Technology).
@@ -876,13 +1037,55 @@ type MemoryBandwidthStats struct {
 type CacheStats struct {
 	// The 'llc_occupancy'.
 	LLCOccupancy uint64 `json:"llc_occupancy,omitempty"`
+
+	// Id of the L3 cache (usually socket) that the statistics come from,
+	// taken from the name of mon_L3_XX directory.
+	CacheID uint64 `json:"cache_id"`
+}
+
+// MemoryBandwidthAllocationStats corresponds to MBA (Memory Bandwidth Allocation)
+// configured for a single domain of the container's control group.
+// See: https://www.kernel.org/doc/Documentation/x86/intel_rdt_ui.txt
+type MemoryBandwidthAllocationStats struct {
+	// Id of the domain (socket) that the allocation applies to.
+	Domain uint64 `json:"domain"`
+
+	// Memory bandwidth available to the container as a percentage of the total
+	// bandwidth. It is set only if the platform uses linear delay values.
+	BandwidthPercentage uint64 `json:"bandwidth_percentage,omitempty"`
+
+	// Throttling delay requested from the hardware. The scale of delay values
+	// is platform specific if they are not linear.
+	Delay uint64 `json:"delay"`
+
+	// The 'delay_linear'.
+	DelayLinear bool `json:"delay_linear"`
 }
 
 // ResctrlStats corresponds to statistics from Resource Control.
 type ResctrlStats struct {
+	// Stable identifier of the container's monitoring, derived from name of
+	// the container. Unlike RMID it stays the same when the monitoring group
+	// is created again, e.g. after resctrl filesystem is remounted.
+	ID string `json:"id,omitempty"`
+	// RMID of the monitoring group, which changes when the group is created
+	// again. Reported only if resctrl filesystem exposes it, i.e. on Linux
+	// 6.6+ with resctrl mounted with debug option.
+	RMID *uint64 `json:"rmid,omitempty"`
+	// Time when the monitoring group was created. Counters of the group
+	// start from zero when it is created again.
+	CreationTime *time.Time `json:"creation_time,omitempty"`
 	// Each NUMA Node statistics corresponds to one element in the array.
 	MemoryBandwidth []MemoryBandwidthStats `json:"memory_bandwidth,omitempty"`
 	Cache           []CacheStats           `json:"cache,omitempty"`
+	// Each domain of the control group corresponds to one element in the array.
+	MemoryBandwidthAllocation []MemoryBandwidthAllocationStats `json:"memory_bandwidth_allocation,omitempty"`
+	// Number of tasks assigned to the container's monitoring group.
+	TaskCount uint64 `json:"task_count,omitempty"`
+	// Statistics of tasks of the default control group that do not belong
+	// to any monitored container. Reported for the root container if enabled.
+	SystemMemoryBandwidth []MemoryBandwidthStats `json:"system_memory_bandwidth,omitempty"`
+	SystemCache           []CacheStats           `json:"system_cache,omitempty"`
 }
 
 // PerfUncoreStat represents value of a single monitored perf uncore event.
@@ -948,6 +1151,35 @@ type ContainerStats struct {
 	// Statistics originating from perf events
 	PerfStats []PerfStat `json:"perf_stats,omitempty"`
 
+	// Indicates that reading perf events took too long and some of them
+	// are missing from PerfStats.
+	PerfStatsTruncated bool `json:"perf_stats_truncated,omitempty"`
+
+	// Estimate of multiplexing of core perf events on each CPU. It is
+	// reported only if enabled in perf events configuration.
+	PerfMultiplexing []PerfMultiplexing `json:"perf_multiplexing,omitempty"`
+
+	// Time elapsed since the previous reading of perf events of the
+	// container, or since counting started if they have not been read
+	// since then.
+	PerfInterval time.Duration `json:"perf_interval,omitempty"`
+
+	// Number of core perf events per second of CPU time that the container
+	// has consumed since counting started, by event name. It allows to
+	// compare containers with different CPU allocations.
+	PerfPerCPUSecond map[string]float64 `json:"perf_per_cpu_second,omitempty"`
+
+	// Percentage of configured core perf events on CPUs that they are
+	// intended to be measured on which have been read without error in the
+	// most recent measurement. It is lower when events are skipped, fail on
+	// some CPUs or are not read in time.
+	PerfCoverage *float64 `json:"perf_coverage,omitempty"`
+
+	// Metrics derived from core perf events, e.g. Top-down
+	// Microarchitecture Analysis categories. They are reported only if
+	// enabled in perf events configuration.
+	PerfDerivedMetrics []PerfDerivedMetric `json:"perf_derived_metrics,omitempty"`
+
 	// Statistics originating from perf uncore events.
 	// Applies only for root container.
 	PerfUncoreStats []PerfUncoreStat `json:"perf_uncore_stats,omitempty"`
@@ -15,6 +15,8 @@
 package v1
 
 import (
+	"encoding/json"
+	"strings"
 	"testing"
 	"time"
 )
@@ -66,3 +68,52 @@ func TestStatsEndTime(t *testing.T) {
 		t.Errorf("start time is %v; should be %v", start, ref)
 	}
 }
+
+func TestPerfStatStartTimeOmitted(t *testing.T) {
+	stat := PerfStat{PerfValue: PerfValue{Name: "instructions", Value: 42}}
+	data, err := json.Marshal(stat)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "start_time") {
+		t.Errorf("unknown start time should be omitted: %s", data)
+	}
+
+	startTime := time.Date(2020, time.October, 1, 12, 0, 0, 0, time.UTC)
+	stat.StartTime = &startTime
+	data, err = json.Marshal(stat)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"start_time":"2020-10-01T12:00:00Z"`) {
+		t.Errorf("start time should be reported: %s", data)
+	}
+}
+
+func TestPerfStatCoreOmitted(t *testing.T) {
+	stat := PerfStat{PerfValue: PerfValue{Name: "instructions", Value: 42}, Cpu: 1}
+	data, err := json.Marshal(stat)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), `"core"`) {
+		t.Errorf("zero core should be omitted: %s", data)
+	}
+
+	stat.Core = 3
+	data, err = json.Marshal(stat)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"core":3`) {
+		t.Errorf("core should be reported: %s", data)
+	}
+	decoded := PerfStat{}
+	err = json.Unmarshal(data, &decoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Core != 3 {
+		t.Errorf("expected core 3, got %d", decoded.Core)
+	}
+}
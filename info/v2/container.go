@@ -139,6 +139,16 @@ type DeprecatedContainerStats struct {
 	CustomMetrics    map[string][]v1.MetricVal `json:"custom_metrics,omitempty"`
 	// Perf events counters
 	PerfStats []v1.PerfStat `json:"perf_stats,omitempty"`
+	// Indicates that some perf events counters are missing
+	PerfStatsTruncated bool `json:"perf_stats_truncated,omitempty"`
+	// Estimate of multiplexing of perf events counters on each CPU
+	PerfMultiplexing []v1.PerfMultiplexing `json:"perf_multiplexing,omitempty"`
+	// Time elapsed since the previous reading of perf events counters
+	PerfInterval time.Duration `json:"perf_interval,omitempty"`
+	// Perf events counters per second of CPU time consumed
+	PerfPerCPUSecond map[string]float64 `json:"perf_per_cpu_second,omitempty"`
+	// Percentage of configured perf events counters actually measured
+	PerfCoverage *float64 `json:"perf_coverage,omitempty"`
 	// Statistics originating from perf uncore events.
 	// Applies only for root container.
 	PerfUncoreStats []v1.PerfUncoreStat `json:"perf_uncore_stats,omitempty"`
@@ -176,6 +186,16 @@ type ContainerStats struct {
 	CustomMetrics map[string][]v1.MetricVal `json:"custom_metrics,omitempty"`
 	// Perf events counters
 	PerfStats []v1.PerfStat `json:"perf_stats,omitempty"`
+	// Indicates that some perf events counters are missing
+	PerfStatsTruncated bool `json:"perf_stats_truncated,omitempty"`
+	// Estimate of multiplexing of perf events counters on each CPU
+	PerfMultiplexing []v1.PerfMultiplexing `json:"perf_multiplexing,omitempty"`
+	// Time elapsed since the previous reading of perf events counters
+	PerfInterval time.Duration `json:"perf_interval,omitempty"`
+	// Perf events counters per second of CPU time consumed
+	PerfPerCPUSecond map[string]float64 `json:"perf_per_cpu_second,omitempty"`
+	// Percentage of configured perf events counters actually measured
+	PerfCoverage *float64 `json:"perf_coverage,omitempty"`
 	// Statistics originating from perf uncore events.
 	// Applies only for root container.
 	PerfUncoreStats []v1.PerfUncoreStat `json:"perf_uncore_stats,omitempty"`
@@ -154,6 +154,13 @@ func ContainerStatsFromV1(containerName string, spec *v1.ContainerSpec, stats []
 		}
 		if len(val.PerfStats) > 0 {
 			stat.PerfStats = val.PerfStats
+			stat.PerfStatsTruncated = val.PerfStatsTruncated
+			stat.PerfMultiplexing = val.PerfMultiplexing
+			stat.PerfInterval = val.PerfInterval
+			stat.PerfPerCPUSecond = val.PerfPerCPUSecond
+		}
+		if val.PerfCoverage != nil {
+			stat.PerfCoverage = val.PerfCoverage
 		}
 		if len(val.PerfUncoreStats) > 0 {
 			stat.PerfUncoreStats = val.PerfUncoreStats
@@ -215,6 +222,13 @@ func DeprecatedStatsFromV1(cont *v1.ContainerInfo) []DeprecatedContainerStats {
 		}
 		if len(val.PerfStats) > 0 {
 			stat.PerfStats = val.PerfStats
+			stat.PerfStatsTruncated = val.PerfStatsTruncated
+			stat.PerfMultiplexing = val.PerfMultiplexing
+			stat.PerfInterval = val.PerfInterval
+			stat.PerfPerCPUSecond = val.PerfPerCPUSecond
+		}
+		if val.PerfCoverage != nil {
+			stat.PerfCoverage = val.PerfCoverage
 		}
 		if len(val.PerfUncoreStats) > 0 {
 			stat.PerfUncoreStats = val.PerfUncoreStats
@@ -48,7 +48,6 @@ import (
 
 	"github.com/opencontainers/runc/libcontainer/cgroups"
 	"github.com/opencontainers/runc/libcontainer/cgroups/fs2"
-	"github.com/opencontainers/runc/libcontainer/intelrdt"
 
 	"k8s.io/klog/v2"
 	"k8s.io/utils/clock"
@@ -217,7 +216,7 @@ func New(memoryCache *memory.InMemoryCache, sysfs sysfs.SysFs, houskeepingConfig
 		return nil, err
 	}
 
-	newManager.resctrlManager, err = resctrl.NewManager(selfContainer)
+	newManager.resctrlManager, err = resctrl.NewManager()
 	if err != nil {
 		klog.V(4).Infof("Cannot gather resctrl metrics: %v", err)
 	}
@@ -262,7 +261,7 @@ type manager struct {
 	collectorHTTPClient      *http.Client
 	nvidiaManager            stats.Manager
 	perfManager              stats.Manager
-	resctrlManager           stats.Manager
+	resctrlManager           resctrl.Manager
 	// List of raw container cgroup path prefix whitelist.
 	rawContainerCgroupPathPrefixWhiteList []string
 }
@@ -327,7 +326,8 @@ func (m *manager) Start() error {
 
 func (m *manager) Stop() error {
 	defer m.nvidiaManager.Destroy()
-	defer m.destroyPerfCollectors()
+	defer m.resctrlManager.Destroy()
+	defer m.destroyCollectors()
 	// Stop and wait on all quit channels.
 	for i, c := range m.quitChannels {
 		// Send the exit signal and wait on the thread to exit (by closing the channel).
@@ -345,9 +345,10 @@ func (m *manager) Stop() error {
 	return nil
 }
 
-func (m *manager) destroyPerfCollectors() {
+func (m *manager) destroyCollectors() {
 	for _, container := range m.containers {
 		container.perfCollector.Destroy()
+		container.resctrlCollector.Destroy()
 	}
 }
 
@@ -956,11 +957,19 @@ func (m *manager) createContainerLocked(containerName string, watchSource watche
 	}
 
 	if m.includedMetrics.Has(container.ResctrlMetrics) {
-		resctrlPath, err := intelrdt.GetIntelRdtPath(containerName)
+		// Errors of collectors set up above must not prevent resctrl
+		// collector from being set up.
+		var cgroupPath string
+		var err error
+		if cgroups.IsCgroup2UnifiedMode() {
+			cgroupPath = path.Join(fs2.UnifiedMountpoint, containerName)
+		} else {
+			cgroupPath, err = handler.GetCgroupPath("cpu")
+		}
 		if err != nil {
-			klog.V(4).Infof("Error getting resctrl path: %q", err)
+			klog.V(4).Infof("Error getting cpu cgroup path: %q", err)
 		} else {
-			cont.resctrlCollector, err = m.resctrlManager.GetCollector(resctrlPath)
+			cont.resctrlCollector, err = m.resctrlManager.GetCollector(containerName, cgroupPath, handler.GetContainerLabels())
 			if err != nil {
 				klog.V(4).Infof("resctrl metrics will not be available for container %s: %s", cont.info.Name, err)
 			}
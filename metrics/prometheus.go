@@ -1951,6 +1951,14 @@ func getNumaStatsPerNode(nodeStats map[uint8]uint64, labels []string, timestamp
 func getPerCPUCorePerfEvents(s *info.ContainerStats) metricValues {
 	values := make(metricValues, 0, len(s.PerfStats))
 	for _, metric := range s.PerfStats {
+		if metric.Cpu == info.PerfStatAllCPUs {
+			// Totals are exported by aggregated metrics.
+			continue
+		}
+		if metric.Delta {
+			// Counter must be cumulative.
+			continue
+		}
 		values = append(values, metricValue{
 			value:     float64(metric.Value),
 			labels:    []string{strconv.Itoa(metric.Cpu), metric.Name},
@@ -1963,6 +1971,10 @@ func getPerCPUCorePerfEvents(s *info.ContainerStats) metricValues {
 func getPerCPUCoreScalingRatio(s *info.ContainerStats) metricValues {
 	values := make(metricValues, 0, len(s.PerfStats))
 	for _, metric := range s.PerfStats {
+		if metric.Cpu == info.PerfStatAllCPUs {
+			// Totals are exported by aggregated metrics.
+			continue
+		}
 		values = append(values, metricValue{
 			value:     metric.ScalingRatio,
 			labels:    []string{strconv.Itoa(metric.Cpu), metric.Name},
@@ -1978,6 +1990,9 @@ func getAggregatedCorePerfEvents(s *info.ContainerStats) metricValues {
 	perfEventStatAgg := make(map[string]uint64)
 	// aggregate by event
 	for _, perfStat := range s.PerfStats {
+		if perfStat.Cpu == info.PerfStatAllCPUs || perfStat.Delta {
+			continue
+		}
 		perfEventStatAgg[perfStat.Name] += perfStat.Value
 	}
 	// create aggregated metrics
@@ -1996,6 +2011,9 @@ func getMinCoreScalingRatio(s *info.ContainerStats) metricValues {
 	perfEventStatMin := make(map[string]float64)
 	// search for minimal value of scalin ratio for specific event
 	for _, perfStat := range s.PerfStats {
+		if perfStat.Cpu == info.PerfStatAllCPUs {
+			continue
+		}
 		if _, ok := perfEventStatMin[perfStat.Name]; !ok {
 			// found a new event
 			perfEventStatMin[perfStat.Name] = perfStat.ScalingRatio
@@ -186,6 +186,36 @@ func TestGetPerCpuCorePerfEvents(t *testing.T) {
 	assert.Contains(t, values, 789.0)
 }
 
+func TestGetPerCpuCorePerfEventsDelta(t *testing.T) {
+	containerStats := &info.ContainerStats{
+		Timestamp: time.Unix(1395066367, 0),
+		PerfStats: []info.PerfStat{
+			{
+				PerfValue: info.PerfValue{
+					ScalingRatio: 1.0,
+					Value:        123,
+					Name:         "instructions"},
+				Cpu:   0,
+				Delta: true,
+			},
+			{
+				PerfValue: info.PerfValue{
+					ScalingRatio: 1.0,
+					Value:        456,
+					Name:         "cycles"},
+				Cpu: 0,
+			},
+		},
+	}
+	metricVals := getPerCPUCorePerfEvents(containerStats)
+	assert.Equal(t, 1, len(metricVals))
+	assert.Equal(t, 456.0, metricVals[0].value)
+
+	metricVals = getAggregatedCorePerfEvents(containerStats)
+	assert.Equal(t, 1, len(metricVals))
+	assert.Equal(t, 456.0, metricVals[0].value)
+}
+
 func TestGetPerCpuCoreScalingRatio(t *testing.T) {
 	containerStats := &info.ContainerStats{
 		Timestamp: time.Unix(1395066367, 0),
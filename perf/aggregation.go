@@ -0,0 +1,93 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Aggregation of perf events measured in different groups.
+package perf
+
+import (
+	info "github.com/google/cadvisor/info/v1"
+)
+
+type aggregationKey struct {
+	name string
+	cpu  int
+}
+
+// aggregate sums values of events that belong to the same aggregation on
+// every CPU and reports them as a single event. Scaling ratio and running
+// time of the aggregate are the lowest ratio and time of its events. Events
+// in error state are not taken into account.
+func aggregate(perfStats []info.PerfStat, aggregations []Aggregation) []info.PerfStat {
+	if len(aggregations) == 0 {
+		return perfStats
+	}
+
+	eventToAggregation := map[string]int{}
+	for i, aggregation := range aggregations {
+		for _, event := range aggregation.Events {
+			eventToAggregation[string(event)] = i
+		}
+	}
+
+	result := make([]info.PerfStat, 0, len(perfStats))
+	aggregated := map[aggregationKey]int{}
+	for _, stat := range perfStats {
+		index, ok := eventToAggregation[stat.Name]
+		if !ok {
+			result = append(result, stat)
+			continue
+		}
+		aggregation := aggregations[index]
+		if aggregation.KeepEvents {
+			result = append(result, stat)
+		}
+
+		key := aggregationKey{name: string(aggregation.Name), cpu: stat.Cpu}
+		position, ok := aggregated[key]
+		if !ok {
+			combined := stat
+			combined.Name = string(aggregation.Name)
+			if stat.Errored {
+				combined.Value = 0
+				combined.RawValue = 0
+				combined.ScalingRatio = 0
+				combined.TimeEnabled = 0
+				combined.TimeRunning = 0
+			}
+			aggregated[key] = len(result)
+			result = append(result, combined)
+			continue
+		}
+
+		if stat.Errored {
+			continue
+		}
+		combined := &result[position]
+		if combined.Errored {
+			combined.Errored = false
+			combined.ScalingRatio = stat.ScalingRatio
+			combined.TimeEnabled = stat.TimeEnabled
+			combined.TimeRunning = stat.TimeRunning
+		} else if stat.ScalingRatio < combined.ScalingRatio {
+			combined.ScalingRatio = stat.ScalingRatio
+		}
+		if stat.TimeRunning < combined.TimeRunning {
+			combined.TimeEnabled = stat.TimeEnabled
+			combined.TimeRunning = stat.TimeRunning
+		}
+		combined.Value += stat.Value
+		combined.RawValue += stat.RawValue
+	}
+	return result
+}
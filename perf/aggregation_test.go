@@ -0,0 +1,89 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Aggregation of perf events measured in different groups.
+package perf
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	info "github.com/google/cadvisor/info/v1"
+)
+
+func perfStat(name string, cpu int, value uint64, scalingRatio float64) info.PerfStat {
+	return info.PerfStat{
+		PerfValue: info.PerfValue{ScalingRatio: scalingRatio, Value: value, Name: name},
+		Cpu:       cpu,
+	}
+}
+
+func TestAggregate(t *testing.T) {
+	// llc_misses is split across two groups.
+	perfStats := []info.PerfStat{
+		perfStat("instructions", 0, 1000, 1),
+		perfStat("llc_misses_demand", 0, 10, 0.5),
+		perfStat("instructions", 1, 2000, 1),
+		perfStat("llc_misses_demand", 1, 20, 0.5),
+		perfStat("cycles", 0, 3000, 0.75),
+		perfStat("llc_misses_prefetch", 0, 5, 0.75),
+		perfStat("cycles", 1, 4000, 0.25),
+		perfStat("llc_misses_prefetch", 1, 7, 0.25),
+	}
+	aggregation := Aggregation{Name: "llc_misses", Events: []Event{"llc_misses_demand", "llc_misses_prefetch"}}
+
+	assert.Equal(t, []info.PerfStat{
+		perfStat("instructions", 0, 1000, 1),
+		perfStat("llc_misses", 0, 15, 0.5),
+		perfStat("instructions", 1, 2000, 1),
+		perfStat("llc_misses", 1, 27, 0.25),
+		perfStat("cycles", 0, 3000, 0.75),
+		perfStat("cycles", 1, 4000, 0.25),
+	}, aggregate(perfStats, []Aggregation{aggregation}))
+
+	aggregation.KeepEvents = true
+	assert.Equal(t, []info.PerfStat{
+		perfStat("instructions", 0, 1000, 1),
+		perfStat("llc_misses_demand", 0, 10, 0.5),
+		perfStat("llc_misses", 0, 15, 0.5),
+		perfStat("instructions", 1, 2000, 1),
+		perfStat("llc_misses_demand", 1, 20, 0.5),
+		perfStat("llc_misses", 1, 27, 0.25),
+		perfStat("cycles", 0, 3000, 0.75),
+		perfStat("llc_misses_prefetch", 0, 5, 0.75),
+		perfStat("cycles", 1, 4000, 0.25),
+		perfStat("llc_misses_prefetch", 1, 7, 0.25),
+	}, aggregate(perfStats, []Aggregation{aggregation}))
+}
+
+func TestAggregateErrored(t *testing.T) {
+	errored := perfStat("llc_misses_demand", 0, 0, 1)
+	errored.Errored = true
+	aggregation := Aggregation{Name: "llc_misses", Events: []Event{"llc_misses_demand", "llc_misses_prefetch"}}
+
+	assert.Equal(t, []info.PerfStat{
+		perfStat("llc_misses", 0, 5, 0.75),
+	}, aggregate([]info.PerfStat{errored, perfStat("llc_misses_prefetch", 0, 5, 0.75)}, []Aggregation{aggregation}))
+
+	allErrored := errored
+	allErrored.Name = "llc_misses"
+	allErrored.ScalingRatio = 0
+	assert.Equal(t, []info.PerfStat{allErrored}, aggregate([]info.PerfStat{errored}, []Aggregation{aggregation}))
+}
+
+func TestAggregateWithoutAggregations(t *testing.T) {
+	perfStats := []info.PerfStat{perfStat("instructions", 0, 1000, 1)}
+	assert.Equal(t, perfStats, aggregate(perfStats, nil))
+}
@@ -0,0 +1,125 @@
+// +build libpfm,cgo
+
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Buffers that values of perf events are read into.
+package perf
+
+import (
+	"sync"
+
+	info "github.com/google/cadvisor/info/v1"
+)
+
+const (
+	// Size of GroupReadFormat struct.
+	groupReadFormatSize = 24
+	// Size of Values struct of each event in a group.
+	valuesSize = 16
+	// Size of ReadFormat struct.
+	readFormatSize = 32
+)
+
+// readBuffers are buffers reused by reads of perf events of a collector.
+// Their size is computed when events are opened, so that values of the
+// largest group fit in, and reads do not allocate. Values are decoded into
+// slices that fit events of the largest group, reused in the same way.
+// Buffers are pooled, because groups are read concurrently on many CPUs,
+// when reads are pinned to sockets or abandoned after read timeout.
+type readBuffers struct {
+	size   int
+	events int
+	pool   sync.Pool
+	values sync.Pool
+}
+
+// groupReadSize returns number of bytes read from leader of a group with
+// the number of events. See https://man7.org/linux/man-pages/man2/perf_event_open.2.html
+// section "Reading results".
+func groupReadSize(events int) int {
+	return groupReadFormatSize + valuesSize*events
+}
+
+// newReadBuffers returns buffers that fit values of the largest of the
+// groups.
+func newReadBuffers(groups []Group) *readBuffers {
+	size, events := readFormatSize, 1
+	for _, group := range groups {
+		if groupSize := groupReadSize(len(group.events)); groupSize > size {
+			size = groupSize
+		}
+		if len(group.events) > events {
+			events = len(group.events)
+		}
+	}
+	buffers := &readBuffers{size: size, events: events}
+	buffers.pool.New = func() interface{} {
+		buf := make([]byte, size)
+		return &buf
+	}
+	buffers.values.New = func() interface{} {
+		values := make([]info.PerfValue, events)
+		return &values
+	}
+	return buffers
+}
+
+// get returns zeroed buffer of the size, which has to be released with put.
+// A new buffer is allocated if buffers are not set up or too small.
+func (r *readBuffers) get(size int) *[]byte {
+	if r == nil || size > r.size {
+		buf := make([]byte, size)
+		return &buf
+	}
+	buf := r.pool.Get().(*[]byte)
+	*buf = (*buf)[:size]
+	for i := range *buf {
+		(*buf)[i] = 0
+	}
+	return buf
+}
+
+// put returns buffer to the pool, unless it has not been taken from it.
+func (r *readBuffers) put(buf *[]byte) {
+	if r == nil || cap(*buf) != r.size {
+		return
+	}
+	r.pool.Put(buf)
+}
+
+// getValues returns zeroed slice of values of the events, which has to be
+// released with putValues. A new slice is allocated if buffers are not set
+// up or too small.
+func (r *readBuffers) getValues(events int) *[]info.PerfValue {
+	if r == nil || events > r.events {
+		values := make([]info.PerfValue, events)
+		return &values
+	}
+	values := r.values.Get().(*[]info.PerfValue)
+	*values = (*values)[:events]
+	for i := range *values {
+		(*values)[i] = info.PerfValue{}
+	}
+	return values
+}
+
+// putValues returns slice of values to the pool, unless it has not been
+// taken from it.
+func (r *readBuffers) putValues(values *[]info.PerfValue) {
+	if r == nil || cap(*values) != r.events {
+		return
+	}
+	r.values.Put(values)
+}
@@ -0,0 +1,30 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Capabilities required to open perf events.
+package perf
+
+// Capabilities describes capabilities of cAdvisor process that allow to
+// open perf events. CAP_PERFMON is sufficient on Linux 5.8+, older kernels
+// require CAP_SYS_ADMIN.
+type Capabilities struct {
+	Perfmon  bool
+	SysAdmin bool
+}
+
+// Sufficient returns true if perf events can be opened regardless of
+// perf_event_paranoid setting.
+func (c Capabilities) Sufficient() bool {
+	return c.Perfmon || c.SysAdmin
+}
@@ -0,0 +1,66 @@
+// +build libpfm,cgo
+
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Detection of capabilities required to open perf events.
+package perf
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+const (
+	// See include/uapi/linux/capability.h.
+	capSysAdmin = 21
+	capPerfmon  = 38
+
+	capEffField = "CapEff:"
+)
+
+// Handle for mocking purposes.
+var getCapabilities = readCapabilities
+
+func readCapabilities() (Capabilities, error) {
+	status, err := ioutil.ReadFile("/proc/self/status")
+	if err != nil {
+		return Capabilities{}, fmt.Errorf("unable to read process status: %w", err)
+	}
+	return parseCapabilities(string(status))
+}
+
+// parseCapabilities parses effective capabilities from content of
+// /proc/<pid>/status.
+func parseCapabilities(status string) (Capabilities, error) {
+	scanner := bufio.NewScanner(strings.NewReader(status))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, capEffField) {
+			continue
+		}
+		capEff, err := strconv.ParseUint(strings.TrimSpace(strings.TrimPrefix(line, capEffField)), 16, 64)
+		if err != nil {
+			return Capabilities{}, fmt.Errorf("unable to parse effective capabilities %q: %w", line, err)
+		}
+		return Capabilities{
+			Perfmon:  capEff&(1<<capPerfmon) != 0,
+			SysAdmin: capEff&(1<<capSysAdmin) != 0,
+		}, nil
+	}
+	return Capabilities{}, fmt.Errorf("effective capabilities not found in process status")
+}
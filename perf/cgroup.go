@@ -0,0 +1,33 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Resolving cgroup paths as seen by the host.
+package perf
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// resolveHostCgroupPath translates cgroup path seen by cAdvisor, which is
+// under cgroup mountpoint, to the path of the same cgroup in host cgroup
+// hierarchy mounted at hostCgroupPath.
+func resolveHostCgroupPath(cgroupPath string, mountpoint string, hostCgroupPath string) (string, error) {
+	relative, err := filepath.Rel(mountpoint, cgroupPath)
+	if err != nil || relative == ".." || strings.HasPrefix(relative, "../") {
+		return "", fmt.Errorf("cgroup %q is not under cgroup mountpoint %q", cgroupPath, mountpoint)
+	}
+	return filepath.Join(hostCgroupPath, relative), nil
+}
@@ -0,0 +1,35 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Resolving cgroup paths as seen by the host.
+package perf
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResolveHostCgroupPath(t *testing.T) {
+	path, err := resolveHostCgroupPath("/sys/fs/cgroup/perf_event/docker/abc", "/sys/fs/cgroup/perf_event", "/rootfs/sys/fs/cgroup/perf_event")
+	assert.NoError(t, err)
+	assert.Equal(t, "/rootfs/sys/fs/cgroup/perf_event/docker/abc", path)
+
+	path, err = resolveHostCgroupPath("/sys/fs/cgroup/perf_event", "/sys/fs/cgroup/perf_event", "/proc/1/root/sys/fs/cgroup/perf_event")
+	assert.NoError(t, err)
+	assert.Equal(t, "/proc/1/root/sys/fs/cgroup/perf_event", path)
+
+	_, err = resolveHostCgroupPath("/sys/fs/cgroup/cpu/docker/abc", "/sys/fs/cgroup/perf_event", "/rootfs/sys/fs/cgroup/perf_event")
+	assert.Error(t, err)
+}
@@ -0,0 +1,84 @@
+// +build libpfm,cgo
+
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Coalescing of reads of core perf events made by different consumers.
+package perf
+
+import (
+	"time"
+
+	info "github.com/google/cadvisor/info/v1"
+)
+
+// readConsumer identifies consumer of values of core perf events.
+type readConsumer uint8
+
+const (
+	updateConsumer readConsumer = 1 << iota
+	snapshotConsumer
+)
+
+// coalescedRead holds values of a group read on every CPU, which are reused
+// by reads of the group made by other consumers within read coalescing
+// window.
+type coalescedRead struct {
+	perfStats []info.PerfStat
+	// Time when reading of the group started.
+	time time.Time
+	// Generation of the files that the group was read from.
+	generation uint64
+	// Consumers that have got the values, each of them gets them once.
+	consumers readConsumer
+}
+
+// coalescedRead returns copy of values of the group read within read
+// coalescing window that consumer has not got yet, if there is such a read.
+func (c *collector) coalescedRead(groupIndex int, consumer readConsumer) ([]info.PerfStat, bool) {
+	if c.events.ReadCoalescing <= 0 {
+		return nil, false
+	}
+	read, ok := c.coalescedReads[groupIndex]
+	if !ok || read.consumers&consumer != 0 || read.generation != c.generation || now().Sub(read.time) >= time.Duration(c.events.ReadCoalescing) {
+		return nil, false
+	}
+	read.consumers |= consumer
+	c.coalescedReads[groupIndex] = read
+	return copyPerfStats(read.perfStats), true
+}
+
+// coalesceRead stores copy of values of the group read by consumer at
+// readTime, so that reads of the group by other consumers within read
+// coalescing window reuse them.
+func (c *collector) coalesceRead(groupIndex int, perfStats []info.PerfStat, readTime time.Time, consumer readConsumer) {
+	if c.events.ReadCoalescing <= 0 {
+		return
+	}
+	if c.coalescedReads == nil {
+		c.coalescedReads = map[int]coalescedRead{}
+	}
+	c.coalescedReads[groupIndex] = coalescedRead{
+		perfStats:  copyPerfStats(perfStats),
+		time:       readTime,
+		generation: c.generation,
+		consumers:  consumer,
+	}
+}
+
+func copyPerfStats(perfStats []info.PerfStat) []info.PerfStat {
+	copied := make([]info.PerfStat, len(perfStats))
+	copy(copied, perfStats)
+	return copied
+}
@@ -0,0 +1,167 @@
+// +build libpfm,cgo
+
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Coalescing of reads of core perf events made by different consumers.
+package perf
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/sys/unix"
+
+	info "github.com/google/cadvisor/info/v1"
+	"github.com/google/cadvisor/stats"
+)
+
+func newCoalescingCollector(counter readerCloser, readCoalescing time.Duration) *collector {
+	return &collector{
+		uncore: &stats.NoopCollector{},
+		events: PerfEvents{Delta: true, ReadCoalescing: Duration(readCoalescing)},
+		differ: newDiffer(),
+		cpuFiles: map[int]group{
+			0: {
+				cpuFiles:   map[string]map[int]readerCloser{"instructions": {0: counter}},
+				names:      []string{"instructions"},
+				leaderName: "instructions",
+			},
+		},
+		ioctlSetInt: func(fd int, req uint, value int) error {
+			return nil
+		},
+	}
+}
+
+func TestCollector_ReadCoalescing(t *testing.T) {
+	originalNow := now
+	defer func() {
+		now = originalNow
+	}()
+	clock := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	now = func() time.Time {
+		return clock
+	}
+
+	reads := 0
+	counter := &fakeCounter{value: 100, time: 1}
+	collector := newCoalescingCollector(countingReader{readerCloser: counter, reads: &reads}, time.Second)
+
+	assert.NoError(t, collector.UpdateStats(&info.ContainerStats{}))
+	assert.Equal(t, 1, reads)
+
+	// Snapshot reuses values read by UpdateStats, which are cumulative
+	// although UpdateStats reports increases.
+	counter.value = 150
+	clock = clock.Add(500 * time.Millisecond)
+	perfStats := collector.snapshot()
+	assert.Equal(t, 1, reads)
+	assert.Equal(t, uint64(100), perfStats[0].Value)
+
+	// Consumer does not reuse values it has already got.
+	perfStats = collector.snapshot()
+	assert.Equal(t, 2, reads)
+	assert.Equal(t, uint64(150), perfStats[0].Value)
+
+	// UpdateStats reuses values read by Snapshot.
+	counter.value = 160
+	stats := &info.ContainerStats{}
+	assert.NoError(t, collector.UpdateStats(stats))
+	assert.Equal(t, 2, reads)
+	assert.Equal(t, uint64(50), stats.PerfStats[0].Value)
+
+	// Values are not reused after read coalescing window.
+	assert.NoError(t, collector.UpdateStats(&info.ContainerStats{}))
+	assert.Equal(t, 3, reads)
+	clock = clock.Add(time.Second)
+	perfStats = collector.snapshot()
+	assert.Equal(t, 4, reads)
+	assert.Equal(t, uint64(160), perfStats[0].Value)
+}
+
+func TestCollector_ReadCoalescingDisabled(t *testing.T) {
+	reads := 0
+	collector := newCoalescingCollector(countingReader{readerCloser: &fakeCounter{value: 100, time: 1}, reads: &reads}, 0)
+
+	assert.NoError(t, collector.UpdateStats(&info.ContainerStats{}))
+	collector.snapshot()
+	assert.Equal(t, 2, reads)
+	assert.Nil(t, collector.coalescedReads)
+}
+
+func TestCollector_ReadCoalescingAfterIoctl(t *testing.T) {
+	counter := &fakeCounter{value: 100, time: 1}
+	collector := newCoalescingCollector(counter, time.Minute)
+
+	assert.NoError(t, collector.UpdateStats(&info.ContainerStats{}))
+	assert.Len(t, collector.coalescedReads, 1)
+
+	// Values read before counters are reset are not reused.
+	assert.NoError(t, collector.ioctlLeaders(unix.PERF_EVENT_IOC_RESET))
+	counter.value = 0
+	assert.Equal(t, uint64(0), collector.snapshot()[0].Value)
+}
+
+func TestCollector_ReadCoalescingAfterReopening(t *testing.T) {
+	counter := &fakeCounter{value: 100, time: 1}
+	collector := newCoalescingCollector(counter, time.Minute)
+
+	assert.NoError(t, collector.UpdateStats(&info.ContainerStats{}))
+	// Values read from the former events are not reused.
+	collector.generation++
+	counter.value = 10
+	assert.Equal(t, uint64(10), collector.snapshot()[0].Value)
+}
+
+// BenchmarkCollector_UpdateStatsAndSnapshot measures reading of independent
+// groups by both UpdateStats and Snapshot in every measurement. With read
+// coalescing each group is read once per CPU instead of twice.
+func BenchmarkCollector_UpdateStatsAndSnapshot(b *testing.B) {
+	for _, readCoalescing := range []time.Duration{0, time.Minute} {
+		b.Run(fmt.Sprintf("read_coalescing=%v", readCoalescing), func(b *testing.B) {
+			const groups, cpus = 4, 8
+			reads := 0
+			collector := collector{
+				uncore:   &stats.NoopCollector{},
+				events:   PerfEvents{ReadCoalescing: Duration(readCoalescing)},
+				cpuFiles: map[int]group{},
+			}
+			for i := 0; i < groups; i++ {
+				name := fmt.Sprintf("event%d", i)
+				files := map[int]readerCloser{}
+				for cpu := 0; cpu < cpus; cpu++ {
+					files[cpu] = countingReader{readerCloser: &fakeCounter{value: 42, time: 1}, reads: &reads}
+				}
+				collector.cpuFiles[i] = group{
+					cpuFiles:   map[string]map[int]readerCloser{name: files},
+					names:      []string{name},
+					leaderName: name,
+				}
+			}
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				err := collector.UpdateStats(&info.ContainerStats{})
+				if err != nil {
+					b.Fatal(err)
+				}
+				collector.snapshot()
+			}
+			b.ReportMetric(float64(reads)/float64(b.N), "reads/op")
+		})
+	}
+}
@@ -0,0 +1,65 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Collector of perf events for a container.
+package perf
+
+import (
+	info "github.com/google/cadvisor/info/v1"
+	"github.com/google/cadvisor/stats"
+)
+
+// Collector of core perf events. Collectors returned by perf manager
+// implement it when cAdvisor is built with libpfm support, so programs that
+// embed cAdvisor can reach it with type assertion of stats.Collector.
+type Collector interface {
+	stats.Collector
+
+	// TriggerStart resets the groups that are counting and starts
+	// measurement window which is finished by calling TriggerStop.
+	TriggerStart() error
+
+	// TriggerStop returns values accumulated since TriggerStart was called.
+	TriggerStop() ([]info.PerfStat, error)
+
+	// EventID returns id that kernel assigned to the event of the group on
+	// the CPU as seen in the most recent read.
+	EventID(groupIndex int, name string, cpu int) (uint64, bool)
+
+	// EffectiveEvents returns description of perf events measured by the
+	// collector.
+	EffectiveEvents() EffectiveEvents
+
+	// OpenCalls returns number of perf_event_open syscalls that the
+	// collector has made to set up core perf events.
+	OpenCalls() uint64
+
+	// ActiveCPUs returns CPUs that core perf events of the collector are
+	// opened on.
+	ActiveCPUs() []int
+
+	// DroppedCPUs returns CPUs that groups of core perf events have not
+	// been set up on, by group index, because leader of the group failed to
+	// open there.
+	DroppedCPUs() map[int][]int
+
+	// SkippedEvents returns events of groups of core perf events that have
+	// not been set up, by group index, because libpfm4 cannot encode them on
+	// the host.
+	SkippedEvents() map[int][]string
+
+	// DebugSnapshot returns copy of state of the collector that can be
+	// attached to bug reports.
+	DebugSnapshot() DebugSnapshot
+}
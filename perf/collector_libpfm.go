@@ -27,10 +27,17 @@ import (
 	"bytes"
 	"encoding/binary"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"os"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
 	"unsafe"
 
+	"github.com/opencontainers/runc/libcontainer/cgroups"
 	"golang.org/x/sys/unix"
 	"k8s.io/klog/v2"
 
@@ -38,213 +45,1729 @@ import (
 	"github.com/google/cadvisor/stats"
 )
 
+var _ Collector = &collector{}
+
+// collector reads core perf events of a cgroup.
+//
+// All the state of core events is guarded by cpuFilesLock. UpdateStats
+// reads copies of files of the groups without holding it, under
+// countersLock instead, which is held exclusively by ioctls that reset,
+// enable or disable the groups, so values of a group are never read in the
+// middle of such change. Values read from groups that have been reopened or
+// closed meanwhile are discarded. Group is read on each CPU with a single
+// read of its leader, which kernel serves atomically for all the members.
+// The only read made without either lock is the one abandoned after read
+// timeout of the group was exceeded; its result is discarded. Uncore events
+// are guarded by their own collector.
 type collector struct {
 	cgroupPath         string
 	events             PerfEvents
 	cpuFiles           map[int]group
 	cpuFilesLock       sync.Mutex
+	countersLock       sync.RWMutex
 	onlineCPUs         []int
+	cpuToSocket        map[int]int
+	cpuToCore          map[int]physicalCore
 	eventToCustomEvent map[Event]*CustomEvent
 	uncore             stats.Collector
+	differ             *differ
+	histogram          *histogram
+	sink               Sink
+	thresholds         *thresholdTracker
+	thresholdCallback  ThresholdCallback
+	// CPUs that core perf events are opened on.
+	cpus []int
+	// Time when counting of core events started.
+	startTime time.Time
+	// Removes the collector from manager when it is destroyed.
+	unregister func()
+	// Records result of reopening core events in manager, which returns
+	// false once it gives up on the cgroup.
+	recordSetup func(err error) bool
+	// The most recent reopening of core events has failed.
+	reopenFailing bool
+	// Manager has given up on core events of the cgroup after repeated
+	// failures, they are closed and not reopened anymore.
+	unavailable bool
+	// Reads of groups abandoned after exceeding read timeout of the group
+	// which have not been collected yet.
+	pendingReads map[int]<-chan groupReadResult
+	// Number of times that reading each group exceeded its read timeout.
+	readTimeouts map[int]uint64
+	// Values of the groups reused by reads within read coalescing window,
+	// by group index.
+	coalescedReads map[int]coalescedRead
+	// Incremented whenever files of the groups are closed or moved to other
+	// indexes, so that values read without holding the lock meanwhile are
+	// discarded.
+	generation uint64
+	// Number of perf_event_open calls made to set up core perf events.
+	openCalls uint64
+	// Time of the previous reading of core events by UpdateStats.
+	lastUpdateTime time.Time
+	// Index of the group that is measured when groups are rotated.
+	rotationGroup int
+	// Core perf event stats reported by the previous UpdateStats.
+	lastPerfStats []info.PerfStat
+	// Identity of cgroup directory that core perf events are opened on.
+	cgroup cgroupIdentity
+	// CPUs that each group has not been set up on, because its leader
+	// failed to open there, by group index.
+	droppedCPUs map[int][]int
+	// Events of each group that have not been set up, because libpfm4
+	// cannot encode them on the host, by group index.
+	skippedEvents map[int][]string
+	// Buffers that values of groups are read into, sized for the largest
+	// group when events are opened.
+	readBuffers *readBuffers
+	// Values do not continue values reported previously for the cgroup,
+	// because events have been reopened or the collector has replaced
+	// another one. Stats of the next measurement are marked as reopened.
+	discontinuous bool
+	// Activity of SMT siblings of the CPUs, nil if not reported.
+	smt *smtTracker
+	// Counting of all the groups is disabled by manager until it is
+	// resumed. Groups are kept open in the meantime.
+	paused bool
+	// Measurement window started by TriggerStart is open, groups are not
+	// rotated until it is finished by TriggerStop.
+	triggered bool
+	// Process that core events are opened on instead of cgroup, when
+	// positive.
+	pid int
+
+	// Handle for mocking purposes.
+	ioctlSetInt       func(fd int, req uint, value int) error
+	readFrequency     func(cpu int) (uint64, error)
+	readCPUTimes      func() (map[int]cpuTimes, error)
+	resolveCgroupPath func(cgroupPath string) (string, error)
+	readCpuset        func(cgroupPath string) ([]int, error)
+	readOnlineCPUs    func() ([]int, error)
+	perfEventOpen     func(attr *unix.PerfEventAttr, pid int, cpu int, groupFd int, flags int) (int, error)
+}
+
+type group struct {
+	cpuFiles   map[string]map[int]readerCloser
+	names      []string
+	leaderName string
+	leaderOnly bool
+	// perfStatScaling indicates that values are scaled exactly as perf stat does.
+	perfStatScaling bool
+	// ids stores kernel assigned id of each event on each CPU.
+	ids map[string]map[int]uint64
+	// readTimeout is maximum time of reading the group, no limit if zero.
+	readTimeout time.Duration
+	// buffers are buffers that values of the group are read into.
+	buffers *readBuffers
+	// ungrouped indicates that the group failed to open and each of its
+	// events has been opened and is read on its own.
+	ungrouped bool
+	// startTime is the time when counting of the group started, if it was
+	// opened after the other groups.
+	startTime time.Time
+}
+
+// cgroupIdentity identifies cgroup directory regardless of its path.
+type cgroupIdentity struct {
+	dev uint64
+	ino uint64
+}
+
+// groupReadResult is result of reading group that may be abandoned.
+type groupReadResult struct {
+	perfStats []info.PerfStat
+	truncated bool
+}
+
+// groupRead is reading of a group that is done without holding the lock of
+// the collector. Group has its own copy of files and ids.
+type groupRead struct {
+	index int
+	group group
+	groupReadResult
+	// Results of the read if it has been abandoned after read timeout.
+	abandoned <-chan groupReadResult
+	// Values have been read within read coalescing window and are reused.
+	coalesced bool
+}
+
+var (
+	isLibpfmInitialized = false
+	libpmfMutex         = sync.Mutex{}
+	// Error returned by pfm_initialize, if it failed.
+	libpfmInitializationError error
+	// Events encoded with libpfm4 by name, guarded by libpmfMutex.
+	encodedEvents = map[string]unix.PerfEventAttr{}
+	// Handle for mocking purposes.
+	encodeEvent = encodePerfEventAttr
+
+	registeredSink Sink
+	sinkMutex      = sync.Mutex{}
+
+	registeredThresholdCallback ThresholdCallback
+	thresholdCallbackMutex      = sync.Mutex{}
+
+	// Handle for mocking purposes.
+	now = time.Now
+)
+
+const (
+	groupLeaderFileDescriptor = -1
+	cpuFrequencyPath          = "/sys/devices/system/cpu/cpu%d/cpufreq/scaling_cur_freq"
+	// inherit_thread bit of perf_event_attr, which is not defined in unix package.
+	perfBitInheritThread = unix.CBitFieldMaskBit35
+)
+
+func init() {
+	libpmfMutex.Lock()
+	defer libpmfMutex.Unlock()
+	pErr := C.pfm_initialize()
+	if pErr != C.PFM_SUCCESS {
+		libpfmInitializationError = fmt.Errorf("pfm_initialize failed with %d: %s", int(pErr), C.GoString(C.pfm_strerror(pErr)))
+		klog.Errorf("unable to initialize libpfm: %v", libpfmInitializationError)
+		return
+	}
+	isLibpfmInitialized = true
+}
+
+// checkLibpfmInitialized returns error explaining why perf events cannot be
+// encoded with libpfm4 if it is not initialized.
+func checkLibpfmInitialized() error {
+	libpmfMutex.Lock()
+	defer libpmfMutex.Unlock()
+
+	if isLibpfmInitialized {
+		return nil
+	}
+	if libpfmInitializationError != nil {
+		return fmt.Errorf("libpfm4 is not initialized, perf events cannot be set up: %w", libpfmInitializationError)
+	}
+	return fmt.Errorf("libpfm4 has been finalized, perf events cannot be set up")
+}
+
+// requiresLibpfm checks if any of the events has to be encoded with
+// libpfm4, i.e. it is neither custom nor software event.
+func requiresLibpfm(events Events) bool {
+	return len(namedEvents(events)) > 0
+}
+
+func newCollector(cgroupPath string, events PerfEvents, onlineCPUs []int, cpuToSocket map[int]int, cpuToCore map[int]physicalCore) *collector {
+	collector := &collector{cgroupPath: cgroupPath, events: events, onlineCPUs: onlineCPUs, cpuToSocket: cpuToSocket, cpuToCore: cpuToCore, cpuFiles: map[int]group{}, uncore: NewUncoreCollector(cgroupPath, events, cpuToSocket), differ: newDiffer(), ioctlSetInt: unix.IoctlSetInt, readFrequency: readCPUFrequency, readCPUTimes: readCPUTimes, resolveCgroupPath: newCgroupPathResolver(events.HostCgroupPath), readCpuset: readContainerCpuset, readOnlineCPUs: readOnlineCPUs, perfEventOpen: unix.PerfEventOpen}
+	if len(events.HistogramBuckets) > 0 {
+		collector.histogram = newHistogram(events.HistogramBuckets)
+	}
+	// Siblings of a core are summed up with per core values.
+	if events.SMTContention && !events.PerCore {
+		collector.smt = newSMTTracker(cpuToCore)
+	}
+	mapEventsToCustomEvents(collector)
+
+	sinkMutex.Lock()
+	collector.sink = registeredSink
+	sinkMutex.Unlock()
+
+	thresholdCallbackMutex.Lock()
+	collector.thresholdCallback = registeredThresholdCallback
+	thresholdCallbackMutex.Unlock()
+	if len(events.Thresholds) > 0 {
+		collector.thresholds = newThresholdTracker(events.Thresholds)
+	}
+
+	return collector
+}
+
+// RegisterSink registers sink that receives core perf events measured by
+// collectors created afterwards.
+func RegisterSink(sink Sink) {
+	sinkMutex.Lock()
+	defer sinkMutex.Unlock()
+	registeredSink = sink
+}
+
+// RegisterThresholdCallback registers callback that is called when core
+// perf event crosses threshold configured in perf events configuration.
+// It applies to collectors created afterwards.
+func RegisterThresholdCallback(callback ThresholdCallback) {
+	thresholdCallbackMutex.Lock()
+	defer thresholdCallbackMutex.Unlock()
+	registeredThresholdCallback = callback
+}
+
+func (c *collector) UpdateStats(stats *info.ContainerStats) error {
+	err := c.uncore.UpdateStats(stats)
+	if err != nil {
+		klog.Errorf("Failed to get uncore perf event stats: %v", err)
+	}
+
+	c.cpuFilesLock.Lock()
+	refreshed := !c.unavailable
+	var reopenErr error
+	if refreshed {
+		if c.events.FollowCgroupMoves && c.pid == 0 {
+			err = c.refreshCgroup()
+			if err != nil {
+				klog.Errorf("Failed to reopen perf events of moved cgroup %q: %v", c.cgroupPath, err)
+				reopenErr = err
+			}
+		}
+		if c.events.ContainerCPUs || c.events.CPUHotplug {
+			err = c.refreshCPUs()
+			if err != nil {
+				klog.Errorf("Failed to reopen perf events of cgroup %q on changed CPUs: %v", c.cgroupPath, err)
+				reopenErr = err
+			}
+		}
+	}
+	c.cpuFilesLock.Unlock()
+	// Result is reported without holding the lock, as manager takes lock
+	// of collectors to record it and holds that lock while it takes the
+	// lock of the collector, e.g. in PauseAll or Reload.
+	if refreshed {
+		c.reportReopen(reopenErr)
+	}
+
+	readTime, fired := c.updateCoreStats(stats)
+
+	// Threshold callback and sink are called without holding the lock, so
+	// that they can use the collector or manager, e.g. take a snapshot, and
+	// slow ones do not block the other operations of the collector.
+	for _, threshold := range fired {
+		c.thresholdCallback(c.cgroupPath, threshold.threshold, threshold.value)
+	}
+	if c.sink != nil {
+		perfStats := make([]info.PerfStat, len(stats.PerfStats))
+		copy(perfStats, stats.PerfStats)
+		err = c.sink.Write(c.cgroupPath, perfStats, readTime)
+		if err != nil {
+			klog.Warningf("Unable to write perf events of %q to sink: %v", c.cgroupPath, err)
+		}
+	}
+
+	return nil
+}
+
+// updateCoreStats reads core perf events into stats and returns the time
+// when they were read and thresholds that have been crossed since the
+// previous measurement.
+func (c *collector) updateCoreStats(stats *info.ContainerStats) (time.Time, []firedThreshold) {
+	c.cpuFilesLock.Lock()
+	defer c.cpuFilesLock.Unlock()
+
+	stats.PerfStats = []info.PerfStat{}
+	stats.PerfStatsTruncated = false
+	stats.PerfMultiplexing = nil
+	stats.PerfCoverage = nil
+	stats.PerfDerivedMetrics = nil
+	klog.V(5).Infof("Attempting to update perf_event stats from cgroup %q", c.cgroupPath)
+	stats.PerfInterval = c.measuredInterval(now())
+
+	deadline := time.Time{}
+	if c.events.ReadTimeout > 0 {
+		deadline = now().Add(time.Duration(c.events.ReadTimeout))
+	}
+
+	readTime := now()
+	reads := c.prepareReads(c.measuredGroups())
+	generation, cgroupPath, cpuToSocket, workers := c.generation, c.cgroupPath, c.pinnedReads(), c.events.ReadWorkers
+	// Files are read without holding the lock, so that reading groups on
+	// many CPUs does not block the other operations of the collector.
+	c.cpuFilesLock.Unlock()
+	c.countersLock.RLock()
+	reads = readGroups(reads, deadline, cgroupPath, cpuToSocket, workers)
+	c.countersLock.RUnlock()
+	c.cpuFilesLock.Lock()
+	reads = c.finishReads(reads, generation, readTime)
+
+	multiplexing := multiplexing{}
+	normalization := cpuTimeNormalization{}
+	for _, read := range reads {
+		groupIndex, stat, truncated := read.index, read.perfStats, read.truncated
+		normalization.addGroup(stat)
+		if c.events.Multiplexing {
+			multiplexing.addGroup(stat)
+		}
+		if c.histogram != nil {
+			for i := range stat {
+				stat[i].Histogram = c.histogram.observe(groupIndex, stat[i], c.events.PerfStatScaling)
+			}
+		}
+		if c.events.Delta {
+			for i := range stat {
+				stat[i].Value = c.differ.delta(groupIndex, stat[i], c.events.PerfStatScaling)
+				stat[i].Delta = true
+			}
+		}
+
+		stats.PerfStats = append(stats.PerfStats, stat...)
+		if truncated {
+			klog.V(4).Infof("Reading perf events from cgroup %q exceeded %v, remaining events are skipped", c.cgroupPath, time.Duration(c.events.ReadTimeout))
+			stats.PerfStatsTruncated = true
+			break
+		}
+	}
+	if c.discontinuous && len(stats.PerfStats) > 0 {
+		// Consumers should not compute rates across reopening.
+		for i := range stats.PerfStats {
+			stats.PerfStats[i].Reopened = true
+		}
+		c.discontinuous = false
+	}
+	if c.events.Multiplexing {
+		stats.PerfMultiplexing = multiplexing.estimate()
+	}
+	stats.PerfPerCPUSecond = normalization.perCPUSecond(c.events.Aggregations)
+	stats.PerfCoverage = coverage(stats.PerfStats, configuredEvents(c.events.Core), len(c.cpus))
+	// Paused groups stay disabled, so the rotated group is enabled on resume.
+	if c.events.Rotation && !c.paused && !c.triggered {
+		err := c.rotate()
+		if err != nil {
+			klog.Errorf("Failed to rotate perf event groups of cgroup %q: %v", c.cgroupPath, err)
+		}
+	}
+	c.addFrequency(stats.PerfStats)
+	stats.PerfStats = c.addTotals(c.addCores(aggregate(stats.PerfStats, c.events.Aggregations)))
+	c.addSMTContention(stats.PerfStats)
+	stats.PerfDerivedMetrics = computeDerivedMetrics(stats.PerfStats, c.events.derivedMetrics)
+	addConfidence(stats.PerfStats, c.events.Confidence)
+	convertPerfStats(stats.PerfStats)
+	if c.paused {
+		for i := range stats.PerfStats {
+			stats.PerfStats[i].Paused = true
+		}
+	}
+	c.lastPerfStats = stats.PerfStats
+
+	fired := []firedThreshold{}
+	if c.thresholds != nil && c.thresholdCallback != nil {
+		c.thresholds.evaluate(stats.PerfStats, func(threshold Threshold, value uint64) {
+			fired = append(fired, firedThreshold{threshold: threshold, value: value})
+		})
+	}
+	return readTime, fired
+}
+
+// measuredInterval returns time elapsed between the previous reading of core
+// events, or start of counting if events were opened since then, and the
+// reading at updateTime. Increases of cumulative values divided by it give
+// accurate rates even if UpdateStats is not called at regular intervals.
+func (c *collector) measuredInterval(updateTime time.Time) time.Duration {
+	since := c.lastUpdateTime
+	if c.startTime.After(since) {
+		since = c.startTime
+	}
+	c.lastUpdateTime = updateTime
+	if since.IsZero() {
+		return 0
+	}
+	return updateTime.Sub(since)
+}
+
+// measuredGroups returns the groups that are counting, by group index. With
+// rotation it is only the group that is being measured, the others are
+// disabled and their values are stale.
+func (c *collector) measuredGroups() map[int]group {
+	if c.events.Rotation && len(c.cpuFiles) > 0 {
+		return map[int]group{c.rotationGroup: c.cpuFiles[c.rotationGroup]}
+	}
+	return c.cpuFiles
+}
+
+// snapshot reads cumulative values of core perf events without updating
+// state used to report increases or histograms and without writing them
+// to the sink. With rotation only the group that is being measured is
+// read, as UpdateStats does.
+func (c *collector) snapshot() []info.PerfStat {
+	c.cpuFilesLock.Lock()
+	defer c.cpuFilesLock.Unlock()
+
+	readTime := now()
+	perfStats := []info.PerfStat{}
+	for groupIndex, group := range c.measuredGroups() {
+		stat, ok := c.coalescedRead(groupIndex, snapshotConsumer)
+		if !ok {
+			stat, _ = c.readGroup(group, time.Time{})
+			c.coalesceRead(groupIndex, stat, readTime, snapshotConsumer)
+		}
+		perfStats = append(perfStats, stat...)
+	}
+	c.addFrequency(perfStats)
+	perfStats = c.addTotals(c.addCores(aggregate(perfStats, c.events.Aggregations)))
+	convertPerfStats(perfStats)
+	for i := range perfStats {
+		perfStats[i].Paused = c.paused
+	}
+	return perfStats
+}
+
+// TriggerStart resets the groups that are counting, i.e. only the group that
+// is being measured with rotation, and starts measurement window which is
+// finished by calling TriggerStop. Groups are not rotated until then. Values
+// reported by UpdateStats afterwards are counted from the reset, so they are
+// marked as reopened and increases start over. It fails if counting is
+// paused.
+func (c *collector) TriggerStart() error {
+	c.cpuFilesLock.Lock()
+	defer c.cpuFilesLock.Unlock()
+
+	if c.paused {
+		return fmt.Errorf("unable to start measurement window of cgroup %q, counting of perf events is paused", c.cgroupPath)
+	}
+	for _, group := range c.measuredGroups() {
+		err := c.ioctlLeader(group, unix.PERF_EVENT_IOC_RESET)
+		if err != nil {
+			return err
+		}
+		err = c.ioctlLeader(group, unix.PERF_EVENT_IOC_ENABLE)
+		if err != nil {
+			return err
+		}
+	}
+	c.triggered = true
+	c.startTime = now()
+	c.discontinuous = true
+	// Values of reset events are counted from zero.
+	c.differ = newDiffer()
+	if c.histogram != nil {
+		c.histogram.differ = newDiffer()
+	}
+	return nil
+}
+
+// TriggerStop returns values accumulated since TriggerStart was called.
+// The groups that are counting are disabled while they are read, so that
+// all of them are read at the end of the window, and enabled again
+// afterwards, so UpdateStats continues to report values from there. With
+// rotation only the group that is being measured is read.
+func (c *collector) TriggerStop() ([]info.PerfStat, error) {
+	c.cpuFilesLock.Lock()
+	defer c.cpuFilesLock.Unlock()
+
+	c.triggered = false
+	groups := c.measuredGroups()
+	// Paused groups are disabled already and stay so until resumed.
+	if !c.paused {
+		for _, group := range groups {
+			err := c.ioctlLeader(group, unix.PERF_EVENT_IOC_DISABLE)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	perfStats := []info.PerfStat{}
+	for _, group := range groups {
+		stat, _ := c.readGroup(group, time.Time{})
+		perfStats = append(perfStats, stat...)
+	}
+
+	if !c.paused {
+		for _, group := range groups {
+			err := c.ioctlLeader(group, unix.PERF_EVENT_IOC_ENABLE)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	c.addFrequency(perfStats)
+	perfStats = c.addTotals(c.addCores(aggregate(perfStats, c.events.Aggregations)))
+	addConfidence(perfStats, c.events.Confidence)
+	convertPerfStats(perfStats)
+	return perfStats, nil
+}
+
+// pause disables counting of all the groups, keeping them open, until
+// resume is called. Collector is considered paused even if some of the
+// groups fail to be disabled, so that resume enables them all again.
+func (c *collector) pause() error {
+	c.cpuFilesLock.Lock()
+	defer c.cpuFilesLock.Unlock()
+
+	if c.paused {
+		return nil
+	}
+	c.paused = true
+	return c.ioctlLeaders(unix.PERF_EVENT_IOC_DISABLE)
+}
+
+// resume enables counting of the groups disabled by pause. Values continue
+// from where they were paused. With rotation only the group that is being
+// measured is enabled.
+func (c *collector) resume() error {
+	c.cpuFilesLock.Lock()
+	defer c.cpuFilesLock.Unlock()
+
+	if !c.paused {
+		return nil
+	}
+	c.paused = false
+	if c.events.Rotation {
+		if len(c.cpuFiles) == 0 {
+			return nil
+		}
+		return c.ioctlLeader(c.cpuFiles[c.rotationGroup], unix.PERF_EVENT_IOC_ENABLE)
+	}
+	return c.ioctlLeaders(unix.PERF_EVENT_IOC_ENABLE)
+}
+
+// addCores sets physical core that perf events were measured on or sums
+// values of perf events per physical core if enabled.
+func (c *collector) addCores(perfStats []info.PerfStat) []info.PerfStat {
+	if c.events.PerCore {
+		return aggregateCores(perfStats, c.cpuToCore)
+	}
+	addCores(perfStats, c.cpuToCore)
+	return perfStats
+}
+
+// addTotals appends totals of perf events over all CPUs if enabled.
+func (c *collector) addTotals(perfStats []info.PerfStat) []info.PerfStat {
+	if !c.events.CPUTotals {
+		return perfStats
+	}
+	return addCPUTotals(perfStats)
+}
+
+// addFrequency sets current frequency of CPU that perf events were
+// measured on if it is enabled. Frequency is read once for each CPU.
+func (c *collector) addFrequency(perfStats []info.PerfStat) {
+	if !c.events.Frequency {
+		return
+	}
+
+	frequencies := map[int]uint64{}
+	for i, stat := range perfStats {
+		frequency, ok := frequencies[stat.Cpu]
+		if !ok {
+			var err error
+			frequency, err = c.readFrequency(stat.Cpu)
+			if err != nil {
+				klog.Warningf("Unable to read frequency of CPU %d: %v", stat.Cpu, err)
+			}
+			frequencies[stat.Cpu] = frequency
+		}
+		perfStats[i].Frequency = frequency
+	}
+}
+
+// addSMTContention sets how busy SMT siblings of CPUs were since the
+// previous measurement, if enabled.
+func (c *collector) addSMTContention(perfStats []info.PerfStat) {
+	if c.smt == nil {
+		return
+	}
+	times, err := c.readCPUTimes()
+	if err != nil {
+		klog.Warningf("Unable to read CPU times to detect busy SMT siblings: %v", err)
+		return
+	}
+	addSiblingBusy(perfStats, c.smt.siblingBusy(times))
+}
+
+// newCgroupPathResolver returns function that translates cgroup path seen
+// by cAdvisor to the path in host cgroup hierarchy that is used to open
+// perf events. Path is not translated if hostCgroupPath is empty.
+func newCgroupPathResolver(hostCgroupPath string) func(string) (string, error) {
+	if hostCgroupPath == "" {
+		return func(cgroupPath string) (string, error) {
+			return cgroupPath, nil
+		}
+	}
+	return func(cgroupPath string) (string, error) {
+		mountpoint, err := cgroups.FindCgroupMountpoint("", "perf_event")
+		if err != nil {
+			return "", err
+		}
+		return resolveHostCgroupPath(cgroupPath, mountpoint, hostCgroupPath)
+	}
+}
+
+// readContainerCpuset reads cpuset of the cgroup which core perf events
+// are measured in. In cgroup v1 cpuset is read from the same cgroup in
+// cpuset hierarchy.
+func readContainerCpuset(cgroupPath string) ([]int, error) {
+	if cgroups.IsCgroup2UnifiedMode() {
+		return readCpuset(cgroupPath)
+	}
+	perfEventMountpoint, err := cgroups.FindCgroupMountpoint("", "perf_event")
+	if err != nil {
+		return nil, err
+	}
+	cpusetMountpoint, err := cgroups.FindCgroupMountpoint("", "cpuset")
+	if err != nil {
+		return nil, err
+	}
+	cpusetPath, err := resolveHostCgroupPath(cgroupPath, perfEventMountpoint, cpusetMountpoint)
+	if err != nil {
+		return nil, err
+	}
+	return readCpuset(cpusetPath)
+}
+
+// containerCPUs returns CPUs that core perf events should be opened on.
+func (c *collector) containerCPUs() ([]int, error) {
+	if c.events.CPUHotplug {
+		c.refreshOnlineCPUs()
+	}
+	// Process may be moved between cpusets, so its events are opened on
+	// all online CPUs.
+	if !c.events.ContainerCPUs || c.pid > 0 {
+		return c.onlineCPUs, nil
+	}
+	cpuset, err := c.readCpuset(c.cgroupPath)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read cpuset of cgroup %q: %w", c.cgroupPath, err)
+	}
+	return filterCPUs(c.onlineCPUs, cpuset), nil
+}
+
+// refreshOnlineCPUs updates online CPUs with the ones currently online.
+// Previous online CPUs are kept if they cannot be read, so that events are
+// not reopened because of a transient failure.
+func (c *collector) refreshOnlineCPUs() {
+	online, err := c.readOnlineCPUs()
+	if err != nil {
+		klog.V(4).Infof("Unable to read online CPUs for cgroup %q, keeping %v: %v", c.cgroupPath, c.onlineCPUs, err)
+		return
+	}
+	if !equalCPUs(online, c.onlineCPUs) {
+		klog.V(2).Infof("Online CPUs have changed from %v to %v", c.onlineCPUs, online)
+		c.onlineCPUs = online
+	}
+}
+
+// refreshCPUs reopens core perf events if online CPUs, with cpu_hotplug, or
+// cpuset of the container have changed since they were opened.
+func (c *collector) refreshCPUs() error {
+	cpus, err := c.containerCPUs()
+	if err != nil {
+		return err
+	}
+	if equalCPUs(cpus, c.cpus) {
+		return nil
+	}
+
+	klog.V(2).Infof("CPUs of cgroup %q have changed from %v to %v, reopening perf events", c.cgroupPath, c.cpus, cpus)
+	return c.reopenEvents()
+}
+
+// refreshCgroup reopens core perf events if cgroup directory of the
+// container has been replaced since they were opened, e.g. when container
+// runtime moved the cgroup. Events opened on the former directory do not
+// count tasks of the container anymore.
+func (c *collector) refreshCgroup() error {
+	cgroupPath, err := c.resolveCgroupPath(c.cgroupPath)
+	if err != nil {
+		return fmt.Errorf("unable to resolve cgroup directory %s: %w", c.cgroupPath, err)
+	}
+	stat := unix.Stat_t{}
+	err = unix.Stat(cgroupPath, &stat)
+	if err != nil {
+		// Cgroup is being removed, collector is going to be destroyed.
+		klog.V(4).Infof("Unable to check cgroup directory %s: %v", cgroupPath, err)
+		return nil
+	}
+	cgroup := cgroupIdentity{dev: uint64(stat.Dev), ino: stat.Ino}
+	if cgroup == c.cgroup {
+		return nil
+	}
+
+	klog.Infof("Cgroup directory %s has been replaced (inode %d, now %d), reopening perf events of cgroup %q", cgroupPath, c.cgroup.ino, cgroup.ino, c.cgroupPath)
+	return c.reopenEvents()
+}
+
+// reopenEvents closes core perf events and opens them again with the same
+// configuration.
+func (c *collector) reopenEvents() error {
+	c.closeEvents()
+	c.discontinuous = true
+	c.cpuFiles = map[int]group{}
+	// Values of reopened events are counted from zero.
+	c.differ = newDiffer()
+	if c.histogram != nil {
+		c.histogram = newHistogram(c.events.HistogramBuckets)
+	}
+	err := c.openEvents()
+	if err != nil || !c.paused {
+		return err
+	}
+	// Events are enabled when opened, but counting stays paused.
+	return c.ioctlLeaders(unix.PERF_EVENT_IOC_DISABLE)
+}
+
+// readCPUFrequency reads current frequency of CPU in kHz from cpufreq.
+func readCPUFrequency(cpu int) (uint64, error) {
+	path := fmt.Sprintf(cpuFrequencyPath, cpu)
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(strings.TrimSpace(string(content)), 10, 64)
+}
+
+// readGroup reads values of the group on every CPU. Reading stops when
+// deadline, if not zero, is exceeded and partial results are returned
+// with truncation indicated.
+func (c *collector) readGroup(group group, deadline time.Time) ([]info.PerfStat, bool) {
+	perfStats, truncated := readGroupOnCPUs(group, deadline, c.cgroupPath, c.pinnedReads(), c.events.ReadWorkers)
+	startTime := c.groupStartTime(group)
+	for i := range perfStats {
+		perfStats[i].StartTime = startTime
+	}
+	return perfStats, truncated
+}
+
+// groupStartTime returns the time since which values of the group are
+// counted, nil if it is not known.
+func (c *collector) groupStartTime(group group) *time.Time {
+	startTime := c.startTime
+	if group.startTime.After(startTime) {
+		startTime = group.startTime
+	}
+	if startTime.IsZero() {
+		return nil
+	}
+	return &startTime
+}
+
+// pinnedReads returns mapping of CPUs to sockets that reads of core events
+// are pinned to, nil if reads are not pinned.
+func (c *collector) pinnedReads() map[int]int {
+	if !c.events.PinReads {
+		return nil
+	}
+	return c.cpuToSocket
+}
+
+// prepareReads returns reads of the groups with copies of their files and
+// ids, as closing the events removes files from the group and ids are
+// tracked by the reads without holding the lock. Group whose read has been
+// abandoned after read timeout is skipped until the abandoned read
+// finishes. Group read by Snapshot within read coalescing window is not
+// read again.
+func (c *collector) prepareReads(groups map[int]group) []groupRead {
+	if c.pendingReads == nil {
+		c.pendingReads = map[int]<-chan groupReadResult{}
+	}
+	if c.readTimeouts == nil {
+		c.readTimeouts = map[int]uint64{}
+	}
+
+	reads := make([]groupRead, 0, len(groups))
+	for groupIndex, group := range groups {
+		if pending, ok := c.pendingReads[groupIndex]; ok {
+			select {
+			case <-pending:
+				delete(c.pendingReads, groupIndex)
+			default:
+				c.readTimeouts[groupIndex]++
+				klog.V(4).Infof("Abandoned read of perf event group %q of cgroup %q has not finished yet, the group is skipped", group.leaderName, c.cgroupPath)
+				continue
+			}
+		}
+		if perfStats, ok := c.coalescedRead(groupIndex, updateConsumer); ok {
+			reads = append(reads, groupRead{index: groupIndex, group: group, groupReadResult: groupReadResult{perfStats: perfStats}, coalesced: true})
+			continue
+		}
+
+		cpuFiles := make(map[string]map[int]readerCloser, len(group.cpuFiles))
+		for name, files := range group.cpuFiles {
+			cpuFiles[name] = make(map[int]readerCloser, len(files))
+			for cpu, file := range files {
+				cpuFiles[name][cpu] = file
+			}
+		}
+		group.cpuFiles = cpuFiles
+		group.ids = copyIDs(group.ids)
+		reads = append(reads, groupRead{index: groupIndex, group: group})
+	}
+	return reads
+}
+
+// readGroups reads the groups one after another until deadline, if not zero,
+// is exceeded and returns the reads that have been done. When read timeout
+// of a group is exceeded the read is abandoned, so a wedged group does not
+// block reading of the others. It does not access state of the collector.
+func readGroups(reads []groupRead, deadline time.Time, cgroupPath string, cpuToSocket map[int]int, workers int) []groupRead {
+	for i := range reads {
+		group := reads[i].group
+		if reads[i].coalesced {
+			continue
+		}
+		if group.readTimeout <= 0 {
+			reads[i].perfStats, reads[i].truncated = readGroupOnCPUs(group, deadline, cgroupPath, cpuToSocket, workers)
+		} else {
+			results := make(chan groupReadResult, 1)
+			go func() {
+				perfStats, truncated := readGroupOnCPUs(group, deadline, cgroupPath, cpuToSocket, workers)
+				results <- groupReadResult{perfStats: perfStats, truncated: truncated}
+			}()
+			timer := time.NewTimer(group.readTimeout)
+			select {
+			case result := <-results:
+				reads[i].groupReadResult = result
+			case <-timer.C:
+				reads[i].perfStats = []info.PerfStat{}
+				reads[i].abandoned = results
+				klog.Warningf("Reading perf event group %q of cgroup %q exceeded %v, the group is skipped", group.leaderName, cgroupPath, group.readTimeout)
+			}
+			timer.Stop()
+		}
+		if reads[i].truncated {
+			return reads[:i+1]
+		}
+	}
+	return reads
+}
+
+// finishReads stores ids tracked by the reads in the groups, sets start
+// time of the values, records abandoned reads and keeps values of complete
+// reads started at readTime for read coalescing. Values are discarded if
+// events have been closed or reopened since the reads were prepared, as
+// they may come from the former events.
+func (c *collector) finishReads(reads []groupRead, generation uint64, readTime time.Time) []groupRead {
+	if c.generation != generation {
+		klog.V(4).Infof("Perf events of cgroup %q have been reopened while being read, the values are discarded", c.cgroupPath)
+		return nil
+	}
+	for i, read := range reads {
+		if read.abandoned != nil {
+			c.readTimeouts[read.index]++
+			c.pendingReads[read.index] = read.abandoned
+			continue
+		}
+		if read.coalesced {
+			continue
+		}
+		if ids := c.cpuFiles[read.index].ids; ids != nil {
+			for name, cpuIDs := range read.group.ids {
+				ids[name] = cpuIDs
+			}
+		}
+		startTime := c.groupStartTime(read.group)
+		for j := range read.perfStats {
+			reads[i].perfStats[j].StartTime = startTime
+		}
+		if !read.truncated {
+			c.coalesceRead(read.index, reads[i].perfStats, readTime, updateConsumer)
+		}
+	}
+	return reads
+}
+
+// readGroupOnCPUs reads values of the group on every CPU until deadline, if
+// not zero, is exceeded. CPUs of each socket are read by a thread pinned to
+// them if cpuToSocket is not nil, otherwise CPUs are split among workers.
+// It does not access state of the collector, so it can be run without
+// holding the lock and after the read has been abandoned.
+func readGroupOnCPUs(group group, deadline time.Time, cgroupPath string, cpuToSocket map[int]int, workers int) ([]info.PerfStat, bool) {
+	if cpuToSocket != nil {
+		return readGroupPinned(group, deadline, cgroupPath, cpuToSocket)
+	}
+	return readGroupConcurrently(group, deadline, cgroupPath, workers)
+}
+
+// readGroupFiles reads values of the group from its files on every CPU
+// until deadline, if not zero, is exceeded.
+func readGroupFiles(group group, deadline time.Time, cgroupPath string) ([]info.PerfStat, bool) {
+	perfStats := []info.PerfStat{}
+	for cpu, file := range group.cpuFiles[group.leaderName] {
+		if !deadline.IsZero() && now().After(deadline) {
+			return perfStats, true
+		}
+		stat, err := readGroupPerfStat(file, group, cpu, cgroupPath)
+		if err != nil {
+			klog.Warningf("Unable to read from perf_event_file (event: %q, CPU: %d) for %q: %q", group.leaderName, cpu, cgroupPath, err.Error())
+			continue
+		}
+
+		perfStats = append(perfStats, stat...)
+	}
+	return perfStats, false
+}
+
+// ioctlLeaders executes ioctl request on group leaders on every CPU. Request
+// is applied to all the events in a group.
+func (c *collector) ioctlLeaders(request uint) error {
+	for _, group := range c.cpuFiles {
+		err := c.ioctlLeader(group, request)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ioctlLeader executes ioctl request for the whole group on all CPUs. Each
+// event of ungrouped group is a leader of its own. Reads made without
+// cpuFilesLock wait until the request is applied on all the CPUs. Values
+// kept for read coalescing are dropped, as the request changes counting.
+func (c *collector) ioctlLeader(group group, request uint) error {
+	c.countersLock.Lock()
+	defer c.countersLock.Unlock()
+	c.coalescedReads = nil
+	leaders := []string{group.leaderName}
+	if group.ungrouped {
+		leaders = group.names
+	}
+	for _, leader := range leaders {
+		for cpu, file := range group.cpuFiles[leader] {
+			perfFile, ok := file.(fileDescriptor)
+			if !ok {
+				return fmt.Errorf("unable to get file descriptor of perf event %q on CPU %d", leader, cpu)
+			}
+			err := c.ioctlSetInt(int(perfFile.Fd()), request, unix.PERF_IOC_FLAG_GROUP)
+			if err != nil {
+				return fmt.Errorf("unable to execute ioctl %#x for perf event %q on CPU %d: %w", request, leader, cpu, err)
+			}
+		}
+	}
+	return nil
+}
+
+// rotate disables the group that has been measured and enables the next one
+// with its counters reset, so that it is the only group competing for PMU
+// counters until the next measurement.
+func (c *collector) rotate() error {
+	if len(c.cpuFiles) == 0 {
+		return nil
+	}
+	err := c.ioctlLeader(c.cpuFiles[c.rotationGroup], unix.PERF_EVENT_IOC_DISABLE)
+	if err != nil {
+		return err
+	}
+	// Indexes of the groups have gaps when some of the groups are not
+	// set up, so the next group is the open one with the next index.
+	indexes := c.groupIndexes()
+	previous := c.rotationGroup
+	c.rotationGroup = indexes[0]
+	for _, i := range indexes {
+		if i > previous {
+			c.rotationGroup = i
+			break
+		}
+	}
+	next := c.cpuFiles[c.rotationGroup]
+	err = c.ioctlLeader(next, unix.PERF_EVENT_IOC_RESET)
+	if err != nil {
+		return err
+	}
+	err = c.ioctlLeader(next, unix.PERF_EVENT_IOC_ENABLE)
+	if err != nil {
+		return err
+	}
+	c.startTime = now()
+	return nil
+}
+
+// groupIndexes returns indexes of the open groups in ascending order. Group
+// is not open e.g. when all its events are unsupported and ignored.
+func (c *collector) groupIndexes() []int {
+	indexes := make([]int, 0, len(c.cpuFiles))
+	for i := range c.cpuFiles {
+		indexes = append(indexes, i)
+	}
+	sort.Ints(indexes)
+	return indexes
+}
+
+func readGroupPerfStat(file readerCloser, group group, cpu int, cgroupPath string) ([]info.PerfStat, error) {
+	// Values are copied to the returned stats, so the slice that they are
+	// decoded into is reused.
+	valuesPtr := group.buffers.getValues(len(group.names))
+	defer group.buffers.putValues(valuesPtr)
+	values, err := readPerfValues(file, group, cpu, *valuesPtr)
+	if err != nil {
+		return nil, err
+	}
+
+	perfStats := make([]info.PerfStat, len(values))
+	for i, value := range values {
+		// Arguments are not evaluated unless logged, as they would be
+		// allocated on every read.
+		if klog.V(5).Enabled() {
+			klog.V(5).Infof("Read metric for event %q for cpu %d from cgroup %q: %d", value.Name, cpu, cgroupPath, value.Value)
+		}
+		perfStats[i] = info.PerfStat{
+			PerfValue: value,
+			Cpu:       cpu,
+		}
+	}
+
+	return perfStats, nil
+}
+
+func getPerfValues(file readerCloser, group group, cpu int) ([]info.PerfValue, error) {
+	return readPerfValues(file, group, cpu, make([]info.PerfValue, len(group.names)))
+}
+
+// readPerfValues reads values of the group as getPerfValues does. Values read
+// from the leader of the group are decoded into perfValues, which has an
+// element for each event of the group.
+func readPerfValues(file readerCloser, group group, cpu int, perfValues []info.PerfValue) ([]info.PerfValue, error) {
+	if group.leaderOnly {
+		return getLeaderPerfValue(file, group, cpu)
+	}
+	if group.ungrouped {
+		return readUngrouped(group, cpu), nil
+	}
+
+	// GroupReadFormat struct followed by Values struct for each element
+	// in group. Buffer is reused, so reading does not allocate it.
+	bufPtr := group.buffers.get(groupReadSize(len(group.names)))
+	defer group.buffers.put(bufPtr)
+	buf := *bufPtr
+	n, err := file.Read(buf)
+	if err != nil {
+		return readMembers(group, cpu, fmt.Errorf("unable to read perf event group ( leader = %s ): %w", group.leaderName, err))
+	}
+	// Nothing is read from leader in error state.
+	if n > 0 && (n < groupReadFormatSize || (n-groupReadFormatSize)%valuesSize != 0) {
+		return readMembers(group, cpu, fmt.Errorf("unable to decode perf event group ( leader = %s ): unexpected size of %d bytes", group.leaderName, n))
+	}
+	perfData := GroupReadFormat{
+		Nr:          binary.LittleEndian.Uint64(buf[0:]),
+		TimeEnabled: binary.LittleEndian.Uint64(buf[8:]),
+		TimeRunning: binary.LittleEndian.Uint64(buf[16:]),
+	}
+	// Members that failed to be read are missing at the end of the group.
+	read := len(group.names)
+	if perfData.Nr < uint64(read) {
+		read = int(perfData.Nr)
+	}
+
+	for i, name := range group.names {
+		perfValues[i] = info.PerfValue{Name: name}
+		// Follower in error state occupies its slot but is never counted.
+		offset := groupReadFormatSize + valuesSize*i
+		values := Values{
+			Value: binary.LittleEndian.Uint64(buf[offset:]),
+			ID:    binary.LittleEndian.Uint64(buf[offset+8:]),
+		}
+		if i >= read || (i > 0 && values.Value == 0 && perfData.TimeRunning != 0 && isErrored(group.cpuFiles[name][cpu])) {
+			klog.V(5).Infof("Perf event %q on CPU %d is in error state", name, cpu)
+			_, perfValues[i].ScalingRatio = scaleValue(0, perfData.TimeEnabled, perfData.TimeRunning, group.perfStatScaling)
+			perfValues[i].Errored = true
+			continue
+		}
+		perfValues[i].Value, perfValues[i].ScalingRatio = scaleValue(values.Value, perfData.TimeEnabled, perfData.TimeRunning, group.perfStatScaling)
+		perfValues[i].RawValue = values.Value
+		perfValues[i].Reopened = group.trackID(name, cpu, values.ID)
+		perfValues[i].TimeEnabled = perfData.TimeEnabled
+		perfValues[i].TimeRunning = perfData.TimeRunning
+	}
+
+	return perfValues, nil
+}
+
+// readMembers reads each event of the group on its own when values of the
+// whole group could not be read or decoded, e.g. because of a kernel quirk,
+// so that values of the events are not lost. Events that cannot be read are
+// reported in error state. Error of the group read is returned if none of
+// the events is read.
+func readMembers(group group, cpu int, groupErr error) ([]info.PerfValue, error) {
+	klog.Warningf("%v, reading its events one by one on CPU %d", groupErr, cpu)
+	perfValues := make([]info.PerfValue, len(group.names))
+	read := 0
+	for i, name := range group.names {
+		perfValues[i] = info.PerfValue{Name: name, Errored: true}
+		file, ok := group.cpuFiles[name][cpu]
+		if !ok {
+			continue
+		}
+		value, err := readMember(file, group, i, cpu)
+		if err != nil {
+			klog.V(4).Infof("Unable to read perf event %q on CPU %d on its own: %v", name, cpu, err)
+			continue
+		}
+		perfValues[i] = value
+		read++
+	}
+	if read == 0 {
+		return []info.PerfValue{}, groupErr
+	}
+	return perfValues, nil
 }
 
-type group struct {
-	cpuFiles   map[string]map[int]readerCloser
-	names      []string
-	leaderName string
+// readUngrouped reads each event of group that has been opened without
+// grouping on its own. Events that cannot be read are in error state.
+func readUngrouped(group group, cpu int) []info.PerfValue {
+	perfValues := make([]info.PerfValue, len(group.names))
+	for i, name := range group.names {
+		perfValues[i] = info.PerfValue{Name: name, Errored: true}
+		file, ok := group.cpuFiles[name][cpu]
+		if !ok {
+			continue
+		}
+		value, err := readMember(file, group, i, cpu)
+		if err != nil {
+			klog.V(4).Infof("Unable to read perf event %q on CPU %d: %v", name, cpu, err)
+			continue
+		}
+		perfValues[i] = value
+	}
+	return perfValues
 }
 
-var (
-	isLibpfmInitialized = false
-	libpmfMutex         = sync.Mutex{}
-)
+// readMember reads event at the position in the group on its own. Event
+// that is read without group format returns its own value. Otherwise values
+// of the whole group are returned and the event is found among them by id
+// seen in previous reads or, if it has not been read yet, by its position.
+// Buffer is large enough for groups larger than configured, in case kernel
+// reports more events.
+func readMember(file readerCloser, group group, position int, cpu int) (info.PerfValue, error) {
+	name := group.names[position]
+	buf := make([]byte, 4096)
+	n, err := file.Read(buf)
+	if err != nil {
+		return info.PerfValue{}, err
+	}
 
-const (
-	groupLeaderFileDescriptor = -1
-)
+	var value, timeEnabled, timeRunning, id uint64
+	reader := bytes.NewReader(buf[:n])
+	switch {
+	case n == 32:
+		perfData := ReadFormat{}
+		err = binary.Read(reader, binary.LittleEndian, &perfData)
+		if err != nil {
+			return info.PerfValue{}, err
+		}
+		value, timeEnabled, timeRunning, id = perfData.Value, perfData.TimeEnabled, perfData.TimeRunning, perfData.ID
+	case n >= 24 && (n-24)%16 == 0:
+		perfData := GroupReadFormat{}
+		err = binary.Read(reader, binary.LittleEndian, &perfData)
+		if err != nil {
+			return info.PerfValue{}, err
+		}
+		values := make([]Values, (n-24)/16)
+		err = binary.Read(reader, binary.LittleEndian, values)
+		if err != nil {
+			return info.PerfValue{}, err
+		}
+		index := position
+		if known, ok := group.ids[name][cpu]; ok {
+			index = -1
+			for i := range values {
+				if values[i].ID == known {
+					index = i
+				}
+			}
+		}
+		if index < 0 || index >= len(values) {
+			return info.PerfValue{}, fmt.Errorf("event is not among %d values of the group", len(values))
+		}
+		value, timeEnabled, timeRunning, id = values[index].Value, perfData.TimeEnabled, perfData.TimeRunning, values[index].ID
+	default:
+		return info.PerfValue{}, fmt.Errorf("unexpected size of %d bytes", n)
+	}
 
-func init() {
-	libpmfMutex.Lock()
-	defer libpmfMutex.Unlock()
-	pErr := C.pfm_initialize()
-	if pErr != C.PFM_SUCCESS {
-		klog.Errorf("unable to initialize libpfm: %d", int(pErr))
-		return
+	scaled, scalingRatio := scaleValue(value, timeEnabled, timeRunning, group.perfStatScaling)
+	return info.PerfValue{
+		ScalingRatio: scalingRatio,
+		Value:        scaled,
+		RawValue:     value,
+		Name:         name,
+		Reopened:     group.trackID(name, cpu, id),
+		TimeEnabled:  timeEnabled,
+		TimeRunning:  timeRunning,
+	}, nil
+}
+
+// scaleValue normalizes value of perf event against multiplexing and
+// returns it with scaling ratio.
+func scaleValue(value, timeEnabled, timeRunning uint64, perfStatScaling bool) (uint64, float64) {
+	if perfStatScaling {
+		return scaleValueAsPerfStat(value, timeEnabled, timeRunning)
 	}
-	isLibpfmInitialized = true
+
+	scalingRatio := 1.0
+	if timeRunning != 0 && timeEnabled != 0 {
+		scalingRatio = float64(timeRunning) / float64(timeEnabled)
+	}
+	return uint64(float64(value) / scalingRatio), scalingRatio
 }
 
-func newCollector(cgroupPath string, events PerfEvents, onlineCPUs []int, cpuToSocket map[int]int) *collector {
-	collector := &collector{cgroupPath: cgroupPath, events: events, onlineCPUs: onlineCPUs, cpuFiles: map[int]group{}, uncore: NewUncoreCollector(cgroupPath, events, cpuToSocket)}
-	mapEventsToCustomEvents(collector)
-	return collector
+// scaleValueAsPerfStat normalizes value of perf event with the same
+// arithmetic that perf stat uses (value * time_enabled / time_running).
+// Event that has not been running is reported as 0.
+// See perf_counts_values__scale() in tools/perf/util/evsel.c.
+func scaleValueAsPerfStat(value, timeEnabled, timeRunning uint64) (uint64, float64) {
+	if timeRunning == 0 {
+		return 0, 0
+	}
+	scalingRatio := 1.0
+	if timeEnabled != 0 {
+		scalingRatio = float64(timeRunning) / float64(timeEnabled)
+	}
+	if timeRunning < timeEnabled {
+		value = uint64(float64(value) * float64(timeEnabled) / float64(timeRunning))
+	}
+	return value, scalingRatio
 }
 
-func (c *collector) UpdateStats(stats *info.ContainerStats) error {
-	err := c.uncore.UpdateStats(stats)
+// isErrored checks if perf event is in error state. Kernel returns no data
+// when such event is read. Otherwise the read fails as the buffer is too small.
+func isErrored(file readerCloser) bool {
+	if file == nil {
+		return false
+	}
+	n, err := file.Read(make([]byte, 1))
+	return n == 0 && (err == nil || err == io.EOF)
+}
+
+func getLeaderPerfValue(file readerCloser, group group, cpu int) ([]info.PerfValue, error) {
+	// ReadFormat struct.
+	// See https://man7.org/linux/man-pages/man2/perf_event_open.2.html section "Reading results" without PERF_FORMAT_GROUP specified.
+	bufPtr := group.buffers.get(readFormatSize)
+	defer group.buffers.put(bufPtr)
+	buf := *bufPtr
+	_, err := file.Read(buf)
 	if err != nil {
-		klog.Errorf("Failed to get uncore perf event stats: %v", err)
+		return []info.PerfValue{}, fmt.Errorf("unable to read perf event group leader ( leader = %s ): %w", group.leaderName, err)
+	}
+	perfData := ReadFormat{
+		Value:       binary.LittleEndian.Uint64(buf[0:]),
+		TimeEnabled: binary.LittleEndian.Uint64(buf[8:]),
+		TimeRunning: binary.LittleEndian.Uint64(buf[16:]),
+		ID:          binary.LittleEndian.Uint64(buf[24:]),
+	}
+
+	value, scalingRatio := scaleValue(perfData.Value, perfData.TimeEnabled, perfData.TimeRunning, group.perfStatScaling)
+
+	return []info.PerfValue{{
+		ScalingRatio: scalingRatio,
+		Value:        value,
+		RawValue:     perfData.Value,
+		Name:         group.leaderName,
+		Reopened:     group.trackID(group.leaderName, cpu, perfData.ID),
+		TimeEnabled:  perfData.TimeEnabled,
+		TimeRunning:  perfData.TimeRunning,
+	}}, nil
+}
+
+// trackID stores id that kernel assigned to the event on the CPU and
+// returns true if it has changed since previous read, which means that
+// the event has been reopened. Ids are not tracked if ids map is nil.
+func (g group) trackID(name string, cpu int, id uint64) bool {
+	if g.ids == nil {
+		return false
+	}
+	ids, ok := g.ids[name]
+	if !ok {
+		ids = map[int]uint64{}
+		g.ids[name] = ids
+	}
+	previous, ok := ids[cpu]
+	ids[cpu] = id
+	return ok && previous != id
+}
+
+// copyIDs returns deep copy of ids of events of a group.
+func copyIDs(ids map[string]map[int]uint64) map[string]map[int]uint64 {
+	if ids == nil {
+		return nil
 	}
+	copied := make(map[string]map[int]uint64, len(ids))
+	for name, cpuIDs := range ids {
+		copied[name] = make(map[int]uint64, len(cpuIDs))
+		for cpu, id := range cpuIDs {
+			copied[name][cpu] = id
+		}
+	}
+	return copied
+}
 
+// EventID returns id that kernel assigned to the event on the CPU as seen
+// in the most recent read. Id changes when the event is reopened.
+func (c *collector) EventID(groupIndex int, name string, cpu int) (uint64, bool) {
 	c.cpuFilesLock.Lock()
 	defer c.cpuFilesLock.Unlock()
 
-	stats.PerfStats = []info.PerfStat{}
-	klog.V(5).Infof("Attempting to update perf_event stats from cgroup %q", c.cgroupPath)
+	id, ok := c.cpuFiles[groupIndex].ids[name][cpu]
+	return id, ok
+}
 
-	for _, group := range c.cpuFiles {
-		for cpu, file := range group.cpuFiles[group.leaderName] {
-			stat, err := readGroupPerfStat(file, group, cpu, c.cgroupPath)
-			if err != nil {
-				klog.Warningf("Unable to read from perf_event_file (event: %q, CPU: %d) for %q: %q", group.leaderName, cpu, c.cgroupPath, err.Error())
-				continue
-			}
+// OpenCalls returns number of perf_event_open syscalls that the collector
+// has made to set up core perf events, including the failed ones and ones
+// made to reopen events when cpuset of the container changed. Each event is
+// opened on every CPU, so it drives number of file descriptors and cost of
+// the setup.
+func (c *collector) OpenCalls() uint64 {
+	c.cpuFilesLock.Lock()
+	defer c.cpuFilesLock.Unlock()
+
+	return c.openCalls
+}
+
+// ActiveCPUs returns CPUs that core perf events of the collector are opened
+// on, i.e. online CPUs limited to cpuset of the container when container_cpus
+// is set. Returned slice is a copy.
+func (c *collector) ActiveCPUs() []int {
+	c.cpuFilesLock.Lock()
+	defer c.cpuFilesLock.Unlock()
+
+	cpus := make([]int, len(c.cpus))
+	copy(cpus, c.cpus)
+	return cpus
+}
+
+// SkippedEvents returns events of each group of core perf events that have
+// not been set up, by group index, because libpfm4 cannot encode them on
+// the host and ignore_unsupported_events is set. Groups without skipped
+// events are not included. Returned map is a copy.
+func (c *collector) SkippedEvents() map[int][]string {
+	c.cpuFilesLock.Lock()
+	defer c.cpuFilesLock.Unlock()
+
+	skipped := make(map[int][]string, len(c.skippedEvents))
+	for groupIndex, events := range c.skippedEvents {
+		skipped[groupIndex] = append([]string{}, events...)
+	}
+	return skipped
+}
+
+// DroppedCPUs returns CPUs that each group of core perf events has not been
+// set up on, by group index, because leader of the group failed to open
+// there and partial_groups is set. Groups set up on all the CPUs are not
+// included. Returned map is a copy.
+func (c *collector) DroppedCPUs() map[int][]int {
+	c.cpuFilesLock.Lock()
+	defer c.cpuFilesLock.Unlock()
 
-			stats.PerfStats = append(stats.PerfStats, stat...)
+	dropped := make(map[int][]int, len(c.droppedCPUs))
+	for groupIndex, cpus := range c.droppedCPUs {
+		dropped[groupIndex] = append([]int{}, cpus...)
+	}
+	return dropped
+}
+
+func (c *collector) setup() error {
+	if requiresLibpfm(c.events.Core) {
+		err := checkLibpfmInitialized()
+		if err != nil {
+			return err
 		}
 	}
 
-	return nil
+	c.cpuFilesLock.Lock()
+	defer c.cpuFilesLock.Unlock()
+
+	return c.openEvents()
 }
 
-func readGroupPerfStat(file readerCloser, group group, cpu int, cgroupPath string) ([]info.PerfStat, error) {
-	values, err := getPerfValues(file, group)
+// checkThreadedSubtree warns when threads of the container that live in
+// threaded cgroups below its cgroup are not going to be counted. Events are
+// opened on the cgroup of the container and count threaded descendants only
+// if kernel scopes cgroup perf events to whole subtrees.
+func (c *collector) checkThreadedSubtree(cgroupPath string) {
+	threaded, err := threadedDescendants(cgroupPath)
 	if err != nil {
-		return nil, err
+		klog.V(4).Infof("Unable to find threaded cgroups of %q: %v", c.cgroupPath, err)
+		return
+	}
+	if len(threaded) == 0 {
+		return
+	}
+	err = checkSubtreeAggregate(true)
+	if err != nil {
+		klog.Warningf("Threads of cgroup %q in threaded cgroups %v are not counted by its perf events: %v", c.cgroupPath, threaded, err)
+		return
 	}
+	klog.V(4).Infof("Perf events of cgroup %q count threads in its threaded cgroups %v", c.cgroupPath, threaded)
+}
 
-	perfStats := make([]info.PerfStat, len(values))
-	for i, value := range values {
-		klog.V(5).Infof("Read metric for event %q for cpu %d from cgroup %q: %d", value.Name, cpu, cgroupPath, value.Value)
-		perfStats[i] = info.PerfStat{
-			PerfValue: value,
-			Cpu:       cpu,
+// openEvents opens core perf events of all the groups on CPUs that
+// the container may run on. Events that have been opened are closed when
+// any of them fails to open, so that their file descriptors do not leak.
+func (c *collector) openEvents() error {
+	cpus, err := c.containerCPUs()
+	if err != nil {
+		return err
+	}
+	c.cpus = cpus
+
+	target, cgroup, err := c.openTarget()
+	if err != nil {
+		return err
+	}
+	if cgroup != nil {
+		defer cgroup.Close()
+		stat := unix.Stat_t{}
+		err = unix.Fstat(target, &stat)
+		if err != nil {
+			return fmt.Errorf("unable to stat cgroup directory %s: %w", cgroup.Name(), err)
 		}
+		c.cgroup = cgroupIdentity{dev: uint64(stat.Dev), ino: stat.Ino}
 	}
 
-	return perfStats, nil
+	c.droppedCPUs = map[int][]int{}
+	c.skippedEvents = map[int][]string{}
+	c.readBuffers = newReadBuffers(c.events.Core.Events)
+	for i := range c.events.Core.Events {
+		err = c.openGroup(i, c.events.Core.Events[i], target)
+		if err != nil {
+			c.closeEvents()
+			c.cpuFiles = map[int]group{}
+			return err
+		}
+	}
+	c.rotationGroup = 0
+	if indexes := c.groupIndexes(); len(indexes) > 0 {
+		c.rotationGroup = indexes[0]
+	}
+	// Kernel does not provide counts from before perf_event_open so values
+	// are counted since now and not since the container start.
+	c.startTime = now()
+
+	return nil
 }
 
-func getPerfValues(file readerCloser, group group) ([]info.PerfValue, error) {
-	// 24 bytes of GroupReadFormat struct.
-	// 16 bytes of Values struct for each element in group.
-	// See https://man7.org/linux/man-pages/man2/perf_event_open.2.html section "Reading results" with PERF_FORMAT_GROUP specified.
-	buf := make([]byte, 24+16*len(group.names))
-	_, err := file.Read(buf)
+// openTarget returns pid argument of perf_event_open that core perf events
+// are opened with: pid of the process of the collector or file descriptor of
+// cgroup directory, which is returned as well and has to be closed after the
+// events are opened.
+func (c *collector) openTarget() (int, *os.File, error) {
+	if c.pid > 0 {
+		return c.pid, nil, nil
+	}
+	cgroup, _, err := c.openCgroup()
 	if err != nil {
-		return []info.PerfValue{}, fmt.Errorf("unable to read perf event group ( leader = %s ): %w", group.leaderName, err)
+		return 0, nil, err
 	}
-	perfData := &GroupReadFormat{}
-	reader := bytes.NewReader(buf[:24])
-	err = binary.Read(reader, binary.LittleEndian, perfData)
+	return int(cgroup.Fd()), cgroup, nil
+}
+
+// openCgroup opens cgroup directory that core perf events are opened on and
+// returns it with its path.
+func (c *collector) openCgroup() (*os.File, string, error) {
+	cgroupPath, err := c.resolveCgroupPath(c.cgroupPath)
 	if err != nil {
-		return []info.PerfValue{}, fmt.Errorf("unable to decode perf event group ( leader = %s ): %w", group.leaderName, err)
+		return nil, "", fmt.Errorf("unable to resolve cgroup directory %s: %w", c.cgroupPath, err)
 	}
-	values := make([]Values, perfData.Nr)
-	reader = bytes.NewReader(buf[24:])
-	err = binary.Read(reader, binary.LittleEndian, values)
+	c.checkThreadedSubtree(cgroupPath)
+	cgroup, err := os.Open(cgroupPath)
 	if err != nil {
-		return []info.PerfValue{}, fmt.Errorf("unable to decode perf event group values ( leader = %s ): %w", group.leaderName, err)
+		return nil, "", fmt.Errorf("unable to open cgroup directory %s: %s", cgroupPath, err)
 	}
+	return cgroup, cgroupPath, nil
+}
 
-	scalingRatio := 1.0
-	if perfData.TimeRunning != 0 && perfData.TimeEnabled != 0 {
-		scalingRatio = float64(perfData.TimeRunning) / float64(perfData.TimeEnabled)
+// openGroup opens events of the group with index i on CPUs of the collector
+// and enables counting of the group, unless groups are rotated and it is
+// not the first one. Target is pid argument of perf_event_open returned by
+// openTarget.
+func (c *collector) openGroup(i int, group Group, target int) error {
+	leaderFileDescriptors, err := c.openGroupEvents(i, group, target)
+	if err != nil && c.events.WeakGroups && len(group.events) > 1 {
+		klog.Warningf("Perf event group %v of cgroup %q failed to open, its events are opened on their own and are not counted at the same time: %v", group.events, c.cgroupPath, err)
+		c.closeGroup(c.cpuFiles[i])
+		delete(c.cpuFiles, i)
+		delete(c.droppedCPUs, i)
+		delete(c.skippedEvents, i)
+		leaderFileDescriptors, err = c.openUngrouped(i, group, target)
+	}
+	if err != nil {
+		return err
 	}
 
-	perfValues := make([]info.PerfValue, perfData.Nr)
-	if scalingRatio != float64(0) {
-		for i, name := range group.names {
-			perfValues[i] = info.PerfValue{
-				ScalingRatio: scalingRatio,
-				Value:        uint64(float64(values[i].Value) / scalingRatio),
-				Name:         name,
-			}
+	// Only the first open group is counted when groups are rotated.
+	if indexes := c.groupIndexes(); c.events.Rotation && len(indexes) > 0 && indexes[0] < i {
+		return nil
+	}
+	// Group is prepared so we should reset and enable counting.
+	for _, fd := range leaderFileDescriptors {
+		err = c.ioctlSetInt(fd, unix.PERF_EVENT_IOC_RESET, 0)
+		if err != nil {
+			return err
 		}
-	} else {
-		for i, name := range group.names {
-			perfValues[i] = info.PerfValue{
-				ScalingRatio: scalingRatio,
-				Value:        values[i].Value,
-				Name:         name,
-			}
+		err = c.ioctlSetInt(fd, unix.PERF_EVENT_IOC_ENABLE, 0)
+		if err != nil {
+			return err
 		}
 	}
-
-	return perfValues, nil
+	return nil
 }
 
-func (c *collector) setup() error {
-	cgroup, err := os.Open(c.cgroupPath)
-	if err != nil {
-		return fmt.Errorf("unable to open cgroup directory %s: %s", c.cgroupPath, err)
+// openGroupEvents opens events of group i as a group on every CPU and
+// returns file descriptors of the group leader.
+func (c *collector) openGroupEvents(i int, group Group, target int) ([]int, error) {
+	// CPUs file descriptors of group leader needed for perf_event_open.
+	leaderFileDescriptors := make(map[int]int, len(c.cpus))
+	for _, cpu := range c.cpus {
+		leaderFileDescriptors[cpu] = groupLeaderFileDescriptor
 	}
-	defer cgroup.Close()
 
-	c.cpuFilesLock.Lock()
-	defer c.cpuFilesLock.Unlock()
-	cgroupFd := int(cgroup.Fd())
-	for i, group := range c.events.Core.Events {
-		// CPUs file descriptors of group leader needed for perf_event_open.
-		leaderFileDescriptors := make(map[int]int, len(c.onlineCPUs))
-		for _, cpu := range c.onlineCPUs {
-			leaderFileDescriptors[cpu] = groupLeaderFileDescriptor
+	var err error
+	// First event that is not skipped is group leader.
+	isGroupLeader := true
+	for _, event := range group.events {
+		if c.skipUnsupported(i, event) {
+			continue
 		}
-
-		for j, event := range group.events {
-			// First element is group leader.
-			isGroupLeader := j == 0
-			customEvent, ok := c.eventToCustomEvent[event]
-			if ok {
-				config := c.createConfigFromRawEvent(customEvent)
-				leaderFileDescriptors, err = c.registerEvent(eventInfo{string(customEvent.Name), config, cgroupFd, i, isGroupLeader}, leaderFileDescriptors)
-				if err != nil {
-					return err
-				}
-			} else {
-				config, err := c.createConfigFromEvent(event)
-				if err != nil {
-					return err
-				}
-				leaderFileDescriptors, err = c.registerEvent(eventInfo{string(event), config, cgroupFd, i, isGroupLeader}, leaderFileDescriptors)
-				if err != nil {
-					return err
-				}
-				// Clean memory allocated by C code.
-				C.free(unsafe.Pointer(config))
-			}
+		leaderFileDescriptors, err = c.openEvent(eventInfo{name: string(event), pid: target, groupIndex: i, isGroupLeader: isGroupLeader}, leaderFileDescriptors)
+		if err != nil {
+			return nil, err
 		}
+		isGroupLeader = false
+	}
+	if isGroupLeader {
+		// All the events have been skipped.
+		return []int{}, nil
+	}
+	fileDescriptors := make([]int, 0, len(leaderFileDescriptors))
+	for _, fd := range leaderFileDescriptors {
+		fileDescriptors = append(fileDescriptors, fd)
+	}
+	return fileDescriptors, nil
+}
 
-		// Group is prepared so we should reset and enable counting.
-		for _, fd := range leaderFileDescriptors {
-			err = unix.IoctlSetInt(fd, unix.PERF_EVENT_IOC_RESET, 0)
-			if err != nil {
-				return err
-			}
-			err = unix.IoctlSetInt(fd, unix.PERF_EVENT_IOC_ENABLE, 0)
-			if err != nil {
-				return err
-			}
+// openUngrouped opens each event of group i as a group of its own on every
+// CPU and returns file descriptors of all the events. Events that fail to
+// open are skipped, so that the others are counted.
+func (c *collector) openUngrouped(i int, group Group, target int) ([]int, error) {
+	fileDescriptors := []int{}
+	var lastErr error
+	for _, event := range group.events {
+		if c.skipUnsupported(i, event) {
+			continue
+		}
+		leaderFileDescriptors := make(map[int]int, len(c.cpus))
+		for _, cpu := range c.cpus {
+			leaderFileDescriptors[cpu] = groupLeaderFileDescriptor
+		}
+		opened, err := c.openEvent(eventInfo{name: string(event), pid: target, groupIndex: i, isGroupLeader: true, ungrouped: true}, leaderFileDescriptors)
+		if err != nil {
+			klog.Warningf("Perf event %q of cgroup %q is not counted, because it failed to open on its own: %v", event, c.cgroupPath, err)
+			c.dropEvent(i, string(event))
+			lastErr = err
+			continue
+		}
+		for _, fd := range opened {
+			fileDescriptors = append(fileDescriptors, fd)
 		}
 	}
+	if len(fileDescriptors) == 0 {
+		return nil, fmt.Errorf("none of events of perf event group %v could be opened on its own: %w", group.events, lastErr)
+	}
+	ungrouped := c.cpuFiles[i]
+	ungrouped.ungrouped = true
+	c.cpuFiles[i] = ungrouped
+	return fileDescriptors, nil
+}
 
-	return nil
+// skipUnsupported checks if the event of group i should be skipped, because
+// ignore_unsupported_events is set and libpfm4 cannot encode the event on the
+// host. Skipped event is logged and recorded.
+func (c *collector) skipUnsupported(i int, event Event) bool {
+	if !c.events.IgnoreUnsupportedEvents {
+		return false
+	}
+	if _, ok := c.eventToCustomEvent[event]; ok {
+		return false
+	}
+	_, err := encodedEvent(string(event))
+	if err == nil {
+		return false
+	}
+	klog.Warningf("Perf event %q of cgroup %q is skipped, because it is not supported on the host: %v", event, c.cgroupPath, err)
+	c.skippedEvents[i] = append(c.skippedEvents[i], string(event))
+	return true
+}
+
+// openEvent encodes the event and opens it on every CPU that its leader has
+// been opened on. Name of the event is looked up among custom events first.
+func (c *collector) openEvent(event eventInfo, leaderFileDescriptors map[int]int) (map[int]int, error) {
+	customEvent, ok := c.eventToCustomEvent[Event(event.name)]
+	if ok {
+		event.name = string(customEvent.Name)
+		event.config = c.createConfigFromRawEvent(customEvent)
+		return c.registerEvent(event, leaderFileDescriptors)
+	}
+	config, err := c.createConfigFromEvent(Event(event.name))
+	if err != nil {
+		return nil, err
+	}
+	// Clean memory allocated by C code.
+	defer C.free(unsafe.Pointer(config))
+	event.config = config
+	return c.registerEvent(event, leaderFileDescriptors)
+}
+
+// dropEvent closes files of the event that has been opened on some CPUs of
+// group i only and removes the event from the group.
+func (c *collector) dropEvent(i int, name string) {
+	opened, ok := c.cpuFiles[i]
+	if !ok {
+		return
+	}
+	c.closeGroup(group{cpuFiles: map[string]map[int]readerCloser{name: opened.cpuFiles[name]}})
+	delete(opened.cpuFiles, name)
+	delete(opened.ids, name)
+	names := []string{}
+	for _, have := range opened.names {
+		if have != name {
+			names = append(names, have)
+		}
+	}
+	if len(names) == 0 {
+		delete(c.cpuFiles, i)
+		return
+	}
+	opened.names = names
+	opened.leaderName = names[0]
+	c.cpuFiles[i] = opened
 }
 
+// readPerfEventAttr returns perf_event_attr of the event encoded by libpfm4.
+// Encoding does not change on the host, so each event is encoded once and
+// its copy is returned afterwards, which makes setup of collectors cheaper
+// on hosts with container churn. Returned memory is allocated by C code and
+// it has to be freed by the caller.
 func readPerfEventAttr(name string) (*unix.PerfEventAttr, error) {
+	encoded, err := encodedEvent(name)
+	if err != nil {
+		return nil, err
+	}
+
+	config := (*unix.PerfEventAttr)(C.malloc(C.ulong(unsafe.Sizeof(unix.PerfEventAttr{}))))
+	*config = encoded
+	return config, nil
+}
+
+// encodedEvent returns encoding of the event, which is encoded with libpfm4
+// only the first time it is needed.
+func encodedEvent(name string) (unix.PerfEventAttr, error) {
+	libpmfMutex.Lock()
+	defer libpmfMutex.Unlock()
+
+	encoded, ok := encodedEvents[name]
+	if !ok {
+		var err error
+		encoded, err = encodeEvent(name)
+		if err != nil {
+			return unix.PerfEventAttr{}, err
+		}
+		encodedEvents[name] = encoded
+	}
+	return encoded, nil
+}
+
+// encodePerfEventAttr encodes the event into perf_event_attr with libpfm4.
+func encodePerfEventAttr(name string) (unix.PerfEventAttr, error) {
 	perfEventAttrMemory := C.malloc(C.ulong(unsafe.Sizeof(unix.PerfEventAttr{})))
+	defer C.free(perfEventAttrMemory)
 	event := pfmPerfEncodeArgT{}
 	fstr := C.CString("")
 	event.fstr = unsafe.Pointer(fstr)
 	event.attr = perfEventAttrMemory
 	event.size = C.ulong(unsafe.Sizeof(event))
 	cSafeName := C.CString(name)
+	defer C.free(unsafe.Pointer(cSafeName))
 	pErr := C.pfm_get_os_event_encoding(cSafeName, C.PFM_PLM0|C.PFM_PLM3, C.PFM_OS_PERF_EVENT, unsafe.Pointer(&event))
 	if pErr != C.PFM_SUCCESS {
-		return nil, fmt.Errorf("unable to transform event name %s to perf_event_attr: %d", name, int(pErr))
+		return unix.PerfEventAttr{}, fmt.Errorf("unable to transform event name %s to perf_event_attr: %d", name, int(pErr))
 	}
 
-	return (*unix.PerfEventAttr)(perfEventAttrMemory), nil
+	return *(*unix.PerfEventAttr)(perfEventAttrMemory), nil
 }
 
 type eventInfo struct {
@@ -253,28 +1776,63 @@ type eventInfo struct {
 	pid           int
 	groupIndex    int
 	isGroupLeader bool
+	// Event is opened as a group of its own after its group failed to open.
+	ungrouped bool
 }
 
 func (c *collector) registerEvent(event eventInfo, leaderFileDescriptors map[int]int) (map[int]int, error) {
-	newLeaderFileDescriptors := make(map[int]int, len(c.onlineCPUs))
+	newLeaderFileDescriptors := make(map[int]int, len(c.cpus))
 	var pid, flags int
-	if event.isGroupLeader {
+	switch {
+	case c.pid > 0:
+		// Events of a process, followers included, are opened on its pid,
+		// as all the events of a group have to count the same task.
+		pid = event.pid
+		flags = unix.PERF_FLAG_FD_CLOEXEC
+	case event.isGroupLeader:
 		pid = event.pid
 		flags = unix.PERF_FLAG_FD_CLOEXEC | unix.PERF_FLAG_PID_CGROUP
-	} else {
+	default:
 		pid = -1
 		flags = unix.PERF_FLAG_FD_CLOEXEC
 	}
 
 	setAttributes(event.config, event.isGroupLeader)
+	setInheritanceAttributes(event.config, c.events.Inheritance)
+	setExclusionAttributes(event.config, c.exclusion(Event(event.name)))
+	if event.isGroupLeader && (c.events.Core.Events[event.groupIndex].leaderOnly || event.ungrouped) {
+		// Followers are opened for scheduling purposes only so leader is read on its own.
+		event.config.Read_format &^= unix.PERF_FORMAT_GROUP
+	}
 
-	for _, cpu := range c.onlineCPUs {
-		fd, err := unix.PerfEventOpen(event.config, pid, cpu, leaderFileDescriptors[cpu], flags)
+	var leaderErr error
+	for _, cpu := range c.cpus {
+		leaderFileDescriptor, ok := leaderFileDescriptors[cpu]
+		if !ok {
+			// Leader of the group has not been opened on the CPU.
+			continue
+		}
+		c.openCalls++
+		fd, err := c.perfEventOpen(event.config, pid, cpu, leaderFileDescriptor, flags)
+		if err == unix.EMFILE && raiseFileLimit() {
+			c.openCalls++
+			fd, err = c.perfEventOpen(event.config, pid, cpu, leaderFileDescriptor, flags)
+		}
+		if err == unix.EMFILE {
+			klog.Errorf("Perf event %q of cgroup %q cannot be opened on CPU %d, because limit of open files has been reached: %s. Each event is opened on every CPU, so measure fewer events or raise the limit.", event.name, c.cgroupPath, cpu, describeFileUsage())
+		}
+		if err != nil && event.isGroupLeader && c.events.PartialGroups {
+			klog.Warningf("Perf event group %q of cgroup %q is not set up on CPU %d, because its leader failed to open there: %v", event.name, c.cgroupPath, cpu, err)
+			c.droppedCPUs[event.groupIndex] = append(c.droppedCPUs[event.groupIndex], cpu)
+			leaderErr = err
+			continue
+		}
 		if err != nil {
 			return nil, fmt.Errorf("setting up perf event %#v failed: %q", event.config, err)
 		}
 		perfFile := os.NewFile(uintptr(fd), event.name)
 		if perfFile == nil {
+			unix.Close(fd)
 			return nil, fmt.Errorf("unable to create os.File from file descriptor %#v", fd)
 		}
 
@@ -287,6 +1845,9 @@ func (c *collector) registerEvent(event eventInfo, leaderFileDescriptors map[int
 	}
 
 	if event.isGroupLeader {
+		if len(newLeaderFileDescriptors) == 0 && leaderErr != nil {
+			return nil, fmt.Errorf("setting up perf event %#v failed on all CPUs: %q", event.config, leaderErr)
+		}
 		return newLeaderFileDescriptors, nil
 	}
 	return leaderFileDescriptors, nil
@@ -296,8 +1857,13 @@ func (c *collector) addEventFile(index int, name string, cpu int, perfFile *os.F
 	_, ok := c.cpuFiles[index]
 	if !ok {
 		c.cpuFiles[index] = group{
-			leaderName: name,
-			cpuFiles:   map[string]map[int]readerCloser{},
+			leaderName:      name,
+			leaderOnly:      c.events.Core.Events[index].leaderOnly,
+			perfStatScaling: c.events.PerfStatScaling,
+			cpuFiles:        map[string]map[int]readerCloser{},
+			ids:             map[string]map[int]uint64{},
+			readTimeout:     c.events.Core.Events[index].readTimeout,
+			buffers:         c.readBuffers,
 		}
 	}
 
@@ -317,9 +1883,14 @@ func (c *collector) addEventFile(index int, name string, cpu int, perfFile *os.F
 
 	// Otherwise save it.
 	c.cpuFiles[index] = group{
-		cpuFiles:   c.cpuFiles[index].cpuFiles,
-		names:      append(c.cpuFiles[index].names, name),
-		leaderName: c.cpuFiles[index].leaderName,
+		cpuFiles:        c.cpuFiles[index].cpuFiles,
+		names:           append(c.cpuFiles[index].names, name),
+		leaderName:      c.cpuFiles[index].leaderName,
+		leaderOnly:      c.cpuFiles[index].leaderOnly,
+		perfStatScaling: c.cpuFiles[index].perfStatScaling,
+		ids:             c.cpuFiles[index].ids,
+		readTimeout:     c.cpuFiles[index].readTimeout,
+		buffers:         c.cpuFiles[index].buffers,
 	}
 }
 
@@ -354,22 +1925,75 @@ func setAttributes(config *unix.PerfEventAttr, leader bool) {
 	config.Size = uint32(unsafe.Sizeof(unix.PerfEventAttr{}))
 }
 
+// setInheritanceAttributes sets bits controlling inheritance of core perf
+// event by child tasks, in addition to inherit bit set by setAttributes.
+func setInheritanceAttributes(config *unix.PerfEventAttr, inheritance Inheritance) {
+	if inheritance.Stat {
+		config.Bits |= unix.PerfBitInheritStat
+	}
+	if inheritance.Thread {
+		config.Bits |= perfBitInheritThread
+	}
+}
+
+// setExclusionAttributes sets bits excluding privilege levels that core perf
+// event is not counted at. Event is counted at all the levels by default.
+func setExclusionAttributes(config *unix.PerfEventAttr, exclusion Exclusion) {
+	if exclusion.Kernel {
+		config.Bits |= unix.PerfBitExcludeKernel
+	}
+	if exclusion.User {
+		config.Bits |= unix.PerfBitExcludeUser
+	}
+	if exclusion.Hypervisor {
+		config.Bits |= unix.PerfBitExcludeHv
+	}
+}
+
+// exclusion returns privilege levels that the event is not counted at, as
+// configured with exclusions or in configuration of the custom event.
+func (c *collector) exclusion(event Event) Exclusion {
+	exclusion := c.events.Exclusions[event]
+	if customEvent, ok := c.eventToCustomEvent[event]; ok {
+		exclusion.Kernel = exclusion.Kernel || customEvent.Kernel
+		exclusion.User = exclusion.User || customEvent.User
+		exclusion.Hypervisor = exclusion.Hypervisor || customEvent.Hypervisor
+	}
+	return exclusion
+}
+
 func (c *collector) Destroy() {
+	if c.unregister != nil {
+		c.unregister()
+	}
 	c.uncore.Destroy()
 	c.cpuFilesLock.Lock()
 	defer c.cpuFilesLock.Unlock()
 
+	c.closeEvents()
+}
+
+// closeEvents closes core perf events of all the groups.
+func (c *collector) closeEvents() {
 	for _, group := range c.cpuFiles {
-		for name, files := range group.cpuFiles {
-			for cpu, file := range files {
-				klog.V(5).Infof("Closing perf_event file descriptor for cgroup %q, event %q and CPU %d", c.cgroupPath, name, cpu)
-				err := file.Close()
-				if err != nil {
-					klog.Warningf("Unable to close perf_event file descriptor for cgroup %q, event %q and CPU %d", c.cgroupPath, name, cpu)
-				}
+		c.closeGroup(group)
+	}
+	c.generation++
+	// Abandoned reads refer to closed files, so their results are not needed.
+	c.pendingReads = nil
+}
+
+// closeGroup closes events of the group on all CPUs.
+func (c *collector) closeGroup(group group) {
+	for name, files := range group.cpuFiles {
+		for cpu, file := range files {
+			klog.V(5).Infof("Closing perf_event file descriptor for cgroup %q, event %q and CPU %d", c.cgroupPath, name, cpu)
+			err := file.Close()
+			if err != nil {
+				klog.Warningf("Unable to close perf_event file descriptor for cgroup %q, event %q and CPU %d", c.cgroupPath, name, cpu)
 			}
-			delete(group.cpuFiles, name)
 		}
+		delete(group.cpuFiles, name)
 	}
 }
 
@@ -393,6 +2017,52 @@ func mapEventsToCustomEvents(collector *collector) {
 	for key, event := range collector.events.Core.CustomEvents {
 		collector.eventToCustomEvent[event.Name] = &collector.events.Core.CustomEvents[key]
 	}
+	for _, event := range collector.events.Core.SoftwareEvents {
+		customEvent := softwareToCustomEvent(event)
+		collector.eventToCustomEvent[event.Name] = &customEvent
+	}
+}
+
+// softwareToCustomEvent converts software event to custom event, so it is
+// set up without libpfm4 encoding.
+func softwareToCustomEvent(event SoftwareEvent) CustomEvent {
+	return CustomEvent{
+		Type:   unix.PERF_TYPE_SOFTWARE,
+		Config: Config{event.Config},
+		Name:   event.Name,
+	}
+}
+
+// validateSoftwareEvents checks if software events can be counted.
+// PERF_COUNT_SW_DUMMY and PERF_COUNT_SW_BPF_OUTPUT are rejected as they
+// do not count anything.
+func validateSoftwareEvents(events PerfEvents) error {
+	if len(events.Uncore.SoftwareEvents) > 0 {
+		return fmt.Errorf("software events can be measured only as core events")
+	}
+	for _, event := range events.Core.SoftwareEvents {
+		if event.Config >= unix.PERF_COUNT_SW_MAX || event.Config == unix.PERF_COUNT_SW_DUMMY || event.Config == unix.PERF_COUNT_SW_BPF_OUTPUT {
+			return fmt.Errorf("software event %q has unsupported config %d", event.Name, event.Config)
+		}
+	}
+	return nil
+}
+
+// validateRotation checks if rotation of groups can be combined with other
+// options. Rotated groups are reset each time they are enabled, so their
+// values are not cumulative and neither increases nor histograms of
+// increases can be computed from them.
+func validateRotation(events PerfEvents) error {
+	if !events.Rotation {
+		return nil
+	}
+	if events.Delta {
+		return fmt.Errorf("rotation cannot be combined with delta")
+	}
+	if len(events.HistogramBuckets) > 0 {
+		return fmt.Errorf("rotation cannot be combined with histogram buckets")
+	}
+	return nil
 }
 
 func (c *collector) createConfigFromRawEvent(event *CustomEvent) *unix.PerfEventAttr {
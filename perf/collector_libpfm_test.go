@@ -20,9 +20,22 @@ package perf
 import (
 	"bytes"
 	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
+	"unsafe"
 
 	"github.com/stretchr/testify/assert"
+	"golang.org/x/sys/unix"
 
 	info "github.com/google/cadvisor/info/v1"
 	"github.com/google/cadvisor/stats"
@@ -116,7 +129,10 @@ func TestCollector_UpdateStats(t *testing.T) {
 		PerfValue: info.PerfValue{
 			ScalingRatio: 0.3333333333333333,
 			Value:        999999999,
+			RawValue:     333333333,
 			Name:         "cycles",
+			TimeEnabled:  3,
+			TimeRunning:  1,
 		},
 		Cpu: 11,
 	})
@@ -124,7 +140,10 @@ func TestCollector_UpdateStats(t *testing.T) {
 		PerfValue: info.PerfValue{
 			ScalingRatio: 1,
 			Value:        123456789,
+			RawValue:     123456789,
 			Name:         "instructions",
+			TimeEnabled:  100,
+			TimeRunning:  100,
 		},
 		Cpu: 0,
 	})
@@ -132,7 +151,10 @@ func TestCollector_UpdateStats(t *testing.T) {
 		PerfValue: info.PerfValue{
 			ScalingRatio: 1.0,
 			Value:        123456,
+			RawValue:     123456,
 			Name:         "cache-misses",
+			TimeEnabled:  100,
+			TimeRunning:  100,
 		},
 		Cpu: 0,
 	})
@@ -140,7 +162,10 @@ func TestCollector_UpdateStats(t *testing.T) {
 		PerfValue: info.PerfValue{
 			ScalingRatio: 1.0,
 			Value:        654321,
+			RawValue:     654321,
 			Name:         "cache-references",
+			TimeEnabled:  100,
+			TimeRunning:  100,
 		},
 		Cpu: 0,
 	})
@@ -161,6 +186,67 @@ func TestCreatePerfEventAttr(t *testing.T) {
 	assert.Equal(t, uint64(4), attributes.Ext2)
 }
 
+func TestCreateSoftwarePerfEventAttr(t *testing.T) {
+	collector := newCollector("cgroup", PerfEvents{
+		Core: Events{
+			Events:         []Group{{events: []Event{"context_switches"}}},
+			SoftwareEvents: []SoftwareEvent{{Config: unix.PERF_COUNT_SW_CONTEXT_SWITCHES, Name: "context_switches"}},
+		},
+	}, []int{0}, map[int]int{0: 0}, map[int]physicalCore{})
+
+	customEvent, ok := collector.eventToCustomEvent["context_switches"]
+	assert.True(t, ok)
+
+	attributes := collector.createConfigFromRawEvent(customEvent)
+
+	assert.Equal(t, uint32(unix.PERF_TYPE_SOFTWARE), attributes.Type)
+	assert.Equal(t, uint64(unix.PERF_COUNT_SW_CONTEXT_SWITCHES), attributes.Config)
+	assert.Equal(t, uint64(0), attributes.Ext1)
+}
+
+func TestValidateSoftwareEvents(t *testing.T) {
+	var testCases = []struct {
+		name   string
+		events PerfEvents
+		valid  bool
+	}{
+		{
+			name: "context switches and page faults",
+			events: PerfEvents{Core: Events{SoftwareEvents: []SoftwareEvent{
+				{Config: unix.PERF_COUNT_SW_CONTEXT_SWITCHES, Name: "context_switches"},
+				{Config: unix.PERF_COUNT_SW_PAGE_FAULTS, Name: "page_faults"},
+			}}},
+			valid: true,
+		},
+		{
+			name:   "unknown config",
+			events: PerfEvents{Core: Events{SoftwareEvents: []SoftwareEvent{{Config: unix.PERF_COUNT_SW_MAX, Name: "unknown"}}}},
+			valid:  false,
+		},
+		{
+			name:   "dummy",
+			events: PerfEvents{Core: Events{SoftwareEvents: []SoftwareEvent{{Config: unix.PERF_COUNT_SW_DUMMY, Name: "dummy"}}}},
+			valid:  false,
+		},
+		{
+			name:   "uncore",
+			events: PerfEvents{Uncore: Events{SoftwareEvents: []SoftwareEvent{{Config: unix.PERF_COUNT_SW_CONTEXT_SWITCHES, Name: "context_switches"}}}},
+			valid:  false,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(tt *testing.T) {
+			err := validateSoftwareEvents(testCase.events)
+			if testCase.valid {
+				assert.NoError(tt, err)
+			} else {
+				assert.Error(tt, err)
+			}
+		})
+	}
+}
+
 func TestSetGroupAttributes(t *testing.T) {
 	event := CustomEvent{
 		Type:   0x1,
@@ -181,17 +267,97 @@ func TestSetGroupAttributes(t *testing.T) {
 	assert.Equal(t, uint64(0x2), attributes.Bits)
 }
 
+func TestSetInheritanceAttributes(t *testing.T) {
+	testCases := []struct {
+		inheritance Inheritance
+		bits        uint64
+	}{
+		{Inheritance{}, unix.PerfBitInherit},
+		{Inheritance{Stat: true}, unix.PerfBitInherit | unix.PerfBitInheritStat},
+		{Inheritance{Thread: true}, unix.PerfBitInherit | perfBitInheritThread},
+		{Inheritance{Stat: true, Thread: true}, unix.PerfBitInherit | unix.PerfBitInheritStat | perfBitInheritThread},
+	}
+
+	for _, tc := range testCases {
+		attributes := createPerfEventAttr(CustomEvent{Type: 0x1, Config: Config{0x2}, Name: "fake_event"})
+		setAttributes(attributes, false)
+		setInheritanceAttributes(attributes, tc.inheritance)
+		assert.Equal(t, tc.bits, attributes.Bits, "%+v", tc.inheritance)
+		assert.Equal(t, tc.inheritance.Stat, attributes.Bits&unix.PerfBitInheritStat != 0)
+		assert.Equal(t, tc.inheritance.Thread, attributes.Bits&(1<<35) != 0)
+	}
+}
+
+func TestSetExclusionAttributes(t *testing.T) {
+	testCases := []struct {
+		exclusion Exclusion
+		bits      uint64
+	}{
+		{Exclusion{}, unix.PerfBitInherit},
+		{Exclusion{Kernel: true}, unix.PerfBitInherit | unix.PerfBitExcludeKernel},
+		{Exclusion{User: true}, unix.PerfBitInherit | unix.PerfBitExcludeUser},
+		{Exclusion{Hypervisor: true}, unix.PerfBitInherit | unix.PerfBitExcludeHv},
+		{Exclusion{Kernel: true, Hypervisor: true}, unix.PerfBitInherit | unix.PerfBitExcludeKernel | unix.PerfBitExcludeHv},
+	}
+
+	for _, tc := range testCases {
+		attributes := createPerfEventAttr(CustomEvent{Type: 0x1, Config: Config{0x2}, Name: "fake_event"})
+		setAttributes(attributes, false)
+		setExclusionAttributes(attributes, tc.exclusion)
+		assert.Equal(t, tc.bits, attributes.Bits, "%+v", tc.exclusion)
+	}
+}
+
+func TestCollector_SetupExclusions(t *testing.T) {
+	cgroupPath, err := ioutil.TempDir("", "perf_event")
+	assert.NoError(t, err)
+	defer os.RemoveAll(cgroupPath)
+
+	excluded := uint64(unix.PerfBitExcludeKernel | unix.PerfBitExcludeUser | unix.PerfBitExcludeHv)
+	bits := []uint64{}
+	collector := newCollector(cgroupPath, PerfEvents{
+		Core: Events{
+			Events: []Group{{events: []Event{"instructions", "cycles", "cache-misses"}}},
+			CustomEvents: []CustomEvent{{
+				Type:      unix.PERF_TYPE_HARDWARE,
+				Config:    Config{unix.PERF_COUNT_HW_INSTRUCTIONS},
+				Name:      "instructions",
+				Exclusion: Exclusion{Kernel: true},
+			}},
+		},
+		Exclusions: map[Event]Exclusion{
+			"instructions": {Hypervisor: true},
+			"cycles":       {User: true},
+		},
+	}, []int{0}, map[int]int{}, map[int]physicalCore{})
+	collector.perfEventOpen = func(attr *unix.PerfEventAttr, pid int, cpu int, groupFd int, flags int) (int, error) {
+		bits = append(bits, attr.Bits&excluded)
+		return unix.Open(os.DevNull, unix.O_RDONLY, 0)
+	}
+	collector.ioctlSetInt = func(fd int, req uint, value int) error {
+		return nil
+	}
+	defer collector.Destroy()
+
+	err = collector.setup()
+	assert.NoError(t, err)
+	// Exclusions of custom event are combined with the ones configured by
+	// its name and event without exclusions is counted at all privilege
+	// levels.
+	assert.Equal(t, []uint64{unix.PerfBitExcludeKernel | unix.PerfBitExcludeHv, unix.PerfBitExcludeUser, 0}, bits)
+}
+
 func TestNewCollector(t *testing.T) {
 	perfCollector := newCollector("cgroup", PerfEvents{
 		Core: Events{
-			Events: []Group{{[]Event{"event_1"}, false}, {[]Event{"event_2"}, false}},
+			Events: []Group{{events: []Event{"event_1"}, array: false}, {events: []Event{"event_2"}, array: false}},
 			CustomEvents: []CustomEvent{{
 				Type:   0,
 				Config: []uint64{1, 2, 3},
 				Name:   "event_2",
 			}},
 		},
-	}, []int{0, 1, 2, 3}, map[int]int{})
+	}, []int{0, 1, 2, 3}, map[int]int{}, map[int]physicalCore{})
 	assert.Len(t, perfCollector.eventToCustomEvent, 1)
 	assert.Nil(t, perfCollector.eventToCustomEvent[Event("event_1")])
 	assert.Same(t, &perfCollector.events.Core.CustomEvents[0], perfCollector.eventToCustomEvent[Event("event_2")])
@@ -223,6 +389,7 @@ var readGroupPerfStatCases = []struct {
 			PerfValue: info.PerfValue{
 				ScalingRatio: 1,
 				Value:        5,
+				RawValue:     5,
 				Name:         "some metric",
 			},
 			Cpu: 1,
@@ -246,7 +413,9 @@ var readGroupPerfStatCases = []struct {
 			PerfValue: info.PerfValue{
 				ScalingRatio: 1,
 				Value:        5,
+				RawValue:     5,
 				Name:         "some metric",
+				TimeRunning:  1,
 			},
 			Cpu: 1,
 		}},
@@ -269,7 +438,10 @@ var readGroupPerfStatCases = []struct {
 			PerfValue: info.PerfValue{
 				ScalingRatio: 0.5,
 				Value:        8,
+				RawValue:     4,
 				Name:         "some metric",
+				TimeEnabled:  4,
+				TimeRunning:  2,
 			},
 			Cpu: 2,
 		}},
@@ -292,7 +464,9 @@ var readGroupPerfStatCases = []struct {
 			PerfValue: info.PerfValue{
 				ScalingRatio: 1.0,
 				Value:        4,
+				RawValue:     4,
 				Name:         "some metric",
+				TimeEnabled:  1,
 			},
 			Cpu: 3,
 		}},
@@ -315,7 +489,9 @@ var readGroupPerfStatCases = []struct {
 			PerfValue: info.PerfValue{
 				ScalingRatio: 1.0,
 				Value:        4,
+				RawValue:     4,
 				Name:         "some metric",
+				TimeRunning:  1,
 			},
 			Cpu: 3,
 		}},
@@ -362,6 +538,7 @@ var readGroupPerfStatCases = []struct {
 				ScalingRatio: 1.0,
 				Value:        0,
 				Name:         "some metric",
+				TimeRunning:  3,
 			},
 			Cpu: 4,
 		}},
@@ -387,3 +564,2784 @@ func TestReadPerfStat(t *testing.T) {
 		})
 	}
 }
+
+func TestReadPerfStatLeaderOnly(t *testing.T) {
+	buf := &buffer{bytes.NewBuffer([]byte{})}
+	err := binary.Write(buf, binary.LittleEndian, ReadFormat{
+		Value:       10,
+		TimeEnabled: 4,
+		TimeRunning: 2,
+		ID:          0,
+	})
+	assert.NoError(t, err)
+
+	stat, err := readGroupPerfStat(buf, group{
+		cpuFiles:   nil,
+		names:      []string{"instructions", "cycles"},
+		leaderName: "instructions",
+		leaderOnly: true,
+	}, 1, "/")
+
+	assert.NoError(t, err)
+	assert.Equal(t, []info.PerfStat{{
+		PerfValue: info.PerfValue{
+			ScalingRatio: 0.5,
+			Value:        20,
+			RawValue:     10,
+			Name:         "instructions",
+			TimeEnabled:  4,
+			TimeRunning:  2,
+		},
+		Cpu: 1,
+	}}, stat)
+}
+
+// healthyEvent simulates reading perf event that is not in error state with too small buffer.
+type healthyEvent struct{}
+
+func (h healthyEvent) Read(p []byte) (int, error) {
+	return 0, unix.ENOSPC
+}
+
+func (h healthyEvent) Close() error {
+	return nil
+}
+
+func TestReadPerfStatErroredFollower(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		follower readerCloser
+		nr       uint64
+		values   []Values
+		expected []info.PerfStat
+	}{
+		{
+			name:     "follower in error state",
+			follower: buffer{bytes.NewBuffer([]byte{})},
+			nr:       2,
+			values:   []Values{{Value: 100, ID: 1}, {Value: 0, ID: 2}},
+			expected: []info.PerfStat{
+				{PerfValue: info.PerfValue{ScalingRatio: 1, Value: 100, RawValue: 100, Name: "instructions", TimeEnabled: 10, TimeRunning: 10}, Cpu: 1},
+				{PerfValue: info.PerfValue{ScalingRatio: 1, Name: "cycles", Errored: true}, Cpu: 1},
+			},
+		},
+		{
+			name:     "follower that did not count",
+			follower: healthyEvent{},
+			nr:       2,
+			values:   []Values{{Value: 100, ID: 1}, {Value: 0, ID: 2}},
+			expected: []info.PerfStat{
+				{PerfValue: info.PerfValue{ScalingRatio: 1, Value: 100, RawValue: 100, Name: "instructions", TimeEnabled: 10, TimeRunning: 10}, Cpu: 1},
+				{PerfValue: info.PerfValue{ScalingRatio: 1, Value: 0, Name: "cycles", TimeEnabled: 10, TimeRunning: 10}, Cpu: 1},
+			},
+		},
+		{
+			name:     "follower missing from the read",
+			follower: healthyEvent{},
+			nr:       1,
+			values:   []Values{{Value: 100, ID: 1}},
+			expected: []info.PerfStat{
+				{PerfValue: info.PerfValue{ScalingRatio: 1, Value: 100, RawValue: 100, Name: "instructions", TimeEnabled: 10, TimeRunning: 10}, Cpu: 1},
+				{PerfValue: info.PerfValue{ScalingRatio: 1, Name: "cycles", Errored: true}, Cpu: 1},
+			},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			buf := &buffer{bytes.NewBuffer([]byte{})}
+			err := binary.Write(buf, binary.LittleEndian, GroupReadFormat{Nr: test.nr, TimeEnabled: 10, TimeRunning: 10})
+			assert.NoError(t, err)
+			err = binary.Write(buf, binary.LittleEndian, test.values)
+			assert.NoError(t, err)
+
+			stat, err := readGroupPerfStat(buf, group{
+				cpuFiles: map[string]map[int]readerCloser{
+					"instructions": {1: buf},
+					"cycles":       {1: test.follower},
+				},
+				names:      []string{"instructions", "cycles"},
+				leaderName: "instructions",
+			}, 1, "/")
+			assert.NoError(t, err)
+			assert.Equal(t, test.expected, stat)
+		})
+	}
+}
+
+func TestCollector_UpdateStatsDelta(t *testing.T) {
+	buf := buffer{bytes.NewBuffer([]byte{})}
+	collector := collector{
+		uncore: &stats.NoopCollector{},
+		events: PerfEvents{Delta: true},
+		differ: newDiffer(),
+		cpuFiles: map[int]group{
+			0: {
+				cpuFiles: map[string]map[int]readerCloser{
+					"instructions": {0: buf},
+				},
+				names:      []string{"instructions"},
+				leaderName: "instructions",
+			},
+		},
+	}
+
+	for _, test := range []struct {
+		value    uint64
+		expected uint64
+	}{
+		{value: 100, expected: 100},
+		{value: 250, expected: 150},
+		{value: 250, expected: 0},
+		// Counter went backwards so it has been reset.
+		{value: 50, expected: 50},
+		{value: 60, expected: 10},
+	} {
+		err := binary.Write(buf, binary.LittleEndian, GroupReadFormat{Nr: 1, TimeEnabled: 1, TimeRunning: 1})
+		assert.NoError(t, err)
+		err = binary.Write(buf, binary.LittleEndian, Values{Value: test.value})
+		assert.NoError(t, err)
+
+		stats := &info.ContainerStats{}
+		err = collector.UpdateStats(stats)
+		assert.NoError(t, err)
+		assert.Equal(t, []info.PerfStat{{
+			PerfValue: info.PerfValue{
+				ScalingRatio: 1,
+				Value:        test.expected,
+				RawValue:     test.value,
+				Name:         "instructions",
+				TimeEnabled:  1,
+				TimeRunning:  1,
+			},
+			Cpu:   0,
+			Delta: true,
+		}}, stats.PerfStats)
+	}
+}
+
+func TestCollector_UpdateStatsStartTime(t *testing.T) {
+	buf := buffer{bytes.NewBuffer([]byte{})}
+	startTime := time.Date(2020, time.October, 1, 12, 0, 0, 0, time.UTC)
+	collector := collector{
+		uncore:    &stats.NoopCollector{},
+		startTime: startTime,
+		cpuFiles: map[int]group{
+			0: {
+				cpuFiles: map[string]map[int]readerCloser{
+					"instructions": {0: buf},
+				},
+				names:      []string{"instructions"},
+				leaderName: "instructions",
+			},
+		},
+	}
+	err := binary.Write(buf, binary.LittleEndian, GroupReadFormat{Nr: 1, TimeEnabled: 1, TimeRunning: 1})
+	assert.NoError(t, err)
+	err = binary.Write(buf, binary.LittleEndian, Values{Value: 42})
+	assert.NoError(t, err)
+
+	stats := &info.ContainerStats{}
+	err = collector.UpdateStats(stats)
+	assert.NoError(t, err)
+	assert.Equal(t, []info.PerfStat{{
+		PerfValue: info.PerfValue{
+			ScalingRatio: 1,
+			Value:        42,
+			RawValue:     42,
+			Name:         "instructions",
+			TimeEnabled:  1,
+			TimeRunning:  1,
+		},
+		Cpu:       0,
+		StartTime: &startTime,
+	}}, stats.PerfStats)
+}
+
+func TestCollector_UpdateStatsReopened(t *testing.T) {
+	// Ids are tracked the same way when reads are made with a timeout.
+	for _, readTimeout := range []time.Duration{0, time.Second} {
+		buf := buffer{bytes.NewBuffer([]byte{})}
+		collector := collector{
+			uncore: &stats.NoopCollector{},
+			cpuFiles: map[int]group{
+				0: {
+					cpuFiles: map[string]map[int]readerCloser{
+						"instructions": {0: buf},
+					},
+					names:       []string{"instructions"},
+					leaderName:  "instructions",
+					ids:         map[string]map[int]uint64{},
+					readTimeout: readTimeout,
+				},
+			},
+		}
+
+		_, ok := collector.EventID(0, "instructions", 0)
+		assert.False(t, ok)
+
+		// Event is reopened between the second and the third read.
+		for _, read := range []struct {
+			id       uint64
+			reopened bool
+		}{
+			{id: 1, reopened: false},
+			{id: 1, reopened: false},
+			{id: 2, reopened: true},
+			{id: 2, reopened: false},
+		} {
+			err := binary.Write(buf, binary.LittleEndian, GroupReadFormat{Nr: 1, TimeEnabled: 1, TimeRunning: 1})
+			assert.NoError(t, err)
+			err = binary.Write(buf, binary.LittleEndian, Values{Value: 42, ID: read.id})
+			assert.NoError(t, err)
+
+			stats := &info.ContainerStats{}
+			err = collector.UpdateStats(stats)
+			assert.NoError(t, err)
+			assert.Len(t, stats.PerfStats, 1)
+			assert.Equal(t, read.reopened, stats.PerfStats[0].Reopened)
+
+			id, ok := collector.EventID(0, "instructions", 0)
+			assert.True(t, ok)
+			assert.Equal(t, read.id, id)
+		}
+	}
+}
+
+func TestCollector_UpdateStatsThreshold(t *testing.T) {
+	buf := buffer{bytes.NewBuffer([]byte{})}
+	fired := []Threshold{}
+	threshold := Threshold{Event: "cache-misses", Comparator: ">", Value: 1000}
+	collector := collector{
+		cgroupPath: "/sys/fs/cgroup/perf_event/container",
+		uncore:     &stats.NoopCollector{},
+		thresholds: newThresholdTracker([]Threshold{threshold}),
+		thresholdCallback: func(cgroupPath string, threshold Threshold, value uint64) {
+			assert.Equal(t, "/sys/fs/cgroup/perf_event/container", cgroupPath)
+			assert.Equal(t, uint64(5000), value)
+			fired = append(fired, threshold)
+		},
+		cpuFiles: map[int]group{
+			0: {
+				cpuFiles: map[string]map[int]readerCloser{
+					"cache-misses": {0: buf},
+				},
+				names:      []string{"cache-misses"},
+				leaderName: "cache-misses",
+			},
+		},
+	}
+	err := binary.Write(buf, binary.LittleEndian, GroupReadFormat{Nr: 1, TimeEnabled: 1, TimeRunning: 1})
+	assert.NoError(t, err)
+	err = binary.Write(buf, binary.LittleEndian, Values{Value: 5000})
+	assert.NoError(t, err)
+
+	err = collector.UpdateStats(&info.ContainerStats{})
+	assert.NoError(t, err)
+	assert.Equal(t, []Threshold{threshold}, fired)
+}
+
+func TestCollector_UpdateStatsThresholdCallbackTakesSnapshot(t *testing.T) {
+	buf := buffer{bytes.NewBuffer([]byte{})}
+	m := &manager{collectors: map[string]managedCollector{}}
+	snapshots := []Snapshot{}
+	collector := &collector{
+		cgroupPath: "/sys/fs/cgroup/perf_event/container",
+		uncore:     &stats.NoopCollector{},
+		thresholds: newThresholdTracker([]Threshold{{Event: "cache-misses", Comparator: ">", Value: 1000}}),
+		// Callback is called without holding the lock of the collector,
+		// which taking a snapshot needs.
+		thresholdCallback: func(cgroupPath string, threshold Threshold, value uint64) {
+			snapshots = append(snapshots, m.Snapshot())
+		},
+		cpuFiles: map[int]group{
+			0: {
+				cpuFiles: map[string]map[int]readerCloser{
+					"cache-misses": {0: buf},
+				},
+				names:      []string{"cache-misses"},
+				leaderName: "cache-misses",
+			},
+		},
+	}
+	m.collectors[collector.cgroupPath] = collector
+	err := binary.Write(buf, binary.LittleEndian, GroupReadFormat{Nr: 1, TimeEnabled: 1, TimeRunning: 1})
+	assert.NoError(t, err)
+	err = binary.Write(buf, binary.LittleEndian, Values{Value: 5000})
+	assert.NoError(t, err)
+
+	err = collector.UpdateStats(&info.ContainerStats{})
+	assert.NoError(t, err)
+	assert.Len(t, snapshots, 1)
+	assert.Contains(t, snapshots[0].PerfStats, collector.cgroupPath)
+}
+
+func TestCollector_UpdateStatsFrequency(t *testing.T) {
+	instructions := buffer{bytes.NewBuffer([]byte{})}
+	cycles := buffer{bytes.NewBuffer([]byte{})}
+	reads := map[int]int{}
+	collector := collector{
+		uncore: &stats.NoopCollector{},
+		events: PerfEvents{Frequency: true},
+		readFrequency: func(cpu int) (uint64, error) {
+			reads[cpu]++
+			if cpu == 1 {
+				return 0, fmt.Errorf("cpufreq is not available")
+			}
+			return 2300000, nil
+		},
+		cpuFiles: map[int]group{
+			0: {
+				cpuFiles: map[string]map[int]readerCloser{
+					"instructions": {0: instructions},
+				},
+				names:      []string{"instructions"},
+				leaderName: "instructions",
+			},
+			1: {
+				cpuFiles: map[string]map[int]readerCloser{
+					"cycles": {0: cycles, 1: cycles},
+				},
+				names:      []string{"cycles"},
+				leaderName: "cycles",
+			},
+		},
+	}
+	for _, buf := range []buffer{instructions, cycles, cycles} {
+		err := binary.Write(buf, binary.LittleEndian, GroupReadFormat{Nr: 1, TimeEnabled: 1, TimeRunning: 1})
+		assert.NoError(t, err)
+		err = binary.Write(buf, binary.LittleEndian, Values{Value: 42})
+		assert.NoError(t, err)
+	}
+
+	stats := &info.ContainerStats{}
+	err := collector.UpdateStats(stats)
+	assert.NoError(t, err)
+	assert.Len(t, stats.PerfStats, 3)
+	for _, stat := range stats.PerfStats {
+		if stat.Cpu == 0 {
+			assert.Equal(t, uint64(2300000), stat.Frequency)
+		} else {
+			assert.Equal(t, uint64(0), stat.Frequency)
+		}
+	}
+	// Frequency is read once for each CPU.
+	assert.Equal(t, map[int]int{0: 1, 1: 1}, reads)
+}
+
+// recordingSink records perf events written to it.
+type recordingSink struct {
+	cgroupPaths []string
+	perfStats   [][]info.PerfStat
+	timestamps  []time.Time
+	// Called on each write if set.
+	write func()
+}
+
+func (r *recordingSink) Write(cgroupPath string, perfStats []info.PerfStat, timestamp time.Time) error {
+	if r.write != nil {
+		r.write()
+	}
+	r.cgroupPaths = append(r.cgroupPaths, cgroupPath)
+	r.perfStats = append(r.perfStats, perfStats)
+	r.timestamps = append(r.timestamps, timestamp)
+	return nil
+}
+
+func TestCollector_UpdateStatsSink(t *testing.T) {
+	readTime := time.Date(2020, time.October, 1, 12, 0, 0, 0, time.UTC)
+	originalNow := now
+	defer func() {
+		now = originalNow
+	}()
+	now = func() time.Time {
+		return readTime
+	}
+
+	buf := buffer{bytes.NewBuffer([]byte{})}
+	sink := &recordingSink{}
+	RegisterSink(sink)
+	defer RegisterSink(nil)
+
+	collector := newCollector("/sys/fs/cgroup/perf_event/container", PerfEvents{}, []int{0}, map[int]int{0: 0}, map[int]physicalCore{})
+	collector.uncore = &stats.NoopCollector{}
+	collector.cpuFiles = map[int]group{
+		0: {
+			cpuFiles: map[string]map[int]readerCloser{
+				"instructions": {0: buf},
+			},
+			names:      []string{"instructions"},
+			leaderName: "instructions",
+		},
+	}
+	err := binary.Write(buf, binary.LittleEndian, GroupReadFormat{Nr: 1, TimeEnabled: 1, TimeRunning: 1})
+	assert.NoError(t, err)
+	err = binary.Write(buf, binary.LittleEndian, Values{Value: 42})
+	assert.NoError(t, err)
+
+	// Sink is written without holding the lock, so it can use the
+	// collector.
+	snapshots := 0
+	sink.write = func() {
+		collector.snapshot()
+		snapshots++
+	}
+	stats := &info.ContainerStats{}
+	err = collector.UpdateStats(stats)
+	assert.NoError(t, err)
+
+	assert.Equal(t, []string{"/sys/fs/cgroup/perf_event/container"}, sink.cgroupPaths)
+	assert.Equal(t, [][]info.PerfStat{stats.PerfStats}, sink.perfStats)
+	assert.Equal(t, []time.Time{readTime}, sink.timestamps)
+	assert.Equal(t, 1, snapshots)
+	// Sink receives a copy of the stats.
+	sink.perfStats[0][0].Value = 0
+	assert.Equal(t, uint64(42), stats.PerfStats[0].Value)
+}
+
+func TestScaleValueAsPerfStat(t *testing.T) {
+	for _, test := range []struct {
+		name          string
+		value         uint64
+		timeEnabled   uint64
+		timeRunning   uint64
+		expectedValue uint64
+		expectedRatio float64
+	}{
+		// Hand-computed with perf_counts_values__scale() arithmetic.
+		{"not scaled", 123456789, 1000, 1000, 123456789, 1},
+		{"scaled", 1000, 3, 1, 3000, 1.0 / 3},
+		{"scaled with rounding", 7, 10, 3, 23, 0.3},
+		// 9007199254740993 * 3 / 2 loses precision in float64 as in perf.
+		{"large value", 9007199254740993, 3, 2, 13510798882111488, 2.0 / 3},
+		{"not running", 100, 1000, 0, 0, 0},
+		{"running longer than enabled", 100, 10, 20, 100, 2},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			value, ratio := scaleValue(test.value, test.timeEnabled, test.timeRunning, true)
+			assert.Equal(t, test.expectedValue, value)
+			assert.InDelta(t, test.expectedRatio, ratio, 1e-9)
+		})
+	}
+}
+
+func TestReadPerfStatPerfStatScaling(t *testing.T) {
+	for _, test := range []struct {
+		perfStatScaling bool
+		expected        uint64
+	}{
+		// 5 / (5 / 29) = 28.999999999999996
+		{perfStatScaling: false, expected: 28},
+		// 5 * 29 / 5 = 29
+		{perfStatScaling: true, expected: 29},
+	} {
+		buf := &buffer{bytes.NewBuffer([]byte{})}
+		err := binary.Write(buf, binary.LittleEndian, GroupReadFormat{Nr: 1, TimeEnabled: 29, TimeRunning: 5})
+		assert.NoError(t, err)
+		err = binary.Write(buf, binary.LittleEndian, Values{Value: 5})
+		assert.NoError(t, err)
+
+		stat, err := readGroupPerfStat(buf, group{
+			cpuFiles:        map[string]map[int]readerCloser{"instructions": {0: buf}},
+			names:           []string{"instructions"},
+			leaderName:      "instructions",
+			perfStatScaling: test.perfStatScaling,
+		}, 0, "/")
+		assert.NoError(t, err)
+		assert.Len(t, stat, 1)
+		assert.Equal(t, test.expected, stat[0].Value)
+	}
+}
+
+func TestCollector_UpdateStatsHistogram(t *testing.T) {
+	buf := buffer{bytes.NewBuffer([]byte{})}
+	collector := collector{
+		uncore:    &stats.NoopCollector{},
+		events:    PerfEvents{HistogramBuckets: []uint64{10, 100}},
+		histogram: newHistogram([]uint64{10, 100}),
+		cpuFiles: map[int]group{
+			0: {
+				cpuFiles: map[string]map[int]readerCloser{
+					"cache-misses": {0: buf},
+				},
+				names:      []string{"cache-misses"},
+				leaderName: "cache-misses",
+			},
+		},
+	}
+
+	// Increases: 5, 5, 50, 500, 10, 100, 101.
+	values := []uint64{5, 10, 60, 560, 570, 670, 771}
+	stats := &info.ContainerStats{}
+	for _, value := range values {
+		err := binary.Write(buf, binary.LittleEndian, GroupReadFormat{Nr: 1, TimeEnabled: 1, TimeRunning: 1})
+		assert.NoError(t, err)
+		err = binary.Write(buf, binary.LittleEndian, Values{Value: value})
+		assert.NoError(t, err)
+
+		err = collector.UpdateStats(stats)
+		assert.NoError(t, err)
+	}
+
+	assert.Len(t, stats.PerfStats, 1)
+	assert.Equal(t, uint64(771), stats.PerfStats[0].Value)
+	assert.Equal(t, []info.PerfHistogramBucket{
+		{UpperBound: 10, Count: 3},
+		{UpperBound: 100, Count: 2},
+		{UpperBound: math.MaxUint64, Count: 2},
+	}, stats.PerfStats[0].Histogram)
+}
+
+// slowBuffer simulates reading perf event that takes long time.
+type slowBuffer struct {
+	buffer
+	delay time.Duration
+}
+
+func (s slowBuffer) Read(p []byte) (int, error) {
+	time.Sleep(s.delay)
+	return s.buffer.Read(p)
+}
+
+func TestCollector_UpdateStatsReadTimeout(t *testing.T) {
+	newGroup := func(name string) group {
+		buf := slowBuffer{buffer: buffer{bytes.NewBuffer([]byte{})}, delay: 20 * time.Millisecond}
+		err := binary.Write(buf.buffer, binary.LittleEndian, GroupReadFormat{Nr: 1, TimeEnabled: 1, TimeRunning: 1})
+		assert.NoError(t, err)
+		err = binary.Write(buf.buffer, binary.LittleEndian, Values{Value: 42})
+		assert.NoError(t, err)
+		return group{
+			cpuFiles:   map[string]map[int]readerCloser{name: {0: buf}},
+			names:      []string{name},
+			leaderName: name,
+		}
+	}
+	collector := collector{
+		uncore: &stats.NoopCollector{},
+		events: PerfEvents{ReadTimeout: Duration(5 * time.Millisecond)},
+		cpuFiles: map[int]group{
+			0: newGroup("instructions"),
+			1: newGroup("cycles"),
+		},
+	}
+
+	stats := &info.ContainerStats{}
+	err := collector.UpdateStats(stats)
+	assert.NoError(t, err)
+	// Reading the first group exceeds timeout so the second one is skipped.
+	assert.Len(t, stats.PerfStats, 1)
+	assert.True(t, stats.PerfStatsTruncated)
+}
+
+// clockBuffer simulates reading perf event that takes time measured by
+// mocked clock.
+type clockBuffer struct {
+	buffer
+	clock *time.Time
+	delay time.Duration
+}
+
+func (c clockBuffer) Read(p []byte) (int, error) {
+	*c.clock = c.clock.Add(c.delay)
+	return c.buffer.Read(p)
+}
+
+func TestCollector_UpdateStatsReadTimeoutMockedClock(t *testing.T) {
+	originalNow := now
+	defer func() {
+		now = originalNow
+	}()
+	clock := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	now = func() time.Time {
+		return clock
+	}
+
+	buf := clockBuffer{buffer: buffer{bytes.NewBuffer([]byte{})}, clock: &clock, delay: 20 * time.Millisecond}
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, binary.Write(buf.buffer, binary.LittleEndian, GroupReadFormat{Nr: 1, TimeEnabled: 1, TimeRunning: 1}))
+		assert.NoError(t, binary.Write(buf.buffer, binary.LittleEndian, Values{Value: 42}))
+	}
+	collector := collector{
+		uncore: &stats.NoopCollector{},
+		events: PerfEvents{ReadTimeout: Duration(30 * time.Millisecond)},
+		cpuFiles: map[int]group{
+			0: {
+				cpuFiles:   map[string]map[int]readerCloser{"instructions": {0: buf, 1: buf, 2: buf}},
+				names:      []string{"instructions"},
+				leaderName: "instructions",
+			},
+		},
+	}
+
+	stats := &info.ContainerStats{}
+	err := collector.UpdateStats(stats)
+	assert.NoError(t, err)
+	// Deadline follows the mocked clock, which passes it after the second
+	// CPU is read, without waiting for real time.
+	assert.Len(t, stats.PerfStats, 2)
+	assert.True(t, stats.PerfStatsTruncated)
+}
+
+func TestCollector_UpdateStatsWithoutReadTimeout(t *testing.T) {
+	buf := slowBuffer{buffer: buffer{bytes.NewBuffer([]byte{})}, delay: 20 * time.Millisecond}
+	for i := 0; i < 2; i++ {
+		err := binary.Write(buf.buffer, binary.LittleEndian, GroupReadFormat{Nr: 1, TimeEnabled: 1, TimeRunning: 1})
+		assert.NoError(t, err)
+		err = binary.Write(buf.buffer, binary.LittleEndian, Values{Value: 42})
+		assert.NoError(t, err)
+	}
+	collector := collector{
+		uncore: &stats.NoopCollector{},
+		cpuFiles: map[int]group{
+			0: {
+				cpuFiles:   map[string]map[int]readerCloser{"instructions": {0: buf, 1: buf}},
+				names:      []string{"instructions"},
+				leaderName: "instructions",
+			},
+		},
+	}
+
+	stats := &info.ContainerStats{PerfStatsTruncated: true}
+	err := collector.UpdateStats(stats)
+	assert.NoError(t, err)
+	assert.Len(t, stats.PerfStats, 2)
+	assert.False(t, stats.PerfStatsTruncated)
+}
+
+func TestCollector_UpdateStatsMultiplexing(t *testing.T) {
+	newGroup := func(name string, timeRunning uint64) group {
+		files := map[int]readerCloser{}
+		for cpu := 0; cpu < 2; cpu++ {
+			buf := buffer{bytes.NewBuffer([]byte{})}
+			// Group is counted all the time on CPU 1.
+			running := timeRunning
+			if cpu == 1 {
+				running = 100
+			}
+			err := binary.Write(buf, binary.LittleEndian, GroupReadFormat{Nr: 1, TimeEnabled: 100, TimeRunning: running})
+			assert.NoError(t, err)
+			err = binary.Write(buf, binary.LittleEndian, Values{Value: 42})
+			assert.NoError(t, err)
+			files[cpu] = buf
+		}
+		return group{
+			cpuFiles:   map[string]map[int]readerCloser{name: files},
+			names:      []string{name},
+			leaderName: name,
+		}
+	}
+	collector := collector{
+		uncore: &stats.NoopCollector{},
+		events: PerfEvents{Multiplexing: true},
+		cpuFiles: map[int]group{
+			0: newGroup("instructions", 25),
+			1: newGroup("cycles", 25),
+			2: newGroup("cache-misses", 25),
+			3: newGroup("cache-references", 25),
+		},
+	}
+
+	stats := &info.ContainerStats{}
+	err := collector.UpdateStats(stats)
+	assert.NoError(t, err)
+	assert.Len(t, stats.PerfStats, 8)
+	assert.Equal(t, []info.PerfMultiplexing{
+		{Cpu: 0, Groups: 4, AverageScalingRatio: 0.25, MultiplexGroups: 4},
+		{Cpu: 1, Groups: 4, AverageScalingRatio: 1, MultiplexGroups: 1},
+	}, stats.PerfMultiplexing)
+}
+
+// blockingBuffer simulates reading perf event that hangs until released.
+type blockingBuffer struct {
+	buffer
+	release chan struct{}
+}
+
+func (b blockingBuffer) Read(p []byte) (int, error) {
+	<-b.release
+	return b.buffer.Read(p)
+}
+
+func TestCollector_UpdateStatsGroupReadTimeout(t *testing.T) {
+	writeValue := func(buf buffer, value uint64) {
+		err := binary.Write(buf, binary.LittleEndian, GroupReadFormat{Nr: 1, TimeEnabled: 1, TimeRunning: 1})
+		assert.NoError(t, err)
+		err = binary.Write(buf, binary.LittleEndian, Values{Value: value})
+		assert.NoError(t, err)
+	}
+	slow := blockingBuffer{buffer: buffer{bytes.NewBuffer([]byte{})}, release: make(chan struct{})}
+	fast := buffer{bytes.NewBuffer([]byte{})}
+	for i := 0; i < 3; i++ {
+		writeValue(slow.buffer, 100)
+		writeValue(fast, 42)
+	}
+	collector := collector{
+		uncore: &stats.NoopCollector{},
+		cpuFiles: map[int]group{
+			0: {
+				cpuFiles:    map[string]map[int]readerCloser{"instructions": {0: slow}},
+				names:       []string{"instructions"},
+				leaderName:  "instructions",
+				readTimeout: 5 * time.Millisecond,
+			},
+			1: {
+				cpuFiles:    map[string]map[int]readerCloser{"cycles": {0: fast}},
+				names:       []string{"cycles"},
+				leaderName:  "cycles",
+				readTimeout: time.Second,
+			},
+		},
+	}
+
+	// Read of the slow group is abandoned and the other group is read.
+	stats := &info.ContainerStats{}
+	err := collector.UpdateStats(stats)
+	assert.NoError(t, err)
+	assert.Len(t, stats.PerfStats, 1)
+	assert.Equal(t, "cycles", stats.PerfStats[0].Name)
+	assert.False(t, stats.PerfStatsTruncated)
+
+	// Slow group is skipped while the abandoned read is in progress.
+	err = collector.UpdateStats(stats)
+	assert.NoError(t, err)
+	assert.Len(t, stats.PerfStats, 1)
+	assert.Equal(t, "cycles", stats.PerfStats[0].Name)
+
+	effective := collector.EffectiveEvents()
+	assert.Equal(t, uint64(2), effective.Core[0].ReadTimeouts)
+	assert.Equal(t, uint64(0), effective.Core[1].ReadTimeouts)
+
+	// Group is read again once the abandoned read finishes.
+	close(slow.release)
+	for i := 0; i < 100; i++ {
+		if len(collector.pendingReads[0]) > 0 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	err = collector.UpdateStats(stats)
+	assert.NoError(t, err)
+	assert.Len(t, stats.PerfStats, 2)
+	assert.Equal(t, uint64(2), collector.readTimeouts[0])
+}
+
+func TestCollector_SetupResolvesCgroupPath(t *testing.T) {
+	hostCgroupPath, err := ioutil.TempDir("", "perf_event")
+	assert.NoError(t, err)
+	defer os.RemoveAll(hostCgroupPath)
+
+	resolved := []string{}
+	collector := newCollector("/sys/fs/cgroup/perf_event/docker/abc", PerfEvents{}, []int{0}, map[int]int{0: 0}, map[int]physicalCore{})
+	collector.resolveCgroupPath = func(cgroupPath string) (string, error) {
+		resolved = append(resolved, cgroupPath)
+		return hostCgroupPath, nil
+	}
+	err = collector.setup()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"/sys/fs/cgroup/perf_event/docker/abc"}, resolved)
+
+	collector.resolveCgroupPath = func(cgroupPath string) (string, error) {
+		return "", fmt.Errorf("cgroup is not under cgroup mountpoint")
+	}
+	err = collector.setup()
+	assert.Error(t, err)
+}
+
+func TestCollector_SetupContainerCPUs(t *testing.T) {
+	cgroupPath, err := ioutil.TempDir("", "perf_event")
+	assert.NoError(t, err)
+	defer os.RemoveAll(cgroupPath)
+
+	cpuset := []int{1, 3, 5}
+	opened := []int{}
+	collector := newCollector(cgroupPath, PerfEvents{
+		Core: Events{
+			Events:       []Group{{events: []Event{"instructions"}}},
+			CustomEvents: []CustomEvent{{Type: unix.PERF_TYPE_HARDWARE, Config: Config{unix.PERF_COUNT_HW_INSTRUCTIONS}, Name: "instructions"}},
+		},
+		ContainerCPUs: true,
+	}, []int{0, 1, 2, 3}, map[int]int{}, map[int]physicalCore{})
+	collector.readCpuset = func(string) ([]int, error) {
+		return cpuset, nil
+	}
+	collector.perfEventOpen = func(attr *unix.PerfEventAttr, pid int, cpu int, groupFd int, flags int) (int, error) {
+		opened = append(opened, cpu)
+		return unix.Open(os.DevNull, unix.O_RDONLY, 0)
+	}
+	collector.ioctlSetInt = func(fd int, req uint, value int) error {
+		return nil
+	}
+	defer collector.Destroy()
+
+	err = collector.setup()
+	assert.NoError(t, err)
+	// CPU 5 is not online.
+	assert.Equal(t, []int{1, 3}, opened)
+	assert.Len(t, collector.cpuFiles[0].cpuFiles["instructions"], 2)
+	assert.Contains(t, collector.cpuFiles[0].cpuFiles["instructions"], 1)
+	assert.Contains(t, collector.cpuFiles[0].cpuFiles["instructions"], 3)
+
+	// Events are not reopened if cpuset has not changed.
+	err = collector.UpdateStats(&info.ContainerStats{})
+	assert.NoError(t, err)
+	assert.Equal(t, []int{1, 3}, opened)
+
+	// Events are reopened on changed cpuset.
+	cpuset = []int{0}
+	err = collector.UpdateStats(&info.ContainerStats{})
+	assert.NoError(t, err)
+	assert.Equal(t, []int{1, 3, 0}, opened)
+	assert.Len(t, collector.cpuFiles[0].cpuFiles["instructions"], 1)
+	assert.Contains(t, collector.cpuFiles[0].cpuFiles["instructions"], 0)
+}
+
+func TestCollector_CPUHotplug(t *testing.T) {
+	cgroupPath, err := ioutil.TempDir("", "perf_event")
+	assert.NoError(t, err)
+	defer os.RemoveAll(cgroupPath)
+
+	online := []int{0, 1}
+	var onlineErr error
+	opened := []int{}
+	collector := newCollector(cgroupPath, PerfEvents{
+		Core: Events{
+			Events:       []Group{{events: []Event{"instructions"}}},
+			CustomEvents: []CustomEvent{{Type: unix.PERF_TYPE_HARDWARE, Config: Config{unix.PERF_COUNT_HW_INSTRUCTIONS}, Name: "instructions"}},
+		},
+		CPUHotplug: true,
+	}, []int{0, 1, 2, 3}, map[int]int{}, map[int]physicalCore{})
+	collector.readOnlineCPUs = func() ([]int, error) {
+		return online, onlineErr
+	}
+	collector.perfEventOpen = func(attr *unix.PerfEventAttr, pid int, cpu int, groupFd int, flags int) (int, error) {
+		opened = append(opened, cpu)
+		return unix.Open(os.DevNull, unix.O_RDONLY, 0)
+	}
+	collector.ioctlSetInt = func(fd int, req uint, value int) error {
+		return nil
+	}
+	defer collector.Destroy()
+
+	// CPUs that have gone offline since the manager was created are skipped.
+	err = collector.setup()
+	assert.NoError(t, err)
+	assert.Equal(t, []int{0, 1}, opened)
+
+	// Events are not reopened if online CPUs have not changed.
+	err = collector.UpdateStats(&info.ContainerStats{})
+	assert.NoError(t, err)
+	assert.Equal(t, []int{0, 1}, opened)
+
+	// Events are reopened when a CPU is onlined.
+	online = []int{0, 1, 2}
+	err = collector.UpdateStats(&info.ContainerStats{})
+	assert.NoError(t, err)
+	assert.Equal(t, []int{0, 1, 0, 1, 2}, opened)
+	assert.Len(t, collector.cpuFiles[0].cpuFiles["instructions"], 3)
+
+	// Online CPUs are kept when they cannot be read.
+	online, onlineErr = nil, fmt.Errorf("no such file")
+	err = collector.UpdateStats(&info.ContainerStats{})
+	assert.NoError(t, err)
+	assert.Equal(t, []int{0, 1, 2}, collector.ActiveCPUs())
+
+	// Events are reopened when a CPU is offlined.
+	online, onlineErr = []int{1, 2}, nil
+	err = collector.UpdateStats(&info.ContainerStats{})
+	assert.NoError(t, err)
+	assert.Equal(t, []int{0, 1, 0, 1, 2, 1, 2}, opened)
+	assert.NotContains(t, collector.cpuFiles[0].cpuFiles["instructions"], 0)
+}
+
+func TestCollector_ActiveCPUs(t *testing.T) {
+	cgroupPath, err := ioutil.TempDir("", "perf_event")
+	assert.NoError(t, err)
+	defer os.RemoveAll(cgroupPath)
+
+	cpuset := []int{1, 3, 5}
+	collector := newCollector(cgroupPath, PerfEvents{
+		Core: Events{
+			Events:       []Group{{events: []Event{"instructions"}}},
+			CustomEvents: []CustomEvent{{Type: unix.PERF_TYPE_HARDWARE, Config: Config{unix.PERF_COUNT_HW_INSTRUCTIONS}, Name: "instructions"}},
+		},
+		ContainerCPUs: true,
+	}, []int{0, 1, 2, 3}, map[int]int{}, map[int]physicalCore{})
+	collector.readCpuset = func(string) ([]int, error) {
+		return cpuset, nil
+	}
+	collector.perfEventOpen = func(attr *unix.PerfEventAttr, pid int, cpu int, groupFd int, flags int) (int, error) {
+		return unix.Open(os.DevNull, unix.O_RDONLY, 0)
+	}
+	collector.ioctlSetInt = func(fd int, req uint, value int) error {
+		return nil
+	}
+	defer collector.Destroy()
+
+	assert.Empty(t, collector.ActiveCPUs())
+
+	err = collector.setup()
+	assert.NoError(t, err)
+	// Cpuset intersected with online CPUs.
+	cpus := collector.ActiveCPUs()
+	assert.Equal(t, []int{1, 3}, cpus)
+
+	// Returned slice is a copy.
+	cpus[0] = 2
+	assert.Equal(t, []int{1, 3}, collector.ActiveCPUs())
+
+	cpuset = []int{0, 2}
+	err = collector.UpdateStats(&info.ContainerStats{})
+	assert.NoError(t, err)
+	assert.Equal(t, []int{0, 2}, collector.ActiveCPUs())
+}
+
+func TestCollector_UpdateStatsPerfInterval(t *testing.T) {
+	start := time.Date(2020, 10, 1, 12, 0, 0, 0, time.UTC)
+	currentTime := start
+	originalNow := now
+	now = func() time.Time {
+		return currentTime
+	}
+	defer func() {
+		now = originalNow
+	}()
+
+	collector := collector{uncore: &stats.NoopCollector{}, startTime: start}
+	containerStats := &info.ContainerStats{}
+
+	// The first interval starts when counting started.
+	for _, interval := range []time.Duration{10 * time.Second, 15 * time.Second, time.Second, 1500 * time.Millisecond} {
+		currentTime = currentTime.Add(interval)
+		assert.NoError(t, collector.UpdateStats(containerStats))
+		assert.Equal(t, interval, containerStats.PerfInterval)
+	}
+
+	// Events reopened in between count since then.
+	collector.startTime = currentTime.Add(3 * time.Second)
+	currentTime = currentTime.Add(5 * time.Second)
+	assert.NoError(t, collector.UpdateStats(containerStats))
+	assert.Equal(t, 2*time.Second, containerStats.PerfInterval)
+}
+
+func TestCollector_SetupGroupLeader(t *testing.T) {
+	cgroupPath, err := ioutil.TempDir("", "perf_event")
+	assert.NoError(t, err)
+	defer os.RemoveAll(cgroupPath)
+
+	events := Events{}
+	err = json.Unmarshal([]byte(`{
+		"events": [{"events": ["instructions", "cycles"], "leader": "cycles"}],
+		"custom_events": [
+			{"type": 0, "config": ["0x1"], "name": "instructions"},
+			{"type": 0, "config": ["0x0"], "name": "cycles"}
+		]
+	}`), &events)
+	assert.NoError(t, err)
+
+	leaders := []uint64{}
+	collector := newCollector(cgroupPath, PerfEvents{Core: events}, []int{0}, map[int]int{}, map[int]physicalCore{})
+	collector.perfEventOpen = func(attr *unix.PerfEventAttr, pid int, cpu int, groupFd int, flags int) (int, error) {
+		if groupFd == groupLeaderFileDescriptor {
+			leaders = append(leaders, attr.Config)
+		}
+		return unix.Open(os.DevNull, unix.O_RDONLY, 0)
+	}
+	collector.ioctlSetInt = func(fd int, req uint, value int) error {
+		return nil
+	}
+	defer collector.Destroy()
+
+	err = collector.setup()
+	assert.NoError(t, err)
+	// Only cycles (PERF_COUNT_HW_CPU_CYCLES) is opened as leader.
+	assert.Equal(t, []uint64{unix.PERF_COUNT_HW_CPU_CYCLES}, leaders)
+	assert.Equal(t, "cycles", collector.cpuFiles[0].leaderName)
+	assert.Equal(t, []string{"cycles", "instructions"}, collector.cpuFiles[0].names)
+}
+
+func TestCollector_OpenCalls(t *testing.T) {
+	cgroupPath, err := ioutil.TempDir("", "perf_event")
+	assert.NoError(t, err)
+	defer os.RemoveAll(cgroupPath)
+
+	onlineCPUs := []int{0, 1, 2, 3}
+	collector := newCollector(cgroupPath, PerfEvents{
+		Core: Events{
+			Events: []Group{
+				{events: []Event{"instructions", "cycles"}, array: true},
+				{events: []Event{"cache-misses"}},
+			},
+			CustomEvents: []CustomEvent{
+				{Type: unix.PERF_TYPE_HARDWARE, Config: Config{unix.PERF_COUNT_HW_INSTRUCTIONS}, Name: "instructions"},
+				{Type: unix.PERF_TYPE_HARDWARE, Config: Config{unix.PERF_COUNT_HW_CPU_CYCLES}, Name: "cycles"},
+				{Type: unix.PERF_TYPE_HARDWARE, Config: Config{unix.PERF_COUNT_HW_CACHE_MISSES}, Name: "cache-misses"},
+			},
+		},
+	}, onlineCPUs, map[int]int{}, map[int]physicalCore{})
+	calls := 0
+	collector.perfEventOpen = func(attr *unix.PerfEventAttr, pid int, cpu int, groupFd int, flags int) (int, error) {
+		calls++
+		return unix.Open(os.DevNull, unix.O_RDONLY, 0)
+	}
+	collector.ioctlSetInt = func(fd int, req uint, value int) error {
+		return nil
+	}
+	defer collector.Destroy()
+
+	err = collector.setup()
+	assert.NoError(t, err)
+	// Three events on four CPUs.
+	assert.Equal(t, uint64(3*len(onlineCPUs)), collector.OpenCalls())
+	assert.Equal(t, uint64(calls), collector.OpenCalls())
+}
+
+func TestCollector_SetupPartialGroups(t *testing.T) {
+	cgroupPath, err := ioutil.TempDir("", "perf_event")
+	assert.NoError(t, err)
+	defer os.RemoveAll(cgroupPath)
+
+	events := Events{}
+	err = json.Unmarshal([]byte(`{
+		"events": [["instructions", "cycles"], ["cache-misses"]],
+		"custom_events": [
+			{"type": 0, "config": ["0x1"], "name": "instructions"},
+			{"type": 0, "config": ["0x0"], "name": "cycles"},
+			{"type": 0, "config": ["0x3"], "name": "cache-misses"}
+		]
+	}`), &events)
+	assert.NoError(t, err)
+
+	for _, partialGroups := range []bool{false, true} {
+		collector := newCollector(cgroupPath, PerfEvents{Core: events, PartialGroups: partialGroups}, []int{0, 1, 2}, map[int]int{}, map[int]physicalCore{})
+		// Leader of the first group cannot be opened on CPU 1.
+		collector.perfEventOpen = func(attr *unix.PerfEventAttr, pid int, cpu int, groupFd int, flags int) (int, error) {
+			if cpu == 1 && groupFd == groupLeaderFileDescriptor && attr.Config == unix.PERF_COUNT_HW_INSTRUCTIONS {
+				return -1, unix.EACCES
+			}
+			return unix.Open(os.DevNull, unix.O_RDONLY, 0)
+		}
+		collector.ioctlSetInt = func(fd int, req uint, value int) error {
+			return nil
+		}
+
+		err = collector.setup()
+		if !partialGroups {
+			assert.Error(t, err)
+			collector.Destroy()
+			continue
+		}
+		assert.NoError(t, err)
+		// Both events of the first group are set up on the remaining CPUs.
+		for _, name := range []string{"instructions", "cycles"} {
+			assert.Len(t, collector.cpuFiles[0].cpuFiles[name], 2)
+			assert.Contains(t, collector.cpuFiles[0].cpuFiles[name], 0)
+			assert.Contains(t, collector.cpuFiles[0].cpuFiles[name], 2)
+		}
+		assert.Len(t, collector.cpuFiles[1].cpuFiles["cache-misses"], 3)
+		assert.Equal(t, map[int][]int{0: {1}}, collector.DroppedCPUs())
+		collector.Destroy()
+	}
+}
+
+func TestCollector_SetupSubtreeAggregate(t *testing.T) {
+	parent, err := ioutil.TempDir("", "perf_event")
+	assert.NoError(t, err)
+	defer os.RemoveAll(parent)
+	children := []string{filepath.Join(parent, "child1"), filepath.Join(parent, "child2")}
+	for _, child := range children {
+		assert.NoError(t, os.Mkdir(child, 0755))
+	}
+
+	var parentStat unix.Stat_t
+	assert.NoError(t, unix.Stat(parent, &parentStat))
+
+	collector := newCollector(parent, PerfEvents{
+		Core: Events{
+			Events: []Group{{events: []Event{"instructions"}}},
+			CustomEvents: []CustomEvent{
+				{Type: unix.PERF_TYPE_HARDWARE, Config: Config{unix.PERF_COUNT_HW_INSTRUCTIONS}, Name: "instructions"},
+			},
+		},
+		SubtreeAggregate: true,
+	}, []int{0, 1}, map[int]int{}, map[int]physicalCore{})
+	collector.perfEventOpen = func(attr *unix.PerfEventAttr, pid int, cpu int, groupFd int, flags int) (int, error) {
+		// Events are opened once, on the parent cgroup, and the kernel
+		// counts tasks of both children in them.
+		var stat unix.Stat_t
+		assert.NoError(t, unix.Fstat(pid, &stat))
+		assert.Equal(t, parentStat.Ino, stat.Ino)
+		assert.NotZero(t, flags&unix.PERF_FLAG_PID_CGROUP)
+		return unix.Open(os.DevNull, unix.O_RDONLY, 0)
+	}
+	collector.ioctlSetInt = func(fd int, req uint, value int) error {
+		return nil
+	}
+	defer collector.Destroy()
+
+	err = collector.setup()
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(2), collector.OpenCalls())
+}
+
+func TestCollector_SetupThreadedSubtree(t *testing.T) {
+	container, err := ioutil.TempDir("", "perf_event")
+	assert.NoError(t, err)
+	defer os.RemoveAll(container)
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(container, cgroupTypeFile), []byte("domain threaded\n"), 0644))
+	for _, threaded := range []string{"workers", "workers/io"} {
+		assert.NoError(t, os.MkdirAll(filepath.Join(container, threaded), 0755))
+		assert.NoError(t, ioutil.WriteFile(filepath.Join(container, threaded, cgroupTypeFile), []byte("threaded\n"), 0644))
+	}
+
+	var containerStat unix.Stat_t
+	assert.NoError(t, unix.Stat(container, &containerStat))
+
+	collector := newCollector(container, PerfEvents{
+		Core: Events{
+			Events: []Group{{events: []Event{"instructions"}}},
+			CustomEvents: []CustomEvent{
+				{Type: unix.PERF_TYPE_HARDWARE, Config: Config{unix.PERF_COUNT_HW_INSTRUCTIONS}, Name: "instructions"},
+			},
+		},
+	}, []int{0, 1}, map[int]int{}, map[int]physicalCore{})
+	collector.perfEventOpen = func(attr *unix.PerfEventAttr, pid int, cpu int, groupFd int, flags int) (int, error) {
+		// Threads in threaded cgroups are counted by events opened on the
+		// cgroup of the container, which is the root of threaded subtree.
+		var stat unix.Stat_t
+		assert.NoError(t, unix.Fstat(pid, &stat))
+		assert.Equal(t, containerStat.Ino, stat.Ino)
+		assert.NotZero(t, flags&unix.PERF_FLAG_PID_CGROUP)
+		return unix.Open(os.DevNull, unix.O_RDONLY, 0)
+	}
+	collector.ioctlSetInt = func(fd int, req uint, value int) error {
+		return nil
+	}
+	defer collector.Destroy()
+
+	err = collector.setup()
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(2), collector.OpenCalls())
+}
+
+func TestCollector_SetupEncodesEventOnce(t *testing.T) {
+	cgroupPath, err := ioutil.TempDir("", "perf_event")
+	assert.NoError(t, err)
+	defer os.RemoveAll(cgroupPath)
+
+	encoded := map[string]int{}
+	libpmfMutex.Lock()
+	originalEncodedEvents, originalEncodeEvent := encodedEvents, encodeEvent
+	encodedEvents = map[string]unix.PerfEventAttr{}
+	encodeEvent = func(name string) (unix.PerfEventAttr, error) {
+		encoded[name]++
+		return encodePerfEventAttr(name)
+	}
+	libpmfMutex.Unlock()
+	defer func() {
+		libpmfMutex.Lock()
+		defer libpmfMutex.Unlock()
+		encodedEvents, encodeEvent = originalEncodedEvents, originalEncodeEvent
+	}()
+
+	configs := []unix.PerfEventAttr{}
+	for i := 0; i < 3; i++ {
+		// Containers are created and destroyed repeatedly.
+		collector := newCollector(cgroupPath, PerfEvents{
+			Core: Events{Events: []Group{{events: []Event{"instructions", "cycles"}, array: true}}},
+		}, []int{0}, map[int]int{}, map[int]physicalCore{})
+		collector.perfEventOpen = func(attr *unix.PerfEventAttr, pid int, cpu int, groupFd int, flags int) (int, error) {
+			configs = append(configs, *attr)
+			return unix.Open(os.DevNull, unix.O_RDONLY, 0)
+		}
+		collector.ioctlSetInt = func(fd int, req uint, value int) error {
+			return nil
+		}
+		err = collector.setup()
+		assert.NoError(t, err)
+		collector.Destroy()
+	}
+
+	assert.Equal(t, map[string]int{"instructions": 1, "cycles": 1}, encoded)
+	// Attributes of each collector are applied to copies of the encoding.
+	assert.Len(t, configs, 6)
+	for i := 2; i < len(configs); i++ {
+		assert.Equal(t, configs[i%2], configs[i])
+	}
+	assert.NotZero(t, configs[0].Bits&unix.PerfBitDisabled)
+	assert.Zero(t, configs[1].Bits&unix.PerfBitDisabled)
+	assert.Zero(t, encodedEvents["instructions"].Bits)
+}
+
+func TestCollector_SetupIgnoreUnsupportedEvents(t *testing.T) {
+	cgroupPath, err := ioutil.TempDir("", "perf_event")
+	assert.NoError(t, err)
+	defer os.RemoveAll(cgroupPath)
+
+	events := PerfEvents{
+		Core: Events{Events: []Group{
+			{events: []Event{"non-existing-event", "instructions", "cycles"}},
+			{events: []Event{"non-existing-event"}},
+		}},
+	}
+	leaders := 0
+	newTestCollector := func(events PerfEvents) *collector {
+		collector := newCollector(cgroupPath, events, []int{0}, map[int]int{}, map[int]physicalCore{})
+		collector.perfEventOpen = func(attr *unix.PerfEventAttr, pid int, cpu int, groupFd int, flags int) (int, error) {
+			if groupFd == groupLeaderFileDescriptor {
+				leaders++
+			}
+			return unix.Open(os.DevNull, unix.O_RDONLY, 0)
+		}
+		collector.ioctlSetInt = func(fd int, req uint, value int) error {
+			return nil
+		}
+		return collector
+	}
+
+	// Unsupported event fails the whole setup by default.
+	collector := newTestCollector(events)
+	err = collector.setup()
+	assert.Error(t, err)
+	collector.Destroy()
+
+	events.IgnoreUnsupportedEvents = true
+	collector = newTestCollector(events)
+	defer collector.Destroy()
+	err = collector.setup()
+	assert.NoError(t, err)
+
+	// The first supported event leads the group and group of unsupported
+	// events only is not set up.
+	assert.Equal(t, 1, leaders)
+	assert.Len(t, collector.cpuFiles, 1)
+	assert.Equal(t, []string{"instructions", "cycles"}, collector.cpuFiles[0].names)
+	assert.Equal(t, "instructions", collector.cpuFiles[0].leaderName)
+	assert.Equal(t, map[int][]string{0: {"non-existing-event"}, 1: {"non-existing-event"}}, collector.SkippedEvents())
+}
+
+// fakeCounter simulates perf event counter that is controlled by ioctl requests.
+type fakeCounter struct {
+	fd      uintptr
+	value   uint64
+	time    uint64
+	enabled bool
+}
+
+func (f *fakeCounter) Read(p []byte) (int, error) {
+	buf := &bytes.Buffer{}
+	err := binary.Write(buf, binary.LittleEndian, GroupReadFormat{Nr: 1, TimeEnabled: f.time, TimeRunning: f.time})
+	if err != nil {
+		return 0, err
+	}
+	err = binary.Write(buf, binary.LittleEndian, Values{Value: f.value})
+	if err != nil {
+		return 0, err
+	}
+	return copy(p, buf.Bytes()), nil
+}
+
+func (f *fakeCounter) Close() error {
+	return nil
+}
+
+func (f *fakeCounter) Fd() uintptr {
+	return f.fd
+}
+
+func (f *fakeCounter) count(value uint64) {
+	if f.enabled {
+		f.value += value
+		f.time++
+	}
+}
+
+func (f *fakeCounter) ioctl(fd int, req uint, value int) error {
+	if uintptr(fd) != f.fd {
+		return fmt.Errorf("unexpected file descriptor %d", fd)
+	}
+	switch req {
+	case unix.PERF_EVENT_IOC_RESET:
+		f.value, f.time = 0, 0
+	case unix.PERF_EVENT_IOC_ENABLE:
+		f.enabled = true
+	case unix.PERF_EVENT_IOC_DISABLE:
+		f.enabled = false
+	}
+	return nil
+}
+
+func TestCollector_TriggerWindow(t *testing.T) {
+	counter := &fakeCounter{fd: 3, enabled: true}
+	collector := collector{
+		uncore:      &stats.NoopCollector{},
+		ioctlSetInt: counter.ioctl,
+		cpuFiles: map[int]group{
+			0: {
+				cpuFiles: map[string]map[int]readerCloser{
+					"instructions": {0: counter},
+				},
+				names:      []string{"instructions"},
+				leaderName: "instructions",
+			},
+		},
+	}
+
+	// Counted before the window.
+	counter.count(100)
+
+	err := collector.TriggerStart()
+	assert.NoError(t, err)
+	// Counting restarts with the window.
+	assert.False(t, collector.startTime.IsZero())
+	counter.count(5)
+	counter.count(7)
+	perfStats, err := collector.TriggerStop()
+	assert.NoError(t, err)
+
+	expected := []info.PerfStat{{
+		PerfValue: info.PerfValue{
+			ScalingRatio: 1,
+			Value:        12,
+			RawValue:     12,
+			Name:         "instructions",
+			TimeEnabled:  2,
+			TimeRunning:  2,
+		},
+		Cpu:       0,
+		StartTime: &collector.startTime,
+	}}
+	assert.Equal(t, expected, perfStats)
+
+	// Counting continues after the window.
+	assert.True(t, counter.enabled)
+	counter.count(50)
+	stats := &info.ContainerStats{}
+	err = collector.UpdateStats(stats)
+	assert.NoError(t, err)
+	assert.Len(t, stats.PerfStats, 1)
+	assert.Equal(t, uint64(62), stats.PerfStats[0].Value)
+	assert.True(t, stats.PerfStats[0].Reopened)
+}
+
+func TestCollector_TriggerWindowRotation(t *testing.T) {
+	counters := []*fakeCounter{{fd: 3, enabled: true}, {fd: 4}}
+	collector := collector{
+		uncore: &stats.NoopCollector{},
+		events: PerfEvents{Rotation: true},
+		ioctlSetInt: func(fd int, req uint, value int) error {
+			return counters[fd-3].ioctl(fd, req, value)
+		},
+		cpuFiles: map[int]group{
+			0: {
+				cpuFiles:   map[string]map[int]readerCloser{"instructions": {0: counters[0]}},
+				names:      []string{"instructions"},
+				leaderName: "instructions",
+			},
+			1: {
+				cpuFiles:   map[string]map[int]readerCloser{"cycles": {0: counters[1]}},
+				names:      []string{"cycles"},
+				leaderName: "cycles",
+			},
+		},
+	}
+	counters[0].count(100)
+
+	// Only the group that is being measured is counting in the window and
+	// groups are not rotated until it is finished.
+	assert.NoError(t, collector.TriggerStart())
+	assert.False(t, counters[1].enabled)
+	counters[0].count(5)
+	assert.NoError(t, collector.UpdateStats(&info.ContainerStats{}))
+	assert.True(t, counters[0].enabled)
+	assert.False(t, counters[1].enabled)
+	counters[0].count(7)
+
+	perfStats, err := collector.TriggerStop()
+	assert.NoError(t, err)
+	assert.Len(t, perfStats, 1)
+	assert.Equal(t, "instructions", perfStats[0].Name)
+	assert.Equal(t, uint64(12), perfStats[0].Value)
+	assert.True(t, counters[0].enabled)
+	assert.False(t, counters[1].enabled)
+
+	// Rotation continues after the window.
+	assert.NoError(t, collector.UpdateStats(&info.ContainerStats{}))
+	assert.False(t, counters[0].enabled)
+	assert.True(t, counters[1].enabled)
+}
+
+func TestCollector_TriggerWindowPaused(t *testing.T) {
+	counter := &fakeCounter{fd: 3, enabled: true}
+	collector := collector{
+		uncore:      &stats.NoopCollector{},
+		ioctlSetInt: counter.ioctl,
+		cpuFiles: map[int]group{
+			0: {
+				cpuFiles:   map[string]map[int]readerCloser{"instructions": {0: counter}},
+				names:      []string{"instructions"},
+				leaderName: "instructions",
+			},
+		},
+	}
+	counter.count(100)
+	assert.NoError(t, collector.pause())
+
+	// Window does not enable paused groups.
+	assert.Error(t, collector.TriggerStart())
+	assert.False(t, counter.enabled)
+	assert.Equal(t, uint64(100), counter.value)
+
+	// Groups paused within the window stay paused after it.
+	assert.NoError(t, collector.resume())
+	assert.NoError(t, collector.TriggerStart())
+	counter.count(5)
+	assert.NoError(t, collector.pause())
+	perfStats, err := collector.TriggerStop()
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(5), perfStats[0].Value)
+	assert.False(t, counter.enabled)
+}
+
+func TestCollector_TriggerWindowDelta(t *testing.T) {
+	counter := &fakeCounter{fd: 3, enabled: true}
+	collector := collector{
+		uncore:      &stats.NoopCollector{},
+		events:      PerfEvents{Delta: true},
+		differ:      newDiffer(),
+		ioctlSetInt: counter.ioctl,
+		cpuFiles: map[int]group{
+			0: {
+				cpuFiles:   map[string]map[int]readerCloser{"instructions": {0: counter}},
+				names:      []string{"instructions"},
+				leaderName: "instructions",
+			},
+		},
+	}
+	counter.count(100)
+	assert.NoError(t, collector.UpdateStats(&info.ContainerStats{}))
+
+	// Increase after reset is counted from zero, even though the value
+	// has not gone below the value read before the window.
+	assert.NoError(t, collector.TriggerStart())
+	counter.count(60)
+	counter.count(60)
+	_, err := collector.TriggerStop()
+	assert.NoError(t, err)
+	stats := &info.ContainerStats{}
+	assert.NoError(t, collector.UpdateStats(stats))
+	assert.Equal(t, uint64(120), stats.PerfStats[0].Value)
+	assert.True(t, stats.PerfStats[0].Reopened)
+
+	counter.count(10)
+	stats = &info.ContainerStats{}
+	assert.NoError(t, collector.UpdateStats(stats))
+	assert.Equal(t, uint64(10), stats.PerfStats[0].Value)
+	assert.False(t, stats.PerfStats[0].Reopened)
+}
+
+func TestCollector_TriggerStartWithoutFileDescriptor(t *testing.T) {
+	collector := collector{
+		uncore: &stats.NoopCollector{},
+		cpuFiles: map[int]group{
+			0: {
+				cpuFiles: map[string]map[int]readerCloser{
+					"instructions": {0: buffer{bytes.NewBuffer([]byte{})}},
+				},
+				names:      []string{"instructions"},
+				leaderName: "instructions",
+			},
+		},
+	}
+
+	err := collector.TriggerStart()
+	assert.Error(t, err)
+}
+
+func TestCollector_UpdateStatsRotation(t *testing.T) {
+	counters := []*fakeCounter{{fd: 3, enabled: true}, {fd: 4}}
+	collector := collector{
+		uncore: &stats.NoopCollector{},
+		events: PerfEvents{Rotation: true},
+		ioctlSetInt: func(fd int, req uint, value int) error {
+			return counters[fd-3].ioctl(fd, req, value)
+		},
+		cpuFiles: map[int]group{
+			0: {
+				cpuFiles:   map[string]map[int]readerCloser{"instructions": {0: counters[0]}},
+				names:      []string{"instructions"},
+				leaderName: "instructions",
+			},
+			1: {
+				cpuFiles:   map[string]map[int]readerCloser{"cycles": {0: counters[1]}},
+				names:      []string{"cycles"},
+				leaderName: "cycles",
+			},
+		},
+	}
+	count := func() {
+		for _, counter := range counters {
+			counter.count(10)
+		}
+	}
+
+	// Groups are measured in turns, each of them on its own for the whole
+	// interval.
+	for _, expected := range []string{"instructions", "cycles", "instructions", "cycles"} {
+		count()
+		count()
+		stats := &info.ContainerStats{}
+		err := collector.UpdateStats(stats)
+		assert.NoError(t, err)
+		assert.Len(t, stats.PerfStats, 1)
+		assert.Equal(t, expected, stats.PerfStats[0].Name)
+		assert.Equal(t, uint64(20), stats.PerfStats[0].Value)
+		assert.Equal(t, 1.0, stats.PerfStats[0].ScalingRatio)
+	}
+	assert.True(t, counters[0].enabled)
+	assert.False(t, counters[1].enabled)
+}
+
+func TestCollector_RotationWithUnsupportedGroups(t *testing.T) {
+	cgroupPath, err := ioutil.TempDir("", "perf_event")
+	assert.NoError(t, err)
+	defer os.RemoveAll(cgroupPath)
+
+	events := PerfEvents{
+		Core: Events{Events: []Group{
+			{events: []Event{"non-existing-event"}},
+			{events: []Event{"instructions"}},
+			{events: []Event{"non-existing-event"}},
+			{events: []Event{"cycles"}},
+		}},
+		IgnoreUnsupportedEvents: true,
+		Rotation:                true,
+	}
+	collector := newCollector(cgroupPath, events, []int{0}, map[int]int{}, map[int]physicalCore{})
+	collector.perfEventOpen = func(attr *unix.PerfEventAttr, pid int, cpu int, groupFd int, flags int) (int, error) {
+		return unix.Open(os.DevNull, unix.O_RDONLY, 0)
+	}
+	enabled := map[int]bool{}
+	collector.ioctlSetInt = func(fd int, req uint, value int) error {
+		switch req {
+		case unix.PERF_EVENT_IOC_ENABLE:
+			enabled[fd] = true
+		case unix.PERF_EVENT_IOC_DISABLE:
+			enabled[fd] = false
+		}
+		return nil
+	}
+	assert.NoError(t, collector.setup())
+	defer collector.Destroy()
+	assert.Len(t, collector.cpuFiles, 2)
+	fd := func(groupIndex int, name string) int {
+		return int(collector.cpuFiles[groupIndex].cpuFiles[name][0].(*os.File).Fd())
+	}
+	instructions, cycles := fd(1, "instructions"), fd(3, "cycles")
+
+	// Groups that are not set up are skipped in turns.
+	assert.Equal(t, 1, collector.rotationGroup)
+	assert.Equal(t, map[int]bool{instructions: true}, enabled)
+	for _, expected := range []int{3, 1, 3} {
+		assert.NoError(t, collector.UpdateStats(&info.ContainerStats{}))
+		assert.Equal(t, expected, collector.rotationGroup)
+		assert.Equal(t, expected == 1, enabled[instructions])
+		assert.Equal(t, expected == 3, enabled[cycles])
+	}
+
+	// Resumed collector enables the group that is being measured.
+	assert.NoError(t, collector.pause())
+	assert.False(t, enabled[cycles])
+	assert.NoError(t, collector.resume())
+	assert.True(t, enabled[cycles])
+	assert.False(t, enabled[instructions])
+}
+
+func TestValidateRotation(t *testing.T) {
+	assert.NoError(t, validateRotation(PerfEvents{Rotation: true}))
+	assert.NoError(t, validateRotation(PerfEvents{Delta: true, HistogramBuckets: []uint64{10}}))
+	assert.Error(t, validateRotation(PerfEvents{Rotation: true, Delta: true}))
+	assert.Error(t, validateRotation(PerfEvents{Rotation: true, HistogramBuckets: []uint64{10}}))
+}
+
+func TestValidateReadWorkers(t *testing.T) {
+	assert.NoError(t, validateReadWorkers(PerfEvents{}))
+	assert.NoError(t, validateReadWorkers(PerfEvents{ReadWorkers: 8}))
+	assert.Error(t, validateReadWorkers(PerfEvents{ReadWorkers: -1}))
+}
+
+// countingReader counts reads of perf event file, each of them is a read(2)
+// system call for real perf event.
+type countingReader struct {
+	readerCloser
+	reads *int
+}
+
+func (c countingReader) Read(p []byte) (int, error) {
+	*c.reads++
+	return c.readerCloser.Read(p)
+}
+
+// BenchmarkCollector_UpdateStats measures reading of independent groups.
+// Group is read with a single read(2) on each CPU regardless of number of
+// its events, so the number of reads is groups × CPUs. Reads of different
+// file descriptors cannot be batched with readv(2), which reads a single
+// file descriptor only, so the way to reduce it is grouping events.
+func BenchmarkCollector_UpdateStats(b *testing.B) {
+	const groups, cpus = 4, 8
+	reads := 0
+	collector := collector{
+		uncore:   &stats.NoopCollector{},
+		cpuFiles: map[int]group{},
+	}
+	for i := 0; i < groups; i++ {
+		name := fmt.Sprintf("event%d", i)
+		files := map[int]readerCloser{}
+		for cpu := 0; cpu < cpus; cpu++ {
+			files[cpu] = countingReader{readerCloser: &fakeCounter{value: 42, time: 1}, reads: &reads}
+		}
+		collector.cpuFiles[i] = group{
+			cpuFiles:   map[string]map[int]readerCloser{name: files},
+			names:      []string{name},
+			leaderName: name,
+		}
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		err := collector.UpdateStats(&info.ContainerStats{})
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.ReportMetric(float64(reads)/float64(b.N), "reads/op")
+}
+
+func TestCollector_UpdateStatsCPUTotals(t *testing.T) {
+	collector := collector{
+		uncore: &stats.NoopCollector{},
+		events: PerfEvents{CPUTotals: true},
+		cpuFiles: map[int]group{
+			0: {
+				cpuFiles:   map[string]map[int]readerCloser{"instructions": {0: &fakeCounter{value: 100, time: 1}, 1: &fakeCounter{value: 200, time: 1}}},
+				names:      []string{"instructions"},
+				leaderName: "instructions",
+			},
+		},
+	}
+
+	stats := &info.ContainerStats{}
+	err := collector.UpdateStats(stats)
+	assert.NoError(t, err)
+	assert.Len(t, stats.PerfStats, 3)
+	sum := uint64(0)
+	var total *info.PerfStat
+	for i, stat := range stats.PerfStats {
+		if stat.Cpu == info.PerfStatAllCPUs {
+			total = &stats.PerfStats[i]
+			continue
+		}
+		sum += stat.Value
+	}
+	assert.NotNil(t, total)
+	assert.Equal(t, uint64(300), sum)
+	assert.Equal(t, sum, total.Value)
+}
+
+func TestCollector_UpdateStatsPinnedReads(t *testing.T) {
+	originalGetaffinity, originalSetaffinity := schedGetaffinity, schedSetaffinity
+	defer func() {
+		schedGetaffinity, schedSetaffinity = originalGetaffinity, originalSetaffinity
+	}()
+	var allCPUs unix.CPUSet
+	for cpu := 0; cpu < 4; cpu++ {
+		allCPUs.Set(cpu)
+	}
+	schedGetaffinity = func(pid int, set *unix.CPUSet) error {
+		*set = allCPUs
+		return nil
+	}
+	lock := sync.Mutex{}
+	pinned := []unix.CPUSet{}
+	restored := 0
+	schedSetaffinity = func(pid int, set *unix.CPUSet) error {
+		lock.Lock()
+		defer lock.Unlock()
+		if *set == allCPUs {
+			restored++
+		} else {
+			pinned = append(pinned, *set)
+		}
+		return nil
+	}
+
+	files := map[int]readerCloser{}
+	for cpu := 0; cpu < 4; cpu++ {
+		files[cpu] = &fakeCounter{value: uint64(cpu + 1), time: 1}
+	}
+	collector := collector{
+		uncore:      &stats.NoopCollector{},
+		events:      PerfEvents{PinReads: true},
+		cpuToSocket: map[int]int{0: 0, 1: 0, 2: 1, 3: 1},
+		cpuFiles: map[int]group{
+			0: {
+				cpuFiles:   map[string]map[int]readerCloser{"instructions": files},
+				names:      []string{"instructions"},
+				leaderName: "instructions",
+				ids:        map[string]map[int]uint64{},
+			},
+		},
+	}
+
+	stats := &info.ContainerStats{}
+	err := collector.UpdateStats(stats)
+	assert.NoError(t, err)
+	assert.Len(t, stats.PerfStats, 4)
+	for i, stat := range stats.PerfStats {
+		assert.Equal(t, i, stat.Cpu)
+		assert.Equal(t, uint64(i+1), stat.Value)
+	}
+	for cpu := 0; cpu < 4; cpu++ {
+		_, ok := collector.EventID(0, "instructions", cpu)
+		assert.True(t, ok)
+	}
+
+	// Each socket is read by a thread pinned to its CPUs, which is unpinned
+	// afterwards.
+	var socket0, socket1 unix.CPUSet
+	socket0.Set(0)
+	socket0.Set(1)
+	socket1.Set(2)
+	socket1.Set(3)
+	assert.ElementsMatch(t, []unix.CPUSet{socket0, socket1}, pinned)
+	assert.Equal(t, 2, restored)
+
+	// Group on CPUs of a single socket is read without pinning.
+	pinned = nil
+	collector.cpuToSocket = map[int]int{0: 0, 1: 0, 2: 0, 3: 0}
+	err = collector.UpdateStats(stats)
+	assert.NoError(t, err)
+	assert.Len(t, stats.PerfStats, 4)
+	assert.Empty(t, pinned)
+}
+
+func TestCollector_UpdateStatsReadWorkers(t *testing.T) {
+	files := map[int]readerCloser{}
+	for cpu := 0; cpu < 8; cpu++ {
+		files[cpu] = &fakeCounter{value: uint64(cpu + 1), time: 1}
+	}
+	collector := collector{
+		uncore: &stats.NoopCollector{},
+		events: PerfEvents{ReadWorkers: 3},
+		cpuFiles: map[int]group{
+			0: {
+				cpuFiles:   map[string]map[int]readerCloser{"instructions": files},
+				names:      []string{"instructions"},
+				leaderName: "instructions",
+				ids:        map[string]map[int]uint64{},
+			},
+		},
+	}
+
+	stats := &info.ContainerStats{}
+	err := collector.UpdateStats(stats)
+	assert.NoError(t, err)
+	assert.Len(t, stats.PerfStats, 8)
+	for i, stat := range stats.PerfStats {
+		assert.Equal(t, i, stat.Cpu)
+		assert.Equal(t, uint64(i+1), stat.Value)
+	}
+	for cpu := 0; cpu < 8; cpu++ {
+		_, ok := collector.EventID(0, "instructions", cpu)
+		assert.True(t, ok)
+	}
+}
+
+// startedBuffer signals that reading has started and hangs until released.
+type startedBuffer struct {
+	buffer
+	started chan struct{}
+	release chan struct{}
+}
+
+func (b startedBuffer) Read(p []byte) (int, error) {
+	close(b.started)
+	<-b.release
+	return b.buffer.Read(p)
+}
+
+func TestCollector_UpdateStatsReadsWithoutLock(t *testing.T) {
+	file := startedBuffer{buffer: buffer{bytes.NewBuffer([]byte{})}, started: make(chan struct{}), release: make(chan struct{})}
+	assert.NoError(t, binary.Write(file.buffer, binary.LittleEndian, GroupReadFormat{Nr: 1, TimeEnabled: 1, TimeRunning: 1}))
+	assert.NoError(t, binary.Write(file.buffer, binary.LittleEndian, Values{Value: 42}))
+	collector := collector{
+		uncore: &stats.NoopCollector{},
+		cpuFiles: map[int]group{
+			0: {
+				cpuFiles:   map[string]map[int]readerCloser{"instructions": {0: file}},
+				names:      []string{"instructions"},
+				leaderName: "instructions",
+			},
+		},
+	}
+
+	stats := &info.ContainerStats{}
+	done := make(chan error)
+	go func() {
+		done <- collector.UpdateStats(stats)
+	}()
+	<-file.started
+
+	// Collector can be destroyed while its events are being read.
+	destroyed := make(chan struct{})
+	go func() {
+		collector.Destroy()
+		close(destroyed)
+	}()
+	select {
+	case <-destroyed:
+	case <-time.After(10 * time.Second):
+		t.Fatal("lock of the collector is held while reading")
+	}
+
+	// Values read from the closed events are discarded.
+	close(file.release)
+	assert.NoError(t, <-done)
+	assert.Empty(t, stats.PerfStats)
+}
+
+// slowCounter is fakeCounter that takes time to read, as reading a counter
+// of another CPU requires an interprocessor interrupt.
+type slowCounter struct {
+	fakeCounter
+	latency time.Duration
+}
+
+func (s *slowCounter) Read(p []byte) (int, error) {
+	time.Sleep(s.latency)
+	return s.fakeCounter.Read(p)
+}
+
+// BenchmarkCollector_UpdateStatsReadWorkers compares reading a group opened
+// on 64 CPUs by a single goroutine and by pools of goroutines.
+func BenchmarkCollector_UpdateStatsReadWorkers(b *testing.B) {
+	const cpus = 64
+	files := map[int]readerCloser{}
+	for cpu := 0; cpu < cpus; cpu++ {
+		files[cpu] = &slowCounter{fakeCounter: fakeCounter{value: 42, time: 1}, latency: 20 * time.Microsecond}
+	}
+
+	for _, workers := range []int{0, 4, 16} {
+		collector := collector{
+			uncore: &stats.NoopCollector{},
+			events: PerfEvents{ReadWorkers: workers},
+			cpuFiles: map[int]group{
+				0: {
+					cpuFiles:   map[string]map[int]readerCloser{"instructions": files},
+					names:      []string{"instructions"},
+					leaderName: "instructions",
+				},
+			},
+		}
+		b.Run(fmt.Sprintf("read_workers=%d", workers), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				err := collector.UpdateStats(&info.ContainerStats{})
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkCollector_UpdateStatsPinnedReads compares latency of reading
+// software events opened on every online CPU with and without pinning reads
+// to sockets. Reading counter of a remote socket requires an interprocessor
+// interrupt that crosses sockets, so the difference shows on NUMA hosts.
+// Opening events on all CPUs requires CAP_PERFMON or CAP_SYS_ADMIN, the
+// benchmark is skipped otherwise.
+func BenchmarkCollector_UpdateStatsPinnedReads(b *testing.B) {
+	online, err := ioutil.ReadFile("/sys/devices/system/cpu/online")
+	if err != nil {
+		b.Skip(err)
+	}
+	cpus, err := parseCPUList(strings.TrimSpace(string(online)))
+	if err != nil {
+		b.Skip(err)
+	}
+	cpuToSocket := map[int]int{}
+	files := map[int]readerCloser{}
+	for _, cpu := range cpus {
+		socket, err := ioutil.ReadFile(fmt.Sprintf("/sys/devices/system/cpu/cpu%d/topology/physical_package_id", cpu))
+		if err != nil {
+			b.Skip(err)
+		}
+		cpuToSocket[cpu], err = strconv.Atoi(strings.TrimSpace(string(socket)))
+		if err != nil {
+			b.Skip(err)
+		}
+		attr := &unix.PerfEventAttr{
+			Type:        unix.PERF_TYPE_SOFTWARE,
+			Config:      unix.PERF_COUNT_SW_CPU_CLOCK,
+			Size:        uint32(unsafe.Sizeof(unix.PerfEventAttr{})),
+			Read_format: unix.PERF_FORMAT_TOTAL_TIME_ENABLED | unix.PERF_FORMAT_TOTAL_TIME_RUNNING | unix.PERF_FORMAT_GROUP | unix.PERF_FORMAT_ID,
+		}
+		fd, err := unix.PerfEventOpen(attr, -1, cpu, -1, unix.PERF_FLAG_FD_CLOEXEC)
+		if err != nil {
+			b.Skipf("unable to open perf event on CPU %d: %v", cpu, err)
+		}
+		file := os.NewFile(uintptr(fd), "cpu-clock")
+		defer file.Close()
+		files[cpu] = file
+	}
+
+	for _, pinReads := range []bool{false, true} {
+		collector := collector{
+			uncore:      &stats.NoopCollector{},
+			events:      PerfEvents{PinReads: pinReads},
+			cpuToSocket: cpuToSocket,
+			cpuFiles: map[int]group{
+				0: {
+					cpuFiles:   map[string]map[int]readerCloser{"cpu-clock": files},
+					names:      []string{"cpu-clock"},
+					leaderName: "cpu-clock",
+				},
+			},
+		}
+		b.Run(fmt.Sprintf("pin_reads=%v", pinReads), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				err := collector.UpdateStats(&info.ContainerStats{})
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// encodedGroup is perf event group that returns the same encoded values on
+// every read without allocating.
+type encodedGroup struct {
+	data []byte
+}
+
+func (e *encodedGroup) Read(p []byte) (int, error) {
+	return copy(p, e.data), nil
+}
+
+func (e *encodedGroup) Close() error {
+	return nil
+}
+
+func TestNewReadBuffers(t *testing.T) {
+	// Buffers fit at least ReadFormat of event read without group format.
+	buffers := newReadBuffers(nil)
+	assert.Equal(t, readFormatSize, buffers.size)
+
+	buffers = newReadBuffers([]Group{{events: []Event{"instructions"}}, {events: []Event{"cycles", "cache-misses", "branch-misses"}}})
+	assert.Equal(t, groupReadSize(3), buffers.size)
+
+	// Reused buffer is zeroed.
+	buf := buffers.get(groupReadSize(2))
+	assert.Len(t, *buf, groupReadSize(2))
+	(*buf)[0] = 1
+	buffers.put(buf)
+	buf = buffers.get(groupReadSize(3))
+	assert.Equal(t, make([]byte, groupReadSize(3)), *buf)
+	buffers.put(buf)
+
+	// Buffer larger than the largest group is allocated.
+	buf = buffers.get(groupReadSize(4))
+	assert.Len(t, *buf, groupReadSize(4))
+	buffers.put(buf)
+
+	// Reused values are zeroed too.
+	values := buffers.getValues(2)
+	assert.Len(t, *values, 2)
+	(*values)[0].Name = "cycles"
+	buffers.putValues(values)
+	values = buffers.getValues(3)
+	assert.Equal(t, make([]info.PerfValue, 3), *values)
+	buffers.putValues(values)
+
+	// Values of events of group larger than the largest group are allocated.
+	values = buffers.getValues(4)
+	assert.Len(t, *values, 4)
+	buffers.putValues(values)
+}
+
+func TestGetPerfValuesReusesBuffer(t *testing.T) {
+	data := &bytes.Buffer{}
+	assert.NoError(t, binary.Write(data, binary.LittleEndian, GroupReadFormat{Nr: 2, TimeEnabled: 100, TimeRunning: 100}))
+	assert.NoError(t, binary.Write(data, binary.LittleEndian, []Values{{Value: 10, ID: 1}, {Value: 20, ID: 2}}))
+	file := &encodedGroup{data: data.Bytes()}
+	group := group{
+		cpuFiles:   map[string]map[int]readerCloser{"instructions": {0: file}, "cycles": {0: file}},
+		names:      []string{"instructions", "cycles"},
+		leaderName: "instructions",
+		buffers:    newReadBuffers([]Group{{events: []Event{"instructions", "cycles"}}}),
+	}
+
+	values, err := getPerfValues(file, group, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, []info.PerfValue{
+		{Name: "instructions", Value: 10, RawValue: 10, ScalingRatio: 1, TimeEnabled: 100, TimeRunning: 100},
+		{Name: "cycles", Value: 20, RawValue: 20, ScalingRatio: 1, TimeEnabled: 100, TimeRunning: 100},
+	}, values)
+
+	// Only the returned values are allocated, buffer that the group is
+	// read into is reused.
+	allocs := testing.AllocsPerRun(100, func() {
+		_, err = getPerfValues(file, group, 0)
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 1.0, allocs)
+}
+
+func TestReadGroupPerfStatReusesValues(t *testing.T) {
+	if raceEnabled {
+		t.Skip("pooled values are dropped at random with the race detector")
+	}
+	data := &bytes.Buffer{}
+	assert.NoError(t, binary.Write(data, binary.LittleEndian, GroupReadFormat{Nr: 2, TimeEnabled: 100, TimeRunning: 100}))
+	assert.NoError(t, binary.Write(data, binary.LittleEndian, []Values{{Value: 10, ID: 1}, {Value: 20, ID: 2}}))
+	file := &encodedGroup{data: data.Bytes()}
+	group := group{
+		cpuFiles:   map[string]map[int]readerCloser{"instructions": {0: file}, "cycles": {0: file}},
+		names:      []string{"instructions", "cycles"},
+		leaderName: "instructions",
+		buffers:    newReadBuffers([]Group{{events: []Event{"instructions", "cycles"}}}),
+	}
+
+	// Only the returned stats are allocated, values are decoded into
+	// reused slice.
+	var err error
+	allocs := testing.AllocsPerRun(100, func() {
+		_, err = readGroupPerfStat(file, group, 0, "/")
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 1.0, allocs)
+}
+
+// TestReadGroupPerfStatConcurrently reads group on many CPUs at once, as
+// UpdateStats does with read workers, and checks that reused buffers and
+// values are not shared between the reads. Run it with -race.
+func TestReadGroupPerfStatConcurrently(t *testing.T) {
+	const cpus = 16
+	group := group{
+		cpuFiles:   map[string]map[int]readerCloser{"instructions": {}, "cycles": {}},
+		names:      []string{"instructions", "cycles"},
+		leaderName: "instructions",
+		buffers:    newReadBuffers([]Group{{events: []Event{"instructions", "cycles"}}}),
+	}
+	for cpu := 0; cpu < cpus; cpu++ {
+		data := &bytes.Buffer{}
+		assert.NoError(t, binary.Write(data, binary.LittleEndian, GroupReadFormat{Nr: 2, TimeEnabled: 100, TimeRunning: 100}))
+		assert.NoError(t, binary.Write(data, binary.LittleEndian, []Values{{Value: uint64(cpu)}, {Value: uint64(cpu + cpus)}}))
+		file := &encodedGroup{data: data.Bytes()}
+		group.cpuFiles["instructions"][cpu] = file
+		group.cpuFiles["cycles"][cpu] = file
+	}
+
+	wg := sync.WaitGroup{}
+	errs := make(chan error, cpus)
+	for cpu := 0; cpu < cpus; cpu++ {
+		wg.Add(1)
+		go func(cpu int) {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				stats, err := readGroupPerfStat(group.cpuFiles["instructions"][cpu], group, cpu, "/")
+				if err != nil {
+					errs <- err
+					return
+				}
+				if len(stats) != 2 || stats[0].Value != uint64(cpu) || stats[1].Value != uint64(cpu+cpus) || stats[1].Name != "cycles" {
+					errs <- fmt.Errorf("unexpected stats on CPU %d: %+v", cpu, stats)
+					return
+				}
+			}
+		}(cpu)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+// BenchmarkGetPerfValues measures decoding of a group read with and without
+// reusing read buffers.
+func BenchmarkGetPerfValues(b *testing.B) {
+	data := &bytes.Buffer{}
+	err := binary.Write(data, binary.LittleEndian, GroupReadFormat{Nr: 4, TimeEnabled: 100, TimeRunning: 50})
+	if err != nil {
+		b.Fatal(err)
+	}
+	err = binary.Write(data, binary.LittleEndian, []Values{{Value: 1}, {Value: 2}, {Value: 3}, {Value: 4}})
+	if err != nil {
+		b.Fatal(err)
+	}
+	file := &encodedGroup{data: data.Bytes()}
+	events := []Event{"instructions", "cycles", "cache-misses", "branch-misses"}
+	for _, buffers := range []*readBuffers{nil, newReadBuffers([]Group{{events: events}})} {
+		group := group{
+			cpuFiles:   map[string]map[int]readerCloser{},
+			names:      []string{"instructions", "cycles", "cache-misses", "branch-misses"},
+			leaderName: "instructions",
+			buffers:    buffers,
+		}
+		b.Run(fmt.Sprintf("reused=%v", buffers != nil), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				_, err := getPerfValues(file, group, 0)
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestCollector_DebugSnapshot(t *testing.T) {
+	originalNow := now
+	defer func() {
+		now = originalNow
+	}()
+	startTime := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	now = func() time.Time {
+		return startTime
+	}
+
+	counters := []*fakeCounter{{fd: 3, enabled: true}, {fd: 4, enabled: true}, {fd: 5, enabled: true}}
+	collector := collector{
+		cgroupPath: "/sys/fs/cgroup/perf_event/docker/container",
+		uncore:     &stats.NoopCollector{},
+		events: PerfEvents{
+			Core: Events{Events: []Group{{events: []Event{"instructions"}}, {events: []Event{"cycles"}}}},
+		},
+		cpuFiles: map[int]group{
+			0: {
+				cpuFiles:   map[string]map[int]readerCloser{"instructions": {0: counters[0], 1: counters[1]}},
+				names:      []string{"instructions"},
+				leaderName: "instructions",
+			},
+			1: {
+				cpuFiles:   map[string]map[int]readerCloser{"cycles": {1: counters[2]}},
+				names:      []string{"cycles"},
+				leaderName: "cycles",
+			},
+		},
+		openCalls: 3,
+		startTime: startTime,
+	}
+
+	snapshot := collector.DebugSnapshot()
+	assert.Equal(t, "/sys/fs/cgroup/perf_event/docker/container", snapshot.CgroupPath)
+	assert.Equal(t, [][]Event{{"instructions"}, {"cycles"}}, snapshot.ConfiguredCore)
+	assert.Empty(t, snapshot.ConfiguredUncore)
+	assert.Len(t, snapshot.Effective.Core, 2)
+	assert.Equal(t, map[int]int{0: 1, 1: 2}, snapshot.FileDescriptors)
+	assert.Equal(t, uint64(3), snapshot.OpenCalls)
+	assert.Equal(t, startTime, snapshot.StartTime)
+	assert.True(t, snapshot.LastReadTime.IsZero())
+	assert.Empty(t, snapshot.LastValues)
+
+	for _, counter := range counters {
+		counter.count(10)
+	}
+	readTime := startTime.Add(time.Minute)
+	now = func() time.Time {
+		return readTime
+	}
+	err := collector.UpdateStats(&info.ContainerStats{})
+	assert.NoError(t, err)
+
+	snapshot = collector.DebugSnapshot()
+	assert.Equal(t, readTime, snapshot.LastReadTime)
+	assert.Equal(t, []DebugValue{
+		{Event: "cycles", Cpu: 1, ScalingRatio: 1.0},
+		{Event: "instructions", Cpu: 0, ScalingRatio: 1.0},
+		{Event: "instructions", Cpu: 1, ScalingRatio: 1.0},
+	}, snapshot.LastValues)
+
+	_, err = json.Marshal(snapshot)
+	assert.NoError(t, err)
+}
+
+func TestCollector_UpdateStatsPerCPUSecond(t *testing.T) {
+	counters := []*fakeCounter{{fd: 3, enabled: true}, {fd: 4, enabled: true}}
+	collector := collector{
+		uncore: &stats.NoopCollector{},
+		cpuFiles: map[int]group{
+			0: {
+				cpuFiles:   map[string]map[int]readerCloser{"instructions": {0: counters[0], 1: counters[1]}},
+				names:      []string{"instructions"},
+				leaderName: "instructions",
+			},
+		},
+	}
+
+	// Tasks of the container run three times longer on CPU 0 than on CPU 1.
+	counters[0].count(30)
+	counters[0].count(30)
+	counters[0].count(30)
+	counters[1].count(10)
+	containerStats := &info.ContainerStats{}
+	err := collector.UpdateStats(containerStats)
+	assert.NoError(t, err)
+	assert.Len(t, containerStats.PerfStats, 2)
+	for _, stat := range containerStats.PerfStats {
+		assert.Equal(t, map[int]uint64{0: 3, 1: 1}[stat.Cpu], stat.TimeRunning)
+	}
+	// 100 instructions in 4 nanoseconds of CPU time.
+	assert.Equal(t, map[string]float64{"instructions": 25e9}, containerStats.PerfPerCPUSecond)
+
+	// Container that has not run reports no rates.
+	collector.cpuFiles[0].cpuFiles["instructions"] = map[int]readerCloser{0: &fakeCounter{fd: 5}}
+	err = collector.UpdateStats(containerStats)
+	assert.NoError(t, err)
+	assert.Nil(t, containerStats.PerfPerCPUSecond)
+}
+
+func TestCollector_SetupMergedGroups(t *testing.T) {
+	originalReadCoreCounters := readCoreCounters
+	defer func() {
+		readCoreCounters = originalReadCoreCounters
+	}()
+	readCoreCounters = func() (Counters, error) {
+		return Counters{PMU: "skl", GeneralPurpose: 4, Fixed: 3}, nil
+	}
+	cgroupPath, err := ioutil.TempDir("", "perf_event")
+	assert.NoError(t, err)
+	defer os.RemoveAll(cgroupPath)
+
+	events := PerfEvents{MergeGroups: 8}
+	err = json.Unmarshal([]byte(`{
+		"events": ["instructions", "cycles", "context-switches"],
+		"custom_events": [
+			{"type": 0, "config": ["0x1"], "name": "instructions"},
+			{"type": 0, "config": ["0x0"], "name": "cycles"},
+			{"type": 1, "config": ["0x3"], "name": "context-switches"}
+		]
+	}`), &events.Core)
+	assert.NoError(t, err)
+	events.Core.Events = mergeCoreGroups(events)
+
+	leaders := map[int]uint64{}
+	followers := map[uint64]int{}
+	collector := newCollector(cgroupPath, events, []int{0}, map[int]int{}, map[int]physicalCore{})
+	collector.perfEventOpen = func(attr *unix.PerfEventAttr, pid int, cpu int, groupFd int, flags int) (int, error) {
+		fd, err := unix.Open(os.DevNull, unix.O_RDONLY, 0)
+		if groupFd == groupLeaderFileDescriptor {
+			leaders[fd] = attr.Config
+		} else {
+			followers[attr.Config] = groupFd
+		}
+		return fd, err
+	}
+	collector.ioctlSetInt = func(fd int, req uint, value int) error {
+		return nil
+	}
+	defer collector.Destroy()
+	err = collector.setup()
+	assert.NoError(t, err)
+
+	// Hardware events share leader, software event is not merged.
+	assert.Len(t, collector.cpuFiles, 2)
+	assert.Equal(t, []string{"instructions", "cycles"}, collector.cpuFiles[0].names)
+	assert.Equal(t, "instructions", collector.cpuFiles[0].leaderName)
+	assert.Equal(t, []string{"context-switches"}, collector.cpuFiles[1].names)
+	assert.Len(t, leaders, 2)
+	assert.Equal(t, uint64(unix.PERF_COUNT_HW_INSTRUCTIONS), leaders[followers[unix.PERF_COUNT_HW_CPU_CYCLES]])
+
+	// Values of merged events are read from the group read format.
+	buf := &buffer{bytes.NewBuffer([]byte{})}
+	err = binary.Write(buf, binary.LittleEndian, GroupReadFormat{Nr: 2, TimeEnabled: 10, TimeRunning: 5})
+	assert.NoError(t, err)
+	err = binary.Write(buf, binary.LittleEndian, []Values{{Value: 100, ID: 1}, {Value: 300, ID: 2}})
+	assert.NoError(t, err)
+	stat, err := readGroupPerfStat(buf, collector.cpuFiles[0], 0, cgroupPath)
+	assert.NoError(t, err)
+	assert.Equal(t, []info.PerfStat{
+		{PerfValue: info.PerfValue{ScalingRatio: 0.5, Value: 200, RawValue: 100, Name: "instructions", TimeEnabled: 10, TimeRunning: 5}},
+		{PerfValue: info.PerfValue{ScalingRatio: 0.5, Value: 600, RawValue: 300, Name: "cycles", TimeEnabled: 10, TimeRunning: 5}},
+	}, stat)
+}
+
+func TestCollector_SetupClosesOpenedEvents(t *testing.T) {
+	cgroupPath, err := ioutil.TempDir("", "perf_event")
+	assert.NoError(t, err)
+	defer os.RemoveAll(cgroupPath)
+
+	events := PerfEvents{}
+	err = json.Unmarshal([]byte(`{
+		"events": [["instructions", "cycles"], ["context-switches"]],
+		"custom_events": [
+			{"type": 0, "config": ["0x1"], "name": "instructions"},
+			{"type": 0, "config": ["0x0"], "name": "cycles"},
+			{"type": 1, "config": ["0x3"], "name": "context-switches"}
+		]
+	}`), &events.Core)
+	assert.NoError(t, err)
+
+	// Two CPUs and three events give six calls, each of them fails once.
+	for failing := 1; failing <= 6; failing++ {
+		opened := []int{}
+		collector := newCollector(cgroupPath, events, []int{0, 1}, map[int]int{}, map[int]physicalCore{})
+		collector.perfEventOpen = func(attr *unix.PerfEventAttr, pid int, cpu int, groupFd int, flags int) (int, error) {
+			if len(opened)+1 == failing {
+				return -1, unix.EMFILE
+			}
+			fd, err := unix.Open(os.DevNull, unix.O_RDONLY, 0)
+			opened = append(opened, fd)
+			return fd, err
+		}
+		collector.ioctlSetInt = func(fd int, req uint, value int) error {
+			return nil
+		}
+		err = collector.setup()
+		assert.Error(t, err)
+
+		assert.Len(t, opened, failing-1)
+		assert.Empty(t, collector.cpuFiles)
+		for _, fd := range opened {
+			_, err = unix.FcntlInt(uintptr(fd), unix.F_GETFD, 0)
+			assert.Equal(t, unix.EBADF, err, "file descriptor %d is open after call %d failed", fd, failing)
+		}
+	}
+}
+
+func TestCollector_SetupWeakGroups(t *testing.T) {
+	cgroupPath, err := ioutil.TempDir("", "perf_event")
+	assert.NoError(t, err)
+	defer os.RemoveAll(cgroupPath)
+
+	events := PerfEvents{WeakGroups: true}
+	err = json.Unmarshal([]byte(`{
+		"events": [["instructions", "cycles"]],
+		"custom_events": [
+			{"type": 0, "config": ["0x1"], "name": "instructions"},
+			{"type": 0, "config": ["0x0"], "name": "cycles"}
+		]
+	}`), &events.Core)
+	assert.NoError(t, err)
+
+	leaders := map[uint64]uint64{}
+	collector := newCollector(cgroupPath, events, []int{0}, map[int]int{}, map[int]physicalCore{})
+	collector.perfEventOpen = func(attr *unix.PerfEventAttr, pid int, cpu int, groupFd int, flags int) (int, error) {
+		// Events do not fit into counters together.
+		if groupFd != groupLeaderFileDescriptor {
+			return -1, unix.EINVAL
+		}
+		leaders[attr.Config] = attr.Read_format
+		return unix.Open(os.DevNull, unix.O_RDONLY, 0)
+	}
+	collector.ioctlSetInt = func(fd int, req uint, value int) error {
+		return nil
+	}
+	defer collector.Destroy()
+	err = collector.setup()
+	assert.NoError(t, err)
+
+	// Each event is opened as a leader of its own without group read format.
+	assert.Len(t, leaders, 2)
+	for _, readFormat := range leaders {
+		assert.Zero(t, readFormat&unix.PERF_FORMAT_GROUP)
+	}
+	assert.True(t, collector.cpuFiles[0].ungrouped)
+	assert.Equal(t, []string{"instructions", "cycles"}, collector.cpuFiles[0].names)
+	assert.True(t, collector.EffectiveEvents().Core[0].Ungrouped)
+
+	// Events are read one by one.
+	for i, name := range collector.cpuFiles[0].names {
+		buf := &buffer{bytes.NewBuffer([]byte{})}
+		err = binary.Write(buf, binary.LittleEndian, ReadFormat{Value: uint64(100 * (i + 1)), TimeEnabled: 10, TimeRunning: 5, ID: uint64(i)})
+		assert.NoError(t, err)
+		collector.cpuFiles[0].cpuFiles[name][0] = buf
+	}
+	values, err := getPerfValues(nil, collector.cpuFiles[0], 0)
+	assert.NoError(t, err)
+	assert.Len(t, values, 2)
+	assert.Equal(t, "instructions", values[0].Name)
+	assert.Equal(t, uint64(100), values[0].RawValue)
+	assert.Equal(t, "cycles", values[1].Name)
+	assert.Equal(t, uint64(200), values[1].RawValue)
+	assert.False(t, values[1].Errored)
+
+	// Without weak grouping setup fails.
+	collector = newCollector(cgroupPath, PerfEvents{Core: events.Core}, []int{0}, map[int]int{}, map[int]physicalCore{})
+	collector.perfEventOpen = func(attr *unix.PerfEventAttr, pid int, cpu int, groupFd int, flags int) (int, error) {
+		if groupFd != groupLeaderFileDescriptor {
+			return -1, unix.EINVAL
+		}
+		return unix.Open(os.DevNull, unix.O_RDONLY, 0)
+	}
+	collector.ioctlSetInt = func(fd int, req uint, value int) error {
+		return nil
+	}
+	defer collector.Destroy()
+	err = collector.setup()
+	assert.Error(t, err)
+}
+
+// fakeGroupCounter simulates group of two perf events that count the same
+// thing, so their values are equal unless the group is read in the middle
+// of being reset. Reads are serialized with each other, as kernel does, but
+// not with ioctls.
+type fakeGroupCounter struct {
+	fd      uintptr
+	values  [2]uint64
+	time    uint64
+	enabled bool
+	readMu  sync.Mutex
+}
+
+func (f *fakeGroupCounter) Read(p []byte) (int, error) {
+	f.readMu.Lock()
+	defer f.readMu.Unlock()
+	if f.enabled {
+		f.values[0]++
+		f.values[1]++
+		f.time++
+	}
+	buf := &bytes.Buffer{}
+	err := binary.Write(buf, binary.LittleEndian, GroupReadFormat{Nr: 2, TimeEnabled: f.time, TimeRunning: f.time})
+	if err != nil {
+		return 0, err
+	}
+	err = binary.Write(buf, binary.LittleEndian, []Values{{Value: f.values[0]}, {Value: f.values[1]}})
+	if err != nil {
+		return 0, err
+	}
+	return copy(p, buf.Bytes()), nil
+}
+
+func (f *fakeGroupCounter) Close() error {
+	return nil
+}
+
+func (f *fakeGroupCounter) Fd() uintptr {
+	return f.fd
+}
+
+func (f *fakeGroupCounter) ioctl(fd int, req uint, value int) error {
+	if uintptr(fd) != f.fd {
+		return fmt.Errorf("unexpected file descriptor %d", fd)
+	}
+	switch req {
+	case unix.PERF_EVENT_IOC_RESET:
+		// Members are reset one by one, giving concurrent reads a chance
+		// to see the group half reset.
+		f.values[0] = 0
+		runtime.Gosched()
+		f.values[1] = 0
+		f.time = 0
+	case unix.PERF_EVENT_IOC_ENABLE:
+		f.enabled = true
+	case unix.PERF_EVENT_IOC_DISABLE:
+		f.enabled = false
+	}
+	return nil
+}
+
+func TestCollector_ConcurrentReadsAndResets(t *testing.T) {
+	counter := &fakeGroupCounter{fd: 3, enabled: true}
+	collector := collector{
+		uncore:      &stats.NoopCollector{},
+		ioctlSetInt: counter.ioctl,
+		cpuFiles: map[int]group{
+			0: {
+				cpuFiles: map[string]map[int]readerCloser{
+					"instructions": {0: counter},
+					"cycles":       {0: counter},
+				},
+				names:      []string{"instructions", "cycles"},
+				leaderName: "instructions",
+			},
+		},
+	}
+
+	assertConsistent := func(perfStats []info.PerfStat) {
+		if !assert.Len(t, perfStats, 2) {
+			return
+		}
+		assert.Equal(t, perfStats[0].Value, perfStats[1].Value, "torn read of the group: %+v", perfStats)
+		assert.Equal(t, perfStats[0].TimeRunning, perfStats[0].Value, "partial read of the group: %+v", perfStats)
+	}
+
+	const iterations = 200
+	wg := sync.WaitGroup{}
+	wg.Add(4)
+	for i := 0; i < 2; i++ {
+		go func() {
+			defer wg.Done()
+			for j := 0; j < iterations; j++ {
+				stats := &info.ContainerStats{}
+				assert.NoError(t, collector.UpdateStats(stats))
+				assertConsistent(stats.PerfStats)
+			}
+		}()
+	}
+	go func() {
+		defer wg.Done()
+		for j := 0; j < iterations; j++ {
+			assertConsistent(collector.snapshot())
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for j := 0; j < iterations; j++ {
+			assert.NoError(t, collector.TriggerStart())
+			runtime.Gosched()
+			perfStats, err := collector.TriggerStop()
+			assert.NoError(t, err)
+			assertConsistent(perfStats)
+		}
+	}()
+	wg.Wait()
+}
+
+func TestCollector_UpdateStatsCoverage(t *testing.T) {
+	counters := []*fakeCounter{{fd: 3, enabled: true}, {fd: 4, enabled: true}, {fd: 5, enabled: true}}
+	for _, counter := range counters {
+		counter.count(10)
+	}
+	collector := collector{
+		uncore: &stats.NoopCollector{},
+		events: PerfEvents{Core: Events{Events: []Group{
+			{events: []Event{"instructions"}},
+			{events: []Event{"cycles"}},
+		}}},
+		cpus: []int{0, 1},
+		cpuFiles: map[int]group{
+			0: {
+				cpuFiles:   map[string]map[int]readerCloser{"instructions": {0: counters[0], 1: counters[1]}},
+				names:      []string{"instructions"},
+				leaderName: "instructions",
+			},
+			1: {
+				cpuFiles:   map[string]map[int]readerCloser{"cycles": {0: counters[2], 1: counters[2]}},
+				names:      []string{"cycles"},
+				leaderName: "cycles",
+			},
+		},
+	}
+
+	stats := &info.ContainerStats{}
+	err := collector.UpdateStats(stats)
+	assert.NoError(t, err)
+	assert.NotNil(t, stats.PerfCoverage)
+	assert.Equal(t, 100.0, *stats.PerfCoverage)
+
+	// Cycles could not be opened on CPU 1.
+	delete(collector.cpuFiles[1].cpuFiles["cycles"], 1)
+	err = collector.UpdateStats(stats)
+	assert.NoError(t, err)
+	assert.NotNil(t, stats.PerfCoverage)
+	assert.Equal(t, 75.0, *stats.PerfCoverage)
+}
+
+func TestCollector_SetupExcludedEvents(t *testing.T) {
+	cgroupPath, err := ioutil.TempDir("", "perf_event")
+	assert.NoError(t, err)
+	defer os.RemoveAll(cgroupPath)
+
+	events := excludeEvents(Events{
+		Events: []Group{
+			{events: []Event{"instructions", "cycles"}, array: true},
+			{events: []Event{"cache-misses"}},
+		},
+		CustomEvents: []CustomEvent{
+			{Type: unix.PERF_TYPE_HARDWARE, Config: Config{unix.PERF_COUNT_HW_INSTRUCTIONS}, Name: "instructions"},
+			{Type: unix.PERF_TYPE_HARDWARE, Config: Config{unix.PERF_COUNT_HW_CPU_CYCLES}, Name: "cycles"},
+			{Type: unix.PERF_TYPE_HARDWARE, Config: Config{unix.PERF_COUNT_HW_CACHE_MISSES}, Name: "cache-misses"},
+		},
+	}, []string{"instructions", "cache-*"})
+
+	opened := map[uint64][]int{}
+	collector := newCollector(cgroupPath, PerfEvents{Core: events}, []int{0, 1}, map[int]int{}, map[int]physicalCore{})
+	collector.perfEventOpen = func(attr *unix.PerfEventAttr, pid int, cpu int, groupFd int, flags int) (int, error) {
+		opened[attr.Config] = append(opened[attr.Config], groupFd)
+		return unix.Open(os.DevNull, unix.O_RDONLY, 0)
+	}
+	collector.ioctlSetInt = func(fd int, req uint, value int) error {
+		return nil
+	}
+	defer collector.Destroy()
+
+	err = collector.setup()
+	assert.NoError(t, err)
+	// Only cycles is opened, as the leader of its group on both CPUs.
+	assert.Equal(t, map[uint64][]int{
+		unix.PERF_COUNT_HW_CPU_CYCLES: {groupLeaderFileDescriptor, groupLeaderFileDescriptor},
+	}, opened)
+	assert.Len(t, collector.cpuFiles, 1)
+	assert.Equal(t, "cycles", collector.cpuFiles[0].leaderName)
+	assert.Equal(t, []string{"cycles"}, collector.cpuFiles[0].names)
+
+	effective := collector.EffectiveEvents()
+	assert.Equal(t, []string{"instructions", "cache-misses"}, effective.Excluded)
+}
+
+func TestGetVersions(t *testing.T) {
+	if checkLibpfmInitialized() != nil {
+		t.Skip("libpfm4 is not initialized")
+	}
+	versions := GetVersions()
+	assert.Regexp(t, `^\d+\.\d+$`, versions.Libpfm)
+	assert.NotEmpty(t, versions.Kernel)
+	assert.LessOrEqual(t, versions.PerfEventAttrSize, uint32(unsafe.Sizeof(unix.PerfEventAttr{})))
+}
+
+func TestCollector_UpdateStatsCgroupMoved(t *testing.T) {
+	root, err := ioutil.TempDir("", "perf_event")
+	assert.NoError(t, err)
+	defer os.RemoveAll(root)
+	cgroupPath := filepath.Join(root, "container")
+	assert.NoError(t, os.Mkdir(cgroupPath, 0755))
+
+	leaders := 0
+	collector := newCollector(cgroupPath, PerfEvents{
+		Core: Events{
+			Events: []Group{{events: []Event{"instructions", "cycles"}, array: true}},
+			CustomEvents: []CustomEvent{
+				{Type: unix.PERF_TYPE_HARDWARE, Config: Config{unix.PERF_COUNT_HW_INSTRUCTIONS}, Name: "instructions"},
+				{Type: unix.PERF_TYPE_HARDWARE, Config: Config{unix.PERF_COUNT_HW_CPU_CYCLES}, Name: "cycles"},
+			},
+		},
+		FollowCgroupMoves: true,
+	}, []int{0}, map[int]int{}, map[int]physicalCore{})
+	collector.perfEventOpen = func(attr *unix.PerfEventAttr, pid int, cpu int, groupFd int, flags int) (int, error) {
+		if groupFd == groupLeaderFileDescriptor {
+			leaders++
+		}
+		return unix.Open(os.DevNull, unix.O_RDONLY, 0)
+	}
+	collector.ioctlSetInt = func(fd int, req uint, value int) error {
+		return nil
+	}
+	defer collector.Destroy()
+
+	err = collector.setup()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, leaders)
+	openCalls := collector.OpenCalls()
+
+	// Events are not reopened while cgroup directory stays the same.
+	err = collector.UpdateStats(&info.ContainerStats{})
+	assert.NoError(t, err)
+	assert.Equal(t, openCalls, collector.OpenCalls())
+
+	// Cgroup is moved away and a new directory appears at its path.
+	assert.NoError(t, os.Rename(cgroupPath, filepath.Join(root, "moved")))
+	assert.NoError(t, os.Mkdir(cgroupPath, 0755))
+	err = collector.UpdateStats(&info.ContainerStats{})
+	assert.NoError(t, err)
+	// Both events are opened again on the new directory.
+	assert.Equal(t, 2*openCalls, collector.OpenCalls())
+	assert.Equal(t, 2, leaders)
+	assert.Equal(t, []string{"instructions", "cycles"}, collector.cpuFiles[0].names)
+	// First values read after reopening are going to be marked as reopened.
+	assert.True(t, collector.discontinuous)
+
+	// And only once.
+	err = collector.UpdateStats(&info.ContainerStats{})
+	assert.NoError(t, err)
+	assert.Equal(t, 2*openCalls, collector.OpenCalls())
+}
+
+func TestCollector_UpdateStatsDiscontinuous(t *testing.T) {
+	files := map[int]readerCloser{0: &fakeCounter{value: 100, time: 1}, 1: &fakeCounter{value: 200, time: 1}}
+	collector := collector{
+		uncore: &stats.NoopCollector{},
+		cpuFiles: map[int]group{
+			0: {
+				cpuFiles:   map[string]map[int]readerCloser{"instructions": files},
+				names:      []string{"instructions"},
+				leaderName: "instructions",
+			},
+		},
+		// Events have just been reopened.
+		discontinuous: true,
+	}
+
+	// The first sample after reopening is marked on every CPU.
+	stats := &info.ContainerStats{}
+	err := collector.UpdateStats(stats)
+	assert.NoError(t, err)
+	assert.Len(t, stats.PerfStats, 2)
+	for _, stat := range stats.PerfStats {
+		assert.True(t, stat.Reopened)
+	}
+
+	// And only the first one.
+	err = collector.UpdateStats(stats)
+	assert.NoError(t, err)
+	assert.Len(t, stats.PerfStats, 2)
+	for _, stat := range stats.PerfStats {
+		assert.False(t, stat.Reopened)
+	}
+}
+
+// singleEvent simulates perf event that is read without group format.
+type singleEvent struct {
+	ReadFormat
+}
+
+func (s singleEvent) Read(p []byte) (int, error) {
+	buf := &bytes.Buffer{}
+	err := binary.Write(buf, binary.LittleEndian, s.ReadFormat)
+	if err != nil {
+		return 0, err
+	}
+	return copy(p, buf.Bytes()), nil
+}
+
+func (s singleEvent) Close() error {
+	return nil
+}
+
+func TestReadPerfStatFallbackToEvents(t *testing.T) {
+	group := group{
+		cpuFiles: map[string]map[int]readerCloser{
+			"instructions": {1: singleEvent{ReadFormat{Value: 100, TimeEnabled: 4, TimeRunning: 2, ID: 7}}},
+			"cycles":       {1: singleEvent{ReadFormat{Value: 300, TimeEnabled: 4, TimeRunning: 4, ID: 8}}},
+			"cache-misses": {},
+		},
+		names:      []string{"instructions", "cycles", "cache-misses"},
+		leaderName: "instructions",
+		ids:        map[string]map[int]uint64{},
+	}
+
+	// Leader returns value of its own, which cannot be decoded as values
+	// of the group, so each event is read on its own.
+	stat, err := readGroupPerfStat(group.cpuFiles["instructions"][1], group, 1, "/")
+	assert.NoError(t, err)
+	assert.Equal(t, []info.PerfStat{
+		{PerfValue: info.PerfValue{ScalingRatio: 0.5, Value: 200, RawValue: 100, Name: "instructions", TimeEnabled: 4, TimeRunning: 2}, Cpu: 1},
+		{PerfValue: info.PerfValue{ScalingRatio: 1, Value: 300, RawValue: 300, Name: "cycles", TimeEnabled: 4, TimeRunning: 4}, Cpu: 1},
+		// Event that could not be read is reported in error state.
+		{PerfValue: info.PerfValue{Name: "cache-misses", Errored: true}, Cpu: 1},
+	}, stat)
+	assert.Equal(t, uint64(8), group.ids["cycles"][1])
+
+	// Error of the group is returned if no event can be read.
+	group.cpuFiles = map[string]map[int]readerCloser{"instructions": {1: buffer{bytes.NewBuffer([]byte{1, 2, 3})}}}
+	_, err = readGroupPerfStat(group.cpuFiles["instructions"][1], group, 1, "/")
+	assert.EqualError(t, err, "unable to decode perf event group ( leader = instructions ): unexpected size of 3 bytes")
+}
+
+func TestReadMemberOfGroup(t *testing.T) {
+	buf := buffer{bytes.NewBuffer([]byte{})}
+	err := binary.Write(buf, binary.LittleEndian, GroupReadFormat{Nr: 3, TimeEnabled: 2, TimeRunning: 2})
+	assert.NoError(t, err)
+	err = binary.Write(buf, binary.LittleEndian, []Values{{Value: 1, ID: 5}, {Value: 2, ID: 6}, {Value: 3, ID: 7}})
+	assert.NoError(t, err)
+	group := group{
+		names:      []string{"instructions", "cycles"},
+		leaderName: "instructions",
+		// Kernel reports more events than configured, cycles is found by id.
+		ids: map[string]map[int]uint64{"cycles": {0: 7}},
+	}
+
+	value, err := readMember(buf, group, 1, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, info.PerfValue{ScalingRatio: 1, Value: 3, RawValue: 3, Name: "cycles", TimeEnabled: 2, TimeRunning: 2}, value)
+}
@@ -18,6 +18,8 @@
 package perf
 
 import (
+	"fmt"
+
 	"github.com/google/cadvisor/stats"
 
 	"k8s.io/klog/v2"
@@ -27,6 +29,41 @@ func NewCollector(cgroupPath string, events Events, numCores int) stats.Collecto
 	return &stats.NoopCollector{}
 }
 
+// RegisterSink does nothing as perf events are not collected.
+func RegisterSink(sink Sink) {
+	klog.V(1).Info("cAdvisor is build without cgo and/or libpfm support. Perf events will not be written to sink")
+}
+
+// RegisterThresholdCallback does nothing as perf events are not collected.
+func RegisterThresholdCallback(callback ThresholdCallback) {
+	klog.V(1).Info("cAdvisor is build without cgo and/or libpfm support. Threshold callback will not be called")
+}
+
+// EventDescription returns error as descriptions are provided by libpfm4.
+func EventDescription(event Event) (string, error) {
+	return "", fmt.Errorf("cAdvisor is build without cgo and/or libpfm support, description of event %s is not available", event)
+}
+
+// SupportedEvents returns error as supported events are provided by libpfm4.
+func SupportedEvents() ([]SupportedEvent, error) {
+	return nil, fmt.Errorf("cAdvisor is build without cgo and/or libpfm support, supported events are not available")
+}
+
+// Validate returns error as perf events are encoded with libpfm4.
+func Validate(events PerfEvents) error {
+	return fmt.Errorf("cAdvisor is build without cgo and/or libpfm support, perf events cannot be validated")
+}
+
+// FeasibilityCheck returns error as number of counters is provided by libpfm4.
+func FeasibilityCheck(events PerfEvents) (Feasibility, error) {
+	return Feasibility{}, fmt.Errorf("cAdvisor is build without cgo and/or libpfm support, number of hardware counters is not available")
+}
+
+// GetVersions returns release of the kernel only as libpfm4 is not used.
+func GetVersions() Versions {
+	return Versions{Kernel: kernelRelease()}
+}
+
 // Finalize terminates libpfm4 to free resources.
 func Finalize() {
 	klog.V(1).Info("cAdvisor is build without cgo and/or libpfm support. Nothing to be finalized")
@@ -0,0 +1,77 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Confidence of perf event values derived from their scaling ratios.
+package perf
+
+import (
+	"fmt"
+
+	info "github.com/google/cadvisor/info/v1"
+)
+
+const (
+	defaultHighConfidence   = 0.95
+	defaultMediumConfidence = 0.5
+)
+
+// withDefaults returns thresholds with defaults in place of the ones that
+// are not set.
+func (t ConfidenceThresholds) withDefaults() ConfidenceThresholds {
+	if t.High == 0 {
+		t.High = defaultHighConfidence
+	}
+	if t.Medium == 0 {
+		t.Medium = defaultMediumConfidence
+	}
+	return t
+}
+
+// validateConfidence checks if thresholds are scaling ratios in ascending
+// order of confidence.
+func validateConfidence(thresholds *ConfidenceThresholds) error {
+	if thresholds == nil {
+		return nil
+	}
+	t := thresholds.withDefaults()
+	if t.Medium < 0 || t.High > 1 || t.Medium > t.High {
+		return fmt.Errorf("confidence thresholds have to satisfy 0 <= medium (%v) <= high (%v) <= 1", t.Medium, t.High)
+	}
+	return nil
+}
+
+// confidence classifies value by its scaling ratio.
+func (t ConfidenceThresholds) confidence(value info.PerfValue) info.PerfConfidence {
+	switch {
+	case value.Errored || value.ScalingRatio <= 0:
+		return info.PerfConfidenceLow
+	case value.ScalingRatio >= t.High:
+		return info.PerfConfidenceHigh
+	case value.ScalingRatio >= t.Medium:
+		return info.PerfConfidenceMedium
+	default:
+		return info.PerfConfidenceLow
+	}
+}
+
+// addConfidence sets confidence of each value if it is enabled.
+func addConfidence(perfStats []info.PerfStat, thresholds *ConfidenceThresholds) {
+	if thresholds == nil {
+		return
+	}
+	t := thresholds.withDefaults()
+	for i := range perfStats {
+		perfStats[i].Confidence = t.confidence(perfStats[i].PerfValue)
+	}
+}
@@ -0,0 +1,73 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Confidence of perf event values derived from their scaling ratios.
+package perf
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	info "github.com/google/cadvisor/info/v1"
+)
+
+func TestAddConfidence(t *testing.T) {
+	perfStats := []info.PerfStat{
+		perfStat("instructions", 0, 100, 1),
+		perfStat("instructions", 1, 100, 0.95),
+		perfStat("instructions", 2, 100, 0.94),
+		perfStat("instructions", 3, 100, 0.5),
+		perfStat("instructions", 4, 100, 0.49),
+		perfStat("instructions", 5, 0, 0),
+	}
+	errored := perfStat("instructions", 6, 100, 1)
+	errored.Errored = true
+	perfStats = append(perfStats, errored)
+
+	addConfidence(perfStats, &ConfidenceThresholds{})
+	confidences := []info.PerfConfidence{}
+	for _, stat := range perfStats {
+		confidences = append(confidences, stat.Confidence)
+	}
+	assert.Equal(t, []info.PerfConfidence{
+		info.PerfConfidenceHigh,
+		info.PerfConfidenceHigh,
+		info.PerfConfidenceMedium,
+		info.PerfConfidenceMedium,
+		info.PerfConfidenceLow,
+		info.PerfConfidenceLow,
+		info.PerfConfidenceLow,
+	}, confidences)
+
+	// Configured thresholds.
+	addConfidence(perfStats, &ConfidenceThresholds{High: 0.9, Medium: 0.2})
+	assert.Equal(t, info.PerfConfidenceHigh, perfStats[2].Confidence)
+	assert.Equal(t, info.PerfConfidenceMedium, perfStats[4].Confidence)
+}
+
+func TestAddConfidenceDisabled(t *testing.T) {
+	perfStats := []info.PerfStat{perfStat("instructions", 0, 100, 1)}
+	addConfidence(perfStats, nil)
+	assert.Empty(t, perfStats[0].Confidence)
+}
+
+func TestValidateConfidence(t *testing.T) {
+	assert.NoError(t, validateConfidence(nil))
+	assert.NoError(t, validateConfidence(&ConfidenceThresholds{}))
+	assert.NoError(t, validateConfidence(&ConfidenceThresholds{High: 0.8}))
+	assert.Error(t, validateConfidence(&ConfidenceThresholds{High: 0.4}))
+	assert.Error(t, validateConfidence(&ConfidenceThresholds{High: 1.5}))
+	assert.Error(t, validateConfidence(&ConfidenceThresholds{Medium: -0.1}))
+}
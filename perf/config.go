@@ -20,6 +20,7 @@ import (
 	"fmt"
 	"os"
 	"strconv"
+	"time"
 
 	"k8s.io/klog/v2"
 )
@@ -30,6 +31,233 @@ type PerfEvents struct {
 
 	// Uncore perf events to be measured.
 	Uncore Events `json:"uncore,omitempty"`
+
+	// Report increase of core perf events since previous measurement
+	// instead of cumulative values.
+	Delta bool `json:"delta,omitempty"`
+
+	// Set up a group of core perf events on CPUs that its leader has been
+	// opened on when the leader fails to open on some of them, instead of
+	// failing the whole setup.
+	PartialGroups bool `json:"partial_groups,omitempty"`
+
+	// Open each event of a group of core perf events on its own when the
+	// group fails to open, instead of failing the whole setup. Events
+	// that fail to open on their own are skipped.
+	WeakGroups bool `json:"weak_groups,omitempty"`
+
+	// Skip core perf events that libpfm4 cannot encode on the host, e.g.
+	// because its PMU lacks them, instead of failing the whole setup.
+	// The other events of the group are set up without them.
+	IgnoreUnsupportedEvents bool `json:"ignore_unsupported_events,omitempty"`
+
+	// Report current frequency of CPU that core perf events were
+	// measured on.
+	Frequency bool `json:"frequency,omitempty"`
+
+	// Open core perf events only on CPUs in cpuset of the container
+	// instead of all online CPUs. Events are reopened when cpuset changes.
+	ContainerCPUs bool `json:"container_cpus,omitempty"`
+
+	// Reopen core perf events when set of online CPUs changes, e.g. when
+	// CPUs are hotplugged. Online CPUs are read from sysfs on each
+	// measurement.
+	CPUHotplug bool `json:"cpu_hotplug,omitempty"`
+
+	// Reopen core perf events when cgroup directory of the container is
+	// replaced, e.g. when container runtime moves the cgroup. Replacement
+	// is detected by change of inode of the directory on each measurement.
+	FollowCgroupMoves bool `json:"follow_cgroup_moves,omitempty"`
+
+	// Report core perf events summed up per physical core instead of per
+	// logical CPU.
+	PerCore bool `json:"per_core,omitempty"`
+
+	// Report uncore perf events summed up over instances of the same PMU
+	// type on each socket, e.g. all uncore_imc_N PMUs, instead of per PMU
+	// instance.
+	UncorePerSocket bool `json:"uncore_per_socket,omitempty"`
+
+	// Read core perf events on CPUs of each socket by a thread pinned to
+	// CPUs of the socket, so counters are not read from a remote socket on
+	// NUMA hosts.
+	PinReads bool `json:"pin_reads,omitempty"`
+
+	// Number of goroutines that read each group of core perf events on its
+	// CPUs concurrently. Groups are read by a single goroutine if it is not
+	// set. It does not apply when reads are pinned to sockets, which are
+	// read concurrently anyway.
+	ReadWorkers int `json:"read_workers,omitempty"`
+
+	// Report, in addition to values of core perf events on each CPU, their
+	// totals over all CPUs computed from the same read.
+	CPUTotals bool `json:"cpu_totals,omitempty"`
+
+	// Report how busy SMT siblings of CPU that core perf events were
+	// measured on have been since the previous measurement and flag values
+	// that may be skewed by tasks running on the siblings. Not reported
+	// with PerCore.
+	SMTContention bool `json:"smt_contention,omitempty"`
+
+	// Report estimate of multiplexing of core perf events on each CPU
+	// derived from scaling ratios of the groups.
+	Multiplexing bool `json:"multiplexing,omitempty"`
+
+	// Classify values of core perf events into confidence levels by their
+	// scaling ratios. Confidence is not reported if not set.
+	Confidence *ConfidenceThresholds `json:"confidence,omitempty"`
+
+	// Inheritance of core perf events by tasks created in the container.
+	Inheritance Inheritance `json:"inheritance,omitempty"`
+
+	// Scale values of perf events with the same arithmetic as perf stat
+	// so they match values reported by perf tool.
+	PerfStatScaling bool `json:"perf_stat_scaling,omitempty"`
+
+	// Upper bounds of histogram buckets that increases of core perf events
+	// between consecutive measurements are counted in. Histogram is not
+	// reported if empty.
+	HistogramBuckets []uint64 `json:"histogram_buckets,omitempty"`
+
+	// Maximum time of reading core perf events of a container in a single
+	// measurement, e.g. "50ms". Remaining events are not read once it is
+	// exceeded. There is no limit if not set.
+	ReadTimeout Duration `json:"read_timeout,omitempty"`
+
+	// Period, e.g. "1s", within which values of core perf events read by
+	// UpdateStats or by Snapshot are reused by the other one instead of
+	// reading the groups again. Groups are read by both if it is not set.
+	ReadCoalescing Duration `json:"read_coalescing,omitempty"`
+
+	// Events measured in different groups that are reported as a single
+	// event.
+	Aggregations []Aggregation `json:"aggregations,omitempty"`
+
+	// Rules that fire registered threshold callback when value of core
+	// perf event summed up over all CPUs crosses a threshold.
+	Thresholds []Threshold `json:"thresholds,omitempty"`
+
+	// Path where host cgroup hierarchy that perf events are measured in is
+	// available, when cAdvisor runs in a container with its own view of
+	// cgroups, e.g. /rootfs/sys/fs/cgroup/perf_event.
+	HostCgroupPath string `json:"host_cgroup_path,omitempty"`
+
+	// Privilege levels that core event is not counted at, by event name.
+	// Custom events can set them in their own configuration too.
+	Exclusions map[Event]Exclusion `json:"exclusions,omitempty"`
+
+	// Measure one group of core events at a time, moving to the next group
+	// on each measurement, instead of multiplexing all the groups.
+	Rotation bool `json:"rotation,omitempty"`
+
+	// Make sure that core perf events of a container include tasks of all
+	// its descendant cgroups.
+	SubtreeAggregate bool `json:"subtree_aggregate,omitempty"`
+
+	// Maximum number of events in a group that core events configured as
+	// separate groups are merged into, when they are counted by the same
+	// PMU. Events are not merged if not set.
+	MergeGroups int `json:"merge_groups,omitempty"`
+
+	// Do not set up perf events if cAdvisor has neither CAP_PERFMON nor
+	// CAP_SYS_ADMIN capability.
+	RequireCapabilities bool `json:"require_capabilities,omitempty"`
+
+	// Perf events to be measured on particular CPUs. The first set matching
+	// the CPU replaces core and uncore perf events.
+	Conditional []ConditionalEvents `json:"conditional,omitempty"`
+
+	// Report metrics derived from core perf events, e.g. level 1 of
+	// Top-down Microarchitecture Analysis, for the detected CPU.
+	DerivedMetrics bool `json:"derived_metrics,omitempty"`
+
+	// Number of consecutive failures to set up or reopen core perf events
+	// of a cgroup after which they are not attempted for the cgroup
+	// anymore. Setup is retried indefinitely if not set.
+	SetupFailureBudget int `json:"setup_failure_budget,omitempty"`
+
+	// Time, e.g. "1h", after the most recent failure to set up or reopen
+	// core perf events of a cgroup after which the failures are forgotten,
+	// so that the cgroup path can be set up again, e.g. when it is reused
+	// by another container. 10 minutes if not set.
+	SetupFailureExpiry Duration `json:"setup_failure_expiry,omitempty"`
+
+	// derivedMetrics are derived metrics selected for the CPU whose
+	// events are configured.
+	derivedMetrics []DerivedMetric
+}
+
+type Aggregation struct {
+	// Name of the event that values of aggregated events are reported as.
+	Name Event `json:"name"`
+
+	// Events which values are summed up.
+	Events []Event `json:"events"`
+
+	// Report values of aggregated events as well.
+	KeepEvents bool `json:"keep_events,omitempty"`
+}
+
+type ConfidenceThresholds struct {
+	// Minimum scaling ratio of value with high confidence, 0.95 if not set.
+	High float64 `json:"high,omitempty"`
+
+	// Minimum scaling ratio of value with medium confidence, 0.5 if not
+	// set. Values with lower scaling ratio have low confidence.
+	Medium float64 `json:"medium,omitempty"`
+}
+
+type Inheritance struct {
+	// Accumulate counts of exiting child tasks into their parents
+	// (inherit_stat bit of perf_event_attr).
+	Stat bool `json:"stat,omitempty"`
+
+	// Inherit events only by threads of the task and not by child
+	// processes (inherit_thread bit of perf_event_attr, Linux 5.13+).
+	Thread bool `json:"thread,omitempty"`
+}
+
+type Exclusion struct {
+	// Do not count the event in kernel (exclude_kernel bit of
+	// perf_event_attr).
+	Kernel bool `json:"exclude_kernel,omitempty"`
+
+	// Do not count the event in user space (exclude_user bit of
+	// perf_event_attr).
+	User bool `json:"exclude_user,omitempty"`
+
+	// Do not count the event in hypervisor (exclude_hv bit of
+	// perf_event_attr).
+	Hypervisor bool `json:"exclude_hv,omitempty"`
+}
+
+type Threshold struct {
+	// Name of the event, as reported, that the rule applies to.
+	Event Event `json:"event"`
+
+	// Comparator of value of the event with the threshold: ">" or "<".
+	Comparator string `json:"comparator"`
+
+	// Threshold that value of the event is compared with.
+	Value uint64 `json:"value"`
+}
+
+type ConditionalEvents struct {
+	// CPU vendor as reported by vendor_id (x86) or CPU implementer (ARM)
+	// field of /proc/cpuinfo, e.g. GenuineIntel, AuthenticAMD or 0x41.
+	Vendor string `json:"vendor"`
+
+	// CPU families that the set applies to. Empty list matches any family.
+	Families []int64 `json:"families,omitempty"`
+
+	// CPU models that the set applies to. Empty list matches any model.
+	Models []int64 `json:"models,omitempty"`
+
+	// Core perf events to be measured.
+	Core Events `json:"core,omitempty"`
+
+	// Uncore perf events to be measured.
+	Uncore Events `json:"uncore,omitempty"`
 }
 
 type Events struct {
@@ -40,6 +268,15 @@ type Events struct {
 	// specify some events using their names and in such case you have
 	// to provide lower level configuration.
 	CustomEvents []CustomEvent `json:"custom_events"`
+
+	// List of software perf events to be measured. They are counted by
+	// kernel and do not consume hardware counters. Only core software
+	// events are supported.
+	SoftwareEvents []SoftwareEvent `json:"software_events,omitempty"`
+
+	// excluded lists configured events that are not measured because they
+	// match --perf_events_exclude.
+	excluded []Event
 }
 
 type Event string
@@ -56,6 +293,19 @@ type CustomEvent struct {
 
 	// Human readable name of metric that will be created from the event.
 	Name Event `json:"name"`
+
+	// Privilege levels that the event is not counted at, when it is
+	// measured as core event.
+	Exclusion
+}
+
+type SoftwareEvent struct {
+	// Id of the software event, e.g. 3 for PERF_COUNT_SW_CONTEXT_SWITCHES.
+	// See perf_event_attr documentation at man perf_event_open.
+	Config uint64 `json:"config"`
+
+	// Human readable name of metric that will be created from the event.
+	Name Event `json:"name"`
 }
 
 type Config []uint64
@@ -80,6 +330,22 @@ func (c *Config) UnmarshalJSON(b []byte) error {
 	return nil
 }
 
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var duration string
+	err := json.Unmarshal(b, &duration)
+	if err != nil {
+		return fmt.Errorf("unmarshalling %s into string failed: %q", b, err)
+	}
+	parsed, err := time.ParseDuration(duration)
+	if err != nil {
+		return fmt.Errorf("parsing %q into duration failed: %q", duration, err)
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
 func parseConfig(file *os.File) (events PerfEvents, err error) {
 	decoder := json.NewDecoder(file)
 	err = decoder.Decode(&events)
@@ -93,6 +359,33 @@ func parseConfig(file *os.File) (events PerfEvents, err error) {
 type Group struct {
 	events []Event
 	array  bool
+
+	// leaderOnly indicates that only the value of group leader should be
+	// read and reported. Followers are still opened in the group so
+	// they are scheduled together with the leader.
+	leaderOnly bool
+
+	// readTimeout is maximum time of reading the group on all CPUs in a
+	// single measurement. There is no limit if zero.
+	readTimeout time.Duration
+}
+
+// groupConfig is an object form of the group that allows to pass
+// additional options alongside the events.
+type groupConfig struct {
+	// List of perf events' names in the group. First one is the leader
+	// unless Leader is set.
+	Events []Event `json:"events"`
+
+	// Event that leads the group. It has to be one of Events.
+	Leader Event `json:"leader,omitempty"`
+
+	// Read and report only the value of group leader.
+	LeaderOnly bool `json:"leader_only,omitempty"`
+
+	// Maximum time of reading the group, e.g. "10ms". Read that takes
+	// longer is abandoned and remaining groups are read.
+	ReadTimeout Duration `json:"read_timeout,omitempty"`
 }
 
 func (g *Group) UnmarshalJSON(b []byte) error {
@@ -122,6 +415,47 @@ func (g *Group) UnmarshalJSON(b []byte) error {
 		}
 		*g = group
 		return nil
+	case map[string]interface{}:
+		group := groupConfig{}
+		err = json.Unmarshal(b, &group)
+		if err != nil {
+			return err
+		}
+		if len(group.Events) == 0 {
+			return fmt.Errorf("group %s does not contain any events", b)
+		}
+		if group.Leader != "" {
+			group.Events, err = leaderFirst(group.Events, group.Leader)
+			if err != nil {
+				return fmt.Errorf("invalid group %s: %w", b, err)
+			}
+		}
+		*g = Group{
+			events:      group.Events,
+			array:       true,
+			leaderOnly:  group.LeaderOnly,
+			readTimeout: time.Duration(group.ReadTimeout),
+		}
+		return nil
 	}
 	return fmt.Errorf("unsupported type")
 }
+
+// leaderFirst moves the leader to the beginning of events, as the first
+// event is opened as group leader. Order of other events is kept.
+func leaderFirst(events []Event, leader Event) ([]Event, error) {
+	ordered := []Event{leader}
+	for _, event := range events {
+		if event != leader {
+			ordered = append(ordered, event)
+		}
+	}
+	switch len(events) - len(ordered) {
+	case -1:
+		return nil, fmt.Errorf("leader %s is not one of events of the group", leader)
+	case 0:
+		return ordered, nil
+	default:
+		return nil, fmt.Errorf("leader %s appears more than once in the group", leader)
+	}
+}
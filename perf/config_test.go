@@ -15,8 +15,10 @@
 package perf
 
 import (
+	"encoding/json"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -49,3 +51,70 @@ func TestConfigParsing(t *testing.T) {
 	assert.Equal(t, Event("cas_count_write"), events.Uncore.CustomEvents[0].Name)
 
 }
+
+func TestGroupParsing(t *testing.T) {
+	var events Events
+	err := json.Unmarshal([]byte(`{"events": ["cycles", {"events": ["instructions", "cycles"], "leader_only": true}]}`), &events)
+
+	assert.Nil(t, err)
+	assert.Len(t, events.Events, 2)
+	assert.Equal(t, Group{events: []Event{"cycles"}, array: false}, events.Events[0])
+	assert.Equal(t, Group{events: []Event{"instructions", "cycles"}, array: true, leaderOnly: true}, events.Events[1])
+
+	err = json.Unmarshal([]byte(`{"events": [{"leader_only": true}]}`), &events)
+	assert.NotNil(t, err)
+}
+
+func TestGroupLeaderParsing(t *testing.T) {
+	var events Events
+	err := json.Unmarshal([]byte(`{"events": [{"events": ["instructions", "cycles", "cache-misses"], "leader": "cycles"}]}`), &events)
+	assert.Nil(t, err)
+	assert.Equal(t, Group{events: []Event{"cycles", "instructions", "cache-misses"}, array: true}, events.Events[0])
+
+	err = json.Unmarshal([]byte(`{"events": [{"events": ["instructions", "cycles"], "leader": "cache-misses"}]}`), &events)
+	assert.NotNil(t, err)
+
+	err = json.Unmarshal([]byte(`{"events": [{"events": ["instructions", "cycles", "cycles"], "leader": "cycles"}]}`), &events)
+	assert.NotNil(t, err)
+}
+
+func TestReadTimeoutParsing(t *testing.T) {
+	var events PerfEvents
+	err := json.Unmarshal([]byte(`{"read_timeout": "50ms"}`), &events)
+	assert.Nil(t, err)
+	assert.Equal(t, Duration(50*time.Millisecond), events.ReadTimeout)
+
+	err = json.Unmarshal([]byte(`{"read_timeout": "fifty"}`), &events)
+	assert.NotNil(t, err)
+
+	err = json.Unmarshal([]byte(`{"read_timeout": 50}`), &events)
+	assert.NotNil(t, err)
+}
+
+func TestReadCoalescingParsing(t *testing.T) {
+	var events PerfEvents
+	err := json.Unmarshal([]byte(`{"read_coalescing": "1s"}`), &events)
+	assert.Nil(t, err)
+	assert.Equal(t, Duration(time.Second), events.ReadCoalescing)
+}
+
+func TestGroupReadTimeoutParsing(t *testing.T) {
+	var events Events
+	err := json.Unmarshal([]byte(`{"events": [{"events": ["instructions", "cycles"], "read_timeout": "10ms"}]}`), &events)
+	assert.Nil(t, err)
+	assert.Equal(t, Group{events: []Event{"instructions", "cycles"}, array: true, readTimeout: 10 * time.Millisecond}, events.Events[0])
+
+	err = json.Unmarshal([]byte(`{"events": [{"events": ["instructions"], "read_timeout": "ten"}]}`), &events)
+	assert.NotNil(t, err)
+}
+
+func TestExclusionParsing(t *testing.T) {
+	var events PerfEvents
+	err := json.Unmarshal([]byte(`{
+		"core": {"custom_events": [{"type": 4, "config": ["0xc0"], "name": "instructions_retired", "exclude_kernel": true, "exclude_hv": true}]},
+		"exclusions": {"cycles": {"exclude_user": true}}
+	}`), &events)
+	assert.Nil(t, err)
+	assert.Equal(t, Exclusion{Kernel: true, Hypervisor: true}, events.Core.CustomEvents[0].Exclusion)
+	assert.Equal(t, map[Event]Exclusion{"cycles": {User: true}}, events.Exclusions)
+}
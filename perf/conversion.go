@@ -0,0 +1,73 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Conversions of values of perf events to domain specific units.
+package perf
+
+import (
+	"sync"
+
+	info "github.com/google/cadvisor/info/v1"
+)
+
+// Conversion converts reported value of perf event to a domain specific
+// quantity, e.g. energy in joules, and returns it with its unit.
+type Conversion func(value uint64) (float64, string)
+
+var (
+	// Conversions registered by event name.
+	conversions      = map[string]Conversion{}
+	conversionsMutex = sync.RWMutex{}
+)
+
+// RegisterConversion registers conversion of values of the event as it is
+// reported, i.e. after aggregations. Values of events without conversion are
+// reported as they are. Registering nil conversion removes the previous one.
+func RegisterConversion(event Event, conversion Conversion) {
+	conversionsMutex.Lock()
+	defer conversionsMutex.Unlock()
+	if conversion == nil {
+		delete(conversions, string(event))
+		return
+	}
+	conversions[string(event)] = conversion
+}
+
+// convert sets converted value and unit of the value if conversion is
+// registered for the event.
+func convert(value *info.PerfValue) {
+	conversion, ok := conversions[value.Name]
+	if !ok || value.Errored {
+		return
+	}
+	value.ConvertedValue, value.Unit = conversion(value.Value)
+}
+
+// convertPerfStats applies registered conversions to core perf events.
+func convertPerfStats(perfStats []info.PerfStat) {
+	conversionsMutex.RLock()
+	defer conversionsMutex.RUnlock()
+	for i := range perfStats {
+		convert(&perfStats[i].PerfValue)
+	}
+}
+
+// convertUncoreStats applies registered conversions to uncore perf events.
+func convertUncoreStats(perfStats []info.PerfUncoreStat) {
+	conversionsMutex.RLock()
+	defer conversionsMutex.RUnlock()
+	for i := range perfStats {
+		convert(&perfStats[i].PerfValue)
+	}
+}
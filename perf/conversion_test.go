@@ -0,0 +1,59 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Conversions of values of perf events to domain specific units.
+package perf
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	info "github.com/google/cadvisor/info/v1"
+)
+
+func TestConversions(t *testing.T) {
+	// Energy is counted in units of 2^-32 joules.
+	RegisterConversion("energy-pkg", func(value uint64) (float64, string) {
+		return float64(value) / (1 << 32), "joules"
+	})
+	defer RegisterConversion("energy-pkg", nil)
+
+	errored := perfStat("energy-pkg", 1, 0, 0)
+	errored.Errored = true
+	perfStats := []info.PerfStat{
+		perfStat("energy-pkg", 0, 3<<32, 1),
+		perfStat("instructions", 0, 42, 1),
+		errored,
+	}
+	convertPerfStats(perfStats)
+	assert.Equal(t, 3.0, perfStats[0].ConvertedValue)
+	assert.Equal(t, "joules", perfStats[0].Unit)
+	assert.Equal(t, uint64(3<<32), perfStats[0].Value)
+	// Values of other events are reported as they are.
+	assert.Zero(t, perfStats[1].ConvertedValue)
+	assert.Empty(t, perfStats[1].Unit)
+	assert.Empty(t, perfStats[2].Unit)
+
+	uncoreStats := []info.PerfUncoreStat{uncoreStat("energy-pkg", "power", 0, 1<<31, 1)}
+	convertUncoreStats(uncoreStats)
+	assert.Equal(t, 0.5, uncoreStats[0].ConvertedValue)
+	assert.Equal(t, "joules", uncoreStats[0].Unit)
+
+	// Conversion is removed.
+	RegisterConversion("energy-pkg", nil)
+	perfStats = []info.PerfStat{perfStat("energy-pkg", 0, 3<<32, 1)}
+	convertPerfStats(perfStats)
+	assert.Empty(t, perfStats[0].Unit)
+}
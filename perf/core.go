@@ -0,0 +1,119 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Mapping of perf events measured on logical CPUs to physical cores.
+package perf
+
+import (
+	info "github.com/google/cadvisor/info/v1"
+)
+
+// physicalCore is a core that logical CPU belongs to.
+type physicalCore struct {
+	// Id of the core as reported in machine topology. It is unique within
+	// a socket only.
+	id int
+	// The lowest logical CPU of the core which identifies it on the host.
+	firstCPU int
+}
+
+// getCPUToCore maps logical CPUs to physical cores they belong to.
+func getCPUToCore(topology []info.Node) map[int]physicalCore {
+	cpuToCore := map[int]physicalCore{}
+	for _, node := range topology {
+		for _, core := range node.Cores {
+			if len(core.Threads) == 0 {
+				continue
+			}
+			firstCPU := core.Threads[0]
+			for _, thread := range core.Threads {
+				if thread < firstCPU {
+					firstCPU = thread
+				}
+			}
+			for _, thread := range core.Threads {
+				cpuToCore[thread] = physicalCore{id: core.Id, firstCPU: firstCPU}
+			}
+		}
+	}
+	return cpuToCore
+}
+
+// addCores sets physical core that perf events were measured on.
+func addCores(perfStats []info.PerfStat, cpuToCore map[int]physicalCore) {
+	for i := range perfStats {
+		if core, ok := cpuToCore[perfStats[i].Cpu]; ok {
+			perfStats[i].Core = core.id
+		}
+	}
+}
+
+// aggregateCores sums values of events measured on logical CPUs of the
+// same physical core and reports them as measured on the lowest CPU of the
+// core. Scaling ratio of the sum is the lowest ratio of its events and
+// running time is the sum of their running times. Events in error state are
+// not taken into account. Events measured on CPUs that
+// are missing in the topology are reported as they are.
+func aggregateCores(perfStats []info.PerfStat, cpuToCore map[int]physicalCore) []info.PerfStat {
+	result := make([]info.PerfStat, 0, len(perfStats))
+	aggregated := map[aggregationKey]int{}
+	for _, stat := range perfStats {
+		core, ok := cpuToCore[stat.Cpu]
+		if !ok {
+			result = append(result, stat)
+			continue
+		}
+
+		key := aggregationKey{name: stat.Name, cpu: core.firstCPU}
+		position, ok := aggregated[key]
+		if !ok {
+			combined := stat
+			combined.Cpu = core.firstCPU
+			combined.Core = core.id
+			if stat.Histogram != nil {
+				combined.Histogram = append([]info.PerfHistogramBucket{}, stat.Histogram...)
+			}
+			if stat.Errored {
+				combined.Value = 0
+				combined.RawValue = 0
+				combined.ScalingRatio = 0
+			}
+			aggregated[key] = len(result)
+			result = append(result, combined)
+			continue
+		}
+
+		combined := &result[position]
+		combined.Reopened = combined.Reopened || stat.Reopened
+		for i := range combined.Histogram {
+			if i < len(stat.Histogram) {
+				combined.Histogram[i].Count += stat.Histogram[i].Count
+			}
+		}
+		if stat.Errored {
+			continue
+		}
+		if combined.Errored {
+			combined.Errored = false
+			combined.ScalingRatio = stat.ScalingRatio
+		} else if stat.ScalingRatio < combined.ScalingRatio {
+			combined.ScalingRatio = stat.ScalingRatio
+		}
+		combined.Value += stat.Value
+		combined.RawValue += stat.RawValue
+		combined.TimeEnabled += stat.TimeEnabled
+		combined.TimeRunning += stat.TimeRunning
+	}
+	return result
+}
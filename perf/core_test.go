@@ -0,0 +1,122 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Mapping of perf events measured on logical CPUs to physical cores.
+package perf
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	info "github.com/google/cadvisor/info/v1"
+)
+
+// Two sockets with two cores each and two threads per core. Core ids
+// repeat on each socket.
+var smtTopology = []info.Node{
+	{Id: 0, Cores: []info.Core{
+		{Id: 0, Threads: []int{0, 4}, SocketID: 0},
+		{Id: 1, Threads: []int{1, 5}, SocketID: 0},
+	}},
+	{Id: 1, Cores: []info.Core{
+		{Id: 0, Threads: []int{6, 2}, SocketID: 1},
+		{Id: 1, Threads: []int{3, 7}, SocketID: 1},
+	}},
+}
+
+func coreStat(name string, cpu, core int, value uint64, scalingRatio float64) info.PerfStat {
+	stat := perfStat(name, cpu, value, scalingRatio)
+	stat.Core = core
+	return stat
+}
+
+func TestGetCPUToCore(t *testing.T) {
+	assert.Equal(t, map[int]physicalCore{
+		0: {id: 0, firstCPU: 0},
+		4: {id: 0, firstCPU: 0},
+		1: {id: 1, firstCPU: 1},
+		5: {id: 1, firstCPU: 1},
+		2: {id: 0, firstCPU: 2},
+		6: {id: 0, firstCPU: 2},
+		3: {id: 1, firstCPU: 3},
+		7: {id: 1, firstCPU: 3},
+	}, getCPUToCore(smtTopology))
+}
+
+func TestAddCores(t *testing.T) {
+	perfStats := []info.PerfStat{
+		perfStat("instructions", 4, 1000, 1),
+		perfStat("instructions", 6, 2000, 1),
+		perfStat("instructions", 8, 3000, 1),
+	}
+	addCores(perfStats, getCPUToCore(smtTopology))
+
+	assert.Equal(t, []info.PerfStat{
+		coreStat("instructions", 4, 0, 1000, 1),
+		coreStat("instructions", 6, 0, 2000, 1),
+		coreStat("instructions", 8, 0, 3000, 1),
+	}, perfStats)
+}
+
+func TestAggregateCores(t *testing.T) {
+	perfStats := []info.PerfStat{
+		perfStat("instructions", 0, 1000, 1),
+		perfStat("instructions", 1, 2000, 1),
+		perfStat("instructions", 2, 3000, 1),
+		perfStat("instructions", 3, 4000, 1),
+		perfStat("instructions", 4, 100, 0.5),
+		perfStat("instructions", 5, 200, 1),
+		perfStat("instructions", 6, 300, 1),
+		perfStat("instructions", 7, 400, 0.25),
+		perfStat("cycles", 0, 10, 1),
+		perfStat("cycles", 4, 20, 1),
+	}
+
+	assert.Equal(t, []info.PerfStat{
+		coreStat("instructions", 0, 0, 1100, 0.5),
+		coreStat("instructions", 1, 1, 2200, 1),
+		coreStat("instructions", 2, 0, 3300, 1),
+		coreStat("instructions", 3, 1, 4400, 0.25),
+		coreStat("cycles", 0, 0, 30, 1),
+	}, aggregateCores(perfStats, getCPUToCore(smtTopology)))
+}
+
+func TestAggregateCoresErrored(t *testing.T) {
+	errored := perfStat("instructions", 0, 0, 1)
+	errored.Errored = true
+
+	assert.Equal(t, []info.PerfStat{
+		coreStat("instructions", 0, 0, 100, 0.5),
+	}, aggregateCores([]info.PerfStat{errored, perfStat("instructions", 4, 100, 0.5)}, getCPUToCore(smtTopology)))
+
+	otherErrored := errored
+	otherErrored.Cpu = 4
+	allErrored := coreStat("instructions", 0, 0, 0, 0)
+	allErrored.Errored = true
+	assert.Equal(t, []info.PerfStat{allErrored}, aggregateCores([]info.PerfStat{errored, otherErrored}, getCPUToCore(smtTopology)))
+}
+
+func TestAggregateCoresHistogram(t *testing.T) {
+	first := perfStat("instructions", 0, 1000, 1)
+	first.Histogram = []info.PerfHistogramBucket{{UpperBound: 10, Count: 1}, {UpperBound: 100, Count: 2}}
+	second := perfStat("instructions", 4, 100, 1)
+	second.Histogram = []info.PerfHistogramBucket{{UpperBound: 10, Count: 3}, {UpperBound: 100, Count: 4}}
+
+	expected := coreStat("instructions", 0, 0, 1100, 1)
+	expected.Histogram = []info.PerfHistogramBucket{{UpperBound: 10, Count: 4}, {UpperBound: 100, Count: 6}}
+	assert.Equal(t, []info.PerfStat{expected}, aggregateCores([]info.PerfStat{first, second}, getCPUToCore(smtTopology)))
+	// Histogram of measured event is not modified.
+	assert.Equal(t, uint64(1), first.Histogram[0].Count)
+}
@@ -0,0 +1,52 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Coverage of configured perf events by measurements.
+package perf
+
+import (
+	info "github.com/google/cadvisor/info/v1"
+)
+
+// configuredEvents returns number of events in all the groups.
+func configuredEvents(events Events) int {
+	count := 0
+	for _, group := range events.Events {
+		count += len(group.events)
+	}
+	return count
+}
+
+// coverage returns percentage of configured events on CPUs that they are
+// intended to be measured on which have been read without error in a single
+// measurement. Nil is returned if no event is intended to be measured.
+func coverage(perfStats []info.PerfStat, events int, cpus int) *float64 {
+	intended := events * cpus
+	if intended == 0 {
+		return nil
+	}
+
+	measured := map[aggregationKey]struct{}{}
+	for _, stat := range perfStats {
+		if stat.Errored {
+			continue
+		}
+		measured[aggregationKey{name: stat.Name, cpu: stat.Cpu}] = struct{}{}
+	}
+	percentage := 100 * float64(len(measured)) / float64(intended)
+	if percentage > 100 {
+		percentage = 100
+	}
+	return &percentage
+}
@@ -0,0 +1,50 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Coverage of configured perf events by measurements.
+package perf
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	info "github.com/google/cadvisor/info/v1"
+)
+
+func TestConfiguredEvents(t *testing.T) {
+	events := Events{Events: []Group{
+		{events: []Event{"instructions", "cycles"}},
+		{events: []Event{"cache-misses"}},
+	}}
+	assert.Equal(t, 3, configuredEvents(events))
+	assert.Equal(t, 0, configuredEvents(Events{}))
+}
+
+func TestCoverage(t *testing.T) {
+	errored := perfStat("cycles", 1, 0, 0)
+	errored.Errored = true
+	perfStats := []info.PerfStat{
+		perfStat("instructions", 0, 10, 1),
+		perfStat("cycles", 0, 20, 1),
+		perfStat("instructions", 1, 10, 1),
+		errored,
+	}
+
+	// Two events on four CPUs, cycles on CPU 1 is in error state.
+	assert.Equal(t, 37.5, *coverage(perfStats, 2, 4))
+	assert.Equal(t, 75.0, *coverage(perfStats, 2, 2))
+	assert.Nil(t, coverage(perfStats, 2, 0))
+	assert.Nil(t, coverage(nil, 0, 2))
+}
@@ -0,0 +1,114 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Selection of perf events based on CPU vendor and model.
+package perf
+
+import (
+	"bufio"
+	"strconv"
+	"strings"
+)
+
+// cpuInfo identifies CPU that cAdvisor is running on.
+type cpuInfo struct {
+	vendor string
+	family int64
+	model  int64
+}
+
+// Fields of /proc/cpuinfo identifying CPU on x86 and ARM.
+var (
+	vendorFields = []string{"vendor_id", "CPU implementer"}
+	familyFields = []string{"cpu family", "CPU architecture"}
+	modelFields  = []string{"model", "CPU part"}
+)
+
+// parseCPUInfo parses content of /proc/cpuinfo. All CPUs are assumed to be
+// the same so only the first processor entry is taken into account.
+func parseCPUInfo(content string) cpuInfo {
+	fields := map[string]string{}
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.TrimSpace(line) == "" && len(fields) > 0 {
+			break
+		}
+		keyValue := strings.SplitN(line, ":", 2)
+		if len(keyValue) != 2 {
+			continue
+		}
+		fields[strings.TrimSpace(keyValue[0])] = strings.TrimSpace(keyValue[1])
+	}
+
+	info := cpuInfo{family: -1, model: -1}
+	for _, field := range vendorFields {
+		if value, ok := fields[field]; ok {
+			info.vendor = value
+			break
+		}
+	}
+	for _, field := range familyFields {
+		if value, ok := fields[field]; ok {
+			if family, err := strconv.ParseInt(value, 0, 64); err == nil {
+				info.family = family
+			}
+			break
+		}
+	}
+	for _, field := range modelFields {
+		if value, ok := fields[field]; ok {
+			if model, err := strconv.ParseInt(value, 0, 64); err == nil {
+				info.model = model
+			}
+			break
+		}
+	}
+	return info
+}
+
+func (c ConditionalEvents) matches(cpu cpuInfo) bool {
+	if c.Vendor != cpu.vendor {
+		return false
+	}
+	return matchesAny(c.Families, cpu.family) && matchesAny(c.Models, cpu.model)
+}
+
+// matchesAny returns true if list of expected values is empty or contains the value.
+func matchesAny(expected []int64, value int64) bool {
+	if len(expected) == 0 {
+		return true
+	}
+	for _, e := range expected {
+		if e == value {
+			return true
+		}
+	}
+	return false
+}
+
+// selectEvents returns events with core and uncore events replaced by the
+// first conditional event set matching the CPU. Events are returned
+// unchanged when no set matches.
+func selectEvents(events PerfEvents, cpu cpuInfo) PerfEvents {
+	for _, conditional := range events.Conditional {
+		if conditional.matches(cpu) {
+			events.Core = conditional.Core
+			events.Uncore = conditional.Uncore
+			break
+		}
+	}
+	events.Conditional = nil
+	return events
+}
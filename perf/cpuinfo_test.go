@@ -0,0 +1,79 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Selection of perf events based on CPU vendor and model.
+package perf
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	amdCPUInfo = `processor	: 0
+vendor_id	: AuthenticAMD
+cpu family	: 23
+model		: 49
+model name	: AMD EPYC 7742 64-Core Processor
+`
+	armCPUInfo = `processor	: 0
+BogoMIPS	: 243.75
+CPU implementer	: 0x41
+CPU architecture: 8
+CPU variant	: 0x3
+CPU part	: 0xd0c
+`
+)
+
+func TestParseCPUInfo(t *testing.T) {
+	intelCPUInfo, err := ioutil.ReadFile("testing/cpuinfo-intel")
+	assert.Nil(t, err)
+
+	assert.Equal(t, cpuInfo{vendor: "GenuineIntel", family: 6, model: 85}, parseCPUInfo(string(intelCPUInfo)))
+	assert.Equal(t, cpuInfo{vendor: "AuthenticAMD", family: 23, model: 49}, parseCPUInfo(amdCPUInfo))
+	assert.Equal(t, cpuInfo{vendor: "0x41", family: 8, model: 0xd0c}, parseCPUInfo(armCPUInfo))
+	assert.Equal(t, cpuInfo{vendor: "", family: -1, model: -1}, parseCPUInfo(""))
+}
+
+func TestSelectEvents(t *testing.T) {
+	file, err := os.Open("testing/perf-conditional.json")
+	assert.Nil(t, err)
+	defer file.Close()
+	events, err := parseConfig(file)
+	assert.Nil(t, err)
+	assert.Len(t, events.Conditional, 2)
+
+	testCases := []struct {
+		name     string
+		cpu      cpuInfo
+		expected Event
+	}{
+		{"Intel model matching", cpuInfo{vendor: "GenuineIntel", family: 6, model: 106}, "instructions_retired"},
+		{"Intel model not matching", cpuInfo{vendor: "GenuineIntel", family: 6, model: 79}, "instructions"},
+		{"Intel family not matching", cpuInfo{vendor: "GenuineIntel", family: 15, model: 85}, "instructions"},
+		{"AMD any model", parseCPUInfo(amdCPUInfo), "retired_instructions"},
+		{"ARM fallback to default", parseCPUInfo(armCPUInfo), "instructions"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			selected := selectEvents(events, tc.cpu)
+			assert.Len(t, selected.Core.Events, 1)
+			assert.Equal(t, []Event{tc.expected}, selected.Core.Events[0].events)
+			assert.Nil(t, selected.Conditional)
+		})
+	}
+}
@@ -0,0 +1,114 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Reading CPUs that container is allowed to run on.
+package perf
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// File that CPUs which are online are listed in.
+var onlineCPUsPath = "/sys/devices/system/cpu/online"
+
+// Files that effective cpuset is read from, in order of preference:
+// cgroup v2, cgroup v1 and cgroup v1 without effective cpuset.
+var cpusetFileNames = []string{"cpuset.cpus.effective", "cpuset.effective_cpus", "cpuset.cpus"}
+
+// readCpuset reads CPUs that tasks of cgroup are allowed to run on.
+func readCpuset(cgroupPath string) ([]int, error) {
+	for _, name := range cpusetFileNames {
+		content, err := ioutil.ReadFile(filepath.Join(cgroupPath, name))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return parseCPUList(strings.TrimSpace(string(content)))
+	}
+	return nil, fmt.Errorf("cpuset of cgroup %q not found", cgroupPath)
+}
+
+// readOnlineCPUs reads CPUs that are currently online.
+func readOnlineCPUs() ([]int, error) {
+	content, err := ioutil.ReadFile(onlineCPUsPath)
+	if err != nil {
+		return nil, err
+	}
+	return parseCPUList(strings.TrimSpace(string(content)))
+}
+
+// parseCPUList parses list of CPUs in format used by cpuset cgroup,
+// e.g. "0-3,8,10-11".
+func parseCPUList(list string) ([]int, error) {
+	cpus := []int{}
+	if list == "" {
+		return cpus, nil
+	}
+	for _, part := range strings.Split(list, ",") {
+		bounds := strings.SplitN(part, "-", 2)
+		first, err := strconv.Atoi(bounds[0])
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse CPU list %q: %w", list, err)
+		}
+		last := first
+		if len(bounds) == 2 {
+			last, err = strconv.Atoi(bounds[1])
+			if err != nil {
+				return nil, fmt.Errorf("unable to parse CPU list %q: %w", list, err)
+			}
+		}
+		if last < first {
+			return nil, fmt.Errorf("unable to parse CPU list %q: invalid range %q", list, part)
+		}
+		for cpu := first; cpu <= last; cpu++ {
+			cpus = append(cpus, cpu)
+		}
+	}
+	return cpus, nil
+}
+
+// filterCPUs returns online CPUs that are in cpuset.
+func filterCPUs(onlineCPUs []int, cpuset []int) []int {
+	allowed := make(map[int]struct{}, len(cpuset))
+	for _, cpu := range cpuset {
+		allowed[cpu] = struct{}{}
+	}
+	cpus := make([]int, 0, len(cpuset))
+	for _, cpu := range onlineCPUs {
+		if _, ok := allowed[cpu]; ok {
+			cpus = append(cpus, cpu)
+		}
+	}
+	return cpus
+}
+
+// equalCPUs checks if lists of CPUs are the same.
+func equalCPUs(a []int, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,96 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Reading CPUs that container is allowed to run on.
+package perf
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseCPUList(t *testing.T) {
+	var testCases = []struct {
+		list  string
+		cpus  []int
+		valid bool
+	}{
+		{"", []int{}, true},
+		{"0", []int{0}, true},
+		{"0-3,8,10-11", []int{0, 1, 2, 3, 8, 10, 11}, true},
+		{"3-1", nil, false},
+		{"a", nil, false},
+		{"0-b", nil, false},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.list, func(tt *testing.T) {
+			cpus, err := parseCPUList(testCase.list)
+			if testCase.valid {
+				assert.NoError(tt, err)
+				assert.Equal(tt, testCase.cpus, cpus)
+			} else {
+				assert.Error(tt, err)
+			}
+		})
+	}
+}
+
+func TestFilterCPUs(t *testing.T) {
+	assert.Equal(t, []int{1, 3}, filterCPUs([]int{0, 1, 2, 3}, []int{5, 3, 1}))
+	assert.Equal(t, []int{}, filterCPUs([]int{0, 1}, []int{}))
+}
+
+func TestReadOnlineCPUs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cpu")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	originalOnlineCPUsPath := onlineCPUsPath
+	defer func() {
+		onlineCPUsPath = originalOnlineCPUsPath
+	}()
+	onlineCPUsPath = filepath.Join(dir, "online")
+
+	_, err = readOnlineCPUs()
+	assert.Error(t, err)
+
+	assert.NoError(t, ioutil.WriteFile(onlineCPUsPath, []byte("0-2,5\n"), 0644))
+	cpus, err := readOnlineCPUs()
+	assert.NoError(t, err)
+	assert.Equal(t, []int{0, 1, 2, 5}, cpus)
+}
+
+func TestReadCpuset(t *testing.T) {
+	cgroupPath, err := ioutil.TempDir("", "cpuset")
+	assert.NoError(t, err)
+	defer os.RemoveAll(cgroupPath)
+
+	_, err = readCpuset(cgroupPath)
+	assert.Error(t, err)
+
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(cgroupPath, "cpuset.cpus"), []byte("0-7\n"), 0644))
+	cpus, err := readCpuset(cgroupPath)
+	assert.NoError(t, err)
+	assert.Equal(t, []int{0, 1, 2, 3, 4, 5, 6, 7}, cpus)
+
+	// Effective cpuset takes precedence.
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(cgroupPath, "cpuset.effective_cpus"), []byte("2-3\n"), 0644))
+	cpus, err = readCpuset(cgroupPath)
+	assert.NoError(t, err)
+	assert.Equal(t, []int{2, 3}, cpus)
+}
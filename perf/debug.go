@@ -0,0 +1,57 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Snapshot of collector state for debugging.
+package perf
+
+import (
+	"time"
+)
+
+// DebugSnapshot is state of perf collector of a container that can be
+// serialized, e.g. to JSON, and attached to bug reports. It contains
+// numbers of file descriptors but never the descriptors themselves.
+type DebugSnapshot struct {
+	CgroupPath string `json:"cgroup_path"`
+
+	// Configured groups of core and uncore events.
+	ConfiguredCore   [][]Event `json:"configured_core"`
+	ConfiguredUncore [][]Event `json:"configured_uncore"`
+
+	// Groups as they have been set up, with number of read timeouts.
+	Effective EffectiveEvents `json:"effective"`
+
+	// Number of open core perf event file descriptors on each CPU.
+	FileDescriptors map[int]int `json:"file_descriptors"`
+
+	// Number of perf_event_open calls made for core events.
+	OpenCalls uint64 `json:"open_calls"`
+
+	// Time when counting of core events started and time of the previous
+	// reading of them, zero if they have not been read yet.
+	StartTime    time.Time `json:"start_time"`
+	LastReadTime time.Time `json:"last_read_time"`
+
+	// Scaling ratios and error states of core events from the previous
+	// reading.
+	LastValues []DebugValue `json:"last_values"`
+}
+
+// DebugValue is state of core event on a CPU from the previous reading.
+type DebugValue struct {
+	Event        string  `json:"event"`
+	Cpu          int     `json:"cpu"`
+	ScalingRatio float64 `json:"scaling_ratio"`
+	Errored      bool    `json:"errored,omitempty"`
+}
@@ -0,0 +1,77 @@
+// +build libpfm,cgo
+
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Snapshot of collector state for debugging.
+package perf
+
+import (
+	"sort"
+)
+
+// DebugSnapshot returns copy of state of the collector. Core state is
+// captured at once under lock of the collector, uncore groups are added
+// afterwards.
+func (c *collector) DebugSnapshot() DebugSnapshot {
+	snapshot := DebugSnapshot{
+		CgroupPath:       c.cgroupPath,
+		ConfiguredCore:   configuredGroups(c.events.Core),
+		ConfiguredUncore: configuredGroups(c.events.Uncore),
+		FileDescriptors:  map[int]int{},
+	}
+
+	c.cpuFilesLock.Lock()
+	snapshot.Effective.Core = c.effectiveGroups()
+	for _, group := range c.cpuFiles {
+		for _, files := range group.cpuFiles {
+			for cpu := range files {
+				snapshot.FileDescriptors[cpu]++
+			}
+		}
+	}
+	snapshot.OpenCalls = c.openCalls
+	snapshot.StartTime = c.startTime
+	snapshot.LastReadTime = c.lastUpdateTime
+	snapshot.LastValues = make([]DebugValue, 0, len(c.lastPerfStats))
+	for _, stat := range c.lastPerfStats {
+		snapshot.LastValues = append(snapshot.LastValues, DebugValue{
+			Event:        stat.Name,
+			Cpu:          stat.Cpu,
+			ScalingRatio: stat.ScalingRatio,
+			Errored:      stat.Errored,
+		})
+	}
+	c.cpuFilesLock.Unlock()
+
+	sort.Slice(snapshot.LastValues, func(i, j int) bool {
+		if snapshot.LastValues[i].Event != snapshot.LastValues[j].Event {
+			return snapshot.LastValues[i].Event < snapshot.LastValues[j].Event
+		}
+		return snapshot.LastValues[i].Cpu < snapshot.LastValues[j].Cpu
+	})
+	if uncore, ok := c.uncore.(*uncoreCollector); ok {
+		snapshot.Effective.Uncore = uncore.effectiveGroups()
+	}
+	return snapshot
+}
+
+// configuredGroups returns names of events in each configured group.
+func configuredGroups(events Events) [][]Event {
+	groups := make([][]Event, 0, len(events.Events))
+	for _, group := range events.Events {
+		groups = append(groups, append([]Event{}, group.events...))
+	}
+	return groups
+}
@@ -0,0 +1,188 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Metrics derived from values of core perf events.
+package perf
+
+import (
+	"sort"
+	"sync"
+
+	info "github.com/google/cadvisor/info/v1"
+)
+
+// DerivedMetric is a metric computed from values of core perf events
+// measured on the same CPU.
+type DerivedMetric struct {
+	// Name that the metric is reported as.
+	Name string
+
+	// Events that the metric is computed from, as they are reported, i.e.
+	// after aggregations. Metric is computed only if all of them are
+	// configured.
+	Events []Event
+
+	// Formula computes the metric from values of Events. It returns false
+	// if the metric cannot be computed from the values, e.g. when divisor
+	// is zero.
+	Formula func(values map[Event]float64) (float64, bool)
+}
+
+// DerivedMetricSet is a set of derived metrics specific to CPUs of a
+// microarchitecture, identified as by ConditionalEvents.
+type DerivedMetricSet struct {
+	// Name of the microarchitecture, e.g. Intel Skylake.
+	Name string
+
+	// CPU vendor as reported by vendor_id (x86) or CPU implementer (ARM)
+	// field of /proc/cpuinfo, e.g. GenuineIntel.
+	Vendor string
+
+	// CPU families that the set applies to. Empty list matches any family.
+	Families []int64
+
+	// CPU models that the set applies to. Empty list matches any model.
+	Models []int64
+
+	// Metrics computed on the CPUs.
+	Metrics []DerivedMetric
+}
+
+var (
+	// Sets registered by RegisterDerivedMetrics, which take precedence
+	// over built-in sets.
+	registeredDerivedMetricSets      = []DerivedMetricSet{}
+	registeredDerivedMetricSetsMutex = sync.Mutex{}
+)
+
+// RegisterDerivedMetrics registers set of derived metrics for CPUs of a
+// microarchitecture. It is used instead of built-in and previously
+// registered sets matching the same CPU by managers created or reloaded
+// afterwards.
+func RegisterDerivedMetrics(set DerivedMetricSet) {
+	registeredDerivedMetricSetsMutex.Lock()
+	defer registeredDerivedMetricSetsMutex.Unlock()
+	registeredDerivedMetricSets = append([]DerivedMetricSet{set}, registeredDerivedMetricSets...)
+}
+
+func (s DerivedMetricSet) matches(cpu cpuInfo) bool {
+	if s.Vendor != cpu.vendor {
+		return false
+	}
+	return matchesAny(s.Families, cpu.family) && matchesAny(s.Models, cpu.model)
+}
+
+// selectDerivedMetrics returns generic derived metrics and metrics of the
+// first set matching the CPU.
+func selectDerivedMetrics(cpu cpuInfo) []DerivedMetric {
+	registeredDerivedMetricSetsMutex.Lock()
+	sets := append(append([]DerivedMetricSet{}, registeredDerivedMetricSets...), builtinDerivedMetricSets...)
+	registeredDerivedMetricSetsMutex.Unlock()
+
+	metrics := append([]DerivedMetric{}, genericDerivedMetrics...)
+	for _, set := range sets {
+		if set.matches(cpu) {
+			return append(metrics, set.Metrics...)
+		}
+	}
+	return metrics
+}
+
+// reportedEvents returns names that core events of the configuration are
+// reported as.
+func reportedEvents(events PerfEvents) map[Event]struct{} {
+	reported := map[Event]struct{}{}
+	for _, group := range events.Core.Events {
+		for _, event := range group.events {
+			reported[event] = struct{}{}
+		}
+	}
+	for _, aggregation := range events.Aggregations {
+		if !aggregation.KeepEvents {
+			for _, event := range aggregation.Events {
+				delete(reported, event)
+			}
+		}
+	}
+	for _, aggregation := range events.Aggregations {
+		reported[aggregation.Name] = struct{}{}
+	}
+	return reported
+}
+
+// availableDerivedMetrics splits metrics into those that can be computed
+// from the configured events and those that cannot, with events missing
+// for each of the latter.
+func availableDerivedMetrics(metrics []DerivedMetric, events PerfEvents) ([]DerivedMetric, map[string][]Event) {
+	reported := reportedEvents(events)
+	available := []DerivedMetric{}
+	missing := map[string][]Event{}
+	for _, metric := range metrics {
+		for _, event := range metric.Events {
+			if _, ok := reported[event]; !ok {
+				missing[metric.Name] = append(missing[metric.Name], event)
+			}
+		}
+		if _, ok := missing[metric.Name]; !ok {
+			available = append(available, metric)
+		}
+	}
+	return available, missing
+}
+
+// computeDerivedMetrics computes metrics on each CPU, and for totals over
+// all CPUs, from values of events measured there. Metric is not computed
+// on a CPU where any of its events is missing or in error state.
+func computeDerivedMetrics(perfStats []info.PerfStat, metrics []DerivedMetric) []info.PerfDerivedMetric {
+	if len(metrics) == 0 {
+		return nil
+	}
+	values := map[int]map[Event]float64{}
+	errored := map[int]map[Event]struct{}{}
+	for _, stat := range perfStats {
+		if _, ok := values[stat.Cpu]; !ok {
+			values[stat.Cpu] = map[Event]float64{}
+			errored[stat.Cpu] = map[Event]struct{}{}
+		}
+		if stat.Errored {
+			errored[stat.Cpu][Event(stat.Name)] = struct{}{}
+			continue
+		}
+		values[stat.Cpu][Event(stat.Name)] += float64(stat.Value)
+	}
+	cpus := make([]int, 0, len(values))
+	for cpu := range values {
+		cpus = append(cpus, cpu)
+	}
+	sort.Ints(cpus)
+
+	derived := []info.PerfDerivedMetric{}
+	for _, cpu := range cpus {
+	metrics:
+		for _, metric := range metrics {
+			for _, event := range metric.Events {
+				_, ok := values[cpu][event]
+				if _, isErrored := errored[cpu][event]; !ok || isErrored {
+					continue metrics
+				}
+			}
+			value, ok := metric.Formula(values[cpu])
+			if !ok {
+				continue
+			}
+			derived = append(derived, info.PerfDerivedMetric{Name: metric.Name, Cpu: cpu, Value: value})
+		}
+	}
+	return derived
+}
@@ -0,0 +1,164 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Metrics derived from values of core perf events tests.
+package perf
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	info "github.com/google/cadvisor/info/v1"
+)
+
+var skylake = cpuInfo{vendor: intelVendor, family: 6, model: 0x55}
+
+func derivedMetricValues(derived []info.PerfDerivedMetric, cpu int) map[string]float64 {
+	values := map[string]float64{}
+	for _, metric := range derived {
+		if metric.Cpu == cpu {
+			values[metric.Name] = metric.Value
+		}
+	}
+	return values
+}
+
+func TestTMALevel1FourWide(t *testing.T) {
+	perfStats := []info.PerfStat{
+		{PerfValue: info.PerfValue{Name: "cycles", Value: 1000}},
+		{PerfValue: info.PerfValue{Name: "instructions", Value: 1500}},
+		{PerfValue: info.PerfValue{Name: "IDQ_UOPS_NOT_DELIVERED:CORE", Value: 800}},
+		{PerfValue: info.PerfValue{Name: "UOPS_ISSUED:ANY", Value: 2200}},
+		{PerfValue: info.PerfValue{Name: "UOPS_RETIRED:RETIRE_SLOTS", Value: 2000}},
+		{PerfValue: info.PerfValue{Name: "INT_MISC:RECOVERY_CYCLES", Value: 50}},
+	}
+	values := derivedMetricValues(computeDerivedMetrics(perfStats, selectDerivedMetrics(skylake)), 0)
+
+	// 4000 slots in 1000 cycles.
+	assert.Len(t, values, 5)
+	assert.InDelta(t, 1.5, values["ipc"], 1e-9)
+	assert.InDelta(t, 0.2, values[tmaFrontendBound], 1e-9)
+	assert.InDelta(t, 0.1, values[tmaBadSpeculation], 1e-9)
+	assert.InDelta(t, 0.5, values[tmaRetiring], 1e-9)
+	assert.InDelta(t, 0.2, values[tmaBackendBound], 1e-9)
+}
+
+func TestTMALevel1SlotsEvent(t *testing.T) {
+	iceLake := cpuInfo{vendor: intelVendor, family: 6, model: 0x6a}
+	perfStats := []info.PerfStat{
+		{PerfValue: info.PerfValue{Name: "TOPDOWN:SLOTS", Value: 5000}},
+		{PerfValue: info.PerfValue{Name: "IDQ_UOPS_NOT_DELIVERED:CORE", Value: 1000}},
+		{PerfValue: info.PerfValue{Name: "UOPS_ISSUED:ANY", Value: 2600}},
+		{PerfValue: info.PerfValue{Name: "UOPS_RETIRED:SLOTS", Value: 2500}},
+		{PerfValue: info.PerfValue{Name: "INT_MISC:RECOVERY_CYCLES", Value: 20}},
+	}
+	values := derivedMetricValues(computeDerivedMetrics(perfStats, selectDerivedMetrics(iceLake)), 0)
+
+	assert.Len(t, values, 4)
+	assert.InDelta(t, 0.2, values[tmaFrontendBound], 1e-9)
+	assert.InDelta(t, 0.04, values[tmaBadSpeculation], 1e-9)
+	assert.InDelta(t, 0.5, values[tmaRetiring], 1e-9)
+	assert.InDelta(t, 0.26, values[tmaBackendBound], 1e-9)
+}
+
+func TestTMALevel1Clamped(t *testing.T) {
+	// Skid makes retired uops exceed slots.
+	perfStats := []info.PerfStat{
+		{PerfValue: info.PerfValue{Name: "cycles", Value: 100}},
+		{PerfValue: info.PerfValue{Name: "IDQ_UOPS_NOT_DELIVERED:CORE", Value: 0}},
+		{PerfValue: info.PerfValue{Name: "UOPS_ISSUED:ANY", Value: 400}},
+		{PerfValue: info.PerfValue{Name: "UOPS_RETIRED:RETIRE_SLOTS", Value: 450}},
+		{PerfValue: info.PerfValue{Name: "INT_MISC:RECOVERY_CYCLES", Value: 0}},
+	}
+	values := derivedMetricValues(computeDerivedMetrics(perfStats, selectDerivedMetrics(skylake)), 0)
+	assert.Equal(t, 1.0, values[tmaRetiring])
+	assert.Equal(t, 0.0, values[tmaBadSpeculation])
+	assert.Equal(t, 0.0, values[tmaBackendBound])
+}
+
+func TestSelectDerivedMetrics(t *testing.T) {
+	names := func(metrics []DerivedMetric) []string {
+		result := []string{}
+		for _, metric := range metrics {
+			result = append(result, metric.Name)
+		}
+		return result
+	}
+	assert.Equal(t, []string{"ipc", tmaFrontendBound, tmaBadSpeculation, tmaRetiring, tmaBackendBound}, names(selectDerivedMetrics(skylake)))
+	// Only generic metrics are known for other CPUs.
+	assert.Equal(t, []string{"ipc"}, names(selectDerivedMetrics(cpuInfo{vendor: "AuthenticAMD", family: 0x19, model: 0x11})))
+
+	// Registered set takes precedence over built-in one.
+	defer func() {
+		registeredDerivedMetricSets = []DerivedMetricSet{}
+	}()
+	RegisterDerivedMetrics(DerivedMetricSet{
+		Name:     "custom",
+		Vendor:   intelVendor,
+		Families: []int64{6},
+		Metrics: []DerivedMetric{{
+			Name:   "misses_per_instruction",
+			Events: []Event{"cache-misses", "instructions"},
+			Formula: func(values map[Event]float64) (float64, bool) {
+				return values["cache-misses"] / values["instructions"], values["instructions"] > 0
+			},
+		}},
+	})
+	assert.Equal(t, []string{"ipc", "misses_per_instruction"}, names(selectDerivedMetrics(skylake)))
+}
+
+func TestAvailableDerivedMetrics(t *testing.T) {
+	events := PerfEvents{
+		Core: Events{Events: []Group{
+			{events: []Event{"instructions", "cpu-cycles"}, array: true},
+			{events: []Event{"IDQ_UOPS_NOT_DELIVERED:CORE"}},
+		}},
+		// Events are required as they are reported.
+		Aggregations: []Aggregation{{Name: "cycles", Events: []Event{"cpu-cycles"}}},
+	}
+	available, missing := availableDerivedMetrics(selectDerivedMetrics(skylake), events)
+	assert.Len(t, available, 2)
+	assert.Equal(t, "ipc", available[0].Name)
+	assert.Equal(t, tmaFrontendBound, available[1].Name)
+	assert.Equal(t, map[string][]Event{
+		tmaBadSpeculation: {"UOPS_ISSUED:ANY", "UOPS_RETIRED:RETIRE_SLOTS", "INT_MISC:RECOVERY_CYCLES"},
+		tmaRetiring:       {"UOPS_RETIRED:RETIRE_SLOTS"},
+		tmaBackendBound:   {"UOPS_ISSUED:ANY", "UOPS_RETIRED:RETIRE_SLOTS", "INT_MISC:RECOVERY_CYCLES"},
+	}, missing)
+}
+
+func TestComputeDerivedMetrics(t *testing.T) {
+	perfStats := []info.PerfStat{
+		{PerfValue: info.PerfValue{Name: "instructions", Value: 300}, Cpu: 1},
+		{PerfValue: info.PerfValue{Name: "cycles", Value: 100}, Cpu: 1},
+		{PerfValue: info.PerfValue{Name: "instructions", Value: 100}, Cpu: 0},
+		{PerfValue: info.PerfValue{Name: "cycles", Value: 200}, Cpu: 0},
+		// Values in error state and zero divisor are not used.
+		{PerfValue: info.PerfValue{Name: "instructions", Value: 100}, Cpu: 2},
+		{PerfValue: info.PerfValue{Name: "cycles", Errored: true}, Cpu: 2},
+		{PerfValue: info.PerfValue{Name: "instructions", Value: 100}, Cpu: 3},
+		{PerfValue: info.PerfValue{Name: "cycles", Value: 0}, Cpu: 3},
+		{PerfValue: info.PerfValue{Name: "instructions", Value: 400}, Cpu: info.PerfStatAllCPUs},
+		{PerfValue: info.PerfValue{Name: "cycles", Value: 300}, Cpu: info.PerfStatAllCPUs},
+	}
+	derived := computeDerivedMetrics(perfStats, genericDerivedMetrics)
+	assert.Equal(t, []info.PerfDerivedMetric{
+		{Name: "ipc", Cpu: info.PerfStatAllCPUs, Value: 400.0 / 300},
+		{Name: "ipc", Cpu: 0, Value: 0.5},
+		{Name: "ipc", Cpu: 1, Value: 3},
+	}, derived)
+
+	assert.Nil(t, computeDerivedMetrics(perfStats, nil))
+}
@@ -0,0 +1,74 @@
+// +build libpfm,cgo
+
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Human readable descriptions of perf events.
+package perf
+
+// #cgo CFLAGS: -I/usr/include
+// #cgo LDFLAGS: -lpfm
+// #include <perfmon/pfmlib.h>
+// #include <stdlib.h>
+import "C"
+
+import (
+	"fmt"
+	"sync"
+	"unsafe"
+)
+
+var (
+	// Descriptions of events by name. They do not change, so each is
+	// looked up in libpfm4 once.
+	eventDescriptions      = map[Event]string{}
+	eventDescriptionsMutex = sync.Mutex{}
+)
+
+// EventDescription returns description of the event, e.g. what
+// "instructions" measure, as provided by libpfm4. It is meant for UIs and
+// debugging and it is not attached to perf stats. Custom events have no
+// description.
+func EventDescription(event Event) (string, error) {
+	eventDescriptionsMutex.Lock()
+	defer eventDescriptionsMutex.Unlock()
+
+	description, ok := eventDescriptions[event]
+	if ok {
+		return description, nil
+	}
+	description, err := readEventDescription(event)
+	if err != nil {
+		return "", err
+	}
+	eventDescriptions[event] = description
+	return description, nil
+}
+
+func readEventDescription(event Event) (string, error) {
+	cSafeName := C.CString(string(event))
+	defer C.free(unsafe.Pointer(cSafeName))
+
+	idx := C.pfm_find_event(cSafeName)
+	if idx < 0 {
+		return "", fmt.Errorf("unable to find event %s: %d", event, int(idx))
+	}
+	eventInfo := C.pfm_event_info_t{}
+	eventInfo.size = C.sizeof_pfm_event_info_t
+	pErr := C.pfm_get_event_info(idx, C.PFM_OS_NONE, &eventInfo)
+	if pErr != C.PFM_SUCCESS {
+		return "", fmt.Errorf("unable to get information about event %s: %d", event, int(pErr))
+	}
+	return C.GoString(eventInfo.desc), nil
+}
@@ -0,0 +1,44 @@
+// +build libpfm,cgo
+
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Human readable descriptions of perf events.
+package perf
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEventDescription(t *testing.T) {
+	description, err := EventDescription("instructions")
+	assert.NoError(t, err)
+	assert.NotEmpty(t, description)
+
+	// Description is cached.
+	eventDescriptionsMutex.Lock()
+	assert.Equal(t, description, eventDescriptions["instructions"])
+	eventDescriptionsMutex.Unlock()
+	cached, err := EventDescription("instructions")
+	assert.NoError(t, err)
+	assert.Equal(t, description, cached)
+
+	_, err = EventDescription("non-existing-event")
+	assert.Error(t, err)
+	eventDescriptionsMutex.Lock()
+	assert.NotContains(t, eventDescriptions, Event("non-existing-event"))
+	eventDescriptionsMutex.Unlock()
+}
@@ -0,0 +1,86 @@
+// +build libpfm,cgo
+
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Calculating increase of perf events between consecutive reads.
+package perf
+
+import (
+	info "github.com/google/cadvisor/info/v1"
+)
+
+type differKey struct {
+	groupIndex int
+	name       string
+	cpu        int
+}
+
+// differValue is value of perf event as read from OS, before it is scaled.
+type differValue struct {
+	raw         uint64
+	timeEnabled uint64
+	timeRunning uint64
+}
+
+// differ stores previously read values of perf events.
+type differ struct {
+	previous map[differKey]differValue
+}
+
+func newDiffer() *differ {
+	return &differ{previous: map[differKey]differValue{}}
+}
+
+// delta returns increase of the event since previous call. Increase of the
+// counter is scaled by the times that the event was enabled and running
+// for in between, as scaled cumulative value may go down when the event is
+// multiplexed. First value read for the event is returned as is because
+// counting starts from zero. The same applies when the event has been
+// reopened or its counter went backwards (e.g. it has been reset). Value of
+// errored event is returned as is and is not remembered.
+func (d *differ) delta(groupIndex int, stat info.PerfStat, perfStatScaling bool) uint64 {
+	if stat.Errored {
+		return stat.Value
+	}
+	key := differKey{groupIndex: groupIndex, name: stat.Name, cpu: stat.Cpu}
+	current := differValue{raw: stat.RawValue, timeEnabled: stat.TimeEnabled, timeRunning: stat.TimeRunning}
+	previous, ok := d.previous[key]
+	d.previous[key] = current
+	if !ok || stat.Reopened || current.raw < previous.raw || current.timeEnabled < previous.timeEnabled || current.timeRunning < previous.timeRunning {
+		return stat.Value
+	}
+	timeEnabled, timeRunning := current.timeEnabled-previous.timeEnabled, current.timeRunning-previous.timeRunning
+	if timeEnabled == 0 && timeRunning == 0 {
+		// Times are not known or have not advanced.
+		return current.raw - previous.raw
+	}
+	value, _ := scaleValue(current.raw-previous.raw, timeEnabled, timeRunning, perfStatScaling)
+	return value
+}
+
+// remapGroups moves previous values of groups to their new indexes, given
+// by their previous indexes. Values of groups without new index are dropped.
+func (d *differ) remapGroups(indexes map[int]int) {
+	previous := make(map[differKey]differValue, len(d.previous))
+	for key, value := range d.previous {
+		index, ok := indexes[key.groupIndex]
+		if !ok {
+			continue
+		}
+		key.groupIndex = index
+		previous[key] = value
+	}
+	d.previous = previous
+}
@@ -0,0 +1,92 @@
+// +build libpfm,cgo
+
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Calculating increase of perf events between consecutive reads.
+package perf
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	info "github.com/google/cadvisor/info/v1"
+)
+
+// rawStat returns stat of the event on CPU 0 counted all the time it was
+// enabled.
+func rawStat(name string, value uint64) info.PerfStat {
+	return info.PerfStat{PerfValue: info.PerfValue{Name: name, Value: value, RawValue: value, ScalingRatio: 1}}
+}
+
+// scaledStat returns stat of the event on CPU 0 scaled as it is read.
+func scaledStat(name string, raw, timeEnabled, timeRunning uint64) info.PerfStat {
+	value, scalingRatio := scaleValue(raw, timeEnabled, timeRunning, false)
+	return info.PerfStat{PerfValue: info.PerfValue{
+		Name:         name,
+		Value:        value,
+		RawValue:     raw,
+		ScalingRatio: scalingRatio,
+		TimeEnabled:  timeEnabled,
+		TimeRunning:  timeRunning,
+	}}
+}
+
+func TestDifferDeltaMultiplexed(t *testing.T) {
+	d := newDiffer()
+
+	// Counted half of the time, scaled to 200.
+	assert.Equal(t, uint64(200), d.delta(0, scaledStat("instructions", 100, 100, 50), false))
+	// Scaled cumulative value goes down to 160, increase of the counter is
+	// scaled instead of the whole value being reported.
+	stat := scaledStat("instructions", 120, 200, 150)
+	assert.Equal(t, uint64(160), stat.Value)
+	assert.Equal(t, uint64(20), d.delta(0, stat, false))
+	// Counted half of the interval.
+	assert.Equal(t, uint64(100), d.delta(0, scaledStat("instructions", 170, 300, 200), false))
+	// Not counted during the interval.
+	assert.Equal(t, uint64(0), d.delta(0, scaledStat("instructions", 170, 400, 200), false))
+	assert.Equal(t, uint64(0), d.delta(0, scaledStat("instructions", 170, 400, 200), true))
+}
+
+func TestDifferDeltaReset(t *testing.T) {
+	d := newDiffer()
+	assert.Equal(t, uint64(100), d.delta(0, rawStat("instructions", 100), false))
+	assert.Equal(t, uint64(50), d.delta(0, rawStat("instructions", 150), false))
+
+	// Counter went backwards, so it has been reset.
+	assert.Equal(t, uint64(10), d.delta(0, rawStat("instructions", 10), false))
+
+	// Reopened event starts counting from zero.
+	stat := rawStat("instructions", 300)
+	stat.Reopened = true
+	assert.Equal(t, uint64(300), d.delta(0, stat, false))
+	assert.Equal(t, uint64(5), d.delta(0, rawStat("instructions", 305), false))
+
+	// Times that went backwards mean reset as well.
+	assert.Equal(t, uint64(95), d.delta(0, scaledStat("instructions", 400, 10, 10), false))
+	assert.Equal(t, uint64(500), d.delta(0, scaledStat("instructions", 500, 5, 5), false))
+}
+
+func TestDifferDeltaErrored(t *testing.T) {
+	d := newDiffer()
+	assert.Equal(t, uint64(100), d.delta(0, rawStat("instructions", 100), false))
+
+	// Errored value is neither differentiated nor remembered.
+	errored := rawStat("instructions", 0)
+	errored.Errored = true
+	assert.Equal(t, uint64(0), d.delta(0, errored, false))
+	assert.Equal(t, uint64(20), d.delta(0, rawStat("instructions", 120), false))
+}
@@ -0,0 +1,57 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Description of perf events that are actually measured.
+package perf
+
+// EffectiveEvents describes perf events as they have been set up, which
+// may differ from configuration, e.g. uncore events are skipped if there
+// is no PMU to count them with.
+type EffectiveEvents struct {
+	Core   []EffectiveGroup
+	Uncore []EffectiveGroup
+
+	// Names of configured core and uncore events that are intentionally
+	// not measured because they match --perf_events_exclude.
+	Excluded []string
+}
+
+// EffectiveGroup describes group of perf events that is measured.
+type EffectiveGroup struct {
+	// Index of the group in configuration.
+	Index int
+
+	// PMU that uncore group is measured with. Empty for core groups.
+	PMU string
+
+	// Name of the group leader.
+	Leader string
+
+	// Names of measured events, the leader first.
+	Events []string
+
+	// CPUs that the group is measured on, sorted.
+	CPUs []int
+
+	// Only the value of group leader is read.
+	LeaderOnly bool
+
+	// Events of the group failed to open together and each of them is
+	// measured on its own, see weak_groups.
+	Ungrouped bool
+
+	// Number of times that reading the group exceeded its read timeout
+	// and the group was skipped. Always zero for uncore groups.
+	ReadTimeouts uint64
+}
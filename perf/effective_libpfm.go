@@ -0,0 +1,98 @@
+// +build libpfm,cgo
+
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Description of perf events that are actually measured.
+package perf
+
+import (
+	"sort"
+)
+
+// EffectiveEvents returns description of perf events measured by the
+// collector. Returned value is a copy that can be used freely.
+func (c *collector) EffectiveEvents() EffectiveEvents {
+	effective := EffectiveEvents{}
+
+	c.cpuFilesLock.Lock()
+	effective.Core = c.effectiveGroups()
+	c.cpuFilesLock.Unlock()
+
+	if uncore, ok := c.uncore.(*uncoreCollector); ok {
+		effective.Uncore = uncore.effectiveGroups()
+	}
+	for _, events := range []Events{c.events.Core, c.events.Uncore} {
+		for _, event := range events.excluded {
+			effective.Excluded = append(effective.Excluded, string(event))
+		}
+	}
+	return effective
+}
+
+// effectiveGroups returns description of core groups measured by the
+// collector. It has to be called with cpuFilesLock held.
+func (c *collector) effectiveGroups() []EffectiveGroup {
+	groups := make([]EffectiveGroup, 0, len(c.cpuFiles))
+	for index, group := range c.cpuFiles {
+		described := describeGroup(index, "", group)
+		described.ReadTimeouts = c.readTimeouts[index]
+		groups = append(groups, described)
+	}
+	sortGroups(groups)
+	return groups
+}
+
+// effectiveGroups returns description of uncore groups measured by the
+// collector on every PMU.
+func (c *uncoreCollector) effectiveGroups() []EffectiveGroup {
+	c.cpuFilesLock.Lock()
+	defer c.cpuFilesLock.Unlock()
+
+	groups := []EffectiveGroup{}
+	for index, pmus := range c.cpuFiles {
+		for pmu, group := range pmus {
+			groups = append(groups, describeGroup(index, pmu, group))
+		}
+	}
+	sortGroups(groups)
+	return groups
+}
+
+func describeGroup(index int, pmu string, group group) EffectiveGroup {
+	cpus := make([]int, 0, len(group.cpuFiles[group.leaderName]))
+	for cpu := range group.cpuFiles[group.leaderName] {
+		cpus = append(cpus, cpu)
+	}
+	sort.Ints(cpus)
+
+	return EffectiveGroup{
+		Index:      index,
+		PMU:        pmu,
+		Leader:     group.leaderName,
+		Events:     append([]string{}, group.names...),
+		CPUs:       cpus,
+		LeaderOnly: group.leaderOnly,
+		Ungrouped:  group.ungrouped,
+	}
+}
+
+func sortGroups(groups []EffectiveGroup) {
+	sort.Slice(groups, func(i, j int) bool {
+		if groups[i].Index != groups[j].Index {
+			return groups[i].Index < groups[j].Index
+		}
+		return groups[i].PMU < groups[j].PMU
+	})
+}
@@ -0,0 +1,94 @@
+// +build libpfm,cgo
+
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Description of perf events that are actually measured.
+package perf
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/sys/unix"
+)
+
+func TestCollector_EffectiveEvents(t *testing.T) {
+	path, err := mockSystemDevices()
+	assert.NoError(t, err)
+	defer os.RemoveAll(path)
+
+	// There is no PMU to count uncore_upi/txl_flits with.
+	events := PerfEvents{
+		Uncore: Events{
+			Events: []Group{
+				{events: []Event{"uncore_upi/txl_flits", "uncore_imc_0/cas_count_read", "uncore_imc_0/cas_count_write"}, array: true},
+			},
+			CustomEvents: []CustomEvent{
+				{Config: Config{0x1}, Name: "uncore_upi/txl_flits"},
+				{Config: Config{0x2}, Name: "uncore_imc_0/cas_count_read"},
+				{Config: Config{0x3}, Name: "uncore_imc_0/cas_count_write"},
+			},
+		},
+	}
+	uncore := &uncoreCollector{
+		perfEventOpen: func(attr *unix.PerfEventAttr, pid int, cpu int, groupFd int, flags int) (int, error) {
+			return unix.Open(os.DevNull, unix.O_RDONLY, 0)
+		},
+		ioctlSetInt: func(fd int, req uint, value int) error {
+			return nil
+		},
+	}
+	err = uncore.setup(events, path)
+	assert.NoError(t, err)
+
+	collector := collector{
+		uncore: uncore,
+		cpuFiles: map[int]group{
+			1: {
+				cpuFiles: map[string]map[int]readerCloser{
+					"instructions": {0: buffer{}, 2: buffer{}},
+					"cycles":       {0: buffer{}, 2: buffer{}},
+				},
+				names:      []string{"instructions", "cycles"},
+				leaderName: "instructions",
+				leaderOnly: true,
+			},
+			0: {
+				cpuFiles: map[string]map[int]readerCloser{
+					"cache-misses": {1: buffer{}},
+				},
+				names:      []string{"cache-misses"},
+				leaderName: "cache-misses",
+			},
+		},
+	}
+	defer uncore.Destroy()
+
+	effective := collector.EffectiveEvents()
+	assert.Equal(t, EffectiveEvents{
+		Core: []EffectiveGroup{
+			{Index: 0, Leader: "cache-misses", Events: []string{"cache-misses"}, CPUs: []int{1}},
+			{Index: 1, Leader: "instructions", Events: []string{"instructions", "cycles"}, CPUs: []int{0, 2}, LeaderOnly: true},
+		},
+		Uncore: []EffectiveGroup{
+			{Index: 0, PMU: "uncore_imc_0", Leader: "uncore_imc_0/cas_count_read", Events: []string{"uncore_imc_0/cas_count_read", "uncore_imc_0/cas_count_write"}, CPUs: []int{0, 1}},
+		},
+	}, effective)
+
+	// Returned value is a copy.
+	effective.Core[1].Events[0] = "modified"
+	assert.Equal(t, "instructions", collector.EffectiveEvents().Core[1].Events[0])
+}
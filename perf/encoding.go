@@ -0,0 +1,258 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Compact binary encoding of perf stats for high-frequency consumers.
+package perf
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"time"
+
+	info "github.com/google/cadvisor/info/v1"
+)
+
+// EncodingVersion is version of binary encoding of perf stats written by
+// EncodePerfStats.
+const EncodingVersion = 1
+
+// Flags of a perf stat in binary encoding.
+const (
+	encodedErrored = 1 << iota
+	encodedReopened
+	encodedDelta
+	encodedStartTime
+	encodedPaused
+)
+
+// EncodePerfStats writes perf stats to w as a single message in compact
+// binary format, which is much cheaper to produce and parse than JSON, e.g.
+// for a sidecar reading perf events from unix socket at high frequency.
+//
+// Message starts with version byte and number of stats. Each stat is a
+// record prefixed with its length, so fields appended to records by future
+// versions are skipped by older decoders. Integers are varint encoded and
+// scaling ratio is IEEE 754 binary64 in little endian.
+func EncodePerfStats(w io.Writer, perfStats []info.PerfStat) error {
+	message := []byte{EncodingVersion}
+	message = appendUvarint(message, uint64(len(perfStats)))
+	record := []byte{}
+	for _, stat := range perfStats {
+		record = encodePerfStat(record[:0], stat)
+		message = appendUvarint(message, uint64(len(record)))
+		message = append(message, record...)
+	}
+	_, err := w.Write(message)
+	return err
+}
+
+func encodePerfStat(record []byte, stat info.PerfStat) []byte {
+	flags := uint64(0)
+	if stat.Errored {
+		flags |= encodedErrored
+	}
+	if stat.Reopened {
+		flags |= encodedReopened
+	}
+	if stat.Delta {
+		flags |= encodedDelta
+	}
+	if stat.StartTime != nil {
+		flags |= encodedStartTime
+	}
+	if stat.Paused {
+		flags |= encodedPaused
+	}
+
+	record = appendUvarint(record, flags)
+	record = appendUvarint(record, uint64(len(stat.Name)))
+	record = append(record, stat.Name...)
+	record = appendUvarint(record, stat.Value)
+	var ratio [8]byte
+	binary.LittleEndian.PutUint64(ratio[:], math.Float64bits(stat.ScalingRatio))
+	record = append(record, ratio[:]...)
+	record = appendVarint(record, int64(stat.Cpu))
+	record = appendVarint(record, int64(stat.Core))
+	if stat.StartTime != nil {
+		record = appendVarint(record, stat.StartTime.UnixNano())
+	}
+	record = appendUvarint(record, stat.Frequency)
+	record = appendUvarint(record, uint64(len(stat.Histogram)))
+	for _, bucket := range stat.Histogram {
+		record = appendUvarint(record, bucket.UpperBound)
+		record = appendUvarint(record, bucket.Count)
+	}
+	record = appendUvarint(record, uint64(len(stat.Confidence)))
+	record = append(record, stat.Confidence...)
+	return record
+}
+
+// DecodePerfStats reads single message written by EncodePerfStats from r.
+// Start time is decoded in UTC and without monotonic clock reading.
+func DecodePerfStats(r io.ByteReader) ([]info.PerfStat, error) {
+	version, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	if version != EncodingVersion {
+		return nil, fmt.Errorf("unsupported version %d of encoded perf stats, expected %d", version, EncodingVersion)
+	}
+	count, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decode number of perf stats: %w", unexpectedEOF(err))
+	}
+
+	perfStats := []info.PerfStat{}
+	for i := uint64(0); i < count; i++ {
+		length, err := binary.ReadUvarint(r)
+		if err != nil {
+			return nil, fmt.Errorf("unable to decode length of perf stat %d: %w", i, unexpectedEOF(err))
+		}
+		record, err := readBytes(r, length)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read perf stat %d: %w", i, err)
+		}
+		stat, err := decodePerfStat(record)
+		if err != nil {
+			return nil, fmt.Errorf("unable to decode perf stat %d: %w", i, err)
+		}
+		perfStats = append(perfStats, stat)
+	}
+	return perfStats, nil
+}
+
+func decodePerfStat(record []byte) (info.PerfStat, error) {
+	d := decoder{buf: record}
+	stat := info.PerfStat{}
+
+	flags := d.uvarint()
+	stat.Errored = flags&encodedErrored != 0
+	stat.Reopened = flags&encodedReopened != 0
+	stat.Delta = flags&encodedDelta != 0
+	stat.Paused = flags&encodedPaused != 0
+	stat.Name = string(d.bytes(d.uvarint()))
+	stat.Value = d.uvarint()
+	stat.ScalingRatio = d.float64()
+	stat.Cpu = int(d.varint())
+	stat.Core = int(d.varint())
+	if flags&encodedStartTime != 0 {
+		startTime := time.Unix(0, d.varint()).UTC()
+		stat.StartTime = &startTime
+	}
+	stat.Frequency = d.uvarint()
+	buckets := d.uvarint()
+	if buckets > 0 && d.err == nil {
+		// Each bucket takes at least two bytes.
+		if buckets > uint64(len(d.buf)) {
+			return stat, io.ErrUnexpectedEOF
+		}
+		stat.Histogram = make([]info.PerfHistogramBucket, buckets)
+		for i := range stat.Histogram {
+			stat.Histogram[i].UpperBound = d.uvarint()
+			stat.Histogram[i].Count = d.uvarint()
+		}
+	}
+	stat.Confidence = info.PerfConfidence(d.bytes(d.uvarint()))
+	// Remaining bytes are fields added by newer versions.
+	return stat, d.err
+}
+
+// decoder reads fields of a record until the first error.
+type decoder struct {
+	buf []byte
+	err error
+}
+
+func (d *decoder) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	value, n := binary.Uvarint(d.buf)
+	if n <= 0 {
+		d.err = io.ErrUnexpectedEOF
+		return 0
+	}
+	d.buf = d.buf[n:]
+	return value
+}
+
+func (d *decoder) varint() int64 {
+	if d.err != nil {
+		return 0
+	}
+	value, n := binary.Varint(d.buf)
+	if n <= 0 {
+		d.err = io.ErrUnexpectedEOF
+		return 0
+	}
+	d.buf = d.buf[n:]
+	return value
+}
+
+func (d *decoder) bytes(n uint64) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if n > uint64(len(d.buf)) {
+		d.err = io.ErrUnexpectedEOF
+		return nil
+	}
+	value := d.buf[:n]
+	d.buf = d.buf[n:]
+	return value
+}
+
+func (d *decoder) float64() float64 {
+	value := d.bytes(8)
+	if value == nil {
+		return 0
+	}
+	return math.Float64frombits(binary.LittleEndian.Uint64(value))
+}
+
+func readBytes(r io.ByteReader, n uint64) ([]byte, error) {
+	buf := []byte{}
+	for i := uint64(0); i < n; i++ {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, unexpectedEOF(err)
+		}
+		buf = append(buf, b)
+	}
+	return buf, nil
+}
+
+// unexpectedEOF reports end of input in the middle of a message as
+// unexpected.
+func unexpectedEOF(err error) error {
+	if errors.Is(err, io.EOF) {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+func appendUvarint(buf []byte, value uint64) []byte {
+	var encoded [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(encoded[:], value)
+	return append(buf, encoded[:n]...)
+}
+
+func appendVarint(buf []byte, value int64) []byte {
+	var encoded [binary.MaxVarintLen64]byte
+	n := binary.PutVarint(encoded[:], value)
+	return append(buf, encoded[:n]...)
+}
@@ -0,0 +1,121 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Compact binary encoding of perf stats for high-frequency consumers.
+package perf
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	info "github.com/google/cadvisor/info/v1"
+)
+
+func TestEncodePerfStatsRoundTrip(t *testing.T) {
+	startTime := time.Date(2020, time.October, 1, 12, 0, 0, 123, time.UTC)
+	perfStats := []info.PerfStat{
+		{
+			PerfValue: info.PerfValue{Name: "instructions", Value: 123456789, ScalingRatio: 0.3333333333333333},
+			Cpu:       0,
+			StartTime: &startTime,
+		},
+		{
+			PerfValue: info.PerfValue{Name: "cycles", Value: math.MaxUint64, ScalingRatio: 1, Errored: true, Reopened: true, Paused: true, Confidence: info.PerfConfidenceLow},
+			Cpu:       127,
+			Core:      63,
+			Delta:     true,
+			Frequency: 3600000,
+			Histogram: []info.PerfHistogramBucket{
+				{UpperBound: 1000, Count: 3},
+				{UpperBound: math.MaxUint64, Count: 1},
+			},
+		},
+		{
+			// Not counted at all and without start time.
+			PerfValue: info.PerfValue{Name: "", ScalingRatio: 0},
+			Cpu:       -1,
+		},
+		{
+			PerfValue: info.PerfValue{Name: "tiny", ScalingRatio: math.SmallestNonzeroFloat64},
+		},
+	}
+
+	buf := &bytes.Buffer{}
+	err := EncodePerfStats(buf, perfStats)
+	assert.NoError(t, err)
+	decoded, err := DecodePerfStats(buf)
+	assert.NoError(t, err)
+	assert.Equal(t, perfStats, decoded)
+	assert.Zero(t, buf.Len())
+}
+
+func TestEncodePerfStatsStream(t *testing.T) {
+	buf := &bytes.Buffer{}
+	first := []info.PerfStat{{PerfValue: info.PerfValue{Name: "instructions", Value: 1, ScalingRatio: 1}}}
+	second := []info.PerfStat{{PerfValue: info.PerfValue{Name: "cycles", Value: 2, ScalingRatio: 0.5}, Cpu: 1}}
+	assert.NoError(t, EncodePerfStats(buf, first))
+	assert.NoError(t, EncodePerfStats(buf, []info.PerfStat{}))
+	assert.NoError(t, EncodePerfStats(buf, second))
+
+	// Messages written one after another are decoded separately.
+	for _, expected := range [][]info.PerfStat{first, {}, second} {
+		decoded, err := DecodePerfStats(buf)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, decoded)
+	}
+	_, err := DecodePerfStats(buf)
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestDecodePerfStatsUnknownFields(t *testing.T) {
+	record := encodePerfStat(nil, info.PerfStat{PerfValue: info.PerfValue{Name: "instructions", Value: 42, ScalingRatio: 1}, Cpu: 3})
+	// Field appended by a newer version.
+	record = append(record, 0x1, 0x8)
+	message := []byte{EncodingVersion, 1}
+	message = appendUvarint(message, uint64(len(record)))
+	message = append(message, record...)
+
+	decoded, err := DecodePerfStats(bytes.NewReader(message))
+	assert.NoError(t, err)
+	assert.Equal(t, []info.PerfStat{{PerfValue: info.PerfValue{Name: "instructions", Value: 42, ScalingRatio: 1}, Cpu: 3}}, decoded)
+}
+
+func TestDecodePerfStatsErrors(t *testing.T) {
+	buf := &bytes.Buffer{}
+	err := EncodePerfStats(buf, []info.PerfStat{{PerfValue: info.PerfValue{Name: "instructions", Value: 42}}})
+	assert.NoError(t, err)
+	message := buf.Bytes()
+
+	// Unsupported version.
+	unsupported := append([]byte{EncodingVersion + 1}, message[1:]...)
+	_, err = DecodePerfStats(bytes.NewReader(unsupported))
+	assert.Error(t, err)
+
+	// Truncated message.
+	for length := 1; length < len(message); length++ {
+		_, err = DecodePerfStats(bytes.NewReader(message[:length]))
+		assert.True(t, errors.Is(err, io.ErrUnexpectedEOF), "length %d: %v", length, err)
+	}
+
+	// Record shorter than its fields.
+	corrupted := []byte{EncodingVersion, 1, 3, 0, 10, 'a'}
+	_, err = DecodePerfStats(bytes.NewReader(corrupted))
+	assert.True(t, errors.Is(err, io.ErrUnexpectedEOF))
+}
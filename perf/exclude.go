@@ -0,0 +1,85 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Exclusion of configured perf events on the host.
+package perf
+
+import (
+	"flag"
+	"fmt"
+	"path"
+	"strings"
+)
+
+var excludedEvents = flag.String("perf_events_exclude", "", "Comma-separated list of names or shell patterns, e.g. uncore_imc*/*, of perf events configured with --perf_events_config that are not measured on this host. Event excluded from a group is removed from it and the next event leads the group if the leader is excluded.")
+
+// parseExcludePatterns splits comma-separated list of patterns of excluded
+// events and checks if they are valid.
+func parseExcludePatterns(list string) ([]string, error) {
+	patterns := []string{}
+	for _, pattern := range strings.Split(list, ",") {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			continue
+		}
+		_, err := path.Match(pattern, "")
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %q of excluded perf events: %w", pattern, err)
+		}
+		patterns = append(patterns, pattern)
+	}
+	return patterns, nil
+}
+
+// isExcluded checks if name of the event matches any of the patterns.
+func isExcluded(event Event, patterns []string) bool {
+	for _, pattern := range patterns {
+		// Patterns have been validated already.
+		if matched, _ := path.Match(pattern, string(event)); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// excludeEvents removes events matching any of the patterns from the groups.
+// The first remaining event of a group becomes its leader and groups left
+// without events are removed. Excluded events are recorded, so they can be
+// told apart from events that could not be set up.
+func excludeEvents(events Events, patterns []string) Events {
+	if len(patterns) == 0 {
+		return events
+	}
+
+	groups := make([]Group, 0, len(events.Events))
+	excluded := append([]Event{}, events.excluded...)
+	for _, group := range events.Events {
+		remaining := make([]Event, 0, len(group.events))
+		for _, event := range group.events {
+			if isExcluded(event, patterns) {
+				excluded = append(excluded, event)
+				continue
+			}
+			remaining = append(remaining, event)
+		}
+		if len(remaining) == 0 {
+			continue
+		}
+		group.events = remaining
+		groups = append(groups, group)
+	}
+	events.Events = groups
+	events.excluded = excluded
+	return events
+}
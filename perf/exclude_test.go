@@ -0,0 +1,56 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Exclusion of configured perf events on the host.
+package perf
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseExcludePatterns(t *testing.T) {
+	patterns, err := parseExcludePatterns("instructions, uncore_imc*/*,,")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"instructions", "uncore_imc*/*"}, patterns)
+
+	patterns, err = parseExcludePatterns("")
+	assert.NoError(t, err)
+	assert.Empty(t, patterns)
+
+	_, err = parseExcludePatterns("cycles,[")
+	assert.Error(t, err)
+}
+
+func TestExcludeEvents(t *testing.T) {
+	events := Events{Events: []Group{
+		{events: []Event{"instructions", "cycles", "cache-misses"}, array: true, leaderOnly: true, readTimeout: time.Second},
+		{events: []Event{"uncore_imc_0/cas_count_read"}},
+		{events: []Event{"branch-misses"}},
+	}}
+
+	excluded := excludeEvents(events, []string{"instructions", "uncore_imc*/*"})
+	// Cycles leads the first group, the second group is removed.
+	assert.Equal(t, []Group{
+		{events: []Event{"cycles", "cache-misses"}, array: true, leaderOnly: true, readTimeout: time.Second},
+		{events: []Event{"branch-misses"}},
+	}, excluded.Events)
+	assert.Equal(t, []Event{"instructions", "uncore_imc_0/cas_count_read"}, excluded.excluded)
+	// Configuration is not modified.
+	assert.Equal(t, []Event{"instructions", "cycles", "cache-misses"}, events.Events[0].events)
+
+	assert.Equal(t, events, excludeEvents(events, nil))
+}
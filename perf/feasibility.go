@@ -0,0 +1,54 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Checking if configured core perf events fit into hardware counters.
+package perf
+
+// Counters is number of hardware counters of core PMU on each CPU.
+type Counters struct {
+	// Name of the PMU as known to libpfm4, e.g. skl.
+	PMU string
+	// Counters that can count any event.
+	GeneralPurpose int
+	// Counters that count single predefined event each, e.g. instructions.
+	Fixed int
+}
+
+// Feasibility predicts how core perf events will be scheduled on counters.
+type Feasibility struct {
+	Counters Counters
+	// Number of events that need hardware counters in all the groups that
+	// fit into counters.
+	Events int
+	// Estimated number of time slices that groups are multiplexed in, the
+	// same as multiplex_groups reported with multiplexing enabled. It is 1
+	// if all the groups fit into counters at once.
+	MultiplexGroups float64
+	Groups          []GroupFeasibility
+}
+
+// GroupFeasibility predicts how a single group will be scheduled.
+type GroupFeasibility struct {
+	Events []Event
+	// Number of events in the group that need hardware counters.
+	HardwareEvents int
+	// Group fits into counters on its own. Group that does not fit is
+	// never counted.
+	Fits bool
+	// Group is expected to share counters with other groups in turns.
+	Multiplexed bool
+	// Expected fraction of time that the group is counted for, 0 if it
+	// does not fit.
+	ExpectedScalingRatio float64
+}
@@ -0,0 +1,130 @@
+// +build libpfm,cgo
+
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Checking if configured core perf events fit into hardware counters.
+package perf
+
+// #cgo CFLAGS: -I/usr/include
+// #cgo LDFLAGS: -lpfm
+// #include <perfmon/pfmlib.h>
+// static int pmu_is_present(pfm_pmu_info_t *info) { return info->is_present; }
+import "C"
+
+import (
+	"fmt"
+
+	"golang.org/x/sys/unix"
+)
+
+// Handle for mocking purposes.
+var readCoreCounters = readCoreCountersFromLibpfm
+
+// FeasibilityCheck compares groups of core events with number of hardware
+// counters of the host before any event is opened. Counters are provided by
+// libpfm4 as kernel does not expose them in sysfs. Prediction is optimistic:
+// it assumes that any event can be counted by fixed counters and that no
+// counter is taken by anybody else, e.g. NMI watchdog.
+func FeasibilityCheck(events PerfEvents) (Feasibility, error) {
+	err := checkLibpfmInitialized()
+	if err != nil {
+		return Feasibility{}, err
+	}
+	counters, err := readCoreCounters()
+	if err != nil {
+		return Feasibility{}, err
+	}
+	return checkFeasibility(events.Core, counters), nil
+}
+
+// checkFeasibility predicts scheduling of the groups on counters. Kernel
+// rotates groups that do not fit at once, so each of them is expected to be
+// counted for the fraction of time equal to number of counters divided by
+// number of events in all the groups.
+func checkFeasibility(events Events, counters Counters) Feasibility {
+	available := counters.GeneralPurpose + counters.Fixed
+	software := softwareEventNames(events)
+	feasibility := Feasibility{Counters: counters, MultiplexGroups: 1, Groups: make([]GroupFeasibility, 0, len(events.Events))}
+	for _, group := range events.Events {
+		hardwareEvents := 0
+		for _, event := range group.events {
+			if _, ok := software[event]; !ok {
+				hardwareEvents++
+			}
+		}
+		groupFeasibility := GroupFeasibility{
+			Events:         group.events,
+			HardwareEvents: hardwareEvents,
+			Fits:           hardwareEvents <= available,
+		}
+		if groupFeasibility.Fits {
+			feasibility.Events += hardwareEvents
+		}
+		feasibility.Groups = append(feasibility.Groups, groupFeasibility)
+	}
+
+	ratio := 1.0
+	if feasibility.Events > available && available > 0 {
+		ratio = float64(available) / float64(feasibility.Events)
+		feasibility.MultiplexGroups = float64(feasibility.Events) / float64(available)
+	}
+	for i := range feasibility.Groups {
+		group := &feasibility.Groups[i]
+		if !group.Fits {
+			continue
+		}
+		if group.HardwareEvents == 0 {
+			group.ExpectedScalingRatio = 1
+			continue
+		}
+		group.ExpectedScalingRatio = ratio
+		group.Multiplexed = ratio < 1
+	}
+	return feasibility
+}
+
+// softwareEventNames returns names of events counted by kernel, which do not
+// need hardware counters.
+func softwareEventNames(events Events) map[Event]struct{} {
+	names := map[Event]struct{}{}
+	for _, event := range events.SoftwareEvents {
+		names[event.Name] = struct{}{}
+	}
+	for _, event := range events.CustomEvents {
+		if event.Type == unix.PERF_TYPE_SOFTWARE {
+			names[event.Name] = struct{}{}
+		}
+	}
+	return names
+}
+
+// readCoreCountersFromLibpfm returns counters of the core PMU that libpfm4
+// detected on the host.
+func readCoreCountersFromLibpfm() (Counters, error) {
+	for pmu := C.pfm_pmu_t(C.PFM_PMU_NONE); pmu < C.PFM_PMU_MAX; pmu++ {
+		pmuInfo := C.pfm_pmu_info_t{}
+		pmuInfo.size = C.sizeof_pfm_pmu_info_t
+		pErr := C.pfm_get_pmu_info(pmu, &pmuInfo)
+		if pErr != C.PFM_SUCCESS || C.pmu_is_present(&pmuInfo) == 0 || pmuInfo._type != C.PFM_PMU_TYPE_CORE {
+			continue
+		}
+		return Counters{
+			PMU:            C.GoString(pmuInfo.name),
+			GeneralPurpose: int(pmuInfo.num_cntrs),
+			Fixed:          int(pmuInfo.num_fixed_cntrs),
+		}, nil
+	}
+	return Counters{}, fmt.Errorf("unable to find core PMU of the host")
+}
@@ -0,0 +1,105 @@
+// +build libpfm,cgo
+
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Checking if configured core perf events fit into hardware counters.
+package perf
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/sys/unix"
+)
+
+func TestCheckFeasibility(t *testing.T) {
+	counters := Counters{PMU: "skl", GeneralPurpose: 4, Fixed: 3}
+	events := Events{
+		Events: []Group{
+			{events: []Event{"instructions", "cycles", "cache-misses", "cache-references"}},
+			{events: []Event{"branches", "branch-misses", "context-switches", "llc-misses"}},
+			{events: []Event{"page-faults"}},
+			{events: []Event{"e1", "e2", "e3", "e4", "e5", "e6", "e7", "e8"}},
+		},
+		SoftwareEvents: []SoftwareEvent{{Config: unix.PERF_COUNT_SW_CONTEXT_SWITCHES, Name: "context-switches"}},
+		CustomEvents:   []CustomEvent{{Type: unix.PERF_TYPE_SOFTWARE, Config: Config{unix.PERF_COUNT_SW_PAGE_FAULTS}, Name: "page-faults"}},
+	}
+
+	feasibility := checkFeasibility(events, counters)
+	// Seven hardware events of the first two groups compete for seven
+	// counters, so they fit without multiplexing.
+	assert.Equal(t, 7, feasibility.Events)
+	assert.Equal(t, 1.0, feasibility.MultiplexGroups)
+	assert.Equal(t, GroupFeasibility{Events: events.Events[0].events, HardwareEvents: 4, Fits: true, ExpectedScalingRatio: 1}, feasibility.Groups[0])
+	assert.Equal(t, GroupFeasibility{Events: events.Events[1].events, HardwareEvents: 3, Fits: true, ExpectedScalingRatio: 1}, feasibility.Groups[1])
+	assert.Equal(t, GroupFeasibility{Events: events.Events[2].events, HardwareEvents: 0, Fits: true, ExpectedScalingRatio: 1}, feasibility.Groups[2])
+	// Group larger than all the counters is never counted.
+	assert.Equal(t, GroupFeasibility{Events: events.Events[3].events, HardwareEvents: 8, Fits: false}, feasibility.Groups[3])
+}
+
+func TestCheckFeasibilityExceedingCounters(t *testing.T) {
+	counters := Counters{PMU: "skl", GeneralPurpose: 4, Fixed: 0}
+	events := Events{
+		Events: []Group{
+			{events: []Event{"instructions", "cycles"}},
+			{events: []Event{"cache-misses", "cache-references"}},
+			{events: []Event{"branches", "branch-misses"}},
+			{events: []Event{"context-switches"}},
+		},
+		SoftwareEvents: []SoftwareEvent{{Config: unix.PERF_COUNT_SW_CONTEXT_SWITCHES, Name: "context-switches"}},
+	}
+
+	feasibility := checkFeasibility(events, counters)
+	// Six hardware events on four counters are multiplexed in 1.5 time
+	// slices, so each group is counted two thirds of time.
+	assert.Equal(t, 6, feasibility.Events)
+	assert.Equal(t, 1.5, feasibility.MultiplexGroups)
+	for _, group := range feasibility.Groups[:3] {
+		assert.True(t, group.Fits)
+		assert.True(t, group.Multiplexed)
+		assert.InDelta(t, 2.0/3, group.ExpectedScalingRatio, 1e-9)
+	}
+	// Software events are always counted.
+	assert.False(t, feasibility.Groups[3].Multiplexed)
+	assert.Equal(t, 1.0, feasibility.Groups[3].ExpectedScalingRatio)
+}
+
+func TestFeasibilityCheck(t *testing.T) {
+	originalReadCoreCounters := readCoreCounters
+	defer func() {
+		readCoreCounters = originalReadCoreCounters
+	}()
+	readCoreCounters = func() (Counters, error) {
+		return Counters{PMU: "skl", GeneralPurpose: 2, Fixed: 1}, nil
+	}
+
+	feasibility, err := FeasibilityCheck(PerfEvents{Core: Events{Events: []Group{
+		{events: []Event{"instructions", "cycles"}},
+		{events: []Event{"cache-misses", "cache-references"}},
+	}}})
+	assert.NoError(t, err)
+	assert.Equal(t, "skl", feasibility.Counters.PMU)
+	assert.InDelta(t, 4.0/3, feasibility.MultiplexGroups, 1e-9)
+	assert.True(t, feasibility.Groups[0].Multiplexed)
+	assert.True(t, feasibility.Groups[1].Multiplexed)
+}
+
+func TestFeasibilityCheckWithUninitializedLibpfm(t *testing.T) {
+	defer mockUninitializedLibpfm(fmt.Errorf("no PMU"))()
+
+	_, err := FeasibilityCheck(PerfEvents{})
+	assert.Error(t, err)
+}
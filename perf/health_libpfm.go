@@ -0,0 +1,166 @@
+// +build libpfm,cgo
+
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Giving up on perf events of cgroups that repeatedly fail to be set up.
+package perf
+
+import (
+	"fmt"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+// setupFailures tracks consecutive failures to set up or reopen core perf
+// events of a cgroup.
+type setupFailures struct {
+	count int
+	// Error of the most recent failure.
+	err error
+	// Failure budget has been exhausted and setup is not attempted anymore.
+	exhausted bool
+	// Time of the most recent failure.
+	last time.Time
+}
+
+// Failures of a cgroup are forgotten after this time without another
+// failure, unless configured otherwise.
+const defaultSetupFailureExpiry = 10 * time.Minute
+
+// validateSetupFailureBudget checks if number of consecutive setup failures
+// is valid.
+func validateSetupFailureBudget(events PerfEvents) error {
+	if events.SetupFailureBudget < 0 {
+		return fmt.Errorf("number of consecutive setup failures has to be positive, got %d", events.SetupFailureBudget)
+	}
+	if events.SetupFailureExpiry < 0 {
+		return fmt.Errorf("expiry of setup failures has to be positive, got %s", time.Duration(events.SetupFailureExpiry))
+	}
+	return nil
+}
+
+// expireFailures forgets failures of cgroups that have not failed for the
+// expiry, so that failures of cgroups that are gone do not pile up and paths
+// reused by other containers are set up again. Lock of collectors has to be
+// held.
+func (m *manager) expireFailures() {
+	expiry := time.Duration(m.events.SetupFailureExpiry)
+	if expiry == 0 {
+		expiry = defaultSetupFailureExpiry
+	}
+	current := now()
+	for cgroupPath, failures := range m.failures {
+		if current.Sub(failures.last) >= expiry {
+			delete(m.failures, cgroupPath)
+		}
+	}
+}
+
+// recordSetup records result of setting up or reopening core perf events
+// of the cgroup and returns false once consecutive failures exhaust the
+// budget, after which setup of the cgroup is not attempted anymore. Success
+// resets the count. Lock of collectors has to be held.
+func (m *manager) recordSetup(cgroupPath string, err error) bool {
+	m.expireFailures()
+	if err == nil {
+		delete(m.failures, cgroupPath)
+		return true
+	}
+	if m.events.SetupFailureBudget == 0 {
+		return true
+	}
+
+	failures, ok := m.failures[cgroupPath]
+	if !ok {
+		failures = &setupFailures{}
+		m.failures[cgroupPath] = failures
+	}
+	failures.count++
+	failures.err = err
+	failures.last = now()
+	if failures.count < m.events.SetupFailureBudget {
+		return true
+	}
+	if !failures.exhausted {
+		klog.Warningf("Giving up on perf events of cgroup %q after %d consecutive failures to set them up, the last one: %v", cgroupPath, failures.count, err)
+		failures.exhausted = true
+	}
+	return false
+}
+
+// unavailable returns error explaining why perf events of the cgroup are
+// not set up anymore, nil if they are still attempted. Lock of collectors
+// has to be held.
+func (m *manager) unavailable(cgroupPath string) error {
+	m.expireFailures()
+	failures, ok := m.failures[cgroupPath]
+	if !ok || !failures.exhausted {
+		return nil
+	}
+	return fmt.Errorf("perf events of cgroup %q are unavailable after %d consecutive setup failures, the last one: %w", cgroupPath, failures.count, failures.err)
+}
+
+// Unavailable returns reasons why perf events are not set up anymore, by
+// path of cgroups whose failure budget has been exhausted.
+func (m *manager) Unavailable() map[string]string {
+	m.collectorsLock.Lock()
+	defer m.collectorsLock.Unlock()
+
+	m.expireFailures()
+	reasons := map[string]string{}
+	for cgroupPath, failures := range m.failures {
+		if failures.exhausted {
+			reasons[cgroupPath] = failures.err.Error()
+		}
+	}
+	return reasons
+}
+
+// reportReopen reports result of reopening core perf events after cgroup
+// or cpuset of the container changed to manager. Events are closed and not
+// reopened anymore once manager gives up on the cgroup. Lock of cpuFiles
+// must not be held, as manager holds lock of collectors while it takes it.
+func (c *collector) reportReopen(err error) {
+	c.cpuFilesLock.Lock()
+	report := c.recordSetup != nil && (err != nil || c.reopenFailing)
+	if report {
+		c.reopenFailing = err != nil
+	}
+	c.cpuFilesLock.Unlock()
+	if !report || c.recordSetup(err) {
+		return
+	}
+
+	c.cpuFilesLock.Lock()
+	defer c.cpuFilesLock.Unlock()
+	if c.unavailable {
+		return
+	}
+	c.closeEvents()
+	c.cpuFiles = map[int]group{}
+	c.unavailable = true
+}
+
+// setupRecorder returns function that collector of the cgroup reports
+// results of reopening its core perf events with. It takes lock of
+// collectors, so it must not be called with lock of the collector held.
+func (m *manager) setupRecorder(cgroupPath string) func(err error) bool {
+	return func(err error) bool {
+		m.collectorsLock.Lock()
+		defer m.collectorsLock.Unlock()
+		return m.recordSetup(cgroupPath, err)
+	}
+}
@@ -0,0 +1,86 @@
+// +build libpfm,cgo
+
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Distribution of increases of perf events between consecutive reads.
+package perf
+
+import (
+	"math"
+
+	info "github.com/google/cadvisor/info/v1"
+)
+
+// histogram counts increases of perf events between consecutive reads
+// in buckets with configured upper bounds. Memory used is bounded by the
+// number of events, CPUs and buckets.
+type histogram struct {
+	bounds []uint64
+	differ *differ
+	counts map[differKey][]uint64
+}
+
+func newHistogram(bounds []uint64) *histogram {
+	return &histogram{bounds: bounds, differ: newDiffer(), counts: map[differKey][]uint64{}}
+}
+
+// observe records increase of the event since previous read and returns
+// distribution of all the increases recorded so far. The last bucket
+// counts increases greater than the highest bound.
+func (h *histogram) observe(groupIndex int, stat info.PerfStat, perfStatScaling bool) []info.PerfHistogramBucket {
+	key := differKey{groupIndex: groupIndex, name: stat.Name, cpu: stat.Cpu}
+	counts, ok := h.counts[key]
+	if !ok {
+		counts = make([]uint64, len(h.bounds)+1)
+		h.counts[key] = counts
+	}
+
+	increase := h.differ.delta(groupIndex, stat, perfStatScaling)
+	bucket := len(h.bounds)
+	for i, bound := range h.bounds {
+		if increase <= bound {
+			bucket = i
+			break
+		}
+	}
+	counts[bucket]++
+
+	buckets := make([]info.PerfHistogramBucket, len(counts))
+	for i, count := range counts {
+		upperBound := uint64(math.MaxUint64)
+		if i < len(h.bounds) {
+			upperBound = h.bounds[i]
+		}
+		buckets[i] = info.PerfHistogramBucket{UpperBound: upperBound, Count: count}
+	}
+	return buckets
+}
+
+// remapGroups moves distributions of groups to their new indexes, given by
+// their previous indexes. Distributions of groups without new index are
+// dropped.
+func (h *histogram) remapGroups(indexes map[int]int) {
+	h.differ.remapGroups(indexes)
+	counts := make(map[differKey][]uint64, len(h.counts))
+	for key, value := range h.counts {
+		index, ok := indexes[key.groupIndex]
+		if !ok {
+			continue
+		}
+		key.groupIndex = index
+		counts[key] = value
+	}
+	h.counts = counts
+}
@@ -0,0 +1,88 @@
+// +build libpfm,cgo
+
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Handling of exhausted limit of open files.
+package perf
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sync"
+
+	"golang.org/x/sys/unix"
+	"k8s.io/klog/v2"
+)
+
+var (
+	// Soft limit of open files is raised at most once per process.
+	fileLimitRaiseAttempted bool
+	fileLimitMutex          sync.Mutex
+
+	// Handle for mocking purposes.
+	getrlimit = unix.Getrlimit
+	// Handle for mocking purposes.
+	setrlimit = unix.Setrlimit
+	// Handle for mocking purposes.
+	openFilesPath = "/proc/self/fd"
+)
+
+// raiseFileLimit raises soft limit of open files of the process to its
+// hard limit. It is attempted only once, so it reports if the limit has
+// been raised by this call and opening of a file is worth retrying.
+func raiseFileLimit() bool {
+	fileLimitMutex.Lock()
+	defer fileLimitMutex.Unlock()
+
+	if fileLimitRaiseAttempted {
+		return false
+	}
+	fileLimitRaiseAttempted = true
+
+	limit := unix.Rlimit{}
+	err := getrlimit(unix.RLIMIT_NOFILE, &limit)
+	if err != nil {
+		klog.Warningf("Unable to read limit of open files: %v", err)
+		return false
+	}
+	if limit.Cur >= limit.Max {
+		return false
+	}
+	previous := limit.Cur
+	limit.Cur = limit.Max
+	err = setrlimit(unix.RLIMIT_NOFILE, &limit)
+	if err != nil {
+		klog.Warningf("Unable to raise limit of open files from %d to %d: %v", previous, limit.Max, err)
+		return false
+	}
+	klog.Infof("Limit of open files has been raised from %d to %d to open perf events", previous, limit.Max)
+	return true
+}
+
+// describeFileUsage describes number of open files of the process and its
+// limits, for diagnostics of exhausted file descriptors.
+func describeFileUsage() string {
+	usage := "unknown number of"
+	files, err := ioutil.ReadDir(openFilesPath)
+	if err == nil {
+		usage = fmt.Sprintf("%d", len(files))
+	}
+	limit := unix.Rlimit{}
+	err = getrlimit(unix.RLIMIT_NOFILE, &limit)
+	if err != nil {
+		return fmt.Sprintf("%s files are open", usage)
+	}
+	return fmt.Sprintf("%s files are open, limit is %d (hard limit %d)", usage, limit.Cur, limit.Max)
+}
@@ -0,0 +1,132 @@
+// +build libpfm,cgo
+
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Handling of exhausted limit of open files.
+package perf
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/sys/unix"
+)
+
+// mockFileLimit replaces limit of open files with the given one and returns
+// function that restores the original handles.
+func mockFileLimit(limit *unix.Rlimit) func() {
+	fileLimitMutex.Lock()
+	defer fileLimitMutex.Unlock()
+	originalGetrlimit, originalSetrlimit, originalAttempted := getrlimit, setrlimit, fileLimitRaiseAttempted
+	getrlimit = func(resource int, rlim *unix.Rlimit) error {
+		*rlim = *limit
+		return nil
+	}
+	setrlimit = func(resource int, rlim *unix.Rlimit) error {
+		*limit = *rlim
+		return nil
+	}
+	fileLimitRaiseAttempted = false
+	return func() {
+		fileLimitMutex.Lock()
+		defer fileLimitMutex.Unlock()
+		getrlimit, setrlimit, fileLimitRaiseAttempted = originalGetrlimit, originalSetrlimit, originalAttempted
+	}
+}
+
+func TestRaiseFileLimit(t *testing.T) {
+	limit := &unix.Rlimit{Cur: 1024, Max: 4096}
+	defer mockFileLimit(limit)()
+
+	assert.True(t, raiseFileLimit())
+	assert.Equal(t, unix.Rlimit{Cur: 4096, Max: 4096}, *limit)
+
+	// Limit is raised only once.
+	limit.Cur = 1024
+	assert.False(t, raiseFileLimit())
+	assert.Equal(t, uint64(1024), limit.Cur)
+}
+
+func TestRaiseFileLimitAtHardLimit(t *testing.T) {
+	limit := &unix.Rlimit{Cur: 4096, Max: 4096}
+	defer mockFileLimit(limit)()
+
+	assert.False(t, raiseFileLimit())
+}
+
+func TestDescribeFileUsage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fd")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	for _, name := range []string{"0", "1", "2"} {
+		assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), []byte{}, 0644))
+	}
+	originalOpenFilesPath := openFilesPath
+	defer func() {
+		openFilesPath = originalOpenFilesPath
+	}()
+	openFilesPath = dir
+	defer mockFileLimit(&unix.Rlimit{Cur: 1024, Max: 4096})()
+
+	assert.Equal(t, "3 files are open, limit is 1024 (hard limit 4096)", describeFileUsage())
+}
+
+func TestCollector_SetupRaisesFileLimit(t *testing.T) {
+	cgroupPath, err := ioutil.TempDir("", "perf_event")
+	assert.NoError(t, err)
+	defer os.RemoveAll(cgroupPath)
+
+	limit := &unix.Rlimit{Cur: 2, Max: 4}
+	defer mockFileLimit(limit)()
+
+	opened := 0
+	newTestCollector := func() *collector {
+		collector := newCollector(cgroupPath, PerfEvents{
+			Core: Events{
+				Events:       []Group{{events: []Event{"instructions"}}},
+				CustomEvents: []CustomEvent{{Type: unix.PERF_TYPE_HARDWARE, Config: Config{unix.PERF_COUNT_HW_INSTRUCTIONS}, Name: "instructions"}},
+			},
+		}, []int{0, 1, 2, 3}, map[int]int{}, map[int]physicalCore{})
+		collector.perfEventOpen = func(attr *unix.PerfEventAttr, pid int, cpu int, groupFd int, flags int) (int, error) {
+			if uint64(opened) >= limit.Cur {
+				return -1, unix.EMFILE
+			}
+			opened++
+			return unix.Open(os.DevNull, unix.O_RDONLY, 0)
+		}
+		collector.ioctlSetInt = func(fd int, req uint, value int) error {
+			return nil
+		}
+		return collector
+	}
+
+	// Limit is raised to the hard limit and opening is retried.
+	collector := newTestCollector()
+	defer collector.Destroy()
+	err = collector.setup()
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(4), limit.Cur)
+	assert.Len(t, collector.cpuFiles[0].cpuFiles["instructions"], 4)
+	assert.Equal(t, uint64(5), collector.OpenCalls())
+
+	// Setup fails once the hard limit is reached.
+	another := newTestCollector()
+	defer another.Destroy()
+	err = another.setup()
+	assert.Error(t, err)
+}
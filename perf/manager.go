@@ -0,0 +1,100 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Manager of perf events for containers.
+package perf
+
+import (
+	"time"
+
+	info "github.com/google/cadvisor/info/v1"
+	"github.com/google/cadvisor/stats"
+)
+
+// Manager is responsible for creating perf collectors. NewManager returns
+// it regardless of how cAdvisor is built, so that programs that embed
+// cAdvisor can use the methods below without type assertions.
+type Manager interface {
+	stats.Manager
+
+	// Capabilities returns capabilities of cAdvisor process detected when
+	// manager was created.
+	Capabilities() Capabilities
+
+	// Snapshot reads core perf events of all the active collectors at
+	// about the same time.
+	Snapshot() Snapshot
+
+	// PauseAll disables counting of core perf events of all the active
+	// collectors, and of collectors created afterwards, until ResumeAll is
+	// called.
+	PauseAll() error
+
+	// ResumeAll enables counting of core perf events paused by PauseAll.
+	ResumeAll() error
+
+	// Reload applies new configuration of perf events to all the active
+	// collectors and to collectors created afterwards.
+	Reload(events PerfEvents) error
+
+	// Unavailable returns reasons why perf events are not set up anymore,
+	// by path of cgroups that have repeatedly failed to set them up.
+	Unavailable() map[string]string
+
+	// GetProcessCollector returns collector of core perf events of the
+	// process with the pid, and the processes it creates, instead of a
+	// cgroup.
+	GetProcessCollector(pid int) (stats.Collector, error)
+}
+
+// NoopManager is returned by NewManager when perf events are not
+// configured or cannot be collected.
+type NoopManager struct {
+	stats.NoopManager
+}
+
+// Capabilities returns no capabilities as they are not detected.
+func (m *NoopManager) Capabilities() Capabilities {
+	return Capabilities{}
+}
+
+// Snapshot returns snapshot without any container.
+func (m *NoopManager) Snapshot() Snapshot {
+	return Snapshot{Timestamp: time.Now(), PerfStats: map[string][]info.PerfStat{}}
+}
+
+// PauseAll does nothing as no perf events are counted.
+func (m *NoopManager) PauseAll() error {
+	return nil
+}
+
+// ResumeAll does nothing as no perf events are counted.
+func (m *NoopManager) ResumeAll() error {
+	return nil
+}
+
+// Reload does nothing as perf events are not collected.
+func (m *NoopManager) Reload(events PerfEvents) error {
+	return nil
+}
+
+// Unavailable returns no cgroups as perf events are not set up.
+func (m *NoopManager) Unavailable() map[string]string {
+	return map[string]string{}
+}
+
+// GetProcessCollector returns collector that does not collect anything.
+func (m *NoopManager) GetProcessCollector(pid int) (stats.Collector, error) {
+	return &stats.NoopCollector{}, nil
+}
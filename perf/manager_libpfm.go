@@ -19,23 +19,71 @@ package perf
 
 import (
 	"fmt"
+	"io/ioutil"
 	"os"
+	"sort"
+	"strings"
+	"sync"
 
 	info "github.com/google/cadvisor/info/v1"
 	"github.com/google/cadvisor/stats"
 	"github.com/google/cadvisor/utils/sysinfo"
+
+	"k8s.io/klog/v2"
 )
 
+// Handle for mocking purposes.
+var cpuInfoPath = "/proc/cpuinfo"
+
+// snapshotter reads core perf events of a container for a snapshot.
+type snapshotter interface {
+	snapshot() []info.PerfStat
+}
+
+// pauser pauses and resumes counting of core perf events of a container.
+type pauser interface {
+	pause() error
+	resume() error
+}
+
+// reconfigurer applies new configuration of perf events to a container.
+type reconfigurer interface {
+	reconfigure(events PerfEvents) error
+}
+
+// managedCollector is a collector that manager keeps track of.
+type managedCollector interface {
+	snapshotter
+	pauser
+	reconfigurer
+}
+
+var _ Manager = &manager{}
+
 type manager struct {
-	events      PerfEvents
-	onlineCPUs  []int
-	cpuToSocket map[int]int
+	// Configuration of perf events, guarded by collectorsLock as it is
+	// replaced by Reload.
+	events       PerfEvents
+	onlineCPUs   []int
+	cpuToSocket  map[int]int
+	cpuToCore    map[int]physicalCore
+	capabilities Capabilities
+	// Active collectors by cgroup path.
+	collectors     map[string]managedCollector
+	collectorsLock sync.Mutex
+	// Counting is paused by PauseAll, guarded by collectorsLock.
+	paused bool
+	// Number of times configuration has been reloaded, guarded by
+	// collectorsLock.
+	reloads uint64
+	// Consecutive setup failures by cgroup path, guarded by collectorsLock.
+	failures map[string]*setupFailures
 	stats.NoopDestroy
 }
 
-func NewManager(configFile string, topology []info.Node) (stats.Manager, error) {
+func NewManager(configFile string, topology []info.Node) (Manager, error) {
 	if configFile == "" {
-		return &stats.NoopManager{}, nil
+		return &NoopManager{}, nil
 	}
 
 	file, err := os.Open(configFile)
@@ -48,6 +96,21 @@ func NewManager(configFile string, topology []info.Node) (stats.Manager, error)
 		return nil, fmt.Errorf("unable to parse configuration file %q: %w", configFile, err)
 	}
 
+	config, err = prepareEvents(config, fmt.Sprintf("configuration file %q", configFile))
+	if err != nil {
+		return nil, err
+	}
+
+	capabilities, err := getCapabilities()
+	if err != nil {
+		klog.Warningf("Unable to detect capabilities required by perf events: %v", err)
+	} else if !capabilities.Sufficient() {
+		klog.Warningf("cAdvisor has neither CAP_PERFMON (Linux 5.8+) nor CAP_SYS_ADMIN capability, perf events can be opened only if allowed by /proc/sys/kernel/perf_event_paranoid. Grant one of the capabilities to cAdvisor if perf events fail to be set up with permission denied error.")
+		if config.RequireCapabilities {
+			return &NoopManager{}, nil
+		}
+	}
+
 	onlineCPUs := sysinfo.GetOnlineCPUs(topology)
 
 	cpuToSocket := make(map[int]int)
@@ -56,15 +119,255 @@ func NewManager(configFile string, topology []info.Node) (stats.Manager, error)
 		cpuToSocket[cpu] = sysinfo.GetSocketFromCPU(topology, cpu)
 	}
 
-	return &manager{events: config, onlineCPUs: onlineCPUs, cpuToSocket: cpuToSocket}, nil
+	return &manager{events: config, onlineCPUs: onlineCPUs, cpuToSocket: cpuToSocket, cpuToCore: getCPUToCore(topology), capabilities: capabilities, collectors: map[string]managedCollector{}, failures: map[string]*setupFailures{}}, nil
+}
+
+// prepareEvents selects events for the CPU, excludes events that should not
+// be measured on the host and validates and merges groups of configuration
+// read from source, which describes the configuration in errors and logs.
+func prepareEvents(config PerfEvents, source string) (PerfEvents, error) {
+	var cpu cpuInfo
+	if len(config.Conditional) > 0 || config.DerivedMetrics {
+		cpuinfo, err := ioutil.ReadFile(cpuInfoPath)
+		if err != nil {
+			return PerfEvents{}, fmt.Errorf("unable to detect CPU to select perf events: %w", err)
+		}
+		cpu = parseCPUInfo(string(cpuinfo))
+	}
+	if len(config.Conditional) > 0 {
+		config = selectEvents(config, cpu)
+	}
+
+	patterns, err := parseExcludePatterns(*excludedEvents)
+	if err != nil {
+		return PerfEvents{}, err
+	}
+	config.Core = excludeEvents(config.Core, patterns)
+	config.Uncore = excludeEvents(config.Uncore, patterns)
+	if len(config.Core.excluded) > 0 || len(config.Uncore.excluded) > 0 {
+		klog.Infof("Core perf events %v and uncore perf events %v configured in %s are excluded on this host", config.Core.excluded, config.Uncore.excluded, source)
+	}
+
+	if requiresLibpfm(config.Core) || requiresLibpfm(config.Uncore) {
+		err = checkLibpfmInitialized()
+		if err != nil {
+			return PerfEvents{}, fmt.Errorf("unable to measure perf events configured in %s: %w", source, err)
+		}
+	}
+
+	// Events that are not supported are reported at once, but they do not
+	// prevent the others, e.g. core events when uncore ones are missing,
+	// from being measured.
+	err = Validate(config)
+	if err != nil {
+		klog.Warningf("Perf events configured in %s are going to fail to be set up: %v", source, err)
+	}
+	err = validateSoftwareEvents(config)
+	if err != nil {
+		return PerfEvents{}, fmt.Errorf("invalid %s: %w", source, err)
+	}
+	err = validateThresholds(config.Thresholds)
+	if err != nil {
+		return PerfEvents{}, fmt.Errorf("invalid %s: %w", source, err)
+	}
+	err = validateConfidence(config.Confidence)
+	if err != nil {
+		return PerfEvents{}, fmt.Errorf("invalid %s: %w", source, err)
+	}
+	err = validateRotation(config)
+	if err != nil {
+		return PerfEvents{}, fmt.Errorf("invalid %s: %w", source, err)
+	}
+	err = validateMergeGroups(config)
+	if err != nil {
+		return PerfEvents{}, fmt.Errorf("invalid %s: %w", source, err)
+	}
+	err = validateSetupFailureBudget(config)
+	if err != nil {
+		return PerfEvents{}, fmt.Errorf("invalid %s: %w", source, err)
+	}
+	err = validateReadWorkers(config)
+	if err != nil {
+		return PerfEvents{}, fmt.Errorf("invalid %s: %w", source, err)
+	}
+	err = checkSubtreeAggregate(config.SubtreeAggregate)
+	if err != nil {
+		return PerfEvents{}, fmt.Errorf("unable to measure perf events configured in %s: %w", source, err)
+	}
+	config.Core.Events = mergeCoreGroups(config)
+
+	if config.DerivedMetrics {
+		var missing map[string][]Event
+		config.derivedMetrics, missing = availableDerivedMetrics(selectDerivedMetrics(cpu), config)
+		for name, events := range missing {
+			klog.Infof("Derived perf metric %q is not reported, because events %v it is computed from are not configured in %s", name, events, source)
+		}
+	}
+
+	feasibility, err := FeasibilityCheck(config)
+	if err != nil {
+		klog.V(4).Infof("Unable to check if perf events fit into hardware counters: %v", err)
+	} else if feasibility.MultiplexGroups > 1 {
+		klog.Warningf("Core perf events configured in %s need %d hardware counters while %s PMU has %d, they will be multiplexed and each group is expected to be counted %.0f%% of time", source, feasibility.Events, feasibility.Counters.PMU, feasibility.Counters.GeneralPurpose+feasibility.Counters.Fixed, 100/feasibility.MultiplexGroups)
+	}
+	return config, nil
+}
+
+// Capabilities returns capabilities of cAdvisor process detected when
+// manager was created.
+func (m *manager) Capabilities() Capabilities {
+	return m.capabilities
 }
 
 func (m *manager) GetCollector(cgroupPath string) (stats.Collector, error) {
-	collector := newCollector(cgroupPath, m.events, m.onlineCPUs, m.cpuToSocket)
-	err := collector.setup()
+	return m.getCollector(cgroupPath, func(events PerfEvents) *collector {
+		return newCollector(cgroupPath, events, m.onlineCPUs, m.cpuToSocket, m.cpuToCore)
+	})
+}
+
+// getCollector sets up collector created with the configuration of perf
+// events and keeps track of it by the cgroup path.
+func (m *manager) getCollector(cgroupPath string, create func(events PerfEvents) *collector) (stats.Collector, error) {
+	m.collectorsLock.Lock()
+	events, reloads := m.events, m.reloads
+	_, discontinuous := m.collectors[cgroupPath]
+	err := m.unavailable(cgroupPath)
+	m.collectorsLock.Unlock()
+	if err != nil {
+		return &stats.NoopCollector{}, err
+	}
+	collector := create(events)
+	collector.discontinuous = discontinuous
+	err = collector.setup()
 	if err != nil {
 		collector.Destroy()
+		m.collectorsLock.Lock()
+		m.recordSetup(cgroupPath, err)
+		m.collectorsLock.Unlock()
 		return &stats.NoopCollector{}, err
 	}
+
+	m.collectorsLock.Lock()
+	m.collectors[cgroupPath] = collector
+	m.recordSetup(cgroupPath, nil)
+	// Configuration has been reloaded while the collector was set up.
+	if m.reloads != reloads {
+		err = collector.reconfigure(m.events)
+		if err != nil {
+			klog.Warningf("Unable to apply reloaded configuration to new collector of %q: %v", cgroupPath, err)
+		}
+	}
+	if m.paused {
+		err = collector.pause()
+		if err != nil {
+			klog.Warningf("Unable to pause counting of perf events of new collector of %q: %v", cgroupPath, err)
+		}
+	}
+	m.collectorsLock.Unlock()
+	collector.unregister = func() {
+		m.collectorsLock.Lock()
+		defer m.collectorsLock.Unlock()
+		if m.collectors[cgroupPath] == collector {
+			delete(m.collectors, cgroupPath)
+		}
+	}
+	collector.recordSetup = m.setupRecorder(cgroupPath)
 	return collector, nil
 }
+
+// PauseAll disables counting of core perf events of all the active
+// collectors, and of collectors created afterwards, until ResumeAll is
+// called. Events stay open and configured, and stats read in the meantime
+// are marked as paused. Collectors that fail to be paused are reported in
+// the error, others are paused regardless.
+func (m *manager) PauseAll() error {
+	m.collectorsLock.Lock()
+	defer m.collectorsLock.Unlock()
+
+	m.paused = true
+	return m.forEachCollector("pause counting of", managedCollector.pause)
+}
+
+// ResumeAll enables counting of core perf events paused by PauseAll.
+// Values continue from where they were paused.
+func (m *manager) ResumeAll() error {
+	m.collectorsLock.Lock()
+	defer m.collectorsLock.Unlock()
+
+	m.paused = false
+	return m.forEachCollector("resume counting of", managedCollector.resume)
+}
+
+// Reload applies new configuration of perf events, e.g. read again on
+// SIGHUP, to all the active collectors and to collectors created
+// afterwards. Groups of core events that are configured the same way in both
+// configurations stay open and their values continue, other groups are
+// opened or closed. Configuration is validated first and nothing is applied
+// if it is invalid. Collectors that fail to be reconfigured are reported in
+// the error, others are reconfigured regardless.
+func (m *manager) Reload(events PerfEvents) error {
+	events, err := prepareEvents(events, "reloaded configuration")
+	if err != nil {
+		return err
+	}
+
+	m.collectorsLock.Lock()
+	defer m.collectorsLock.Unlock()
+
+	m.events = events
+	m.reloads++
+	return m.forEachCollector("reconfigure", func(collector managedCollector) error {
+		return collector.reconfigure(events)
+	})
+}
+
+// forEachCollector calls action on every active collector and returns error
+// listing cgroups that it failed for. Lock of collectors has to be held.
+func (m *manager) forEachCollector(name string, action func(managedCollector) error) error {
+	failed := []string{}
+	for cgroupPath, collector := range m.collectors {
+		err := action(collector)
+		if err != nil {
+			klog.Warningf("Unable to %s perf events of %q: %v", name, cgroupPath, err)
+			failed = append(failed, cgroupPath)
+		}
+	}
+	if len(failed) > 0 {
+		sort.Strings(failed)
+		return fmt.Errorf("unable to %s perf events of %d cgroups: %s", name, len(failed), strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// Snapshot reads core perf events of all the active collectors
+// concurrently, so they are read as close together as possible. Values
+// are cumulative regardless of configuration and reading them does not
+// affect values reported by collectors. Collectors with rotation report
+// only the group that is being measured.
+func (m *manager) Snapshot() Snapshot {
+	m.collectorsLock.Lock()
+	collectors := make(map[string]snapshotter, len(m.collectors))
+	for cgroupPath, collector := range m.collectors {
+		collectors[cgroupPath] = collector
+	}
+	m.collectorsLock.Unlock()
+
+	snapshot := Snapshot{
+		Timestamp: now(),
+		PerfStats: make(map[string][]info.PerfStat, len(collectors)),
+	}
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	for cgroupPath, collector := range collectors {
+		wg.Add(1)
+		go func(cgroupPath string, collector snapshotter) {
+			defer wg.Done()
+			perfStats := collector.snapshot()
+			mu.Lock()
+			snapshot.PerfStats[cgroupPath] = perfStats
+			mu.Unlock()
+		}(cgroupPath, collector)
+	}
+	wg.Wait()
+	return snapshot
+}
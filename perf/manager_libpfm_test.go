@@ -18,7 +18,12 @@
 package perf
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 
@@ -30,7 +35,7 @@ func TestNoConfigFilePassed(t *testing.T) {
 	manager, err := NewManager("", []info.Node{})
 
 	assert.Nil(t, err)
-	_, ok := manager.(*stats.NoopManager)
+	_, ok := manager.(*NoopManager)
 	assert.True(t, ok)
 }
 
@@ -55,3 +60,545 @@ func TestNewManager(t *testing.T) {
 	_, ok := managerInstance.(*manager)
 	assert.True(t, ok)
 }
+
+// mockUninitializedLibpfm simulates failure of libpfm4 initialization and
+// returns function that restores the state.
+func mockUninitializedLibpfm(err error) func() {
+	libpmfMutex.Lock()
+	defer libpmfMutex.Unlock()
+	initialized, initializationError := isLibpfmInitialized, libpfmInitializationError
+	isLibpfmInitialized, libpfmInitializationError = false, err
+	return func() {
+		libpmfMutex.Lock()
+		defer libpmfMutex.Unlock()
+		isLibpfmInitialized, libpfmInitializationError = initialized, initializationError
+	}
+}
+
+func TestNewManagerWithUninitializedLibpfm(t *testing.T) {
+	initErr := errors.New("pfm_initialize failed with -4: not supported")
+	defer mockUninitializedLibpfm(initErr)()
+
+	_, err := NewManager("testing/perf.json", []info.Node{})
+	assert.Error(t, err)
+	assert.True(t, errors.Is(err, initErr))
+	assert.Contains(t, err.Error(), "libpfm4 is not initialized")
+}
+
+func TestCollectorSetupWithUninitializedLibpfm(t *testing.T) {
+	initErr := errors.New("pfm_initialize failed with -4: not supported")
+	defer mockUninitializedLibpfm(initErr)()
+
+	collector := newCollector("/sys/fs/cgroup/perf_event/container", PerfEvents{
+		Core: Events{Events: []Group{{events: []Event{"instructions"}}}},
+	}, []int{0}, map[int]int{0: 0}, map[int]physicalCore{})
+	err := collector.setup()
+	assert.True(t, errors.Is(err, initErr))
+
+	// Custom events are not encoded with libpfm4.
+	assert.False(t, requiresLibpfm(Events{
+		Events:       []Group{{events: []Event{"instructions_retired"}}},
+		CustomEvents: []CustomEvent{{Type: 4, Config: Config{0x5300c0}, Name: "instructions_retired"}},
+	}))
+}
+
+func TestNewManagerWithConditionalEvents(t *testing.T) {
+	cpuInfoPath = "testing/cpuinfo-intel"
+	defer func() { cpuInfoPath = "/proc/cpuinfo" }()
+
+	managerInstance, err := NewManager("testing/perf-conditional.json", []info.Node{})
+	assert.Nil(t, err)
+	perfManager, ok := managerInstance.(*manager)
+	assert.True(t, ok)
+	assert.Len(t, perfManager.events.Core.Events, 1)
+	assert.Equal(t, []Event{"instructions_retired"}, perfManager.events.Core.Events[0].events)
+	assert.Len(t, perfManager.events.Core.CustomEvents, 1)
+}
+
+func mockCapabilities(capabilities Capabilities, err error) func() {
+	getCapabilities = func() (Capabilities, error) {
+		return capabilities, err
+	}
+	return func() { getCapabilities = readCapabilities }
+}
+
+func TestNewManagerCapabilities(t *testing.T) {
+	defer mockCapabilities(Capabilities{Perfmon: true}, nil)()
+
+	managerInstance, err := NewManager("testing/perf.json", []info.Node{})
+	assert.Nil(t, err)
+	perfManager, ok := managerInstance.(*manager)
+	assert.True(t, ok)
+	assert.Equal(t, Capabilities{Perfmon: true}, perfManager.Capabilities())
+}
+
+func TestNewManagerWithoutCapabilities(t *testing.T) {
+	defer mockCapabilities(Capabilities{}, nil)()
+
+	// Perf events are set up anyway.
+	managerInstance, err := NewManager("testing/perf.json", []info.Node{})
+	assert.Nil(t, err)
+	perfManager, ok := managerInstance.(*manager)
+	assert.True(t, ok)
+	assert.False(t, perfManager.Capabilities().Sufficient())
+
+	managerInstance, err = NewManager("testing/perf-require-capabilities.json", []info.Node{})
+	assert.Nil(t, err)
+	_, ok = managerInstance.(*NoopManager)
+	assert.True(t, ok)
+}
+
+func TestParseCapabilities(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		status   string
+		expected Capabilities
+	}{
+		{"root on Linux 5.8+", "Name:\tcadvisor\nCapEff:\t000001ffffffffff\n", Capabilities{Perfmon: true, SysAdmin: true}},
+		{"CAP_PERFMON only", "CapEff:\t0000004000000000\n", Capabilities{Perfmon: true}},
+		{"CAP_SYS_ADMIN only", "CapEff:\t0000000000200000\n", Capabilities{SysAdmin: true}},
+		{"unprivileged", "CapInh:\t0000000000000000\nCapEff:\t0000000000000000\n", Capabilities{}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			capabilities, err := parseCapabilities(test.status)
+			assert.NoError(t, err)
+			assert.Equal(t, test.expected, capabilities)
+		})
+	}
+
+	_, err := parseCapabilities("Name:\tcadvisor\n")
+	assert.Error(t, err)
+}
+
+type stubSnapshotter struct {
+	perfStats []info.PerfStat
+	reads     *int32
+}
+
+func (s stubSnapshotter) snapshot() []info.PerfStat {
+	atomic.AddInt32(s.reads, 1)
+	return s.perfStats
+}
+
+func (s stubSnapshotter) pause() error {
+	return nil
+}
+
+func (s stubSnapshotter) resume() error {
+	return nil
+}
+
+func (s stubSnapshotter) reconfigure(events PerfEvents) error {
+	return nil
+}
+
+// reconfigurableStub records configuration applied to it.
+type reconfigurableStub struct {
+	stubSnapshotter
+	events PerfEvents
+	err    error
+}
+
+func (s *reconfigurableStub) reconfigure(events PerfEvents) error {
+	if s.err != nil {
+		return s.err
+	}
+	s.events = events
+	return nil
+}
+
+func TestManagerSnapshot(t *testing.T) {
+	var reads int32
+	m := &manager{collectors: map[string]managedCollector{}}
+	for _, cgroupPath := range []string{"/a", "/b", "/c"} {
+		m.collectors[cgroupPath] = stubSnapshotter{
+			perfStats: []info.PerfStat{{PerfValue: info.PerfValue{Name: "instructions" + cgroupPath, Value: 1}}},
+			reads:     &reads,
+		}
+	}
+
+	timestamp := time.Date(2020, time.October, 1, 12, 0, 0, 0, time.UTC)
+	originalNow := now
+	defer func() {
+		now = originalNow
+	}()
+	now = func() time.Time {
+		return timestamp
+	}
+
+	snapshot := m.Snapshot()
+	assert.Equal(t, int32(3), reads)
+	assert.Equal(t, timestamp, snapshot.Timestamp)
+	assert.Equal(t, map[string][]info.PerfStat{
+		"/a": {{PerfValue: info.PerfValue{Name: "instructions/a", Value: 1}}},
+		"/b": {{PerfValue: info.PerfValue{Name: "instructions/b", Value: 1}}},
+		"/c": {{PerfValue: info.PerfValue{Name: "instructions/c", Value: 1}}},
+	}, snapshot.PerfStats)
+}
+
+func TestManagerPauseAll(t *testing.T) {
+	counters := map[string]*fakeCounter{"/a": {fd: 3, enabled: true}, "/b": {fd: 4, enabled: true}}
+	m := &manager{collectors: map[string]managedCollector{}}
+	for cgroupPath, counter := range counters {
+		m.collectors[cgroupPath] = &collector{
+			cgroupPath:  cgroupPath,
+			uncore:      &stats.NoopCollector{},
+			ioctlSetInt: counter.ioctl,
+			cpuFiles: map[int]group{
+				0: {
+					cpuFiles:   map[string]map[int]readerCloser{"instructions": {0: counter}},
+					names:      []string{"instructions"},
+					leaderName: "instructions",
+				},
+			},
+		}
+	}
+	count := func(value uint64) {
+		for _, counter := range counters {
+			counter.count(value)
+		}
+	}
+	assertStats := func(value uint64, paused bool) {
+		for cgroupPath := range counters {
+			stats := &info.ContainerStats{}
+			assert.NoError(t, m.collectors[cgroupPath].(*collector).UpdateStats(stats))
+			assert.Len(t, stats.PerfStats, 1)
+			assert.Equal(t, value, stats.PerfStats[0].Value, cgroupPath)
+			assert.Equal(t, paused, stats.PerfStats[0].Paused, cgroupPath)
+		}
+	}
+
+	count(10)
+	assertStats(10, false)
+
+	// Nothing is counted while paused and file descriptors stay open.
+	assert.NoError(t, m.PauseAll())
+	count(5)
+	assertStats(10, true)
+	for _, counter := range counters {
+		assert.False(t, counter.enabled)
+	}
+	assert.Len(t, m.collectors, 2)
+
+	// Counting continues from paused values.
+	assert.NoError(t, m.ResumeAll())
+	count(7)
+	assertStats(17, false)
+	for _, counter := range counters {
+		assert.True(t, counter.enabled)
+	}
+}
+
+func TestManagerPauseAllFailure(t *testing.T) {
+	counter := &fakeCounter{fd: 3, enabled: true}
+	m := &manager{collectors: map[string]managedCollector{
+		"/a": &collector{
+			uncore:      &stats.NoopCollector{},
+			ioctlSetInt: counter.ioctl,
+			cpuFiles: map[int]group{0: {
+				cpuFiles:   map[string]map[int]readerCloser{"instructions": {0: counter}},
+				names:      []string{"instructions"},
+				leaderName: "instructions",
+			}},
+		},
+		// Leader without file descriptor cannot be disabled.
+		"/b": &collector{
+			uncore: &stats.NoopCollector{},
+			cpuFiles: map[int]group{0: {
+				cpuFiles:   map[string]map[int]readerCloser{"instructions": {0: buffer{bytes.NewBuffer([]byte{})}}},
+				names:      []string{"instructions"},
+				leaderName: "instructions",
+			}},
+		},
+	}}
+
+	err := m.PauseAll()
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "/b")
+	assert.False(t, counter.enabled)
+}
+
+func TestManagerReload(t *testing.T) {
+	stubs := map[string]*reconfigurableStub{
+		"/a": {},
+		"/b": {},
+		"/c": {err: errors.New("unable to open events")},
+	}
+	m := &manager{collectors: map[string]managedCollector{}}
+	for cgroupPath, stub := range stubs {
+		m.collectors[cgroupPath] = stub
+	}
+
+	events := PerfEvents{}
+	assert.NoError(t, json.Unmarshal([]byte(`{
+		"core": {
+			"events": ["instructions", ["cycles", "cache-misses"]],
+			"custom_events": [
+				{"type": 0, "config": ["0x1"], "name": "instructions"},
+				{"type": 0, "config": ["0x0"], "name": "cycles"},
+				{"type": 0, "config": ["0x3"], "name": "cache-misses"}
+			]
+		},
+		"delta": true
+	}`), &events))
+
+	// Failure of a single collector does not stop reload of the others.
+	err := m.Reload(events)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "/c")
+	assert.NotContains(t, err.Error(), "/a")
+	for _, cgroupPath := range []string{"/a", "/b"} {
+		assert.True(t, stubs[cgroupPath].events.Delta, cgroupPath)
+		assert.Len(t, stubs[cgroupPath].events.Core.Events, 2, cgroupPath)
+	}
+	assert.False(t, stubs["/c"].events.Delta)
+	// Collectors created afterwards use reloaded configuration.
+	assert.True(t, m.events.Delta)
+	assert.Equal(t, uint64(1), m.reloads)
+
+	// Invalid configuration is not applied at all.
+	invalid := events
+	invalid.Delta = false
+	invalid.Thresholds = []Threshold{{Event: "instructions", Comparator: "=", Value: 1}}
+	err = m.Reload(invalid)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid reloaded configuration")
+	assert.True(t, stubs["/a"].events.Delta)
+	assert.True(t, m.events.Delta)
+}
+
+func TestManagerSetupFailureBudget(t *testing.T) {
+	initErr := errors.New("pfm_initialize failed with -4: not supported")
+	defer mockUninitializedLibpfm(initErr)()
+
+	m := &manager{
+		events: PerfEvents{
+			Core:               Events{Events: []Group{{events: []Event{"instructions"}}}},
+			SetupFailureBudget: 3,
+		},
+		onlineCPUs:  []int{0},
+		cpuToSocket: map[int]int{0: 0},
+		collectors:  map[string]managedCollector{},
+		failures:    map[string]*setupFailures{},
+	}
+
+	// Setup is attempted until budget is exhausted.
+	for i := 0; i < 3; i++ {
+		_, err := m.GetCollector("/a")
+		assert.True(t, errors.Is(err, initErr))
+		assert.NotContains(t, err.Error(), "unavailable")
+	}
+	reasons := m.Unavailable()
+	assert.Len(t, reasons, 1)
+	assert.Contains(t, reasons["/a"], initErr.Error())
+
+	collector, err := m.GetCollector("/a")
+	assert.IsType(t, &stats.NoopCollector{}, collector)
+	assert.Contains(t, err.Error(), `perf events of cgroup "/a" are unavailable after 3 consecutive setup failures`)
+	assert.True(t, errors.Is(err, initErr))
+
+	// Other cgroups have budgets of their own.
+	_, err = m.GetCollector("/b")
+	assert.NotContains(t, err.Error(), "unavailable")
+	assert.Len(t, m.Unavailable(), 1)
+}
+
+func TestManagerSetupFailureExpiry(t *testing.T) {
+	initErr := errors.New("pfm_initialize failed with -4: not supported")
+	defer mockUninitializedLibpfm(initErr)()
+	current := time.Date(2020, time.October, 1, 12, 0, 0, 0, time.UTC)
+	originalNow := now
+	defer func() {
+		now = originalNow
+	}()
+	now = func() time.Time {
+		return current
+	}
+
+	m := &manager{
+		events: PerfEvents{
+			Core:               Events{Events: []Group{{events: []Event{"instructions"}}}},
+			SetupFailureBudget: 2,
+			SetupFailureExpiry: Duration(time.Hour),
+		},
+		onlineCPUs:  []int{0},
+		cpuToSocket: map[int]int{0: 0},
+		collectors:  map[string]managedCollector{},
+		failures:    map[string]*setupFailures{},
+	}
+	for i := 0; i < 2; i++ {
+		_, err := m.GetCollector("/a")
+		assert.NotContains(t, err.Error(), "unavailable")
+	}
+	current = current.Add(30 * time.Minute)
+	_, err := m.GetCollector("/b")
+	assert.NotContains(t, err.Error(), "unavailable")
+	assert.Len(t, m.failures, 2)
+	_, err = m.GetCollector("/a")
+	assert.Contains(t, err.Error(), "unavailable")
+
+	// Cgroup that has been given up on is set up again after the expiry
+	// and failures of cgroups that are gone are forgotten.
+	current = current.Add(30 * time.Minute)
+	_, err = m.GetCollector("/a")
+	assert.NotContains(t, err.Error(), "unavailable")
+	assert.Empty(t, m.Unavailable())
+	assert.Len(t, m.failures, 2)
+	current = current.Add(time.Hour)
+	assert.Empty(t, m.Unavailable())
+	assert.Empty(t, m.failures)
+}
+
+func TestCollectorGivesUpReopening(t *testing.T) {
+	counter := &fakeCounter{fd: 3, enabled: true}
+	results := []bool{true, false}
+	reported := []error{}
+	c := &collector{
+		cgroupPath: "/a",
+		cpuFiles: map[int]group{0: {
+			cpuFiles:   map[string]map[int]readerCloser{"instructions": {0: counter}},
+			names:      []string{"instructions"},
+			leaderName: "instructions",
+		}},
+		recordSetup: func(err error) bool {
+			reported = append(reported, err)
+			result := results[0]
+			results = results[1:]
+			return result
+		},
+	}
+	reopenErr := errors.New("unable to open cgroup")
+
+	// Nothing is reported while events do not need reopening.
+	c.reportReopen(nil)
+	assert.Empty(t, reported)
+
+	c.reportReopen(reopenErr)
+	assert.False(t, c.unavailable)
+	assert.Len(t, c.cpuFiles, 1)
+
+	// Events are closed once manager gives up.
+	c.reportReopen(reopenErr)
+	assert.Equal(t, []error{reopenErr, reopenErr}, reported)
+	assert.True(t, c.unavailable)
+	assert.Empty(t, c.cpuFiles)
+}
+
+func TestCollectorReportsReopenWhileManagerIsBusy(t *testing.T) {
+	events := PerfEvents{}
+	assert.NoError(t, json.Unmarshal([]byte(`{
+		"core": {
+			"events": ["instructions"],
+			"custom_events": [{"type": 0, "config": ["0x1"], "name": "instructions"}]
+		},
+		"follow_cgroup_moves": true,
+		"setup_failure_budget": 10
+	}`), &events))
+	events, err := prepareEvents(events, "test configuration")
+	assert.NoError(t, err)
+
+	counter := &fakeCounter{fd: 3, enabled: true}
+	m := &manager{events: events, collectors: map[string]managedCollector{}, failures: map[string]*setupFailures{}}
+	actions := map[string]func() error{
+		"pause":  m.PauseAll,
+		"reload": func() error { return m.Reload(events) },
+		"resume": m.ResumeAll,
+	}
+	var action func() error
+	results := make(chan error, 1)
+	c := &collector{
+		cgroupPath:  "/a",
+		events:      events,
+		uncore:      &stats.NoopCollector{},
+		differ:      newDiffer(),
+		ioctlSetInt: counter.ioctl,
+		cpuFiles: map[int]group{0: {
+			cpuFiles:   map[string]map[int]readerCloser{"instructions": {0: counter}},
+			names:      []string{"instructions"},
+			leaderName: "instructions",
+		}},
+		// Manager takes lock of collectors and waits for lock of the
+		// collector while events of moved cgroup are being reopened, which
+		// fails.
+		resolveCgroupPath: func(cgroupPath string) (string, error) {
+			go func() {
+				results <- action()
+			}()
+			time.Sleep(10 * time.Millisecond)
+			return "", errors.New("unable to find cgroup")
+		},
+		recordSetup: m.setupRecorder("/a"),
+	}
+	m.collectors["/a"] = c
+
+	for _, name := range []string{"pause", "reload", "resume"} {
+		action = actions[name]
+		done := make(chan error, 1)
+		go func() {
+			done <- c.UpdateStats(&info.ContainerStats{})
+		}()
+		select {
+		case err := <-done:
+			assert.NoError(t, err)
+			assert.NoError(t, <-results, name)
+		case <-time.After(10 * time.Second):
+			t.Fatalf("collector reporting reopening deadlocked with %s of manager", name)
+		}
+	}
+	assert.Equal(t, 3, m.failures["/a"].count)
+	assert.False(t, c.paused)
+}
+
+func TestCollectorSnapshotKeepsDelta(t *testing.T) {
+	counter := &fakeCounter{value: 100, time: 1}
+	collector := collector{
+		uncore: &stats.NoopCollector{},
+		events: PerfEvents{Delta: true},
+		differ: newDiffer(),
+		cpuFiles: map[int]group{
+			0: {
+				cpuFiles:   map[string]map[int]readerCloser{"instructions": {0: counter}},
+				names:      []string{"instructions"},
+				leaderName: "instructions",
+			},
+		},
+	}
+
+	stats := &info.ContainerStats{}
+	assert.NoError(t, collector.UpdateStats(stats))
+	counter.value = 150
+
+	// Snapshot reports cumulative value.
+	assert.Equal(t, uint64(150), collector.snapshot()[0].Value)
+
+	// Increase reported by the collector is not affected by the snapshot.
+	counter.value = 160
+	assert.NoError(t, collector.UpdateStats(stats))
+	assert.Equal(t, uint64(60), stats.PerfStats[0].Value)
+}
+
+func TestCollectorSnapshotWithRotation(t *testing.T) {
+	collector := collector{
+		uncore: &stats.NoopCollector{},
+		events: PerfEvents{Rotation: true},
+		cpuFiles: map[int]group{
+			0: {
+				cpuFiles:   map[string]map[int]readerCloser{"instructions": {0: &fakeCounter{value: 100, time: 1}}},
+				names:      []string{"instructions"},
+				leaderName: "instructions",
+			},
+			1: {
+				cpuFiles:   map[string]map[int]readerCloser{"cycles": {0: &fakeCounter{value: 200, time: 1}}},
+				names:      []string{"cycles"},
+				leaderName: "cycles",
+			},
+		},
+		rotationGroup: 1,
+	}
+
+	// Only the group that is being measured is read.
+	perfStats := collector.snapshot()
+	assert.Len(t, perfStats, 1)
+	assert.Equal(t, "cycles", perfStats[0].Name)
+	assert.Equal(t, uint64(200), perfStats[0].Value)
+}
@@ -0,0 +1,41 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package perf
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/google/cadvisor/stats"
+)
+
+func TestNoopManager(t *testing.T) {
+	m := &NoopManager{}
+	assert.False(t, m.Capabilities().Sufficient())
+
+	snapshot := m.Snapshot()
+	assert.False(t, snapshot.Timestamp.IsZero())
+	assert.Empty(t, snapshot.PerfStats)
+
+	assert.NoError(t, m.PauseAll())
+	assert.NoError(t, m.ResumeAll())
+	assert.NoError(t, m.Reload(PerfEvents{}))
+	assert.Empty(t, m.Unavailable())
+
+	collector, err := m.GetProcessCollector(1234)
+	assert.NoError(t, err)
+	assert.IsType(t, &stats.NoopCollector{}, collector)
+}
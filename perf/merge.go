@@ -0,0 +1,73 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Merging of events configured as separate groups under shared leaders.
+package perf
+
+import "fmt"
+
+// mergeGroups merges events configured as separate groups, e.g. "instructions"
+// rather than ["instructions"], into groups of at most maxEvents events that
+// are counted by the same PMU, so they are scheduled on counters together.
+// The first event of a merged group is its leader and the group takes
+// position of it. Groups configured as arrays or objects and events which
+// PMU is not known are kept as they are. PMU identifies PMU of events which
+// can be merged.
+func mergeGroups(groups []Group, pmu map[Event]uint32, maxEvents int) []Group {
+	if maxEvents < 2 {
+		return groups
+	}
+
+	result := make([]Group, 0, len(groups))
+	// Index of merged group that is being filled for each PMU.
+	filled := map[uint32]int{}
+	for _, group := range groups {
+		if group.array || len(group.events) != 1 {
+			result = append(result, group)
+			continue
+		}
+		event := group.events[0]
+		eventPMU, ok := pmu[event]
+		if !ok {
+			result = append(result, group)
+			continue
+		}
+		index, ok := filled[eventPMU]
+		if ok && len(result[index].events) < maxEvents && !containsEvent(result[index].events, event) {
+			result[index].events = append(result[index].events, event)
+			result[index].array = true
+			continue
+		}
+		filled[eventPMU] = len(result)
+		result = append(result, Group{events: []Event{event}})
+	}
+	return result
+}
+
+func containsEvent(events []Event, event Event) bool {
+	for _, e := range events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// validateMergeGroups checks if maximum size of merged groups is valid.
+func validateMergeGroups(events PerfEvents) error {
+	if events.MergeGroups < 0 {
+		return fmt.Errorf("maximum number of events in merged group has to be positive, got %d", events.MergeGroups)
+	}
+	return nil
+}
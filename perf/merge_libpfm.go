@@ -0,0 +1,94 @@
+// +build libpfm,cgo
+
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Merging of events configured as separate groups under shared leaders.
+package perf
+
+import (
+	"golang.org/x/sys/unix"
+	"k8s.io/klog/v2"
+)
+
+// mergeCoreGroups merges core events configured as separate groups if
+// merging is enabled. Merged groups are limited to number of general
+// purpose counters of the host, when it is known, as larger group would
+// never be scheduled.
+func mergeCoreGroups(events PerfEvents) []Group {
+	maxEvents := events.MergeGroups
+	if maxEvents < 2 {
+		return events.Core.Events
+	}
+	counters, err := readCoreCounters()
+	if err != nil {
+		klog.V(4).Infof("Unable to limit size of merged groups of perf events to number of counters: %v", err)
+	} else if counters.GeneralPurpose > 0 && counters.GeneralPurpose < maxEvents {
+		klog.V(4).Infof("Merged groups of perf events are limited to %d events of %s PMU counters", counters.GeneralPurpose, counters.PMU)
+		maxEvents = counters.GeneralPurpose
+	}
+	return mergeGroups(events.Core.Events, mergeablePMUs(events.Core), maxEvents)
+}
+
+// mergeablePMUs returns PMUs of events configured as separate groups that
+// can be merged. Events counted by kernel, e.g. software events, are not
+// merged as they do not need hardware counters.
+func mergeablePMUs(events Events) map[Event]uint32 {
+	software := softwareEventNames(events)
+	custom := make(map[Event]CustomEvent, len(events.CustomEvents))
+	for _, event := range events.CustomEvents {
+		custom[event.Name] = event
+	}
+
+	pmus := map[Event]uint32{}
+	for _, group := range events.Events {
+		if group.array || len(group.events) != 1 {
+			continue
+		}
+		event := group.events[0]
+		if _, ok := software[event]; ok {
+			continue
+		}
+		eventType := uint32(0)
+		if customEvent, ok := custom[event]; ok {
+			eventType = customEvent.Type
+		} else {
+			encoded, err := encodedEvent(string(event))
+			if err != nil {
+				klog.V(4).Infof("Perf event %q is not merged with other events: %v", event, err)
+				continue
+			}
+			eventType = encoded.Type
+		}
+		pmu, ok := mergeablePMU(eventType)
+		if ok {
+			pmus[event] = pmu
+		}
+	}
+	return pmus
+}
+
+// mergeablePMU returns PMU that counts events of the type. Generalized
+// hardware and cache events are counted by core PMU as its raw events.
+// Events counted by kernel are not mergeable.
+func mergeablePMU(eventType uint32) (uint32, bool) {
+	switch eventType {
+	case unix.PERF_TYPE_HARDWARE, unix.PERF_TYPE_HW_CACHE, unix.PERF_TYPE_RAW:
+		return unix.PERF_TYPE_RAW, true
+	case unix.PERF_TYPE_SOFTWARE, unix.PERF_TYPE_TRACEPOINT, unix.PERF_TYPE_BREAKPOINT:
+		return 0, false
+	}
+	// Dynamic PMU registered in /sys/bus/event_source/devices.
+	return eventType, true
+}
@@ -0,0 +1,69 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Merging of events configured as separate groups under shared leaders.
+package perf
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMergeGroups(t *testing.T) {
+	events := Events{}
+	err := json.Unmarshal([]byte(`{"events": [
+		"instructions",
+		["cache-misses", "cache-references"],
+		"cycles",
+		"uncore_imc/cas_count_read",
+		"context-switches",
+		"branches",
+		"instructions",
+		"branch-misses",
+		"uncore_imc/cas_count_write"
+	]}`), &events)
+	assert.NoError(t, err)
+	pmus := map[Event]uint32{
+		"instructions":               4,
+		"cycles":                     4,
+		"branches":                   4,
+		"branch-misses":              4,
+		"uncore_imc/cas_count_read":  12,
+		"uncore_imc/cas_count_write": 12,
+	}
+
+	merged := mergeGroups(events.Events, pmus, 3)
+	assert.Equal(t, []Group{
+		{events: []Event{"instructions", "cycles", "branches"}, array: true},
+		{events: []Event{"cache-misses", "cache-references"}, array: true},
+		{events: []Event{"uncore_imc/cas_count_read", "uncore_imc/cas_count_write"}, array: true},
+		// Event which PMU is not known is kept in its own group.
+		{events: []Event{"context-switches"}},
+		// Full group is not extended and the same event is not merged twice.
+		{events: []Event{"instructions", "branch-misses"}, array: true},
+	}, merged)
+	// Configuration is not modified.
+	assert.Equal(t, []Event{"instructions"}, events.Events[0].events)
+
+	assert.Equal(t, events.Events, mergeGroups(events.Events, pmus, 0))
+	assert.Equal(t, events.Events, mergeGroups(events.Events, pmus, 1))
+}
+
+func TestValidateMergeGroups(t *testing.T) {
+	assert.NoError(t, validateMergeGroups(PerfEvents{}))
+	assert.NoError(t, validateMergeGroups(PerfEvents{MergeGroups: 4}))
+	assert.Error(t, validateMergeGroups(PerfEvents{MergeGroups: -1}))
+}
@@ -0,0 +1,71 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Estimation of multiplexing of perf events from their scaling ratios.
+package perf
+
+import (
+	"sort"
+
+	info "github.com/google/cadvisor/info/v1"
+)
+
+// multiplexing collects scaling ratios of groups measured on each CPU in
+// a single measurement.
+type multiplexing map[int][]float64
+
+// addGroup adds scaling ratio of the group on every CPU that it has been
+// read on. Events in a group are scheduled together, so the first event
+// that is not in error state represents the group.
+func (m multiplexing) addGroup(perfStats []info.PerfStat) {
+	seen := map[int]struct{}{}
+	for _, stat := range perfStats {
+		if stat.Errored {
+			continue
+		}
+		if _, ok := seen[stat.Cpu]; ok {
+			continue
+		}
+		seen[stat.Cpu] = struct{}{}
+		m[stat.Cpu] = append(m[stat.Cpu], stat.ScalingRatio)
+	}
+}
+
+// estimate returns estimated multiplexing on every CPU, sorted by CPU. When
+// kernel rotates groups that do not fit into counters at once, each group
+// is counted for roughly the same fraction of time, so inverse of average
+// scaling ratio approximates number of time slices that groups are
+// multiplexed in.
+func (m multiplexing) estimate() []info.PerfMultiplexing {
+	estimates := make([]info.PerfMultiplexing, 0, len(m))
+	for cpu, ratios := range m {
+		sum := 0.0
+		for _, ratio := range ratios {
+			sum += ratio
+		}
+		estimate := info.PerfMultiplexing{
+			Cpu:                 cpu,
+			Groups:              len(ratios),
+			AverageScalingRatio: sum / float64(len(ratios)),
+		}
+		if estimate.AverageScalingRatio > 0 {
+			estimate.MultiplexGroups = 1 / estimate.AverageScalingRatio
+		}
+		estimates = append(estimates, estimate)
+	}
+	sort.Slice(estimates, func(i, j int) bool {
+		return estimates[i].Cpu < estimates[j].Cpu
+	})
+	return estimates
+}
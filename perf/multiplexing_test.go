@@ -0,0 +1,50 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Estimation of multiplexing of perf events from their scaling ratios.
+package perf
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	info "github.com/google/cadvisor/info/v1"
+)
+
+func TestMultiplexingEstimate(t *testing.T) {
+	m := multiplexing{}
+	// Four groups time-sliced in two on CPU 0 and counted all the time on CPU 1.
+	m.addGroup([]info.PerfStat{perfStat("instructions", 0, 100, 0.5), perfStat("cycles", 0, 100, 0.5), perfStat("instructions", 1, 100, 1), perfStat("cycles", 1, 100, 1)})
+	m.addGroup([]info.PerfStat{perfStat("cache-misses", 0, 100, 0.5), perfStat("cache-misses", 1, 100, 1)})
+	m.addGroup([]info.PerfStat{perfStat("branches", 0, 100, 0.4), perfStat("branches", 1, 100, 1)})
+	m.addGroup([]info.PerfStat{perfStat("branch-misses", 0, 100, 0.6), perfStat("branch-misses", 1, 100, 1)})
+	// Group that has not been counted at all on CPU 2.
+	m.addGroup([]info.PerfStat{perfStat("stalls", 2, 100, 0)})
+	// Errored event does not represent its group.
+	errored := perfStat("pinned", 3, 100, 0)
+	errored.Errored = true
+	m.addGroup([]info.PerfStat{errored, perfStat("follower", 3, 100, 0.25)})
+
+	assert.Equal(t, []info.PerfMultiplexing{
+		{Cpu: 0, Groups: 4, AverageScalingRatio: 0.5, MultiplexGroups: 2},
+		{Cpu: 1, Groups: 4, AverageScalingRatio: 1, MultiplexGroups: 1},
+		{Cpu: 2, Groups: 1, AverageScalingRatio: 0, MultiplexGroups: 0},
+		{Cpu: 3, Groups: 1, AverageScalingRatio: 0.25, MultiplexGroups: 4},
+	}, m.estimate())
+}
+
+func TestMultiplexingEstimateEmpty(t *testing.T) {
+	assert.Empty(t, multiplexing{}.estimate())
+}
@@ -0,0 +1,83 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Normalization of perf events by CPU time consumed by a container.
+package perf
+
+import (
+	"time"
+
+	info "github.com/google/cadvisor/info/v1"
+)
+
+// cpuTimeNormalization collects values of events counted on all CPUs in a
+// single measurement and times the events were running.
+type cpuTimeNormalization map[string]*countedTime
+
+type countedTime struct {
+	counted float64
+	running uint64
+}
+
+// addGroup adds events of the group read on every CPU. Value that has
+// actually been counted is recovered from the scaled value, so events in
+// error state and events that have not been running are skipped.
+func (n cpuTimeNormalization) addGroup(perfStats []info.PerfStat) {
+	for _, stat := range perfStats {
+		if stat.Errored || stat.TimeRunning == 0 {
+			continue
+		}
+		event, ok := n[stat.Name]
+		if !ok {
+			event = &countedTime{}
+			n[stat.Name] = event
+		}
+		event.counted += float64(stat.Value) * stat.ScalingRatio
+		event.running += stat.TimeRunning
+	}
+}
+
+// perCPUSecond returns number of each event per second of CPU time. Core
+// events of a container are running only while its tasks are scheduled,
+// so the sum of running times approximates CPU time that the container
+// consumed while the events were counted, regardless of number of CPUs it
+// runs on. Rate of an aggregation is the sum of rates of its events, since
+// they may have been counted for different times.
+func (n cpuTimeNormalization) perCPUSecond(aggregations []Aggregation) map[string]float64 {
+	if len(n) == 0 {
+		return nil
+	}
+	rates := make(map[string]float64, len(n))
+	for name, event := range n {
+		rates[name] = event.counted / (float64(event.running) / float64(time.Second))
+	}
+	for _, aggregation := range aggregations {
+		sum, found := 0.0, false
+		for _, name := range aggregation.Events {
+			rate, ok := rates[string(name)]
+			if !ok {
+				continue
+			}
+			sum += rate
+			found = true
+			if !aggregation.KeepEvents {
+				delete(rates, string(name))
+			}
+		}
+		if found {
+			rates[string(aggregation.Name)] = sum
+		}
+	}
+	return rates
+}
@@ -0,0 +1,88 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Normalization of perf events by CPU time consumed by a container.
+package perf
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	info "github.com/google/cadvisor/info/v1"
+)
+
+func runningStat(name string, cpu int, value uint64, scalingRatio float64, timeRunning uint64) info.PerfStat {
+	stat := perfStat(name, cpu, value, scalingRatio)
+	stat.TimeRunning = timeRunning
+	return stat
+}
+
+func TestPerCPUSecond(t *testing.T) {
+	// Container running on 2 CPUs for 1.5 second of CPU time in total.
+	small := cpuTimeNormalization{}
+	small.addGroup([]info.PerfStat{
+		runningStat("instructions", 0, 1e9, 1.0, 1e9),
+		runningStat("instructions", 1, 1e9, 0.5, 5e8),
+	})
+	// Container running on 16 CPUs for 4 seconds of CPU time in total with
+	// the same rate of instructions.
+	large := cpuTimeNormalization{}
+	var stats []info.PerfStat
+	for cpu := 0; cpu < 16; cpu++ {
+		stats = append(stats, runningStat("instructions", cpu, 1e9, 0.25, 25e7))
+	}
+	large.addGroup(stats)
+
+	assert.Equal(t, map[string]float64{"instructions": 1e9}, small.perCPUSecond(nil))
+	assert.Equal(t, map[string]float64{"instructions": 1e9}, large.perCPUSecond(nil))
+}
+
+func TestPerCPUSecondNotRunning(t *testing.T) {
+	normalization := cpuTimeNormalization{}
+	errored := runningStat("cycles", 0, 0, 1.0, 1e9)
+	errored.Errored = true
+	normalization.addGroup([]info.PerfStat{
+		runningStat("instructions", 0, 0, 1.0, 0),
+		runningStat("instructions", 1, 2e9, 1.0, 1e9),
+		errored,
+		runningStat("branches", 0, 0, 1.0, 0),
+	})
+
+	// Events that have not been running on any CPU are not reported.
+	assert.Equal(t, map[string]float64{"instructions": 2e9}, normalization.perCPUSecond(nil))
+	assert.Nil(t, cpuTimeNormalization{}.perCPUSecond(nil))
+}
+
+func TestPerCPUSecondAggregations(t *testing.T) {
+	normalization := cpuTimeNormalization{}
+	normalization.addGroup([]info.PerfStat{
+		runningStat("llc_misses_demand", 0, 1e6, 1.0, 1e9),
+		runningStat("instructions", 0, 1e9, 1.0, 1e9),
+	})
+	normalization.addGroup([]info.PerfStat{
+		runningStat("llc_misses_prefetch", 0, 1e6, 0.5, 5e8),
+	})
+
+	aggregations := []Aggregation{{Name: "llc_misses", Events: []Event{"llc_misses_demand", "llc_misses_prefetch"}}}
+	assert.Equal(t, map[string]float64{"instructions": 1e9, "llc_misses": 2e6}, normalization.perCPUSecond(aggregations))
+
+	aggregations[0].KeepEvents = true
+	assert.Equal(t, map[string]float64{
+		"instructions":        1e9,
+		"llc_misses":          2e6,
+		"llc_misses_demand":   1e6,
+		"llc_misses_prefetch": 1e6,
+	}, normalization.perCPUSecond(aggregations))
+}
@@ -0,0 +1,112 @@
+// +build libpfm,cgo
+
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Reading of perf events on CPUs by concurrent goroutines.
+package perf
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	info "github.com/google/cadvisor/info/v1"
+)
+
+// partReadResult is result of reading group on a part of its CPUs.
+type partReadResult struct {
+	perfStats []info.PerfStat
+	truncated bool
+	ids       map[string]map[int]uint64
+}
+
+// validateReadWorkers checks if number of goroutines that read each group
+// is valid.
+func validateReadWorkers(events PerfEvents) error {
+	if events.ReadWorkers < 0 {
+		return fmt.Errorf("number of read workers has to be positive, got %d", events.ReadWorkers)
+	}
+	return nil
+}
+
+// readGroupConcurrently reads values of the group as readGroupFiles does,
+// but its CPUs are split among at most workers goroutines that read them
+// concurrently. Group is read by the calling goroutine if there is at most
+// one worker or CPU.
+func readGroupConcurrently(group group, deadline time.Time, cgroupPath string, workers int) ([]info.PerfStat, bool) {
+	cpus := make([]int, 0, len(group.cpuFiles[group.leaderName]))
+	for cpu := range group.cpuFiles[group.leaderName] {
+		cpus = append(cpus, cpu)
+	}
+	if workers > len(cpus) {
+		workers = len(cpus)
+	}
+	if workers <= 1 {
+		return readGroupFiles(group, deadline, cgroupPath)
+	}
+
+	sort.Ints(cpus)
+	parts := make([][]int, workers)
+	for i, cpu := range cpus {
+		parts[i%workers] = append(parts[i%workers], cpu)
+	}
+	return readGroupParts(group, parts, deadline, cgroupPath, func([]int) func() {
+		return func() {}
+	})
+}
+
+// readGroupParts reads each part of CPUs of the group by a separate
+// goroutine, which calls prepare before reading and the function returned
+// by it afterwards, and merges their values sorted by CPU.
+func readGroupParts(group group, parts [][]int, deadline time.Time, cgroupPath string, prepare func(cpus []int) func()) ([]info.PerfStat, bool) {
+	results := make(chan partReadResult, len(parts))
+	for _, cpus := range parts {
+		// Each goroutine reads its own part of the group, so ids of
+		// events are not tracked concurrently in the same map.
+		part := group
+		part.cpuFiles = filesOnCPUs(group.cpuFiles, cpus)
+		part.ids = idsOnCPUs(group.ids, cpus)
+		cpus := cpus
+		go func() {
+			finish := prepare(cpus)
+			perfStats, truncated := readGroupFiles(part, deadline, cgroupPath)
+			finish()
+			results <- partReadResult{perfStats: perfStats, truncated: truncated, ids: part.ids}
+		}()
+	}
+
+	perfStats := []info.PerfStat{}
+	truncated := false
+	for range parts {
+		result := <-results
+		perfStats = append(perfStats, result.perfStats...)
+		truncated = truncated || result.truncated
+		if group.ids == nil {
+			continue
+		}
+		for name, cpuIDs := range result.ids {
+			if _, ok := group.ids[name]; !ok {
+				group.ids[name] = map[int]uint64{}
+			}
+			for cpu, id := range cpuIDs {
+				group.ids[name][cpu] = id
+			}
+		}
+	}
+	sort.SliceStable(perfStats, func(i, j int) bool {
+		return perfStats[i].Cpu < perfStats[j].Cpu
+	})
+	return perfStats, truncated
+}
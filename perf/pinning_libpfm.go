@@ -0,0 +1,125 @@
+// +build libpfm,cgo
+
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Reading of perf events by threads pinned to sockets of the CPUs.
+package perf
+
+import (
+	"runtime"
+	"time"
+
+	"golang.org/x/sys/unix"
+	"k8s.io/klog/v2"
+
+	info "github.com/google/cadvisor/info/v1"
+)
+
+var (
+	// Handle for mocking purposes.
+	schedGetaffinity = unix.SchedGetaffinity
+	schedSetaffinity = unix.SchedSetaffinity
+)
+
+// readGroupPinned reads values of the group on every CPU as readGroupFiles
+// does, but CPUs of each socket are read by a separate goroutine locked to
+// an OS thread that is pinned to CPUs of the socket, so counters are not
+// read from a remote socket. Group that is opened on CPUs of a single socket
+// is read by the calling goroutine without pinning.
+func readGroupPinned(group group, deadline time.Time, cgroupPath string, cpuToSocket map[int]int) ([]info.PerfStat, bool) {
+	sockets := map[int][]int{}
+	for cpu := range group.cpuFiles[group.leaderName] {
+		socket := cpuToSocket[cpu]
+		sockets[socket] = append(sockets[socket], cpu)
+	}
+	if len(sockets) <= 1 {
+		return readGroupFiles(group, deadline, cgroupPath)
+	}
+
+	parts := make([][]int, 0, len(sockets))
+	for _, cpus := range sockets {
+		parts = append(parts, cpus)
+	}
+	return readGroupParts(group, parts, deadline, cgroupPath, func(cpus []int) func() {
+		unpin, err := pinThread(cpus)
+		if err != nil {
+			klog.V(4).Infof("Unable to pin reading of perf event group %q of cgroup %q to CPUs of socket %d: %v", group.leaderName, cgroupPath, cpuToSocket[cpus[0]], err)
+		}
+		return unpin
+	})
+}
+
+// pinThread locks the calling goroutine to its OS thread and restricts the
+// thread to the CPUs. Returned function restores previous affinity of the
+// thread and unlocks it. If the affinity cannot be restored, the thread
+// stays locked, so it is terminated when the goroutine exits instead of
+// running other goroutines on the CPUs.
+func pinThread(cpus []int) (func(), error) {
+	runtime.LockOSThread()
+	var previous unix.CPUSet
+	err := schedGetaffinity(0, &previous)
+	if err != nil {
+		runtime.UnlockOSThread()
+		return func() {}, err
+	}
+	var pinned unix.CPUSet
+	for _, cpu := range cpus {
+		pinned.Set(cpu)
+	}
+	err = schedSetaffinity(0, &pinned)
+	if err != nil {
+		runtime.UnlockOSThread()
+		return func() {}, err
+	}
+	return func() {
+		err := schedSetaffinity(0, &previous)
+		if err != nil {
+			klog.Warningf("Unable to restore CPU affinity of thread that read perf events: %v", err)
+			return
+		}
+		runtime.UnlockOSThread()
+	}, nil
+}
+
+// filesOnCPUs returns files of events of a group opened on the CPUs.
+func filesOnCPUs(cpuFiles map[string]map[int]readerCloser, cpus []int) map[string]map[int]readerCloser {
+	files := make(map[string]map[int]readerCloser, len(cpuFiles))
+	for name, eventFiles := range cpuFiles {
+		files[name] = map[int]readerCloser{}
+		for _, cpu := range cpus {
+			if file, ok := eventFiles[cpu]; ok {
+				files[name][cpu] = file
+			}
+		}
+	}
+	return files
+}
+
+// idsOnCPUs returns copy of ids of events of a group on the CPUs.
+func idsOnCPUs(ids map[string]map[int]uint64, cpus []int) map[string]map[int]uint64 {
+	if ids == nil {
+		return nil
+	}
+	copied := make(map[string]map[int]uint64, len(ids))
+	for name, cpuIDs := range ids {
+		copied[name] = map[int]uint64{}
+		for _, cpu := range cpus {
+			if id, ok := cpuIDs[cpu]; ok {
+				copied[name][cpu] = id
+			}
+		}
+	}
+	return copied
+}
@@ -0,0 +1,52 @@
+// +build libpfm,cgo
+
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Collectors of core perf events of processes.
+package perf
+
+import (
+	"fmt"
+
+	"github.com/google/cadvisor/stats"
+)
+
+// processPath returns name that collector of the process is known by in
+// place of cgroup path.
+func processPath(pid int) string {
+	return fmt.Sprintf("pid:%d", pid)
+}
+
+// newProcessCollector returns collector that opens core perf events on the
+// process with the pid, regardless of cgroup it belongs to, instead of
+// processes of a cgroup. Uncore events are not measured for a process.
+func newProcessCollector(pid int, events PerfEvents, onlineCPUs []int, cpuToSocket map[int]int, cpuToCore map[int]physicalCore) *collector {
+	collector := newCollector(processPath(pid), events, onlineCPUs, cpuToSocket, cpuToCore)
+	collector.pid = pid
+	return collector
+}
+
+// GetProcessCollector returns collector of core perf events of the process
+// with the pid, and the processes it creates, instead of a cgroup. It is
+// kept track of as collectors of cgroups are, with pid:<pid> in place of
+// cgroup path.
+func (m *manager) GetProcessCollector(pid int) (stats.Collector, error) {
+	if pid <= 0 {
+		return &stats.NoopCollector{}, fmt.Errorf("invalid pid %d", pid)
+	}
+	return m.getCollector(processPath(pid), func(events PerfEvents) *collector {
+		return newProcessCollector(pid, events, m.onlineCPUs, m.cpuToSocket, m.cpuToCore)
+	})
+}
@@ -0,0 +1,106 @@
+// +build libpfm,cgo
+
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Collectors of core perf events of processes.
+package perf
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/sys/unix"
+
+	info "github.com/google/cadvisor/info/v1"
+	"github.com/google/cadvisor/stats"
+)
+
+func TestCollector_SetupProcess(t *testing.T) {
+	type opening struct {
+		pid, cpu, flags int
+	}
+	opened := []opening{}
+	collector := newProcessCollector(1234, PerfEvents{
+		Core: Events{
+			Events:       []Group{{events: []Event{"instructions", "cycles"}}},
+			CustomEvents: []CustomEvent{{Type: unix.PERF_TYPE_HARDWARE, Config: Config{unix.PERF_COUNT_HW_INSTRUCTIONS}, Name: "instructions"}},
+		},
+		ContainerCPUs:     true,
+		FollowCgroupMoves: true,
+	}, []int{0, 1}, map[int]int{}, map[int]physicalCore{})
+	collector.perfEventOpen = func(attr *unix.PerfEventAttr, pid int, cpu int, groupFd int, flags int) (int, error) {
+		opened = append(opened, opening{pid, cpu, flags})
+		return unix.Open(os.DevNull, unix.O_RDONLY, 0)
+	}
+	collector.ioctlSetInt = func(fd int, req uint, value int) error {
+		return nil
+	}
+	// Process has neither cgroup directory nor cpuset of its own.
+	collector.resolveCgroupPath = func(cgroupPath string) (string, error) {
+		t.Errorf("cgroup directory of %q is resolved", cgroupPath)
+		return "", errors.New("not a cgroup")
+	}
+	collector.readCpuset = func(cgroupPath string) ([]int, error) {
+		t.Errorf("cpuset of %q is read", cgroupPath)
+		return nil, errors.New("not a cgroup")
+	}
+	defer collector.Destroy()
+
+	err := collector.setup()
+	assert.NoError(t, err)
+	assert.Equal(t, "pid:1234", collector.cgroupPath)
+	assert.IsType(t, &stats.NoopCollector{}, collector.uncore)
+	// Leaders and followers are opened on the process on all online CPUs,
+	// without cgroup flag.
+	assert.Equal(t, []opening{
+		{1234, 0, unix.PERF_FLAG_FD_CLOEXEC},
+		{1234, 1, unix.PERF_FLAG_FD_CLOEXEC},
+		{1234, 0, unix.PERF_FLAG_FD_CLOEXEC},
+		{1234, 1, unix.PERF_FLAG_FD_CLOEXEC},
+	}, opened)
+	assert.Len(t, collector.cpuFiles[0].cpuFiles["instructions"], 2)
+	assert.Len(t, collector.cpuFiles[0].cpuFiles["cycles"], 2)
+
+	err = collector.UpdateStats(&info.ContainerStats{})
+	assert.NoError(t, err)
+	assert.Len(t, opened, 4)
+}
+
+func TestManagerGetProcessCollector(t *testing.T) {
+	initErr := errors.New("pfm_initialize failed with -4: not supported")
+	defer mockUninitializedLibpfm(initErr)()
+
+	m := &manager{
+		events: PerfEvents{
+			Core:               Events{Events: []Group{{events: []Event{"instructions"}}}},
+			SetupFailureBudget: 1,
+		},
+		onlineCPUs:  []int{0},
+		cpuToSocket: map[int]int{0: 0},
+		collectors:  map[string]managedCollector{},
+		failures:    map[string]*setupFailures{},
+	}
+
+	collector, err := m.GetProcessCollector(0)
+	assert.IsType(t, &stats.NoopCollector{}, collector)
+	assert.EqualError(t, err, "invalid pid 0")
+
+	// Process is kept track of by its pid.
+	_, err = m.GetProcessCollector(1234)
+	assert.True(t, errors.Is(err, initErr))
+	assert.Contains(t, m.Unavailable(), "pid:1234")
+}
@@ -0,0 +1,210 @@
+// +build libpfm,cgo
+
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Applying reloaded configuration of perf events to collectors.
+package perf
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"golang.org/x/sys/unix"
+	"k8s.io/klog/v2"
+)
+
+// reconfigure applies new configuration of perf events to the collector.
+// Groups of core events that are configured the same way in both
+// configurations stay open and their values continue. Other groups are
+// closed or opened. All the groups are reopened if options that affect
+// opening of events have changed or groups are rotated.
+func (c *collector) reconfigure(events PerfEvents) error {
+	c.cpuFilesLock.Lock()
+	defer c.cpuFilesLock.Unlock()
+
+	previous := c.events
+	c.events = events
+	mapEventsToCustomEvents(c)
+
+	if !reflect.DeepEqual(previous.HistogramBuckets, events.HistogramBuckets) {
+		c.histogram = nil
+		if len(events.HistogramBuckets) > 0 {
+			c.histogram = newHistogram(events.HistogramBuckets)
+		}
+	}
+	if !reflect.DeepEqual(previous.Thresholds, events.Thresholds) {
+		c.thresholds = nil
+		if len(events.Thresholds) > 0 {
+			c.thresholds = newThresholdTracker(events.Thresholds)
+		}
+	}
+	if previous.SMTContention != events.SMTContention || previous.PerCore != events.PerCore {
+		c.smt = nil
+		if events.SMTContention && !events.PerCore {
+			c.smt = newSMTTracker(c.cpuToCore)
+		}
+	}
+	if !reflect.DeepEqual(previous.Uncore, events.Uncore) || previous.UncorePerSocket != events.UncorePerSocket || previous.PerfStatScaling != events.PerfStatScaling {
+		c.uncore.Destroy()
+		c.uncore = NewUncoreCollector(c.cgroupPath, events, c.cpuToSocket)
+	}
+	if previous.HostCgroupPath != events.HostCgroupPath {
+		c.resolveCgroupPath = newCgroupPathResolver(events.HostCgroupPath)
+	}
+
+	// Events of cgroup that manager has given up on stay closed.
+	if c.unavailable {
+		return nil
+	}
+	if !sameOpening(previous, events) || events.Rotation {
+		klog.V(4).Infof("Reopening all perf events of cgroup %q with reloaded configuration", c.cgroupPath)
+		return c.reopenEvents()
+	}
+	return c.reopenChangedGroups(previous.Core.Events)
+}
+
+// sameOpening checks if events of the same group are opened the same way
+// with both configurations.
+func sameOpening(previous, events PerfEvents) bool {
+	return reflect.DeepEqual(previous.Core.CustomEvents, events.Core.CustomEvents) &&
+		reflect.DeepEqual(previous.Core.SoftwareEvents, events.Core.SoftwareEvents) &&
+		reflect.DeepEqual(previous.Exclusions, events.Exclusions) &&
+		previous.Inheritance == events.Inheritance &&
+		previous.PartialGroups == events.PartialGroups &&
+		previous.WeakGroups == events.WeakGroups &&
+		previous.IgnoreUnsupportedEvents == events.IgnoreUnsupportedEvents &&
+		previous.ContainerCPUs == events.ContainerCPUs &&
+		previous.HostCgroupPath == events.HostCgroupPath &&
+		previous.PerfStatScaling == events.PerfStatScaling &&
+		previous.Rotation == events.Rotation
+}
+
+// reopenChangedGroups keeps groups that are configured the same way as one
+// of previous groups open, closes the other previous groups and opens the
+// new ones. State of kept groups is moved to their new indexes. New group
+// that fails to be set up is closed and the others are opened anyway, the
+// failures are returned together.
+func (c *collector) reopenChangedGroups(previousGroups []Group) error {
+	// New index of each kept group by its previous index.
+	indexes := map[int]int{}
+	kept := map[int]struct{}{}
+	for i, group := range c.events.Core.Events {
+		for j, previousGroup := range previousGroups {
+			if _, ok := indexes[j]; ok {
+				continue
+			}
+			if reflect.DeepEqual(group, previousGroup) {
+				indexes[j] = i
+				kept[i] = struct{}{}
+				break
+			}
+		}
+	}
+
+	previousFiles := c.cpuFiles
+	c.cpuFiles = map[int]group{}
+	c.generation++
+	c.readBuffers = newReadBuffers(c.events.Core.Events)
+	for j, group := range previousFiles {
+		i, ok := indexes[j]
+		if !ok {
+			c.closeGroup(group)
+			continue
+		}
+		group.buffers = c.readBuffers
+		c.cpuFiles[i] = group
+	}
+	c.differ.remapGroups(indexes)
+	if c.histogram != nil {
+		c.histogram.remapGroups(indexes)
+	}
+	readTimeouts := map[int]uint64{}
+	for j, timeouts := range c.readTimeouts {
+		if i, ok := indexes[j]; ok {
+			readTimeouts[i] = timeouts
+		}
+	}
+	c.readTimeouts = readTimeouts
+	droppedCPUs := map[int][]int{}
+	for j, cpus := range c.droppedCPUs {
+		if i, ok := indexes[j]; ok {
+			droppedCPUs[i] = cpus
+		}
+	}
+	c.droppedCPUs = droppedCPUs
+	skippedEvents := map[int][]string{}
+	for j, events := range c.skippedEvents {
+		if i, ok := indexes[j]; ok {
+			skippedEvents[i] = events
+		}
+	}
+	c.skippedEvents = skippedEvents
+	// Abandoned reads of closed groups are not needed.
+	pendingReads := map[int]<-chan groupReadResult{}
+	for j, results := range c.pendingReads {
+		if i, ok := indexes[j]; ok {
+			pendingReads[i] = results
+		}
+	}
+	c.pendingReads = pendingReads
+
+	opened := []int{}
+	for i := range c.events.Core.Events {
+		if _, ok := c.cpuFiles[i]; !ok {
+			opened = append(opened, i)
+		}
+	}
+	if len(opened) == 0 {
+		return nil
+	}
+	target, cgroup, err := c.openTarget()
+	if err != nil {
+		return err
+	}
+	if cgroup != nil {
+		defer cgroup.Close()
+	}
+	startTime := now()
+	failed := []string{}
+	for _, i := range opened {
+		err = c.openGroup(i, c.events.Core.Events[i], target)
+		group, ok := c.cpuFiles[i]
+		if err == nil && ok && c.paused {
+			err = c.ioctlLeader(group, unix.PERF_EVENT_IOC_DISABLE)
+		}
+		if err != nil {
+			// Events of the group that have been opened before the
+			// failure are closed, so that their file descriptors do not
+			// leak.
+			c.closeGroup(group)
+			delete(c.cpuFiles, i)
+			delete(c.droppedCPUs, i)
+			delete(c.skippedEvents, i)
+			failed = append(failed, fmt.Sprintf("group %d: %v", i, err))
+			continue
+		}
+		if !ok {
+			continue
+		}
+		group.startTime = startTime
+		c.cpuFiles[i] = group
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("unable to open %d perf event groups of cgroup %q with reloaded configuration: %s", len(failed), c.cgroupPath, strings.Join(failed, ", "))
+	}
+	klog.V(4).Infof("Perf event groups %v of cgroup %q have been opened with reloaded configuration, %d groups have been kept open", opened, c.cgroupPath, len(kept))
+	return nil
+}
@@ -0,0 +1,172 @@
+// +build libpfm,cgo
+
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Applying reloaded configuration of perf events tests.
+package perf
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/sys/unix"
+)
+
+func reloadTestEvents(t *testing.T, groups string) PerfEvents {
+	events := Events{}
+	err := json.Unmarshal([]byte(`{
+		"events": `+groups+`,
+		"custom_events": [
+			{"type": 0, "config": ["0x1"], "name": "instructions"},
+			{"type": 0, "config": ["0x0"], "name": "cycles"},
+			{"type": 0, "config": ["0x3"], "name": "cache-misses"},
+			{"type": 0, "config": ["0x5"], "name": "branch-misses"}
+		]
+	}`), &events)
+	assert.NoError(t, err)
+	return PerfEvents{Core: events}
+}
+
+func TestCollector_ReconfigureKeepsUnchangedGroups(t *testing.T) {
+	cgroupPath, err := ioutil.TempDir("", "perf_event")
+	assert.NoError(t, err)
+	defer os.RemoveAll(cgroupPath)
+
+	collector := newCollector(cgroupPath, reloadTestEvents(t, `[["instructions", "cycles"], ["cache-misses"]]`), []int{0, 1}, map[int]int{}, map[int]physicalCore{})
+	collector.perfEventOpen = func(attr *unix.PerfEventAttr, pid int, cpu int, groupFd int, flags int) (int, error) {
+		return unix.Open(os.DevNull, unix.O_RDONLY, 0)
+	}
+	collector.ioctlSetInt = func(fd int, req uint, value int) error {
+		return nil
+	}
+	assert.NoError(t, collector.setup())
+	defer collector.Destroy()
+	assert.Equal(t, uint64(6), collector.OpenCalls())
+
+	instructions := collector.cpuFiles[0].cpuFiles["instructions"][0]
+	cacheMisses := collector.cpuFiles[1].cpuFiles["cache-misses"][0].(*os.File)
+	collector.differ.delta(0, rawStat("instructions", 100), false)
+	collector.differ.delta(1, rawStat("cache-misses", 10), false)
+
+	// The first group is kept and moved, the second one is replaced.
+	err = collector.reconfigure(reloadTestEvents(t, `[["branch-misses"], ["instructions", "cycles"]]`))
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(8), collector.OpenCalls())
+	assert.False(t, collector.discontinuous)
+	assert.Len(t, collector.cpuFiles, 2)
+	assert.True(t, instructions == collector.cpuFiles[1].cpuFiles["instructions"][0])
+	assert.Len(t, collector.cpuFiles[0].cpuFiles["branch-misses"], 2)
+	assert.False(t, collector.cpuFiles[0].startTime.IsZero())
+	assert.True(t, collector.cpuFiles[1].startTime.IsZero())
+	// Closed file has no descriptor.
+	assert.Equal(t, ^uintptr(0), cacheMisses.Fd())
+
+	// Increases of the kept group continue.
+	assert.Equal(t, uint64(50), collector.differ.delta(1, rawStat("instructions", 150), false))
+	assert.Equal(t, uint64(10), collector.differ.delta(0, rawStat("branch-misses", 10), false))
+
+	// Change of how events are opened reopens all the groups.
+	events := reloadTestEvents(t, `[["branch-misses"], ["instructions", "cycles"]]`)
+	events.PartialGroups = true
+	err = collector.reconfigure(events)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(14), collector.OpenCalls())
+	assert.True(t, collector.discontinuous)
+	assert.False(t, instructions == collector.cpuFiles[1].cpuFiles["instructions"][0])
+}
+
+// openFileDescriptors returns number of file descriptors open by the process.
+func openFileDescriptors(t *testing.T) int {
+	fds, err := ioutil.ReadDir("/proc/self/fd")
+	assert.NoError(t, err)
+	return len(fds)
+}
+
+func TestCollector_ReconfigureOpenFailure(t *testing.T) {
+	cgroupPath, err := ioutil.TempDir("", "perf_event")
+	assert.NoError(t, err)
+	defer os.RemoveAll(cgroupPath)
+	fds := openFileDescriptors(t)
+
+	opens := 0
+	failingOpen := 0
+	collector := newCollector(cgroupPath, reloadTestEvents(t, `[["instructions"]]`), []int{0, 1}, map[int]int{}, map[int]physicalCore{})
+	collector.perfEventOpen = func(attr *unix.PerfEventAttr, pid int, cpu int, groupFd int, flags int) (int, error) {
+		opens++
+		if opens == failingOpen {
+			return 0, unix.EMFILE
+		}
+		return unix.Open(os.DevNull, unix.O_RDONLY, 0)
+	}
+	collector.ioctlSetInt = func(fd int, req uint, value int) error {
+		return nil
+	}
+	assert.NoError(t, collector.setup())
+	assert.Equal(t, fds+2, openFileDescriptors(t))
+
+	// Cycles fail to open on the second CPU, after the group has been
+	// opened on the first one.
+	failingOpen = opens + 4
+	err = collector.reconfigure(reloadTestEvents(t, `[["instructions"], ["cache-misses", "cycles"], ["branch-misses"]]`))
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "group 1")
+
+	// The failing group is closed and the other groups are opened.
+	assert.Len(t, collector.cpuFiles, 2)
+	assert.Len(t, collector.cpuFiles[0].cpuFiles["instructions"], 2)
+	assert.NotContains(t, collector.cpuFiles, 1)
+	assert.Len(t, collector.cpuFiles[2].cpuFiles["branch-misses"], 2)
+	assert.Equal(t, fds+4, openFileDescriptors(t))
+
+	collector.Destroy()
+	assert.Equal(t, fds, openFileDescriptors(t))
+}
+
+func TestCollector_ReconfigureOpenFailureWhilePaused(t *testing.T) {
+	cgroupPath, err := ioutil.TempDir("", "perf_event")
+	assert.NoError(t, err)
+	defer os.RemoveAll(cgroupPath)
+
+	opens := 0
+	collector := newCollector(cgroupPath, reloadTestEvents(t, `[["instructions"]]`), []int{0}, map[int]int{}, map[int]physicalCore{})
+	collector.perfEventOpen = func(attr *unix.PerfEventAttr, pid int, cpu int, groupFd int, flags int) (int, error) {
+		opens++
+		if opens == 2 {
+			return 0, unix.EMFILE
+		}
+		return unix.Open(os.DevNull, unix.O_RDONLY, 0)
+	}
+	disabled := []uintptr{}
+	collector.ioctlSetInt = func(fd int, req uint, value int) error {
+		if req == unix.PERF_EVENT_IOC_DISABLE {
+			disabled = append(disabled, uintptr(fd))
+		}
+		return nil
+	}
+	assert.NoError(t, collector.setup())
+	defer collector.Destroy()
+	assert.NoError(t, collector.pause())
+	disabled = disabled[:0]
+
+	// Group that has failed to open is not disabled, the other one is.
+	err = collector.reconfigure(reloadTestEvents(t, `[["instructions"], ["cycles"], ["cache-misses"]]`))
+	assert.Error(t, err)
+	assert.NotContains(t, collector.cpuFiles, 1)
+	cacheMisses := collector.cpuFiles[2].cpuFiles["cache-misses"][0].(*os.File)
+	assert.Equal(t, []uintptr{cacheMisses.Fd()}, disabled)
+}
@@ -0,0 +1,31 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Sink of perf events for library users.
+package perf
+
+import (
+	"time"
+
+	info "github.com/google/cadvisor/info/v1"
+)
+
+// Sink receives core perf events after each successful measurement of a
+// container. It is called synchronously by the collector so slow sink
+// slows down collection. Sink that pushes data to remote backend should
+// do it in its own goroutine. Sink must not modify perfStats. Timestamp
+// is the time when perfStats were read.
+type Sink interface {
+	Write(cgroupPath string, perfStats []info.PerfStat, timestamp time.Time) error
+}
@@ -0,0 +1,154 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Activity of SMT siblings of CPUs that perf events are measured on.
+package perf
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	info "github.com/google/cadvisor/info/v1"
+)
+
+// Handle for mocking purposes.
+var procStatPath = "/proc/stat"
+
+// contendedSiblingBusy is the fraction of the interval that SMT siblings of
+// a CPU have to be busy for the measurement on the CPU to be considered
+// contended.
+const contendedSiblingBusy = 0.5
+
+// cpuTimes is time that a CPU has spent busy and in total, in clock ticks.
+type cpuTimes struct {
+	busy  uint64
+	total uint64
+}
+
+// readCPUTimes reads busy and total time of each CPU from /proc/stat.
+// Time spent idle and waiting for I/O is not busy.
+func readCPUTimes() (map[int]cpuTimes, error) {
+	content, err := ioutil.ReadFile(procStatPath)
+	if err != nil {
+		return nil, err
+	}
+	return parseCPUTimes(string(content))
+}
+
+func parseCPUTimes(content string) (map[int]cpuTimes, error) {
+	times := map[int]cpuTimes{}
+	for _, line := range strings.Split(content, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 5 || !strings.HasPrefix(fields[0], "cpu") || fields[0] == "cpu" {
+			continue
+		}
+		cpu, err := strconv.Atoi(strings.TrimPrefix(fields[0], "cpu"))
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse CPU of %q: %w", line, err)
+		}
+		// Guest time is already included in user time.
+		if len(fields) > 9 {
+			fields = fields[:9]
+		}
+		cpuTime := cpuTimes{}
+		for i, field := range fields[1:] {
+			value, err := strconv.ParseUint(field, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("unable to parse times of CPU %d: %w", cpu, err)
+			}
+			cpuTime.total += value
+			// Fourth and fifth fields are idle and iowait.
+			if i != 3 && i != 4 {
+				cpuTime.busy += value
+			}
+		}
+		times[cpu] = cpuTime
+	}
+	return times, nil
+}
+
+// getSMTSiblings maps logical CPUs to other logical CPUs of the same
+// physical core. CPUs without siblings are omitted.
+func getSMTSiblings(cpuToCore map[int]physicalCore) map[int][]int {
+	cores := map[int][]int{}
+	for cpu, core := range cpuToCore {
+		cores[core.firstCPU] = append(cores[core.firstCPU], cpu)
+	}
+	siblings := map[int][]int{}
+	for cpu, core := range cpuToCore {
+		for _, sibling := range cores[core.firstCPU] {
+			if sibling != cpu {
+				siblings[cpu] = append(siblings[cpu], sibling)
+			}
+		}
+	}
+	return siblings
+}
+
+// smtTracker measures how busy SMT siblings of each CPU were between
+// consecutive measurements.
+type smtTracker struct {
+	siblings map[int][]int
+	previous map[int]cpuTimes
+}
+
+func newSMTTracker(cpuToCore map[int]physicalCore) *smtTracker {
+	return &smtTracker{siblings: getSMTSiblings(cpuToCore)}
+}
+
+// siblingBusy returns fraction of time since the previous call that SMT
+// siblings of each CPU were busy, given current times of the CPUs. Nothing
+// is returned on the first call.
+func (t *smtTracker) siblingBusy(current map[int]cpuTimes) map[int]float64 {
+	previous := t.previous
+	t.previous = current
+	busy := map[int]float64{}
+	if previous == nil {
+		return busy
+	}
+	for cpu, siblings := range t.siblings {
+		var busyTime, totalTime uint64
+		for _, sibling := range siblings {
+			before, ok := previous[sibling]
+			after, ok2 := current[sibling]
+			// Counters restart when CPU is brought online again.
+			if !ok || !ok2 || after.total < before.total || after.busy < before.busy {
+				continue
+			}
+			busyTime += after.busy - before.busy
+			totalTime += after.total - before.total
+		}
+		if totalTime > 0 {
+			busy[cpu] = float64(busyTime) / float64(totalTime)
+		}
+	}
+	return busy
+}
+
+// addSiblingBusy sets how busy SMT siblings of the CPU were during the
+// measurement of each stat and flags stats measured while siblings were
+// busy at least contendedSiblingBusy of the time. Stats measured on CPUs
+// without siblings, or not on a single CPU, are left as they are.
+func addSiblingBusy(perfStats []info.PerfStat, busy map[int]float64) {
+	for i := range perfStats {
+		fraction, ok := busy[perfStats[i].Cpu]
+		if !ok {
+			continue
+		}
+		perfStats[i].SiblingBusy = fraction
+		perfStats[i].SMTContended = fraction >= contendedSiblingBusy
+	}
+}
@@ -0,0 +1,89 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Activity of SMT siblings tests.
+package perf
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	info "github.com/google/cadvisor/info/v1"
+)
+
+func TestParseCPUTimes(t *testing.T) {
+	content := `cpu  40 0 20 140 0 0 0 0 0 0
+cpu0 10 0 5 80 5 0 0 0 0 0
+cpu1 30 0 15 50 0 5 0 0 7 0
+intr 12345
+`
+	times, err := parseCPUTimes(content)
+	assert.NoError(t, err)
+	assert.Equal(t, map[int]cpuTimes{
+		0: {busy: 15, total: 100},
+		1: {busy: 50, total: 100},
+	}, times)
+
+	_, err = parseCPUTimes("cpu0 10 x 5 80 5\n")
+	assert.Error(t, err)
+}
+
+func TestGetSMTSiblings(t *testing.T) {
+	// Two cores with two threads each and a core without SMT.
+	cpuToCore := map[int]physicalCore{
+		0: {id: 0, firstCPU: 0},
+		2: {id: 0, firstCPU: 0},
+		1: {id: 1, firstCPU: 1},
+		3: {id: 1, firstCPU: 1},
+		4: {id: 2, firstCPU: 4},
+	}
+	siblings := getSMTSiblings(cpuToCore)
+	assert.Equal(t, map[int][]int{0: {2}, 2: {0}, 1: {3}, 3: {1}}, siblings)
+}
+
+func TestSiblingBusy(t *testing.T) {
+	tracker := newSMTTracker(map[int]physicalCore{
+		0: {id: 0, firstCPU: 0},
+		1: {id: 0, firstCPU: 0},
+		2: {id: 1, firstCPU: 2},
+		3: {id: 1, firstCPU: 2},
+	})
+
+	// Nothing is reported before the interval is known.
+	assert.Empty(t, tracker.siblingBusy(map[int]cpuTimes{0: {}, 1: {}, 2: {}, 3: {}}))
+
+	busy := tracker.siblingBusy(map[int]cpuTimes{
+		0: {busy: 100, total: 100},
+		1: {busy: 90, total: 100},
+		2: {busy: 50, total: 100},
+		3: {busy: 10, total: 100},
+	})
+	assert.Equal(t, map[int]float64{0: 0.9, 1: 1, 2: 0.1, 3: 0.5}, busy)
+
+	perfStats := []info.PerfStat{
+		{PerfValue: info.PerfValue{Name: "instructions", Value: 100}, Cpu: 0},
+		{PerfValue: info.PerfValue{Name: "instructions", Value: 100}, Cpu: 2},
+		// Totals are not measured on a single CPU.
+		{PerfValue: info.PerfValue{Name: "instructions", Value: 200}, Cpu: info.PerfStatAllCPUs},
+	}
+	addSiblingBusy(perfStats, busy)
+	// Sibling of CPU 0 was busy for most of the interval.
+	assert.Equal(t, 0.9, perfStats[0].SiblingBusy)
+	assert.True(t, perfStats[0].SMTContended)
+	assert.Equal(t, 0.1, perfStats[1].SiblingBusy)
+	assert.False(t, perfStats[1].SMTContended)
+	assert.Zero(t, perfStats[2].SiblingBusy)
+	assert.False(t, perfStats[2].SMTContended)
+}
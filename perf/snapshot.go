@@ -0,0 +1,35 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Snapshot of perf events of all the containers.
+package perf
+
+import (
+	"time"
+
+	info "github.com/google/cadvisor/info/v1"
+)
+
+// Snapshot holds core perf events of all the containers read at about the
+// same time. Consistency is best-effort: containers are read concurrently
+// but not at the same instant, and groups of a container are read one
+// after another. With rotation only the group that is being measured is
+// read, so events of the other groups are missing.
+type Snapshot struct {
+	// Time when reading of the containers started.
+	Timestamp time.Time
+
+	// Cumulative values of core perf events by cgroup path of container.
+	PerfStats map[string][]info.PerfStat
+}
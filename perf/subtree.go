@@ -0,0 +1,114 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Measuring perf events of whole cgroup subtrees.
+package perf
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/google/cadvisor/machine"
+)
+
+const (
+	cgroupTypeFile = "cgroup.type"
+	threadedCgroup = "threaded"
+)
+
+// Perf events opened with PERF_FLAG_PID_CGROUP count tasks of descendant
+// cgroups of the cgroup only since Linux 3.16, older kernels count tasks
+// that belong directly to the cgroup.
+const (
+	subtreeScopingMajor = 3
+	subtreeScopingMinor = 16
+)
+
+// Handle for mocking purposes.
+var kernelRelease = machine.KernelVersion
+
+// checkSubtreeAggregate makes sure that the kernel scopes cgroup perf events
+// to the whole cgroup subtree if subtree aggregation is requested.
+func checkSubtreeAggregate(subtreeAggregate bool) error {
+	if !subtreeAggregate {
+		return nil
+	}
+	release := kernelRelease()
+	supported, err := scopesCgroupSubtree(release)
+	if err != nil {
+		return err
+	}
+	if !supported {
+		return fmt.Errorf("kernel %s does not count tasks of descendant cgroups in cgroup perf events, Linux %d.%d+ is required for subtree aggregation", release, subtreeScopingMajor, subtreeScopingMinor)
+	}
+	return nil
+}
+
+// scopesCgroupSubtree checks if kernel of given release counts tasks of
+// descendant cgroups in cgroup perf events.
+func scopesCgroupSubtree(release string) (bool, error) {
+	fields := strings.SplitN(release, ".", 3)
+	if len(fields) < 2 {
+		return false, fmt.Errorf("unable to parse kernel release %q", release)
+	}
+	major, err := strconv.Atoi(fields[0])
+	if err != nil {
+		return false, fmt.Errorf("unable to parse kernel release %q: %w", release, err)
+	}
+	// Minor version may be followed by suffix, e.g. 3.16-rc1.
+	minor, err := strconv.Atoi(strings.SplitN(fields[1], "-", 2)[0])
+	if err != nil {
+		return false, fmt.Errorf("unable to parse kernel release %q: %w", release, err)
+	}
+	if major != subtreeScopingMajor {
+		return major > subtreeScopingMajor, nil
+	}
+	return minor >= subtreeScopingMinor, nil
+}
+
+// threadedDescendants returns paths, relative to the cgroup, of descendant
+// cgroups that are in threaded mode of cgroup v2, i.e. that hold threads of
+// processes of the threaded subtree. Cgroups without cgroup.type file, as on
+// cgroup v1, are never threaded.
+func threadedDescendants(cgroupPath string) ([]string, error) {
+	threaded := []string{}
+	err := filepath.Walk(cgroupPath, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() || path == cgroupPath {
+			return nil
+		}
+		cgroupType, err := ioutil.ReadFile(filepath.Join(path, cgroupTypeFile))
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if strings.TrimSpace(string(cgroupType)) == threadedCgroup {
+			relative, err := filepath.Rel(cgroupPath, path)
+			if err != nil {
+				return err
+			}
+			threaded = append(threaded, relative)
+		}
+		return nil
+	})
+	return threaded, err
+}
@@ -0,0 +1,98 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Measuring perf events of whole cgroup subtrees.
+package perf
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScopesCgroupSubtree(t *testing.T) {
+	testCases := []struct {
+		release  string
+		expected bool
+	}{
+		{"2.6.39", false},
+		{"3.15.10", false},
+		{"3.16", true},
+		{"3.16-rc1", true},
+		{"4.19.0-12-amd64", true},
+		{"5.8.0", true},
+	}
+	for _, tc := range testCases {
+		supported, err := scopesCgroupSubtree(tc.release)
+		assert.NoError(t, err, tc.release)
+		assert.Equal(t, tc.expected, supported, tc.release)
+	}
+
+	_, err := scopesCgroupSubtree("Unknown")
+	assert.Error(t, err)
+}
+
+func TestCheckSubtreeAggregate(t *testing.T) {
+	originalKernelRelease := kernelRelease
+	defer func() {
+		kernelRelease = originalKernelRelease
+	}()
+
+	kernelRelease = func() string {
+		return "3.10.0-1160.el7.x86_64"
+	}
+	assert.NoError(t, checkSubtreeAggregate(false))
+	err := checkSubtreeAggregate(true)
+	assert.EqualError(t, err, "kernel 3.10.0-1160.el7.x86_64 does not count tasks of descendant cgroups in cgroup perf events, Linux 3.16+ is required for subtree aggregation")
+
+	kernelRelease = func() string {
+		return "5.4.0"
+	}
+	assert.NoError(t, checkSubtreeAggregate(true))
+}
+
+func TestThreadedDescendants(t *testing.T) {
+	root, err := ioutil.TempDir("", "cgroup")
+	assert.NoError(t, err)
+	defer os.RemoveAll(root)
+	// Threaded subtree rooted at the container cgroup, with a domain child
+	// cgroup and a nested threaded cgroup.
+	for path, cgroupType := range map[string]string{
+		"":             "domain threaded\n",
+		"workers":      "threaded\n",
+		"workers/io":   "threaded\n",
+		"timers":       "threaded\n",
+		"sidecar":      "domain\n",
+		"sidecar/nope": "domain\n",
+	} {
+		assert.NoError(t, os.MkdirAll(filepath.Join(root, path), 0755))
+		assert.NoError(t, ioutil.WriteFile(filepath.Join(root, path, cgroupTypeFile), []byte(cgroupType), 0644))
+	}
+
+	threaded, err := threadedDescendants(root)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"workers", "workers/io", "timers"}, threaded)
+
+	// Cgroup v1 has no cgroup.type files.
+	v1, err := ioutil.TempDir("", "perf_event")
+	assert.NoError(t, err)
+	defer os.RemoveAll(v1)
+	assert.NoError(t, os.Mkdir(filepath.Join(v1, "child"), 0755))
+	threaded, err = threadedDescendants(v1)
+	assert.NoError(t, err)
+	assert.Empty(t, threaded)
+}
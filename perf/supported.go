@@ -0,0 +1,30 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Events supported by libpfm4 on the host.
+package perf
+
+// SupportedEvent is an event that libpfm4 is able to encode on the host.
+type SupportedEvent struct {
+	// Name of the event, e.g. INST_RETIRED. It can be qualified with
+	// the PMU, e.g. skl::INST_RETIRED, if more PMUs provide the event.
+	Name string
+	// Human readable description of what the event counts.
+	Description string
+	// Name of the PMU as known to libpfm4, e.g. skl or skx_unc_imc0.
+	PMU string
+	// Event is measured by uncore PMU, so it belongs to uncore events
+	// in configuration. Otherwise it is a core event.
+	Uncore bool
+}
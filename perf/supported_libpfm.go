@@ -0,0 +1,63 @@
+// +build libpfm,cgo
+
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Events supported by libpfm4 on the host.
+package perf
+
+// #cgo CFLAGS: -I/usr/include
+// #cgo LDFLAGS: -lpfm
+// #include <perfmon/pfmlib.h>
+// static int supported_pmu_is_present(pfm_pmu_info_t *info) { return info->is_present; }
+import "C"
+
+import (
+	"fmt"
+)
+
+// SupportedEvents returns events of all the PMUs that libpfm4 detected on
+// the host, which can be used to write configuration of perf events
+// before it is deployed. Events are listed in order provided by libpfm4.
+func SupportedEvents() ([]SupportedEvent, error) {
+	err := checkLibpfmInitialized()
+	if err != nil {
+		return nil, err
+	}
+	events := []SupportedEvent{}
+	for pmu := C.pfm_pmu_t(C.PFM_PMU_NONE); pmu < C.PFM_PMU_MAX; pmu++ {
+		pmuInfo := C.pfm_pmu_info_t{}
+		pmuInfo.size = C.sizeof_pfm_pmu_info_t
+		pErr := C.pfm_get_pmu_info(pmu, &pmuInfo)
+		if pErr != C.PFM_SUCCESS || C.supported_pmu_is_present(&pmuInfo) == 0 {
+			continue
+		}
+		pmuName := C.GoString(pmuInfo.name)
+		for idx := pmuInfo.first_event; idx != -1; idx = C.pfm_get_event_next(idx) {
+			eventInfo := C.pfm_event_info_t{}
+			eventInfo.size = C.sizeof_pfm_event_info_t
+			pErr = C.pfm_get_event_info(idx, C.PFM_OS_NONE, &eventInfo)
+			if pErr != C.PFM_SUCCESS {
+				return nil, fmt.Errorf("unable to get information about event %d of PMU %s: %d", int(idx), pmuName, int(pErr))
+			}
+			events = append(events, SupportedEvent{
+				Name:        C.GoString(eventInfo.name),
+				Description: C.GoString(eventInfo.desc),
+				PMU:         pmuName,
+				Uncore:      pmuInfo._type == C.PFM_PMU_TYPE_UNCORE,
+			})
+		}
+	}
+	return events, nil
+}
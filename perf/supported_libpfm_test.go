@@ -0,0 +1,42 @@
+// +build libpfm,cgo
+
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Events supported by libpfm4 on the host.
+package perf
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSupportedEvents(t *testing.T) {
+	events, err := SupportedEvents()
+	assert.NoError(t, err)
+	assert.NotEmpty(t, events)
+	for _, event := range events {
+		assert.NotEmpty(t, event.Name)
+		assert.NotEmpty(t, event.PMU)
+	}
+}
+
+func TestSupportedEventsWithUninitializedLibpfm(t *testing.T) {
+	defer mockUninitializedLibpfm(fmt.Errorf("no PMU"))()
+
+	_, err := SupportedEvents()
+	assert.Error(t, err)
+}
@@ -0,0 +1,99 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Threshold rules evaluated against perf events.
+package perf
+
+import (
+	"fmt"
+
+	info "github.com/google/cadvisor/info/v1"
+)
+
+const (
+	comparatorGreater = ">"
+	comparatorLess    = "<"
+)
+
+// ThresholdCallback is called when value of perf event of a container
+// crosses a threshold. It is called synchronously by the collector so it
+// should return quickly. It is called without holding locks of the
+// collector, so it can use the collector or perf manager.
+type ThresholdCallback func(cgroupPath string, threshold Threshold, value uint64)
+
+// validateThresholds checks if threshold rules can be evaluated.
+func validateThresholds(thresholds []Threshold) error {
+	for _, threshold := range thresholds {
+		if threshold.Comparator != comparatorGreater && threshold.Comparator != comparatorLess {
+			return fmt.Errorf("threshold of event %q has unsupported comparator %q", threshold.Event, threshold.Comparator)
+		}
+	}
+	return nil
+}
+
+// exceeds checks if value is on the firing side of the threshold.
+func (t Threshold) exceeds(value uint64) bool {
+	if t.Comparator == comparatorLess {
+		return value < t.Value
+	}
+	return value > t.Value
+}
+
+// firedThreshold is a threshold that has been crossed by value of perf event.
+type firedThreshold struct {
+	threshold Threshold
+	value     uint64
+}
+
+// thresholdTracker remembers which rules are crossed, so callback is
+// fired once when threshold is crossed and not again until value gets
+// back to the other side of the threshold.
+type thresholdTracker struct {
+	thresholds []Threshold
+	crossed    []bool
+}
+
+func newThresholdTracker(thresholds []Threshold) *thresholdTracker {
+	return &thresholdTracker{thresholds: thresholds, crossed: make([]bool, len(thresholds))}
+}
+
+// evaluate calls fire for each rule that has been crossed since the
+// previous evaluation. Value of an event is the sum over all the CPUs.
+// Rules of events that have not been measured are not evaluated.
+func (t *thresholdTracker) evaluate(perfStats []info.PerfStat, fire func(threshold Threshold, value uint64)) {
+	values := make(map[string]uint64, len(t.thresholds))
+	for _, threshold := range t.thresholds {
+		values[string(threshold.Event)] = 0
+	}
+	measured := make(map[string]bool, len(t.thresholds))
+	for _, stat := range perfStats {
+		if _, ok := values[stat.Name]; !ok || stat.Errored || stat.Cpu == info.PerfStatAllCPUs {
+			continue
+		}
+		values[stat.Name] += stat.Value
+		measured[stat.Name] = true
+	}
+
+	for i, threshold := range t.thresholds {
+		if !measured[string(threshold.Event)] {
+			continue
+		}
+		value := values[string(threshold.Event)]
+		exceeds := threshold.exceeds(value)
+		if exceeds && !t.crossed[i] {
+			fire(threshold, value)
+		}
+		t.crossed[i] = exceeds
+	}
+}
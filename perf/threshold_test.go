@@ -0,0 +1,67 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Threshold rules evaluated against perf events.
+package perf
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	info "github.com/google/cadvisor/info/v1"
+)
+
+func TestValidateThresholds(t *testing.T) {
+	assert.NoError(t, validateThresholds([]Threshold{
+		{Event: "cache-misses", Comparator: ">", Value: 1000},
+		{Event: "instructions", Comparator: "<", Value: 10},
+	}))
+	assert.Error(t, validateThresholds([]Threshold{{Event: "cache-misses", Comparator: ">=", Value: 1000}}))
+}
+
+func TestThresholdTracker(t *testing.T) {
+	tracker := newThresholdTracker([]Threshold{
+		{Event: "cache-misses", Comparator: ">", Value: 1000},
+		{Event: "instructions", Comparator: "<", Value: 100},
+	})
+	fired := []uint64{}
+	fire := func(threshold Threshold, value uint64) {
+		fired = append(fired, value)
+	}
+
+	// Values are summed up over CPUs.
+	tracker.evaluate([]info.PerfStat{
+		perfStat("cache-misses", 0, 600, 1),
+		perfStat("cache-misses", 1, 600, 1),
+		perfStat("instructions", 0, 500, 1),
+	}, fire)
+	assert.Equal(t, []uint64{1200}, fired)
+
+	// Callback is not fired again until value gets back below threshold.
+	tracker.evaluate([]info.PerfStat{perfStat("cache-misses", 0, 2000, 1)}, fire)
+	assert.Equal(t, []uint64{1200}, fired)
+	tracker.evaluate([]info.PerfStat{perfStat("cache-misses", 0, 10, 1)}, fire)
+	assert.Equal(t, []uint64{1200}, fired)
+	tracker.evaluate([]info.PerfStat{perfStat("cache-misses", 0, 3000, 1)}, fire)
+	assert.Equal(t, []uint64{1200, 3000}, fired)
+
+	// Event in error state is not taken into account.
+	errored := perfStat("instructions", 0, 0, 1)
+	errored.Errored = true
+	tracker.evaluate([]info.PerfStat{errored}, fire)
+	assert.Equal(t, []uint64{1200, 3000}, fired)
+	tracker.evaluate([]info.PerfStat{perfStat("instructions", 0, 50, 1)}, fire)
+	assert.Equal(t, []uint64{1200, 3000, 50}, fired)
+}
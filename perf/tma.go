@@ -0,0 +1,160 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Built-in derived metrics, including level 1 of Top-down Microarchitecture
+// Analysis (TMA).
+package perf
+
+const intelVendor = "GenuineIntel"
+
+// Names of level 1 TMA categories, as fractions of pipeline slots.
+const (
+	tmaFrontendBound  = "frontend_bound"
+	tmaBadSpeculation = "bad_speculation"
+	tmaRetiring       = "retiring"
+	tmaBackendBound   = "backend_bound"
+)
+
+// genericDerivedMetrics are computed on any CPU.
+var genericDerivedMetrics = []DerivedMetric{
+	{
+		Name:   "ipc",
+		Events: []Event{"instructions", "cycles"},
+		Formula: func(values map[Event]float64) (float64, bool) {
+			if values["cycles"] == 0 {
+				return 0, false
+			}
+			return values["instructions"] / values["cycles"], true
+		},
+	},
+}
+
+// builtinDerivedMetricSets are derived metrics of microarchitectures
+// cAdvisor knows formulas for. Event names are libpfm4 names.
+var builtinDerivedMetricSets = []DerivedMetricSet{
+	{
+		Name:     "Intel Haswell to Comet Lake",
+		Vendor:   intelVendor,
+		Families: []int64{6},
+		Models:   []int64{0x3c, 0x3f, 0x45, 0x46, 0x3d, 0x47, 0x4f, 0x56, 0x4e, 0x5e, 0x55, 0x8e, 0x9e, 0xa5, 0xa6},
+		Metrics: tmaLevel1(tmaEvents{
+			width:          4,
+			cycles:         "cycles",
+			notDelivered:   "IDQ_UOPS_NOT_DELIVERED:CORE",
+			issued:         "UOPS_ISSUED:ANY",
+			retired:        "UOPS_RETIRED:RETIRE_SLOTS",
+			recoveryCycles: "INT_MISC:RECOVERY_CYCLES",
+		}),
+	},
+	{
+		Name:     "Intel Ice Lake and Tiger Lake",
+		Vendor:   intelVendor,
+		Families: []int64{6},
+		Models:   []int64{0x7d, 0x7e, 0x6a, 0x6c, 0x8c, 0x8d},
+		Metrics: tmaLevel1(tmaEvents{
+			width:          5,
+			slots:          "TOPDOWN:SLOTS",
+			notDelivered:   "IDQ_UOPS_NOT_DELIVERED:CORE",
+			issued:         "UOPS_ISSUED:ANY",
+			retired:        "UOPS_RETIRED:SLOTS",
+			recoveryCycles: "INT_MISC:RECOVERY_CYCLES",
+		}),
+	},
+}
+
+// tmaEvents are events that level 1 TMA categories of a microarchitecture
+// are computed from.
+type tmaEvents struct {
+	// Number of uops that the pipeline can issue per cycle.
+	width float64
+	// Event counting pipeline slots. If empty, slots are width times
+	// cycles.
+	slots  Event
+	cycles Event
+	// Slots in which frontend did not deliver uops while backend could
+	// accept them.
+	notDelivered Event
+	issued       Event
+	retired      Event
+	// Cycles in which the frontend was recovering from misprediction or
+	// machine clear.
+	recoveryCycles Event
+}
+
+// tmaLevel1 returns level 1 TMA categories of a microarchitecture. Each of
+// them is fraction of pipeline slots and they sum up to 1, with backend
+// bound being the remainder of the others.
+func tmaLevel1(events tmaEvents) []DerivedMetric {
+	slotEvents := []Event{events.slots}
+	if events.slots == "" {
+		slotEvents = []Event{events.cycles}
+	}
+	slots := func(values map[Event]float64) float64 {
+		if events.slots != "" {
+			return values[events.slots]
+		}
+		return events.width * values[events.cycles]
+	}
+	frontendBound := func(values map[Event]float64) float64 {
+		return values[events.notDelivered] / slots(values)
+	}
+	badSpeculation := func(values map[Event]float64) float64 {
+		return (values[events.issued] - values[events.retired] + events.width*values[events.recoveryCycles]) / slots(values)
+	}
+	retiring := func(values map[Event]float64) float64 {
+		return values[events.retired] / slots(values)
+	}
+	backendBound := func(values map[Event]float64) float64 {
+		return 1 - frontendBound(values) - badSpeculation(values) - retiring(values)
+	}
+	// Categories are fractions of slots, counts that do not add up due to
+	// multiplexing or skid are clamped.
+	fraction := func(category func(map[Event]float64) float64) func(map[Event]float64) (float64, bool) {
+		return func(values map[Event]float64) (float64, bool) {
+			if slots(values) == 0 {
+				return 0, false
+			}
+			value := category(values)
+			if value < 0 {
+				value = 0
+			} else if value > 1 {
+				value = 1
+			}
+			return value, true
+		}
+	}
+
+	return []DerivedMetric{
+		{
+			Name:    tmaFrontendBound,
+			Events:  append([]Event{events.notDelivered}, slotEvents...),
+			Formula: fraction(frontendBound),
+		},
+		{
+			Name:    tmaBadSpeculation,
+			Events:  append([]Event{events.issued, events.retired, events.recoveryCycles}, slotEvents...),
+			Formula: fraction(badSpeculation),
+		},
+		{
+			Name:    tmaRetiring,
+			Events:  append([]Event{events.retired}, slotEvents...),
+			Formula: fraction(retiring),
+		},
+		{
+			Name:    tmaBackendBound,
+			Events:  append([]Event{events.notDelivered, events.issued, events.retired, events.recoveryCycles}, slotEvents...),
+			Formula: fraction(backendBound),
+		},
+	}
+}
@@ -0,0 +1,68 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Totals of perf events over all CPUs reported alongside per CPU values.
+package perf
+
+import (
+	info "github.com/google/cadvisor/info/v1"
+)
+
+// addCPUTotals appends, for each event, its values summed up over all CPUs
+// as a stat with Cpu and Core set to info.PerfStatAllCPUs. Scaling ratio of
+// the total is the lowest ratio of its values and running time is the sum
+// of their running times. Values in error state are not taken into account
+// and the total is in error state only if all of them are. Histograms and
+// frequencies are not summed up.
+func addCPUTotals(perfStats []info.PerfStat) []info.PerfStat {
+	totals := []info.PerfStat{}
+	positions := map[string]int{}
+	for _, stat := range perfStats {
+		position, ok := positions[stat.Name]
+		if !ok {
+			total := stat
+			total.Cpu = info.PerfStatAllCPUs
+			total.Core = info.PerfStatAllCPUs
+			total.Frequency = 0
+			total.Histogram = nil
+			if stat.Errored {
+				total.Value = 0
+				total.RawValue = 0
+				total.ScalingRatio = 0
+				total.TimeEnabled = 0
+				total.TimeRunning = 0
+			}
+			positions[stat.Name] = len(totals)
+			totals = append(totals, total)
+			continue
+		}
+
+		total := &totals[position]
+		total.Reopened = total.Reopened || stat.Reopened
+		if stat.Errored {
+			continue
+		}
+		if total.Errored {
+			total.Errored = false
+			total.ScalingRatio = stat.ScalingRatio
+		} else if stat.ScalingRatio < total.ScalingRatio {
+			total.ScalingRatio = stat.ScalingRatio
+		}
+		total.Value += stat.Value
+		total.RawValue += stat.RawValue
+		total.TimeEnabled += stat.TimeEnabled
+		total.TimeRunning += stat.TimeRunning
+	}
+	return append(perfStats, totals...)
+}
@@ -0,0 +1,66 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package perf
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	info "github.com/google/cadvisor/info/v1"
+)
+
+func TestAddCPUTotals(t *testing.T) {
+	perfStats := []info.PerfStat{
+		perfStat("instructions", 0, 100, 1),
+		perfStat("instructions", 1, 200, 0.5),
+		perfStat("cycles", 0, 300, 1),
+		perfStat("cycles", 1, 0, 0),
+		perfStat("cache-misses", 2, 0, 0),
+	}
+	perfStats[0].TimeEnabled, perfStats[1].TimeEnabled = 20, 10
+	perfStats[0].TimeRunning, perfStats[1].TimeRunning = 10, 5
+	perfStats[3].Errored = true
+	perfStats[4].Errored = true
+
+	result := addCPUTotals(perfStats)
+	assert.Len(t, result, 8)
+	// Per CPU values are kept as they are.
+	assert.Equal(t, perfStats, result[:5])
+
+	totals := map[string]info.PerfStat{}
+	for _, stat := range result[5:] {
+		assert.Equal(t, info.PerfStatAllCPUs, stat.Cpu)
+		assert.Equal(t, info.PerfStatAllCPUs, stat.Core)
+		totals[stat.Name] = stat
+	}
+	// Total equals sum of per CPU values of the same output.
+	for name, total := range totals {
+		sum := uint64(0)
+		for _, stat := range result[:5] {
+			if stat.Name == name && !stat.Errored {
+				sum += stat.Value
+			}
+		}
+		assert.Equal(t, sum, total.Value, name)
+	}
+	assert.Equal(t, 0.5, totals["instructions"].ScalingRatio)
+	assert.Equal(t, uint64(30), totals["instructions"].TimeEnabled)
+	assert.Equal(t, uint64(15), totals["instructions"].TimeRunning)
+	// Errored value does not lower scaling ratio of the total.
+	assert.Equal(t, 1.0, totals["cycles"].ScalingRatio)
+	assert.False(t, totals["cycles"].Errored)
+	assert.True(t, totals["cache-misses"].Errored)
+}
@@ -31,6 +31,15 @@ type GroupReadFormat struct {
 	TimeRunning uint64 /* if PERF_FORMAT_TOTAL_TIME_RUNNING */
 }
 
+// ReadFormat allows to read perf event's value for non-grouped events.
+// See https://man7.org/linux/man-pages/man2/perf_event_open.2.html section "Reading results" without PERF_FORMAT_GROUP specified.
+type ReadFormat struct {
+	Value       uint64 /* The value of the event */
+	TimeEnabled uint64 /* if PERF_FORMAT_TOTAL_TIME_ENABLED */
+	TimeRunning uint64 /* if PERF_FORMAT_TOTAL_TIME_RUNNING */
+	ID          uint64 /* if PERF_FORMAT_ID */
+}
+
 type Values struct {
 	Value uint64 /* The value of the event */
 	ID    uint64 /* if PERF_FORMAT_ID */
@@ -51,3 +60,7 @@ type readerCloser interface {
 	io.Reader
 	io.Closer
 }
+
+type fileDescriptor interface {
+	Fd() uintptr
+}
@@ -0,0 +1,85 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Aggregation of uncore perf events across instances of the same PMU type.
+package perf
+
+import (
+	"regexp"
+
+	info "github.com/google/cadvisor/info/v1"
+)
+
+// Suffix of PMU name that numbers instances of the same PMU type, e.g. "_3"
+// in "uncore_cha_3".
+var pmuInstanceRegexp = regexp.MustCompile(`_\d+$`)
+
+type uncoreAggregationKey struct {
+	name    string
+	socket  int
+	pmuType string
+}
+
+// pmuType returns type of PMU instance, e.g. "uncore_imc" for "uncore_imc_0".
+// Name of PMU that has a single instance is returned as is.
+func pmuType(pmu string) string {
+	return pmuInstanceRegexp.ReplaceAllString(pmu, "")
+}
+
+// aggregateUncoreInstances sums values of uncore perf events measured by
+// instances of the same PMU type on every socket and reports them with PMU
+// type as PMU. Scaling ratio and running time of the sum are the lowest ratio
+// and time of its values. Events in error state are not taken into account.
+func aggregateUncoreInstances(perfStats []info.PerfUncoreStat) []info.PerfUncoreStat {
+	result := make([]info.PerfUncoreStat, 0, len(perfStats))
+	aggregated := map[uncoreAggregationKey]int{}
+	for _, stat := range perfStats {
+		key := uncoreAggregationKey{name: stat.Name, socket: stat.Socket, pmuType: pmuType(stat.PMU)}
+		position, ok := aggregated[key]
+		if !ok {
+			combined := stat
+			combined.PMU = key.pmuType
+			if stat.Errored {
+				combined.Value = 0
+				combined.RawValue = 0
+				combined.ScalingRatio = 0
+				combined.TimeEnabled = 0
+				combined.TimeRunning = 0
+			}
+			aggregated[key] = len(result)
+			result = append(result, combined)
+			continue
+		}
+
+		if stat.Errored {
+			continue
+		}
+		combined := &result[position]
+		if combined.Errored {
+			combined.Errored = false
+			combined.ScalingRatio = stat.ScalingRatio
+			combined.TimeEnabled = stat.TimeEnabled
+			combined.TimeRunning = stat.TimeRunning
+		} else if stat.ScalingRatio < combined.ScalingRatio {
+			combined.ScalingRatio = stat.ScalingRatio
+		}
+		if stat.TimeRunning < combined.TimeRunning {
+			combined.TimeEnabled = stat.TimeEnabled
+			combined.TimeRunning = stat.TimeRunning
+		}
+		combined.Value += stat.Value
+		combined.RawValue += stat.RawValue
+	}
+	return result
+}
@@ -0,0 +1,61 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Aggregation of uncore perf events across instances of the same PMU type.
+package perf
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	info "github.com/google/cadvisor/info/v1"
+)
+
+func uncoreStat(name string, pmu string, socket int, value uint64, scalingRatio float64) info.PerfUncoreStat {
+	return info.PerfUncoreStat{
+		PerfValue: info.PerfValue{ScalingRatio: scalingRatio, Value: value, Name: name},
+		Socket:    socket,
+		PMU:       pmu,
+	}
+}
+
+func TestPMUType(t *testing.T) {
+	assert.Equal(t, "uncore_imc", pmuType("uncore_imc_0"))
+	assert.Equal(t, "uncore_cha", pmuType("uncore_cha_27"))
+	assert.Equal(t, "uncore_imc_free_running", pmuType("uncore_imc_free_running_1"))
+	assert.Equal(t, "uncore_arb", pmuType("uncore_arb"))
+}
+
+func TestAggregateUncoreInstances(t *testing.T) {
+	errored := uncoreStat("cas_count_read", "uncore_imc_2", 0, 0, 1)
+	errored.Errored = true
+	perfStats := []info.PerfUncoreStat{
+		uncoreStat("cas_count_read", "uncore_imc_0", 0, 100, 1),
+		uncoreStat("cas_count_read", "uncore_imc_1", 0, 200, 0.5),
+		errored,
+		uncoreStat("cas_count_read", "uncore_imc_0", 1, 10, 1),
+		uncoreStat("cas_count_read", "uncore_imc_1", 1, 20, 1),
+		uncoreStat("cas_count_write", "uncore_imc_0", 0, 7, 1),
+		uncoreStat("llc_lookup", "uncore_cha_0", 0, 1, 1),
+		uncoreStat("llc_lookup", "uncore_cha_1", 0, 2, 1),
+	}
+
+	assert.Equal(t, []info.PerfUncoreStat{
+		uncoreStat("cas_count_read", "uncore_imc", 0, 300, 0.5),
+		uncoreStat("cas_count_read", "uncore_imc", 1, 30, 1),
+		uncoreStat("cas_count_write", "uncore_imc", 0, 7, 1),
+		uncoreStat("llc_lookup", "uncore_cha", 0, 3, 1),
+	}, aggregateUncoreInstances(perfStats))
+}
@@ -130,6 +130,9 @@ type uncoreCollector struct {
 	events             []Group
 	eventToCustomEvent map[Event]*CustomEvent
 	cpuToSocket        map[int]int
+	perfStatScaling    bool
+	// Sum values up over instances of the same PMU type on each socket.
+	perSocket bool
 
 	// Handle for mocking purposes.
 	perfEventOpen func(attr *unix.PerfEventAttr, pid int, cpu int, groupFd int, flags int) (fd int, err error)
@@ -167,6 +170,8 @@ func (c *uncoreCollector) setup(events PerfEvents, devicesPath string) error {
 
 	c.cpuFiles = make(map[int]map[string]group)
 	c.events = events.Uncore.Events
+	c.perfStatScaling = events.PerfStatScaling
+	c.perSocket = events.UncorePerSocket
 	c.eventToCustomEvent = parseUncoreEvents(events.Uncore)
 	c.cpuFilesLock.Lock()
 	defer c.cpuFilesLock.Unlock()
@@ -341,7 +346,10 @@ func (c *uncoreCollector) Destroy() {
 
 func (c *uncoreCollector) UpdateStats(stats *info.ContainerStats) error {
 	klog.V(5).Info("Attempting to update uncore perf_event stats")
+	c.cpuFilesLock.Lock()
+	defer c.cpuFilesLock.Unlock()
 
+	perfUncoreStats := []info.PerfUncoreStat{}
 	for _, groupPMUs := range c.cpuFiles {
 		for pmu, group := range groupPMUs {
 			for cpu, file := range group.cpuFiles[group.leaderName] {
@@ -351,17 +359,23 @@ func (c *uncoreCollector) UpdateStats(stats *info.ContainerStats) error {
 					continue
 				}
 
-				stats.PerfUncoreStats = append(stats.PerfUncoreStats, stat...)
+				perfUncoreStats = append(perfUncoreStats, stat...)
 			}
 		}
 	}
+	if c.perSocket {
+		perfUncoreStats = aggregateUncoreInstances(perfUncoreStats)
+	}
+	convertUncoreStats(perfUncoreStats)
+	stats.PerfUncoreStats = append(stats.PerfUncoreStats, perfUncoreStats...)
 
 	return nil
 }
 
 func (c *uncoreCollector) setupEvent(name string, pmus uncorePMUs, groupIndex int, leaderFileDescriptors map[string]map[uint32]int) error {
-	if !isLibpfmInitialized {
-		return fmt.Errorf("libpfm4 is not initialized, cannot proceed with setting perf events up")
+	err := checkLibpfmInitialized()
+	if err != nil {
+		return err
 	}
 
 	klog.V(5).Infof("Setting up uncore perf event %s", name)
@@ -377,7 +391,7 @@ func (c *uncoreCollector) setupEvent(name string, pmus uncorePMUs, groupIndex in
 		config.Type = pmu.typeOf
 		isGroupLeader := leaderFileDescriptors[pmu.name][pmu.cpus[0]] == groupLeaderFileDescriptor
 		setAttributes(config, isGroupLeader)
-		leaderFileDescriptors[pmu.name], err = c.registerEvent(eventInfo{name, config, uncorePID, groupIndex, isGroupLeader}, pmu, leaderFileDescriptors[pmu.name])
+		leaderFileDescriptors[pmu.name], err = c.registerEvent(eventInfo{name, config, uncorePID, groupIndex, isGroupLeader, false}, pmu, leaderFileDescriptors[pmu.name])
 		if err != nil {
 			return err
 		}
@@ -427,8 +441,9 @@ func (c *uncoreCollector) addEventFile(index int, name string, pmu string, cpu i
 	_, ok = c.cpuFiles[index][pmu]
 	if !ok {
 		c.cpuFiles[index][pmu] = group{
-			cpuFiles:   map[string]map[int]readerCloser{},
-			leaderName: name,
+			cpuFiles:        map[string]map[int]readerCloser{},
+			leaderName:      name,
+			perfStatScaling: c.perfStatScaling,
 		}
 	}
 
@@ -448,9 +463,10 @@ func (c *uncoreCollector) addEventFile(index int, name string, pmu string, cpu i
 
 	// Otherwise save it.
 	c.cpuFiles[index][pmu] = group{
-		cpuFiles:   c.cpuFiles[index][pmu].cpuFiles,
-		names:      append(c.cpuFiles[index][pmu].names, name),
-		leaderName: c.cpuFiles[index][pmu].leaderName,
+		cpuFiles:        c.cpuFiles[index][pmu].cpuFiles,
+		names:           append(c.cpuFiles[index][pmu].names, name),
+		leaderName:      c.cpuFiles[index][pmu].leaderName,
+		perfStatScaling: c.cpuFiles[index][pmu].perfStatScaling,
 	}
 }
 
@@ -467,7 +483,7 @@ func (c *uncoreCollector) setupRawEvent(event *CustomEvent, pmus uncorePMUs, gro
 		isGroupLeader := leaderFileDescriptors[pmu.name][pmu.cpus[0]] == groupLeaderFileDescriptor
 		setAttributes(config, isGroupLeader)
 		var err error
-		leaderFileDescriptors[pmu.name], err = c.registerEvent(eventInfo{string(newEvent.Name), config, uncorePID, groupIndex, isGroupLeader}, pmu, leaderFileDescriptors[pmu.name])
+		leaderFileDescriptors[pmu.name], err = c.registerEvent(eventInfo{string(newEvent.Name), config, uncorePID, groupIndex, isGroupLeader, false}, pmu, leaderFileDescriptors[pmu.name])
 		if err != nil {
 			return err
 		}
@@ -477,7 +493,9 @@ func (c *uncoreCollector) setupRawEvent(event *CustomEvent, pmus uncorePMUs, gro
 }
 
 func readPerfUncoreStat(file readerCloser, group group, cpu int, pmu string, cpuToSocket map[int]int) ([]info.PerfUncoreStat, error) {
-	values, err := getPerfValues(file, group)
+	valuesPtr := group.buffers.getValues(len(group.names))
+	defer group.buffers.putValues(valuesPtr)
+	values, err := readPerfValues(file, group, cpu, *valuesPtr)
 	if err != nil {
 		return nil, err
 	}
@@ -490,7 +508,9 @@ func readPerfUncoreStat(file readerCloser, group group, cpu int, pmu string, cpu
 
 	perfUncoreStats := make([]info.PerfUncoreStat, len(values))
 	for i, value := range values {
-		klog.V(5).Infof("Read metric for event %q for cpu %d from pmu %q: %d", value.Name, cpu, pmu, value.Value)
+		if klog.V(5).Enabled() {
+			klog.V(5).Infof("Read metric for event %q for cpu %d from pmu %q: %d", value.Name, cpu, pmu, value.Value)
+		}
 		perfUncoreStats[i] = info.PerfUncoreStat{
 			PerfValue: value,
 			Socket:    socket,
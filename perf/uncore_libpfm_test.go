@@ -108,18 +108,18 @@ func TestUncoreCollectorSetup(t *testing.T) {
 	events := PerfEvents{
 		Core: Events{
 			Events: []Group{
-				{[]Event{"cache-misses"}, false},
+				{events: []Event{"cache-misses"}, array: false},
 			},
 		},
 		Uncore: Events{
 			Events: []Group{
-				{[]Event{"uncore_imc_1/cas_count_read"}, false},
-				{[]Event{"uncore_imc_0/cas_count_write", "uncore_imc_0/cas_count_read"}, true},
+				{events: []Event{"uncore_imc_1/cas_count_read"}, array: false},
+				{events: []Event{"uncore_imc_0/cas_count_write", "uncore_imc_0/cas_count_read"}, array: true},
 			},
 			CustomEvents: []CustomEvent{
-				{19, Config{0x01, 0x02}, "uncore_imc_1/cas_count_read"},
-				{0, Config{0x02, 0x03}, "uncore_imc_0/cas_count_write"},
-				{18, Config{0x01, 0x02}, "uncore_imc_0/cas_count_read"},
+				{19, Config{0x01, 0x02}, "uncore_imc_1/cas_count_read", Exclusion{}},
+				{0, Config{0x02, 0x03}, "uncore_imc_0/cas_count_write", Exclusion{}},
+				{18, Config{0x01, 0x02}, "uncore_imc_0/cas_count_read", Exclusion{}},
 			},
 		},
 	}
@@ -141,8 +141,8 @@ func TestParseUncoreEvents(t *testing.T) {
 	events := PerfEvents{
 		Uncore: Events{
 			Events: []Group{
-				{[]Event{"cas_count_read"}, false},
-				{[]Event{"cas_count_write"}, false},
+				{events: []Event{"cas_count_read"}, array: false},
+				{events: []Event{"cas_count_write"}, array: false},
 			},
 			CustomEvents: []CustomEvent{
 				{
@@ -197,12 +197,12 @@ func TestCheckGroup(t *testing.T) {
 		expectedOutput string
 	}{
 		{
-			Group{[]Event{"uncore_imc/cas_count_write"}, false},
+			Group{events: []Event{"uncore_imc/cas_count_write"}, array: false},
 			map[Event]uncorePMUs{},
 			"the event \"uncore_imc/cas_count_write\" don't have any PMU to count with",
 		},
 		{
-			Group{[]Event{"uncore_imc/cas_count_write", "uncore_imc/cas_count_read"}, true},
+			Group{events: []Event{"uncore_imc/cas_count_write", "uncore_imc/cas_count_read"}, array: true},
 			map[Event]uncorePMUs{"uncore_imc/cas_count_write": {
 				"uncore_imc_0": {name: "uncore_imc_0", typeOf: 18, cpus: []uint32{0, 1}},
 				"uncore_imc_1": {name: "uncore_imc_1", typeOf: 19, cpus: []uint32{0, 1}},
@@ -215,7 +215,7 @@ func TestCheckGroup(t *testing.T) {
 			"the events in group usually have to be from single PMU, try reorganizing the \"[uncore_imc/cas_count_write uncore_imc/cas_count_read]\" group",
 		},
 		{
-			Group{[]Event{"uncore_imc_0/cas_count_write", "uncore_imc_1/cas_count_read"}, true},
+			Group{events: []Event{"uncore_imc_0/cas_count_write", "uncore_imc_1/cas_count_read"}, array: true},
 			map[Event]uncorePMUs{"uncore_imc_0/cas_count_write": {
 				"uncore_imc_0": {name: "uncore_imc_0", typeOf: 18, cpus: []uint32{0, 1}},
 			},
@@ -226,7 +226,7 @@ func TestCheckGroup(t *testing.T) {
 			"the events in group usually have to be from the same PMU, try reorganizing the \"[uncore_imc_0/cas_count_write uncore_imc_1/cas_count_read]\" group",
 		},
 		{
-			Group{[]Event{"uncore_imc/cas_count_write"}, false},
+			Group{events: []Event{"uncore_imc/cas_count_write"}, array: false},
 			map[Event]uncorePMUs{"uncore_imc/cas_count_write": {
 				"uncore_imc_0": {name: "uncore_imc_0", typeOf: 18, cpus: []uint32{0, 1}},
 				"uncore_imc_1": {name: "uncore_imc_1", typeOf: 19, cpus: []uint32{0, 1}},
@@ -234,7 +234,7 @@ func TestCheckGroup(t *testing.T) {
 			"",
 		},
 		{
-			Group{[]Event{"uncore_imc_0/cas_count_write", "uncore_imc_0/cas_count_read"}, true},
+			Group{events: []Event{"uncore_imc_0/cas_count_write", "uncore_imc_0/cas_count_read"}, array: true},
 			map[Event]uncorePMUs{"uncore_imc_0/cas_count_write": {
 				"uncore_imc_0": {name: "uncore_imc_0", typeOf: 18, cpus: []uint32{0, 1}},
 			},
@@ -271,7 +271,9 @@ func TestReadPerfUncoreStat(t *testing.T) {
 		PerfValue: v1.PerfValue{
 			ScalingRatio: 1,
 			Value:        4,
+			RawValue:     4,
 			Name:         "foo",
+			TimeRunning:  1,
 		},
 		Socket: 0,
 		PMU:    "bar",
@@ -295,3 +297,60 @@ func TestReadPerfUncoreStat(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, expectedStat, stat)
 }
+
+func TestUncoreCollectorUpdateStatsPerSocket(t *testing.T) {
+	imcGroup := func(files map[int]readerCloser) group {
+		return group{
+			cpuFiles:   map[string]map[int]readerCloser{"cas_count_read": files},
+			names:      []string{"cas_count_read"},
+			leaderName: "cas_count_read",
+		}
+	}
+	imcValue := func(value uint64) readerCloser {
+		buf := &buffer{bytes.NewBuffer([]byte{})}
+		err := binary.Write(buf, binary.LittleEndian, GroupReadFormat{Nr: 1, TimeEnabled: 1, TimeRunning: 1})
+		assert.NoError(t, err)
+		err = binary.Write(buf, binary.LittleEndian, Values{Value: value})
+		assert.NoError(t, err)
+		return buf
+	}
+
+	for _, perSocket := range []bool{false, true} {
+		// Three IMC instances, each measured on CPU 0 of socket 0 and CPU 1
+		// of socket 1.
+		collector := &uncoreCollector{
+			cpuToSocket: map[int]int{0: 0, 1: 1},
+			perSocket:   perSocket,
+			cpuFiles: map[int]map[string]group{
+				0: {
+					"uncore_imc_0": imcGroup(map[int]readerCloser{0: imcValue(100), 1: imcValue(1)}),
+					"uncore_imc_1": imcGroup(map[int]readerCloser{0: imcValue(200), 1: imcValue(2)}),
+					"uncore_imc_2": imcGroup(map[int]readerCloser{0: imcValue(300), 1: imcValue(3)}),
+				},
+			},
+		}
+
+		stats := &v1.ContainerStats{}
+		err := collector.UpdateStats(stats)
+		assert.NoError(t, err)
+
+		value := func(value uint64) v1.PerfValue {
+			return v1.PerfValue{ScalingRatio: 1, Value: value, RawValue: value, Name: "cas_count_read", TimeEnabled: 1, TimeRunning: 1}
+		}
+		if !perSocket {
+			assert.ElementsMatch(t, []v1.PerfUncoreStat{
+				{PerfValue: value(100), Socket: 0, PMU: "uncore_imc_0"},
+				{PerfValue: value(200), Socket: 0, PMU: "uncore_imc_1"},
+				{PerfValue: value(300), Socket: 0, PMU: "uncore_imc_2"},
+				{PerfValue: value(1), Socket: 1, PMU: "uncore_imc_0"},
+				{PerfValue: value(2), Socket: 1, PMU: "uncore_imc_1"},
+				{PerfValue: value(3), Socket: 1, PMU: "uncore_imc_2"},
+			}, stats.PerfUncoreStats)
+			continue
+		}
+		assert.ElementsMatch(t, []v1.PerfUncoreStat{
+			{PerfValue: value(600), Socket: 0, PMU: "uncore_imc"},
+			{PerfValue: value(6), Socket: 1, PMU: "uncore_imc"},
+		}, stats.PerfUncoreStats)
+	}
+}
@@ -0,0 +1,83 @@
+// +build libpfm,cgo
+
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Validation of perf event names before any event is opened.
+package perf
+
+import (
+	"fmt"
+)
+
+// Validate encodes each event that is configured by name with libpfm4 and
+// returns error listing all the events that are unknown or not supported
+// on the host, so that configuration can be rejected when cAdvisor starts
+// instead of when the first container is measured. Custom and software
+// events are not validated as they are not encoded with libpfm4.
+func Validate(events PerfEvents) error {
+	if !requiresLibpfm(events.Core) && !requiresLibpfm(events.Uncore) {
+		return nil
+	}
+	err := checkLibpfmInitialized()
+	if err != nil {
+		return err
+	}
+
+	invalid := []string{}
+	checked := map[string]struct{}{}
+	check := func(name string) {
+		if _, ok := checked[name]; ok {
+			return
+		}
+		checked[name] = struct{}{}
+		_, err := encodedEvent(name)
+		if err != nil {
+			invalid = append(invalid, name)
+		}
+	}
+	for _, name := range namedEvents(events.Core) {
+		check(name)
+	}
+	for _, name := range namedEvents(events.Uncore) {
+		// Uncore events may be prefixed with PMU they are measured by.
+		name, _ = parseEventName(name)
+		check(name)
+	}
+	if len(invalid) > 0 {
+		return fmt.Errorf("perf events %v are unknown or not supported on the host", invalid)
+	}
+	return nil
+}
+
+// namedEvents returns names of configured events that are neither custom
+// nor software events, in order of configuration.
+func namedEvents(events Events) []string {
+	encoded := map[Event]struct{}{}
+	for _, event := range events.CustomEvents {
+		encoded[event.Name] = struct{}{}
+	}
+	for _, event := range events.SoftwareEvents {
+		encoded[event.Name] = struct{}{}
+	}
+	names := []string{}
+	for _, group := range events.Events {
+		for _, event := range group.events {
+			if _, ok := encoded[event]; !ok {
+				names = append(names, string(event))
+			}
+		}
+	}
+	return names
+}
@@ -0,0 +1,72 @@
+// +build libpfm,cgo
+
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Validation of perf event names before any event is opened.
+package perf
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidate(t *testing.T) {
+	err := Validate(PerfEvents{
+		Core: Events{Events: []Group{
+			{events: []Event{"instructions", "cycles"}},
+			{events: []Event{"instructions"}},
+		}},
+	})
+	assert.NoError(t, err)
+
+	// All the invalid events are reported at once, each of them once.
+	err = Validate(PerfEvents{
+		Core: Events{Events: []Group{
+			{events: []Event{"instructions", "instructoins"}},
+			{events: []Event{"cylces", "instructoins"}},
+		}},
+		Uncore: Events{Events: []Group{
+			{events: []Event{"uncore_imc/non-existing-event"}},
+		}},
+	})
+	assert.EqualError(t, err, "perf events [instructoins cylces non-existing-event] are unknown or not supported on the host")
+
+	// Custom and software events are not encoded with libpfm4.
+	err = Validate(PerfEvents{
+		Core: Events{
+			Events:         []Group{{events: []Event{"raw-event", "switches"}}},
+			CustomEvents:   []CustomEvent{{Type: 4, Config: Config{0x5300c0}, Name: "raw-event"}},
+			SoftwareEvents: []SoftwareEvent{{Config: 3, Name: "switches"}},
+		},
+	})
+	assert.NoError(t, err)
+}
+
+func TestValidateWithUninitializedLibpfm(t *testing.T) {
+	initErr := errors.New("no PMU")
+	defer mockUninitializedLibpfm(initErr)()
+
+	err := Validate(PerfEvents{Core: Events{Events: []Group{{events: []Event{"instructions"}}}}})
+	assert.True(t, errors.Is(err, initErr))
+
+	// Libpfm4 is not needed for custom events.
+	err = Validate(PerfEvents{Core: Events{
+		Events:       []Group{{events: []Event{"raw-event"}}},
+		CustomEvents: []CustomEvent{{Type: 4, Config: Config{0x5300c0}, Name: "raw-event"}},
+	}})
+	assert.NoError(t, err)
+}
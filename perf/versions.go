@@ -0,0 +1,33 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Versions of libraries and kernel interfaces used to measure perf events.
+package perf
+
+// Versions identify environment that perf events are measured in, so
+// differences in behavior across hosts can be correlated with it.
+type Versions struct {
+	// Version of libpfm4, e.g. "4.13". Empty if cAdvisor is built without
+	// libpfm4.
+	Libpfm string `json:"libpfm"`
+
+	// Release of the kernel, e.g. "5.10.0-8-amd64".
+	Kernel string `json:"kernel"`
+
+	// Size of perf_event_attr supported by the kernel, which identifies
+	// revision of perf ABI, e.g. 120 for PERF_ATTR_SIZE_VER6. Sizes larger
+	// than the one cAdvisor is built with are reported as the latter.
+	// Zero if it could not be determined.
+	PerfEventAttrSize uint32 `json:"perf_event_attr_size"`
+}
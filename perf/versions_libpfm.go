@@ -0,0 +1,86 @@
+// +build libpfm,cgo
+
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Versions of libraries and kernel interfaces used to measure perf events.
+package perf
+
+// #cgo CFLAGS: -I/usr/include
+// #cgo LDFLAGS: -lpfm
+// #include <perfmon/pfmlib.h>
+import "C"
+
+import (
+	"fmt"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// Versions detected when cAdvisor started. They do not change while it runs.
+var versions = detectVersions()
+
+// GetVersions returns versions of libpfm4 and of kernel interfaces used to
+// measure perf events. They are meant to be attached to bug reports.
+func GetVersions() Versions {
+	return versions
+}
+
+func detectVersions() Versions {
+	return Versions{
+		Libpfm:            libpfmVersion(),
+		Kernel:            kernelRelease(),
+		PerfEventAttrSize: perfEventAttrSize(),
+	}
+}
+
+// libpfmVersion returns version of libpfm4 as major.minor. It does not
+// require libpfm4 to be initialized.
+func libpfmVersion() string {
+	version := int(C.pfm_get_version())
+	if version < 0 {
+		return ""
+	}
+	// See PFM_MAJ_VERSION and PFM_MIN_VERSION in perfmon/pfmlib.h.
+	return fmt.Sprintf("%d.%d", version>>16, version&0xffff)
+}
+
+// perfEventAttrSize returns size of perf_event_attr supported by the kernel,
+// limited to the size cAdvisor is built with, or zero if it is unknown.
+//
+// Kernel rejects perf_event_attr larger than the one it supports with E2BIG
+// and stores size it supports in size field, if the excess is not zeroed.
+// Attribute that kernel accepts is rejected with EINVAL because of reserved
+// bit set, so no event is opened.
+func perfEventAttrSize() uint32 {
+	size := unsafe.Sizeof(unix.PerfEventAttr{})
+	buf := make([]byte, size+8)
+	buf[len(buf)-1] = 1
+	attr := (*unix.PerfEventAttr)(unsafe.Pointer(&buf[0]))
+	attr.Size = uint32(len(buf))
+	attr.Bits = 1 << 63
+
+	fd, _, errno := unix.Syscall6(unix.SYS_PERF_EVENT_OPEN, uintptr(unsafe.Pointer(&buf[0])), 0, ^uintptr(0), ^uintptr(0), 0, 0)
+	switch errno {
+	case unix.E2BIG:
+		return attr.Size
+	case unix.EINVAL:
+		return uint32(size)
+	case 0:
+		unix.Close(int(fd))
+		return uint32(size)
+	}
+	return 0
+}
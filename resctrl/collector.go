@@ -18,57 +18,587 @@
 package resctrl
 
 import (
-	info "github.com/google/cadvisor/info/v1"
-	"github.com/google/cadvisor/stats"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/opencontainers/runc/libcontainer/cgroups"
+	"k8s.io/klog/v2"
 
-	"github.com/opencontainers/runc/libcontainer/configs"
-	"github.com/opencontainers/runc/libcontainer/intelrdt"
+	info "github.com/google/cadvisor/info/v1"
 )
 
+var _ Collector = &collector{}
+
 type collector struct {
-	resctrl intelrdt.IntelRdtManager
-	stats.NoopDestroy
+	id               string
+	cgroupPath       string
+	resctrlPath      string
+	controlGroupPath string
+	systemPath       string
+	mountID          mountID
+	taskCount        uint64
+	placementHook    PlacementHook
+	// Statistics read successfully most recently and time of reading them.
+	lastStats     info.ResctrlStats
+	lastStatsTime time.Time
+	// Number of consecutive transient failures of reading statistics.
+	transientFailures int
+	mu                sync.Mutex
+	// Tasks found by fallback discovery and time of the discovery.
+	fallbackPids     []int
+	fallbackPidsTime time.Time
+	// Key of monitoring group shared with other containers, empty if the
+	// container has its own group.
+	groupKey    string
+	sharesGroup bool
+	// Recycler of RMIDs, nil if recycling is disabled. Evicted container
+	// has no monitoring group, because RMID of its group has been recycled
+	// or none has been free yet. Container that has lost its group waits
+	// until number of released groups changes from evictedReleased.
+	recycler        *recycler
+	evicted         bool
+	waitForRelease  bool
+	evictedReleased uint64
+	// Time when the current monitoring group of the container was created.
+	createdTime time.Time
+	// Container with the same cgroup whose monitoring group tasks of the
+	// container are assigned to. Container is not monitored on its own
+	// until the other one is destroyed. Empty if cgroup is not shared.
+	sharesCgroupWith string
+
+	// Handle for mocking purposes.
+	getPids    func(cgroupPath string) ([]int, error)
+	findPids   func(containerName string) ([]int, error)
+	getMountID func(path string) (mountID, error)
+	getCPUs    func(pid int) ([]int, error)
+	getStats   func(path string) (info.ResctrlStats, error)
+	mkdir      func(path string, perm os.FileMode) error
+	now        func() time.Time
 }
 
-func newCollector(id string, resctrlPath string) *collector {
+func newCollector(id string, cgroupPath string) *collector {
 	collector := &collector{
-		resctrl: intelrdt.IntelRdtManager{
-			Config: &configs.Config{
-				IntelRdt: &configs.IntelRdt{},
-			},
-			Id:   id,
-			Path: resctrlPath,
-		},
+		id:         id,
+		cgroupPath: cgroupPath,
+		getPids:    cgroups.GetPids,
+		findPids:   findPids,
+		getMountID: getMountID,
+		getCPUs:    getCPUAffinity,
+		getStats:   getStats,
+		mkdir:      os.Mkdir,
+		now:        time.Now,
 	}
+	collector.createdTime = collector.now()
+
+	placementHookMutex.Lock()
+	collector.placementHook = registeredPlacementHook
+	placementHookMutex.Unlock()
 
 	return collector
 }
 
+func (c *collector) setup() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Containers deliberately grouped together share monitoring group anyway.
+	if c.id != rootContainer && c.groupKey == "" {
+		owner := c.claimCgroup()
+		if owner != "" {
+			klog.Warningf("Container %q has the same cgroup %q as container %q, its tasks stay in monitoring group of container %q and it is not monitored on its own until that container is destroyed", c.id, c.cgroupPath, owner, owner)
+			c.sharesCgroupWith = owner
+			return nil
+		}
+	}
+	return c.createMonitoringGroup()
+}
+
+// createMonitoringGroup prepares monitoring group of the container and
+// recycles RMID of another container if none is free and recycling is
+// enabled.
+func (c *collector) createMonitoringGroup() error {
+	err := c.prepareMonitoringGroup()
+	if isExhausted(err) && c.recycler != nil {
+		recycleErr := c.recycler.recycle(c.id)
+		if recycleErr != nil {
+			return fmt.Errorf("%v: %w", recycleErr, err)
+		}
+		err = c.prepareMonitoringGroup()
+		if isExhausted(err) {
+			// RMID of the recycled group is not free yet.
+			klog.V(4).Infof("Waiting for RMID to be released for monitoring group of container %q: %v", c.id, err)
+			c.evicted = true
+			return nil
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if c.recycler != nil && c.groupKey == "" && c.id != rootContainer {
+		c.recycler.register(c)
+	}
+	return nil
+}
+
+// prepareMonitoringGroup creates monitoring group for the container and
+// assigns container's tasks to it. Root container is monitored by the
+// root group of resctrl filesystem.
+func (c *collector) prepareMonitoringGroup() error {
+	mountID, err := c.getMountID(rootResctrl)
+	if err != nil {
+		return err
+	}
+	c.mountID = mountID
+
+	if c.id == rootContainer {
+		c.resctrlPath = rootResctrl
+		c.controlGroupPath = rootResctrl
+		if *systemMonitoringGroup {
+			return c.prepareSystemGroup()
+		}
+		return nil
+	}
+
+	pids, err := c.containerPids()
+	if err != nil {
+		return err
+	}
+
+	controlGroupPath := rootResctrl
+	if len(pids) > 0 {
+		controlGroupPath, err = findControlGroup(pids[0])
+		if err != nil {
+			return err
+		}
+	}
+
+	if c.groupKey != "" {
+		path, err := c.acquireSharedGroup(controlGroupPath)
+		if err != nil {
+			return err
+		}
+		c.resctrlPath = path
+		c.controlGroupPath = controlGroupPath
+		c.createdTime = c.now()
+		return c.assignPids(pids)
+	}
+
+	name := monitoringGroupName(c.id)
+	if c.placementHook != nil {
+		name, err = c.placeMonitoringGroup(controlGroupPath, pids)
+		if err != nil {
+			return err
+		}
+	}
+
+	path := filepath.Join(controlGroupPath, monGroupsDirName, name)
+	cancelRemoval(path)
+	err = c.mkdir(path, os.ModePerm)
+	if err != nil && !os.IsExist(err) {
+		return fmt.Errorf("unable to create monitoring group %q for container %q: %w", path, c.id, err)
+	}
+	// Group already exists when it has been left by previous instance of
+	// the container or of cAdvisor, which is not an error.
+	if err != nil && *reuseMonitoringGroups {
+		err = c.prepareExistingGroup(path, pids)
+	} else if err != nil {
+		klog.V(4).Infof("Monitoring group %q for container %q already exists, recreating it", path, c.id)
+		err = c.recreateMonitoringGroup(path)
+	}
+	if err != nil {
+		return err
+	}
+	c.resctrlPath = path
+	c.controlGroupPath = controlGroupPath
+	c.createdTime = c.now()
+
+	return c.assignPids(pids)
+}
+
+// prepareExistingGroup checks if monitoring group left by previous instance
+// of the container can be reused, so its counters stay continuous. Group
+// that contains tasks not belonging to the container is stale and it is
+// recreated.
+func (c *collector) prepareExistingGroup(path string, pids []int) error {
+	tasks, err := readTasks(path)
+	if err != nil {
+		return err
+	}
+
+	containerPids := make(map[int]struct{}, len(pids))
+	for _, pid := range pids {
+		containerPids[pid] = struct{}{}
+	}
+	for task := range tasks {
+		if _, ok := containerPids[task]; ok {
+			continue
+		}
+		klog.V(4).Infof("Monitoring group %q contains task %d which does not belong to container %q, recreating it", path, task, c.id)
+		return c.recreateMonitoringGroup(path)
+	}
+
+	klog.V(4).Infof("Reusing monitoring group %q for container %q", path, c.id)
+	return nil
+}
+
+// recreateMonitoringGroup removes existing monitoring group and creates it
+// again, so its counters start from scratch.
+func (c *collector) recreateMonitoringGroup(path string) error {
+	err := os.RemoveAll(path)
+	if err != nil {
+		return fmt.Errorf("unable to remove existing monitoring group %q: %w", path, err)
+	}
+	err = c.mkdir(path, os.ModePerm)
+	if err != nil {
+		return fmt.Errorf("unable to create monitoring group %q for container %q: %w", path, c.id, err)
+	}
+	return nil
+}
+
+// prepareSystemGroup creates monitoring group for tasks of the default
+// control group which do not belong to any container, so they are
+// monitored separately from the containers.
+func (c *collector) prepareSystemGroup() error {
+	path := filepath.Join(rootResctrl, monGroupsDirName, systemMonitoringGroupName)
+	err := c.mkdir(path, os.ModePerm)
+	if err != nil && !os.IsExist(err) {
+		return fmt.Errorf("unable to create system monitoring group %q: %w", path, err)
+	}
+	c.systemPath = path
+
+	return c.assignSystemTasks()
+}
+
+// assignSystemTasks assigns to the system monitoring group tasks that are
+// left in the default group. Tasks of containers are moved to their own
+// monitoring groups by collectors of the containers.
+func (c *collector) assignSystemTasks() error {
+	tasks, err := readTasks(rootResctrl)
+	if err != nil {
+		return err
+	}
+	pids := make([]int, 0, len(tasks))
+	for pid := range tasks {
+		pids = append(pids, pid)
+	}
+	_, err = writeTasks(c.systemPath, pids)
+	return err
+}
+
+// updatePids assigns to the monitoring group tasks that have been
+// started in the container since the last update.
+func (c *collector) updatePids() error {
+	if c.id == rootContainer {
+		if c.systemPath != "" {
+			return c.assignSystemTasks()
+		}
+		return nil
+	}
+
+	pids, err := c.containerPids()
+	if err != nil {
+		return err
+	}
+	return c.assignPids(pids)
+}
+
+// containerPids returns tasks of the container. When they cannot be read
+// from cgroup of the container, they are discovered from /proc instead, at
+// most once per --resctrl_pids_fallback_interval since it is expensive on
+// busy systems.
+func (c *collector) containerPids() ([]int, error) {
+	pids, err := c.getPids(c.cgroupPath)
+	if err == nil {
+		if !c.fallbackPidsTime.IsZero() {
+			klog.Infof("Tasks of container %q are read from its cgroup again", c.id)
+			c.fallbackPids = nil
+			c.fallbackPidsTime = time.Time{}
+		}
+		return pids, nil
+	}
+	if *pidsFallbackInterval <= 0 {
+		return nil, fmt.Errorf("unable to get tasks of container %q: %w", c.id, err)
+	}
+
+	now := c.now()
+	if !c.fallbackPidsTime.IsZero() && now.Sub(c.fallbackPidsTime) < *pidsFallbackInterval {
+		return c.fallbackPids, nil
+	}
+	if c.fallbackPidsTime.IsZero() {
+		klog.Warningf("Unable to get tasks of container %q from its cgroup, discovering them from %s instead: %v", c.id, procPath, err)
+	}
+	pids, fallbackErr := c.findPids(c.id)
+	if fallbackErr != nil {
+		return nil, fmt.Errorf("unable to get tasks of container %q: %v, discovery from %s failed: %w", c.id, err, procPath, fallbackErr)
+	}
+	c.fallbackPids = pids
+	c.fallbackPidsTime = now
+	return pids, nil
+}
+
+// assignPids assigns tasks to the monitoring group and updates number of
+// tasks in the group.
+func (c *collector) assignPids(pids []int) error {
+	assigned, err := readTasks(c.resctrlPath)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	c.taskCount = uint64(len(assigned))
+
+	newPids := make([]int, 0, len(pids))
+	for _, pid := range pids {
+		if _, ok := assigned[pid]; !ok {
+			newPids = append(newPids, pid)
+		}
+	}
+	if len(newPids) == 0 {
+		return nil
+	}
+	written, err := writeTasks(c.resctrlPath, newPids)
+	c.taskCount += uint64(written)
+	return err
+}
+
+// recoverAfterRemount recreates the monitoring group when resctrl
+// filesystem has been remounted since all the groups are lost then.
+func (c *collector) recoverAfterRemount() error {
+	mountID, err := c.getMountID(rootResctrl)
+	if err != nil {
+		return err
+	}
+	if mountID == c.mountID {
+		return nil
+	}
+
+	klog.Infof("resctrl filesystem has been remounted, recreating monitoring group for container %q", c.id)
+	err = c.prepareMonitoringGroup()
+	if err != nil {
+		return fmt.Errorf("unable to recover monitoring group for container %q after resctrl remount: %w", c.id, err)
+	}
+	klog.Infof("Monitoring group for container %q has been recovered at %q", c.id, c.resctrlPath)
+	return nil
+}
+
+// UpdateStats assigns new tasks of the container to the monitoring group
+// and reads its monitoring counters.
 func (c *collector) UpdateStats(stats *info.ContainerStats) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
 	stats.Resctrl = info.ResctrlStats{}
 
-	resctrlStats, err := c.resctrl.GetStats()
+	err := c.refreshTasks()
+	if err != nil || c.evicted || c.sharesCgroupWith != "" {
+		return err
+	}
+	return c.readCounters(stats)
+}
+
+// RefreshTasks assigns to the monitoring group tasks that have been started
+// in the container since tasks were refreshed last time. It is the expensive
+// part of UpdateStats, so it can be called less often than ReadCounters.
+func (c *collector) RefreshTasks() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.refreshTasks()
+}
+
+// ReadCounters reads monitoring counters of the group without assigning new
+// tasks of the container to it. Task count reported in stats is the one from
+// the most recent refresh of tasks.
+func (c *collector) ReadCounters(stats *info.ContainerStats) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stats.Resctrl = info.ResctrlStats{}
+	if c.evicted || c.sharesCgroupWith != "" {
+		return nil
+	}
+
+	err := c.recoverAfterRemount()
 	if err != nil {
 		return err
 	}
+	return c.readCounters(stats)
+}
 
-	numberOfNUMANodes := len(*resctrlStats.MBMStats)
+// CreationTime returns time when the monitoring group of the container was
+// created. It changes when the group is created again, e.g. after resctrl
+// filesystem has been remounted or RMID of the group has been recycled.
+func (c *collector) CreationTime() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.createdTime
+}
 
-	stats.Resctrl.MemoryBandwidth = make([]info.MemoryBandwidthStats, 0, numberOfNUMANodes)
-	stats.Resctrl.Cache = make([]info.CacheStats, 0, numberOfNUMANodes)
+// Age returns time elapsed since the monitoring group of the container was
+// created.
+func (c *collector) Age() time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 
-	for _, numaNodeStats := range *resctrlStats.MBMStats {
-		stats.Resctrl.MemoryBandwidth = append(stats.Resctrl.MemoryBandwidth,
-			info.MemoryBandwidthStats{
-				TotalBytes: numaNodeStats.MBMTotalBytes,
-				LocalBytes: numaNodeStats.MBMLocalBytes,
-			})
+	return c.now().Sub(c.createdTime)
+}
+
+func (c *collector) refreshTasks() error {
+	if c.sharesCgroupWith != "" {
+		monitored, err := c.takeOverCgroup()
+		if err != nil || !monitored {
+			return err
+		}
+	}
+	if c.evicted {
+		regained, err := c.regainMonitoringGroup()
+		if err != nil || !regained {
+			return err
+		}
+	}
+	err := c.recoverAfterRemount()
+	if err != nil {
+		return err
 	}
+	return c.updatePids()
+}
 
-	for _, numaNodeStats := range *resctrlStats.CMTStats {
-		stats.Resctrl.Cache = append(stats.Resctrl.Cache,
-			info.CacheStats{LLCOccupancy: numaNodeStats.LLCOccupancy})
+func (c *collector) readCounters(stats *info.ContainerStats) error {
+	resctrlStats, err := c.readStats()
+	if err != nil {
+		return err
+	}
+	if c.id != rootContainer {
+		resctrlStats.TaskCount = c.taskCount
+	}
+	// Consumers key on the container, as RMID changes with the group.
+	resctrlStats.ID = c.id
+	createdTime := c.createdTime
+	resctrlStats.CreationTime = &createdTime
+	resctrlStats.RMID, err = readRMID(c.resctrlPath)
+	if err != nil {
+		return err
+	}
+	if c.systemPath != "" {
+		systemStats, err := c.getStats(c.systemPath)
+		if err != nil {
+			return err
+		}
+		resctrlStats.SystemMemoryBandwidth = systemStats.MemoryBandwidth
+		resctrlStats.SystemCache = systemStats.Cache
 	}
 
+	if enabledMBA {
+		mba, err := cachedInfo.getMBAInfo(c.mountID)
+		if err != nil {
+			return err
+		}
+		resctrlStats.MemoryBandwidthAllocation, err = getMBAStats(c.controlGroupPath, mba)
+		if err != nil {
+			return err
+		}
+	}
+	stats.Resctrl = resctrlStats
+
 	return nil
 }
+
+// readStats reads monitoring statistics of the group. Transient failure
+// is retried once and, if it repeats, the most recent statistics are
+// reported until number of consecutive failures reaches the threshold.
+func (c *collector) readStats() (info.ResctrlStats, error) {
+	stats, err := c.getStats(c.resctrlPath)
+	if err != nil && isTransient(err) {
+		stats, err = c.getStats(c.resctrlPath)
+	}
+	if err == nil {
+		now := c.now()
+		if *memoryBandwidthRate {
+			addMemoryBandwidthRate(stats.MemoryBandwidth, c.lastStats.MemoryBandwidth, now.Sub(c.lastStatsTime))
+		}
+		if *estimatedCacheLineFetches {
+			addCacheLineFetchRate(stats.MemoryBandwidth, c.lastStats.MemoryBandwidth, now.Sub(c.lastStatsTime))
+		}
+		if c.recycler != nil {
+			activity, ok := measureActivity(stats, c.lastStats, now.Sub(c.lastStatsTime))
+			if ok {
+				c.recycler.observe(c, activity)
+			}
+		}
+		c.lastStats = stats
+		c.lastStatsTime = now
+		c.transientFailures = 0
+		return stats, nil
+	}
+	if !isTransient(err) {
+		c.transientFailures = 0
+		return stats, err
+	}
+
+	c.transientFailures++
+	if c.transientFailures >= *transientErrorsThreshold {
+		return stats, fmt.Errorf("%d consecutive failures of reading monitoring data of container %q: %w", c.transientFailures, c.id, err)
+	}
+	klog.V(4).Infof("Reporting previous resctrl statistics of container %q after transient failure: %v", c.id, err)
+	return c.lastStats, nil
+}
+
+func (c *collector) Destroy() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.recycler != nil {
+		defer c.recycler.unregister(c, false)
+	}
+	c.releaseCgroup()
+	// Shared monitoring group is removed with the last of its containers.
+	lastInGroup := true
+	if c.sharesGroup {
+		lastInGroup = c.releaseSharedGroup()
+	}
+	if *destroyPolicy == destroyFinalSnapshot && c.id != rootContainer && c.resctrlPath != "" {
+		c.saveFinalSnapshot()
+	}
+	// Monitoring group is kept to be reused when the container is started again.
+	if *reuseMonitoringGroups {
+		return
+	}
+	if c.systemPath != "" {
+		err := os.RemoveAll(c.systemPath)
+		if err != nil {
+			klog.Warningf("Unable to remove system monitoring group %q: %v", c.systemPath, err)
+			return
+		}
+		c.systemPath = ""
+	}
+	if c.id == rootContainer || c.resctrlPath == "" {
+		return
+	}
+	if !lastInGroup {
+		c.resctrlPath = ""
+		return
+	}
+	if *destroyPolicy == destroyDeferred {
+		recycler := c.recycler
+		deferRemoval(c.resctrlPath, c.id, func() {
+			if recycler != nil {
+				recycler.release()
+			}
+		})
+		c.resctrlPath = ""
+		return
+	}
+
+	err := os.RemoveAll(c.resctrlPath)
+	if err != nil {
+		klog.Warningf("Unable to remove monitoring group %q for container %q: %v", c.resctrlPath, c.id, err)
+		return
+	}
+	c.resctrlPath = ""
+	if c.recycler != nil {
+		c.recycler.unregister(c, true)
+	}
+}
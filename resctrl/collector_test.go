@@ -0,0 +1,766 @@
+//go:build linux
+// +build linux
+
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Collector of resctrl for a container.
+package resctrl
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/sys/unix"
+
+	info "github.com/google/cadvisor/info/v1"
+)
+
+// mockResctrl creates fake resctrl filesystem and returns function that removes it.
+func mockResctrl(t *testing.T) func() {
+	root, err := ioutil.TempDir("", "resctrl")
+	assert.NoError(t, err)
+	assert.NoError(t, os.Mkdir(filepath.Join(root, infoDirName), os.ModePerm))
+	assert.NoError(t, os.Mkdir(filepath.Join(root, monGroupsDirName), os.ModePerm))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(root, tasksFileName), []byte{}, 0644))
+
+	rootResctrl = root
+	enabledMBM = true
+	enabledCMT = true
+	// CPUs of monitoring domains are not known unless mocked.
+	cpuSysfsPath = filepath.Join(root, "nonexistent")
+	l3DomainCPUs = &domainCPUs{}
+
+	return func() {
+		os.RemoveAll(root)
+		rootResctrl = ""
+		enabledMBM = false
+		enabledCMT = false
+		enabledMBA = false
+		cachedInfo = &infoCache{}
+		cpuSysfsPath = "/sys/devices/system/cpu"
+		l3DomainCPUs = &domainCPUs{}
+		reportedDomains = nil
+		cgroupOwners = map[string]*collector{}
+	}
+}
+
+func mockMonData(t *testing.T, groupPath string, domain string, totalBytes, localBytes, llcOccupancy uint64) {
+	domainPath := filepath.Join(groupPath, monDataDirName, domain)
+	assert.NoError(t, os.MkdirAll(domainPath, os.ModePerm))
+	for name, value := range map[string]uint64{
+		mbmTotalBytesFileName: totalBytes,
+		mbmLocalBytesFileName: localBytes,
+		llcOccupancyFileName:  llcOccupancy,
+	} {
+		assert.NoError(t, ioutil.WriteFile(filepath.Join(domainPath, name), []byte(fmt.Sprintf("%d\n", value)), 0644))
+	}
+}
+
+func newMockCollector(id string, pids []int, mount *mountID) *collector {
+	collector := newCollector(id, "/sys/fs/cgroup/cpu"+id)
+	collector.getPids = func(string) ([]int, error) {
+		return pids, nil
+	}
+	collector.getMountID = func(string) (mountID, error) {
+		return *mount, nil
+	}
+	return collector
+}
+
+func TestCollectorSetup(t *testing.T) {
+	defer mockResctrl(t)()
+	mount := &mountID{dev: 1, ino: 1}
+
+	collector := newMockCollector("/docker/container", []int{1, 2}, mount)
+	err := collector.setup()
+	assert.NoError(t, err)
+
+	expectedPath := filepath.Join(rootResctrl, monGroupsDirName, "cadvisor-docker-container")
+	assert.Equal(t, expectedPath, collector.resctrlPath)
+	tasks, err := readTasks(expectedPath)
+	assert.NoError(t, err)
+	assert.Equal(t, map[int]struct{}{1: {}, 2: {}}, tasks)
+
+	collector.Destroy()
+	_, err = os.Stat(expectedPath)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestCollectorSetupRootContainer(t *testing.T) {
+	defer mockResctrl(t)()
+	mount := &mountID{dev: 1, ino: 1}
+
+	collector := newMockCollector(rootContainer, nil, mount)
+	err := collector.setup()
+	assert.NoError(t, err)
+	assert.Equal(t, rootResctrl, collector.resctrlPath)
+
+	// Root group is never removed.
+	collector.Destroy()
+	_, err = os.Stat(rootResctrl)
+	assert.NoError(t, err)
+}
+
+func TestCollectorUpdateStats(t *testing.T) {
+	defer mockResctrl(t)()
+	mount := &mountID{dev: 1, ino: 1}
+
+	collector := newMockCollector("/container", []int{1}, mount)
+	err := collector.setup()
+	assert.NoError(t, err)
+	mockMonData(t, collector.resctrlPath, "mon_L3_00", 100, 50, 1024)
+	mockMonData(t, collector.resctrlPath, "mon_L3_01", 200, 150, 2048)
+
+	stats := &info.ContainerStats{}
+	err = collector.UpdateStats(stats)
+	assert.NoError(t, err)
+	assert.Equal(t, info.ResctrlStats{
+		ID:           "/container",
+		CreationTime: &collector.createdTime,
+		MemoryBandwidth: []info.MemoryBandwidthStats{
+			{TotalBytes: 100, LocalBytes: 50},
+			{TotalBytes: 200, LocalBytes: 150},
+		},
+		Cache: []info.CacheStats{
+			{LLCOccupancy: 1024, CacheID: 0},
+			{LLCOccupancy: 2048, CacheID: 1},
+		},
+		TaskCount: 1,
+	}, stats.Resctrl)
+}
+
+func TestCollectorUpdateStatsMemoryBandwidthRate(t *testing.T) {
+	defer mockResctrl(t)()
+	*memoryBandwidthRate = true
+	defer func() { *memoryBandwidthRate = false }()
+	mount := &mountID{dev: 1, ino: 1}
+	now := time.Date(2020, time.October, 1, 12, 0, 0, 0, time.UTC)
+
+	collector := newMockCollector("/container", []int{1}, mount)
+	collector.now = func() time.Time {
+		return now
+	}
+	err := collector.setup()
+	assert.NoError(t, err)
+	mockMonData(t, collector.resctrlPath, "mon_L3_00", 1000, 500, 1024)
+	mockMonData(t, collector.resctrlPath, "mon_L3_01", 2000, 1500, 2048)
+
+	// Rate is not known after the first measurement.
+	stats := &info.ContainerStats{}
+	err = collector.UpdateStats(stats)
+	assert.NoError(t, err)
+	assert.Equal(t, []info.MemoryBandwidthStats{
+		{TotalBytes: 1000, LocalBytes: 500},
+		{TotalBytes: 2000, LocalBytes: 1500},
+	}, stats.Resctrl.MemoryBandwidth)
+
+	now = now.Add(2 * time.Second)
+	mockMonData(t, collector.resctrlPath, "mon_L3_00", 3000, 1500, 1024)
+	mockMonData(t, collector.resctrlPath, "mon_L3_01", 2100, 1600, 2048)
+	err = collector.UpdateStats(stats)
+	assert.NoError(t, err)
+	assert.Equal(t, []info.MemoryBandwidthStats{
+		{TotalBytes: 3000, LocalBytes: 1500, TotalBytesPerSecond: 1000, LocalBytesPerSecond: 500},
+		{TotalBytes: 2100, LocalBytes: 1600, TotalBytesPerSecond: 50, LocalBytesPerSecond: 50},
+	}, stats.Resctrl.MemoryBandwidth)
+
+	// Rate is not reported when counters went backwards.
+	now = now.Add(2 * time.Second)
+	mockMonData(t, collector.resctrlPath, "mon_L3_00", 100, 50, 1024)
+	err = collector.UpdateStats(stats)
+	assert.NoError(t, err)
+	assert.Equal(t, []info.MemoryBandwidthStats{
+		{TotalBytes: 100, LocalBytes: 50},
+		{TotalBytes: 2100, LocalBytes: 1600},
+	}, stats.Resctrl.MemoryBandwidth)
+}
+
+// mockGetStats returns function that returns results in order.
+func mockGetStats(results ...error) (func(string) (info.ResctrlStats, error), *int) {
+	calls := 0
+	return func(string) (info.ResctrlStats, error) {
+		err := results[calls]
+		calls++
+		if err != nil {
+			return info.ResctrlStats{}, err
+		}
+		return info.ResctrlStats{Cache: []info.CacheStats{{LLCOccupancy: uint64(calls)}}}, nil
+	}, &calls
+}
+
+func TestCollectorUpdateStatsTransientError(t *testing.T) {
+	defer mockResctrl(t)()
+	mount := &mountID{dev: 1, ino: 1}
+	transient := fmt.Errorf("unable to read counter: %w", errUnavailable)
+
+	collector := newMockCollector("/container", []int{1}, mount)
+	err := collector.setup()
+	assert.NoError(t, err)
+
+	// Transient failure is retried within the same update.
+	var calls *int
+	collector.getStats, calls = mockGetStats(transient, nil)
+	stats := &info.ContainerStats{}
+	err = collector.UpdateStats(stats)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, *calls)
+	assert.Equal(t, []info.CacheStats{{LLCOccupancy: 2}}, stats.Resctrl.Cache)
+
+	// Previous statistics are reported until the threshold is reached.
+	collector.getStats, calls = mockGetStats(transient, transient, transient, transient, transient, transient)
+	for i := 1; i < *transientErrorsThreshold; i++ {
+		err = collector.UpdateStats(stats)
+		assert.NoError(t, err)
+		assert.Equal(t, []info.CacheStats{{LLCOccupancy: 2}}, stats.Resctrl.Cache)
+	}
+	err = collector.UpdateStats(stats)
+	assert.Error(t, err)
+	assert.True(t, errors.Is(err, errUnavailable))
+	assert.Equal(t, 2*(*transientErrorsThreshold), *calls)
+
+	// Success resets number of consecutive failures.
+	collector.getStats, _ = mockGetStats(nil, transient, transient)
+	err = collector.UpdateStats(stats)
+	assert.NoError(t, err)
+	err = collector.UpdateStats(stats)
+	assert.NoError(t, err)
+	assert.Equal(t, []info.CacheStats{{LLCOccupancy: 1}}, stats.Resctrl.Cache)
+}
+
+func TestCollectorUpdateStatsPermanentError(t *testing.T) {
+	defer mockResctrl(t)()
+	mount := &mountID{dev: 1, ino: 1}
+
+	collector := newMockCollector("/container", []int{1}, mount)
+	err := collector.setup()
+	assert.NoError(t, err)
+
+	var calls *int
+	collector.getStats, calls = mockGetStats(fmt.Errorf("unable to parse counter"))
+	err = collector.UpdateStats(&info.ContainerStats{})
+	assert.Error(t, err)
+	assert.Equal(t, 1, *calls)
+}
+
+func TestCollectorSystemMonitoringGroup(t *testing.T) {
+	defer mockResctrl(t)()
+	*systemMonitoringGroup = true
+	defer func() { *systemMonitoringGroup = false }()
+	mount := &mountID{dev: 1, ino: 1}
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(rootResctrl, tasksFileName), []byte("1\n2\n"), 0644))
+
+	collector := newMockCollector(rootContainer, nil, mount)
+	err := collector.setup()
+	assert.NoError(t, err)
+	assert.Equal(t, rootResctrl, collector.resctrlPath)
+
+	systemPath := filepath.Join(rootResctrl, monGroupsDirName, systemMonitoringGroupName)
+	tasks, err := readTasks(systemPath)
+	assert.NoError(t, err)
+	assert.Equal(t, map[int]struct{}{1: {}, 2: {}}, tasks)
+
+	// Root group counts all the tasks of the default control group.
+	mockMonData(t, rootResctrl, "mon_L3_00", 1000, 500, 4096)
+	mockMonData(t, systemPath, "mon_L3_00", 100, 50, 1024)
+
+	stats := &info.ContainerStats{}
+	err = collector.UpdateStats(stats)
+	assert.NoError(t, err)
+	assert.Equal(t, info.ResctrlStats{
+		ID:                    "/",
+		CreationTime:          &collector.createdTime,
+		MemoryBandwidth:       []info.MemoryBandwidthStats{{TotalBytes: 1000, LocalBytes: 500}},
+		Cache:                 []info.CacheStats{{LLCOccupancy: 4096}},
+		SystemMemoryBandwidth: []info.MemoryBandwidthStats{{TotalBytes: 100, LocalBytes: 50}},
+		SystemCache:           []info.CacheStats{{LLCOccupancy: 1024}},
+	}, stats.Resctrl)
+
+	collector.Destroy()
+	_, err = os.Stat(systemPath)
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat(rootResctrl)
+	assert.NoError(t, err)
+}
+
+func TestCollectorRecoveryAfterRemount(t *testing.T) {
+	defer mockResctrl(t)()
+	mount := &mountID{dev: 1, ino: 1}
+
+	collector := newMockCollector("/container", []int{1, 2}, mount)
+	err := collector.setup()
+	assert.NoError(t, err)
+	groupPath := collector.resctrlPath
+	mockMonData(t, groupPath, "mon_L3_00", 100, 50, 1024)
+
+	stats := &info.ContainerStats{}
+	err = collector.UpdateStats(stats)
+	assert.NoError(t, err)
+
+	// Remounting resctrl filesystem removes all the groups.
+	assert.NoError(t, os.RemoveAll(groupPath))
+	*mount = mountID{dev: 2, ino: 1}
+
+	// Monitoring group is recreated but the kernel has not reported any data for it yet.
+	err = collector.UpdateStats(stats)
+	assert.Error(t, err)
+	assert.Equal(t, groupPath, collector.resctrlPath)
+	tasks, err := readTasks(groupPath)
+	assert.NoError(t, err)
+	assert.Equal(t, map[int]struct{}{1: {}, 2: {}}, tasks)
+
+	mockMonData(t, groupPath, "mon_L3_00", 10, 5, 512)
+	err = collector.UpdateStats(stats)
+	assert.NoError(t, err)
+	assert.Equal(t, info.ResctrlStats{
+		ID:              "/container",
+		CreationTime:    &collector.createdTime,
+		MemoryBandwidth: []info.MemoryBandwidthStats{{TotalBytes: 10, LocalBytes: 5}},
+		Cache:           []info.CacheStats{{LLCOccupancy: 512}},
+		TaskCount:       2,
+	}, stats.Resctrl)
+}
+
+func TestCollectorCreationTime(t *testing.T) {
+	defer mockResctrl(t)()
+	mount := &mountID{dev: 1, ino: 1}
+
+	collector := newMockCollector("/container", []int{1}, mount)
+	assert.False(t, collector.CreationTime().IsZero())
+
+	now := time.Unix(1000, 0)
+	collector.now = func() time.Time {
+		return now
+	}
+	err := collector.setup()
+	assert.NoError(t, err)
+	assert.Equal(t, now, collector.CreationTime())
+	assert.Equal(t, time.Duration(0), collector.Age())
+
+	now = now.Add(5 * time.Second)
+	assert.Equal(t, 5*time.Second, collector.Age())
+	now = now.Add(5 * time.Second)
+	assert.Equal(t, 10*time.Second, collector.Age())
+
+	// Monitoring group created again after remount is younger.
+	assert.NoError(t, os.RemoveAll(collector.resctrlPath))
+	*mount = mountID{dev: 2, ino: 1}
+	assert.NoError(t, collector.RefreshTasks())
+	assert.Equal(t, now, collector.CreationTime())
+	assert.Equal(t, time.Duration(0), collector.Age())
+
+	// Creation time is reported with statistics of the group.
+	mockMonData(t, collector.resctrlPath, "mon_L3_00", 10, 5, 512)
+	stats := &info.ContainerStats{}
+	assert.NoError(t, collector.ReadCounters(stats))
+	assert.Equal(t, &now, stats.Resctrl.CreationTime)
+}
+
+func TestCollectorStableID(t *testing.T) {
+	defer mockResctrl(t)()
+	mount := &mountID{dev: 1, ino: 1}
+
+	collector := newMockCollector("/container", []int{1}, mount)
+	assert.NoError(t, collector.setup())
+	mockMonData(t, collector.resctrlPath, "mon_L3_00", 100, 50, 1024)
+
+	// RMID is not reported unless resctrl filesystem exposes it.
+	stats := info.ContainerStats{}
+	assert.NoError(t, collector.UpdateStats(&stats))
+	assert.Equal(t, "/container", stats.Resctrl.ID)
+	assert.Nil(t, stats.Resctrl.RMID)
+
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(collector.resctrlPath, monHWIDFileName), []byte("5\n"), 0644))
+	assert.NoError(t, collector.UpdateStats(&stats))
+	assert.Equal(t, "/container", stats.Resctrl.ID)
+	assert.Equal(t, uint64(5), *stats.Resctrl.RMID)
+
+	// Monitoring group created again after remount gets another RMID.
+	assert.NoError(t, os.RemoveAll(collector.resctrlPath))
+	*mount = mountID{dev: 2, ino: 1}
+	assert.NoError(t, collector.RefreshTasks())
+	mockMonData(t, collector.resctrlPath, "mon_L3_00", 10, 5, 512)
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(collector.resctrlPath, monHWIDFileName), []byte("7\n"), 0644))
+	assert.NoError(t, collector.UpdateStats(&stats))
+	assert.Equal(t, "/container", stats.Resctrl.ID)
+	assert.Equal(t, uint64(7), *stats.Resctrl.RMID)
+}
+
+func TestCollectorUpdatePids(t *testing.T) {
+	defer mockResctrl(t)()
+	mount := &mountID{dev: 1, ino: 1}
+
+	pids := []int{1}
+	collector := newMockCollector("/container", nil, mount)
+	collector.getPids = func(string) ([]int, error) {
+		return pids, nil
+	}
+	err := collector.setup()
+	assert.NoError(t, err)
+
+	pids = []int{1, 3}
+	err = collector.updatePids()
+	assert.NoError(t, err)
+
+	content, err := ioutil.ReadFile(filepath.Join(collector.resctrlPath, tasksFileName))
+	assert.NoError(t, err)
+	// Already assigned tasks are not written again.
+	assert.Equal(t, "1\n3\n", string(content))
+}
+
+func TestCollectorPidsFallback(t *testing.T) {
+	defer mockResctrl(t)()
+	mount := &mountID{dev: 1, ino: 1}
+	now := time.Date(2020, time.October, 1, 12, 0, 0, 0, time.UTC)
+
+	collector := newMockCollector("/container", nil, mount)
+	collector.getPids = func(string) ([]int, error) {
+		return nil, os.ErrPermission
+	}
+	discovered := []int{1, 2}
+	discoveries := 0
+	collector.findPids = func(containerName string) ([]int, error) {
+		assert.Equal(t, "/container", containerName)
+		discoveries++
+		return discovered, nil
+	}
+	collector.now = func() time.Time {
+		return now
+	}
+	err := collector.setup()
+	assert.NoError(t, err)
+	tasks, err := readTasks(collector.resctrlPath)
+	assert.NoError(t, err)
+	assert.Equal(t, map[int]struct{}{1: {}, 2: {}}, tasks)
+
+	// Tasks are not discovered again until the interval elapses.
+	discovered = []int{1, 2, 3}
+	now = now.Add(*pidsFallbackInterval / 2)
+	err = collector.updatePids()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, discoveries)
+	assert.Equal(t, uint64(2), collector.taskCount)
+
+	now = now.Add(*pidsFallbackInterval)
+	err = collector.updatePids()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, discoveries)
+	assert.Equal(t, uint64(3), collector.taskCount)
+
+	// Fallback is not used once tasks can be read from the cgroup again.
+	collector.getPids = func(string) ([]int, error) {
+		return []int{1, 2, 3, 4}, nil
+	}
+	err = collector.updatePids()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, discoveries)
+	assert.Equal(t, uint64(4), collector.taskCount)
+	assert.True(t, collector.fallbackPidsTime.IsZero())
+}
+
+func TestCollectorPidsFallbackDisabled(t *testing.T) {
+	defer mockResctrl(t)()
+	interval := *pidsFallbackInterval
+	*pidsFallbackInterval = 0
+	defer func() { *pidsFallbackInterval = interval }()
+	mount := &mountID{dev: 1, ino: 1}
+
+	collector := newMockCollector("/container", nil, mount)
+	collector.getPids = func(string) ([]int, error) {
+		return nil, os.ErrPermission
+	}
+	collector.findPids = func(string) ([]int, error) {
+		t.Fatal("tasks must not be discovered when fallback is disabled")
+		return nil, nil
+	}
+	err := collector.setup()
+	assert.True(t, errors.Is(err, os.ErrPermission))
+}
+
+func TestCollectorUpdateStatsWithMBA(t *testing.T) {
+	defer mockResctrl(t)()
+	mount := &mountID{dev: 1, ino: 1}
+	enabledMBA = true
+	mockMBAInfo(t, rootResctrl, "1", "10")
+
+	controlGroupPath := filepath.Join(rootResctrl, "clos")
+	assert.NoError(t, os.MkdirAll(filepath.Join(controlGroupPath, monGroupsDirName), os.ModePerm))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(controlGroupPath, tasksFileName), []byte("5\n"), 0644))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(controlGroupPath, schemataFileName), []byte("MB:0=50\n"), 0644))
+
+	collector := newMockCollector("/container", []int{5}, mount)
+	err := collector.setup()
+	assert.NoError(t, err)
+	assert.Equal(t, controlGroupPath, collector.controlGroupPath)
+	mockMonData(t, collector.resctrlPath, "mon_L3_00", 100, 50, 1024)
+
+	stats := &info.ContainerStats{}
+	err = collector.UpdateStats(stats)
+	assert.NoError(t, err)
+	assert.Equal(t, []info.MemoryBandwidthAllocationStats{
+		{Domain: 0, BandwidthPercentage: 50, Delay: 50, DelayLinear: true},
+	}, stats.Resctrl.MemoryBandwidthAllocation)
+}
+
+func TestCollectorTaskCount(t *testing.T) {
+	defer mockResctrl(t)()
+	mount := &mountID{dev: 1, ino: 1}
+
+	pids := []int{1, 2}
+	collector := newMockCollector("/container", nil, mount)
+	collector.getPids = func(string) ([]int, error) {
+		return pids, nil
+	}
+	err := collector.setup()
+	assert.NoError(t, err)
+	mockMonData(t, collector.resctrlPath, "mon_L3_00", 100, 50, 1024)
+
+	stats := &info.ContainerStats{}
+	err = collector.UpdateStats(stats)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(2), stats.Resctrl.TaskCount)
+
+	pids = []int{1, 2, 3, 4}
+	err = collector.UpdateStats(stats)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(4), stats.Resctrl.TaskCount)
+}
+
+func TestCollectorReadCounters(t *testing.T) {
+	defer mockResctrl(t)()
+	mount := &mountID{dev: 1, ino: 1}
+
+	pids := []int{1, 2}
+	getPidsCalls := 0
+	collector := newMockCollector("/container", nil, mount)
+	collector.getPids = func(string) ([]int, error) {
+		getPidsCalls++
+		return pids, nil
+	}
+	err := collector.setup()
+	assert.NoError(t, err)
+	mockMonData(t, collector.resctrlPath, "mon_L3_00", 100, 50, 1024)
+	getPidsCalls = 0
+
+	// New tasks are not assigned to the group when counters are read.
+	pids = []int{1, 2, 3}
+	stats := &info.ContainerStats{}
+	err = collector.ReadCounters(stats)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, getPidsCalls)
+	assert.Equal(t, []info.MemoryBandwidthStats{{TotalBytes: 100, LocalBytes: 50}}, stats.Resctrl.MemoryBandwidth)
+	assert.Equal(t, []info.CacheStats{{LLCOccupancy: 1024}}, stats.Resctrl.Cache)
+	assert.Equal(t, uint64(2), stats.Resctrl.TaskCount)
+	tasks, err := readTasks(collector.resctrlPath)
+	assert.NoError(t, err)
+	assert.Equal(t, map[int]struct{}{1: {}, 2: {}}, tasks)
+
+	mockMonData(t, collector.resctrlPath, "mon_L3_00", 300, 150, 2048)
+	err = collector.ReadCounters(stats)
+	assert.NoError(t, err)
+	assert.Equal(t, []info.MemoryBandwidthStats{{TotalBytes: 300, LocalBytes: 150}}, stats.Resctrl.MemoryBandwidth)
+	assert.Equal(t, 0, getPidsCalls)
+}
+
+func TestCollectorRefreshTasks(t *testing.T) {
+	defer mockResctrl(t)()
+	mount := &mountID{dev: 1, ino: 1}
+
+	pids := []int{1}
+	collector := newMockCollector("/container", nil, mount)
+	collector.getPids = func(string) ([]int, error) {
+		return pids, nil
+	}
+	getStatsCalls := 0
+	collector.getStats = func(string) (info.ResctrlStats, error) {
+		getStatsCalls++
+		return info.ResctrlStats{}, nil
+	}
+	err := collector.setup()
+	assert.NoError(t, err)
+
+	// Counters are not read when tasks are refreshed.
+	pids = []int{1, 2, 3}
+	err = collector.RefreshTasks()
+	assert.NoError(t, err)
+	assert.Equal(t, 0, getStatsCalls)
+	tasks, err := readTasks(collector.resctrlPath)
+	assert.NoError(t, err)
+	assert.Equal(t, map[int]struct{}{1: {}, 2: {}, 3: {}}, tasks)
+
+	// Task count from the refresh is reported with counters.
+	stats := &info.ContainerStats{}
+	err = collector.ReadCounters(stats)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, getStatsCalls)
+	assert.Equal(t, uint64(3), stats.Resctrl.TaskCount)
+}
+
+func TestCollectorInfoCache(t *testing.T) {
+	defer mockResctrl(t)()
+	mount := &mountID{dev: 1, ino: 1}
+	enabledMBA = true
+	mockMBAInfo(t, rootResctrl, "1", "10")
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(rootResctrl, schemataFileName), []byte("MB:0=50\n"), 0644))
+
+	collector := newMockCollector("/container", []int{1}, mount)
+	err := collector.setup()
+	assert.NoError(t, err)
+	mockMonData(t, collector.resctrlPath, "mon_L3_00", 100, 50, 1024)
+
+	stats := &info.ContainerStats{}
+	err = collector.UpdateStats(stats)
+	assert.NoError(t, err)
+	expected := []info.MemoryBandwidthAllocationStats{{Domain: 0, BandwidthPercentage: 50, Delay: 50, DelayLinear: true}}
+	assert.Equal(t, expected, stats.Resctrl.MemoryBandwidthAllocation)
+
+	// Info directory is not read again.
+	assert.NoError(t, os.RemoveAll(filepath.Join(rootResctrl, infoDirName)))
+	err = collector.UpdateStats(stats)
+	assert.NoError(t, err)
+	assert.Equal(t, expected, stats.Resctrl.MemoryBandwidthAllocation)
+
+	// Info directory is read again after remount.
+	mockMBAInfo(t, rootResctrl, "0", "10")
+	assert.NoError(t, os.RemoveAll(collector.resctrlPath))
+	*mount = mountID{dev: 2, ino: 1}
+	err = collector.UpdateStats(stats)
+	assert.Error(t, err)
+	mockMonData(t, collector.resctrlPath, "mon_L3_00", 100, 50, 1024)
+	err = collector.UpdateStats(stats)
+	assert.NoError(t, err)
+	assert.Equal(t, []info.MemoryBandwidthAllocationStats{{Domain: 0, Delay: 50, DelayLinear: false}}, stats.Resctrl.MemoryBandwidthAllocation)
+}
+
+func TestCollectorReuseMonitoringGroup(t *testing.T) {
+	defer mockResctrl(t)()
+	*reuseMonitoringGroups = true
+	defer func() { *reuseMonitoringGroups = false }()
+	mount := &mountID{dev: 1, ino: 1}
+
+	collector := newMockCollector("/container", []int{1}, mount)
+	err := collector.setup()
+	assert.NoError(t, err)
+	groupPath := collector.resctrlPath
+	mockMonData(t, groupPath, "mon_L3_00", 100, 50, 1024)
+
+	// Group is kept after the container stops.
+	collector.Destroy()
+	_, err = os.Stat(groupPath)
+	assert.NoError(t, err)
+
+	// Restarted container reuses the group and its counters.
+	collector = newMockCollector("/container", []int{1, 2}, mount)
+	err = collector.setup()
+	assert.NoError(t, err)
+	assert.Equal(t, groupPath, collector.resctrlPath)
+	tasks, err := readTasks(groupPath)
+	assert.NoError(t, err)
+	assert.Equal(t, map[int]struct{}{1: {}, 2: {}}, tasks)
+
+	stats := &info.ContainerStats{}
+	err = collector.UpdateStats(stats)
+	assert.NoError(t, err)
+	assert.Equal(t, []info.MemoryBandwidthStats{{TotalBytes: 100, LocalBytes: 50}}, stats.Resctrl.MemoryBandwidth)
+}
+
+func TestCollectorReuseStaleMonitoringGroup(t *testing.T) {
+	defer mockResctrl(t)()
+	*reuseMonitoringGroups = true
+	defer func() { *reuseMonitoringGroups = false }()
+	mount := &mountID{dev: 1, ino: 1}
+
+	collector := newMockCollector("/container", []int{1, 2}, mount)
+	err := collector.setup()
+	assert.NoError(t, err)
+	groupPath := collector.resctrlPath
+	mockMonData(t, groupPath, "mon_L3_00", 100, 50, 1024)
+
+	// Tasks of the previous container do not belong to the new one.
+	collector = newMockCollector("/container", []int{3}, mount)
+	err = collector.setup()
+	assert.NoError(t, err)
+	assert.Equal(t, groupPath, collector.resctrlPath)
+	tasks, err := readTasks(groupPath)
+	assert.NoError(t, err)
+	assert.Equal(t, map[int]struct{}{3: {}}, tasks)
+	_, err = os.Stat(filepath.Join(groupPath, monDataDirName))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestCollectorExistingMonitoringGroupWithoutReuse(t *testing.T) {
+	defer mockResctrl(t)()
+	mount := &mountID{dev: 1, ino: 1}
+
+	collector := newMockCollector("/container", []int{1}, mount)
+	err := collector.setup()
+	assert.NoError(t, err)
+
+	groupPath := collector.resctrlPath
+	mockMonData(t, groupPath, "mon_L3_00", 100, 50, 1024)
+
+	// Group left e.g. by previous instance of cAdvisor is created from scratch.
+	collector = newMockCollector("/container", []int{1}, mount)
+	err = collector.setup()
+	assert.NoError(t, err)
+	assert.Equal(t, groupPath, collector.resctrlPath)
+	tasks, err := readTasks(groupPath)
+	assert.NoError(t, err)
+	assert.Equal(t, map[int]struct{}{1: {}}, tasks)
+	_, err = os.Stat(filepath.Join(groupPath, monDataDirName))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestCollectorRecreateMonitoringGroupFailure(t *testing.T) {
+	defer mockResctrl(t)()
+	mount := &mountID{dev: 1, ino: 1}
+
+	collector := newMockCollector("/container", []int{1}, mount)
+	err := collector.setup()
+	assert.NoError(t, err)
+	groupPath := collector.resctrlPath
+
+	// Group is recreated with the same mkdir as it is created with, so
+	// running out of RMIDs is reported.
+	collector = newMockCollector("/container", []int{1}, mount)
+	collector.mkdir = func(path string, perm os.FileMode) error {
+		if _, err := os.Stat(path); err == nil {
+			return os.ErrExist
+		}
+		return &os.PathError{Op: "mkdir", Path: path, Err: unix.ENOSPC}
+	}
+	err = collector.setup()
+	assert.Error(t, err)
+	assert.True(t, errors.Is(err, unix.ENOSPC))
+	_, err = os.Stat(groupPath)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestCollectorSetupFailure(t *testing.T) {
+	defer mockResctrl(t)()
+	mount := &mountID{dev: 1, ino: 1}
+	// Failure other than existing group is reported.
+	assert.NoError(t, os.RemoveAll(filepath.Join(rootResctrl, monGroupsDirName)))
+
+	collector := newMockCollector("/container", []int{1}, mount)
+	err := collector.setup()
+	assert.Error(t, err)
+	assert.True(t, errors.Is(err, os.ErrNotExist))
+}
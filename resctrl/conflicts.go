@@ -0,0 +1,76 @@
+// +build linux
+
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Containers that share cgroup.
+package resctrl
+
+import (
+	"sync"
+
+	"k8s.io/klog/v2"
+)
+
+var (
+	// Collectors that monitor tasks of each cgroup by cgroup path. Task
+	// can be assigned to a single monitoring group only, so another
+	// container with the same cgroup would take over its tasks and both
+	// groups would report wrong counts.
+	cgroupOwners      = map[string]*collector{}
+	cgroupOwnersMutex sync.Mutex
+)
+
+// claimCgroup makes the collector the one that monitors tasks of its
+// cgroup. If collector of another container already does, name of that
+// container is returned. Collector created again for the same container
+// replaces the previous one.
+func (c *collector) claimCgroup() string {
+	cgroupOwnersMutex.Lock()
+	defer cgroupOwnersMutex.Unlock()
+
+	owner, ok := cgroupOwners[c.cgroupPath]
+	if ok && owner != c && owner.id != c.id {
+		return owner.id
+	}
+	cgroupOwners[c.cgroupPath] = c
+	return ""
+}
+
+// releaseCgroup lets another collector monitor tasks of the cgroup if the
+// collector has been monitoring them.
+func (c *collector) releaseCgroup() {
+	cgroupOwnersMutex.Lock()
+	defer cgroupOwnersMutex.Unlock()
+
+	if cgroupOwners[c.cgroupPath] == c {
+		delete(cgroupOwners, c.cgroupPath)
+	}
+}
+
+// takeOverCgroup starts monitoring of a container that shares cgroup with
+// another container once the other container has been destroyed. It
+// returns false if the container is still not monitored.
+func (c *collector) takeOverCgroup() (bool, error) {
+	if c.claimCgroup() != "" {
+		return false, nil
+	}
+	klog.Infof("Container %q sharing cgroup %q with container %q that has been destroyed is monitored by its own monitoring group now", c.id, c.cgroupPath, c.sharesCgroupWith)
+	c.sharesCgroupWith = ""
+	err := c.createMonitoringGroup()
+	if err != nil || c.evicted {
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,66 @@
+// +build linux
+
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Containers that share cgroup tests.
+package resctrl
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	info "github.com/google/cadvisor/info/v1"
+)
+
+func TestCollectorSharedCgroup(t *testing.T) {
+	defer mockResctrl(t)()
+	mount := &mountID{dev: 1, ino: 1}
+
+	first := newMockCollector("/first", []int{1, 2}, mount)
+	assert.NoError(t, first.setup())
+	firstPath := first.resctrlPath
+	assert.NotEmpty(t, firstPath)
+
+	// Second container with the same cgroup does not take over the tasks.
+	second := newMockCollector("/second", []int{1, 2}, mount)
+	second.cgroupPath = first.cgroupPath
+	assert.NoError(t, second.setup())
+	assert.Equal(t, "/first", second.sharesCgroupWith)
+	assert.Empty(t, second.resctrlPath)
+	_, err := readTasks(filepath.Join(rootResctrl, monGroupsDirName, "cadvisor-second"))
+	assert.Error(t, err)
+
+	stats := info.ContainerStats{}
+	assert.NoError(t, second.UpdateStats(&stats))
+	assert.Equal(t, info.ResctrlStats{}, stats.Resctrl)
+	mockMonData(t, firstPath, "mon_L3_00", 100, 50, 1024)
+	assert.NoError(t, first.UpdateStats(&stats))
+	assert.Equal(t, uint64(100), stats.Resctrl.MemoryBandwidth[0].TotalBytes)
+	tasks, err := readTasks(firstPath)
+	assert.NoError(t, err)
+	assert.Equal(t, map[int]struct{}{1: {}, 2: {}}, tasks)
+
+	// Tasks are monitored by the other container once the first one is destroyed.
+	first.Destroy()
+	assert.NoError(t, second.refreshTasks())
+	assert.Empty(t, second.sharesCgroupWith)
+	assert.NotEmpty(t, second.resctrlPath)
+	tasks, err = readTasks(second.resctrlPath)
+	assert.NoError(t, err)
+	assert.Equal(t, map[int]struct{}{1: {}, 2: {}}, tasks)
+	second.Destroy()
+}
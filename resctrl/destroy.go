@@ -0,0 +1,225 @@
+// +build linux
+
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Policies of removing monitoring groups of destroyed containers.
+package resctrl
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/klog/v2"
+
+	info "github.com/google/cadvisor/info/v1"
+)
+
+const (
+	// Monitoring group is removed when container is destroyed.
+	destroyImmediate = "immediate"
+	// Monitoring group is removed after grace period, so final counters
+	// can be read from resctrl filesystem in the meantime.
+	destroyDeferred = "deferred"
+	// Counters are read once more and kept for grace period, monitoring
+	// group is removed when container is destroyed.
+	destroyFinalSnapshot = "final_snapshot"
+)
+
+var (
+	// Deferred removals of monitoring groups by path.
+	pendingRemovals      = map[string]*pendingRemoval{}
+	pendingRemovalsMutex sync.Mutex
+
+	// Final statistics of destroyed containers by container name.
+	finalSnapshots      = map[string]*finalSnapshot{}
+	finalSnapshotsMutex sync.Mutex
+
+	// Handle for mocking purposes.
+	afterFunc = time.AfterFunc
+)
+
+type pendingRemoval struct {
+	timer         *time.Timer
+	containerName string
+	released      func()
+}
+
+type finalSnapshot struct {
+	stats info.ResctrlStats
+	timer *time.Timer
+}
+
+// validateDestroyPolicy checks if destruction policy of monitoring groups
+// is known.
+func validateDestroyPolicy(policy string, gracePeriod time.Duration) error {
+	switch policy {
+	case destroyImmediate:
+		return nil
+	case destroyDeferred, destroyFinalSnapshot:
+		if gracePeriod <= 0 {
+			return fmt.Errorf("grace period of resctrl destroy policy %q has to be positive", policy)
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown resctrl destroy policy %q, expected one of %q, %q, %q", policy, destroyImmediate, destroyDeferred, destroyFinalSnapshot)
+}
+
+// FinalStats returns statistics of monitoring group of destroyed container
+// read when the container was destroyed. They are available for grace
+// period after that if final_snapshot destroy policy is used.
+func FinalStats(containerName string) (info.ResctrlStats, bool) {
+	finalSnapshotsMutex.Lock()
+	defer finalSnapshotsMutex.Unlock()
+
+	snapshot, ok := finalSnapshots[containerName]
+	if !ok {
+		return info.ResctrlStats{}, false
+	}
+	return snapshot.stats, true
+}
+
+// saveFinalSnapshot reads statistics of the monitoring group once more and
+// keeps them for the grace period.
+func (c *collector) saveFinalSnapshot() {
+	stats, err := c.getStats(c.resctrlPath)
+	if err != nil {
+		klog.Warningf("Unable to read final statistics of monitoring group %q for container %q: %v", c.resctrlPath, c.id, err)
+		return
+	}
+	stats.TaskCount = c.taskCount
+
+	finalSnapshotsMutex.Lock()
+	defer finalSnapshotsMutex.Unlock()
+	if previous, ok := finalSnapshots[c.id]; ok {
+		previous.timer.Stop()
+	}
+	snapshot := &finalSnapshot{stats: stats}
+	id := c.id
+	snapshot.timer = afterFunc(*destroyGracePeriod, func() {
+		finalSnapshotsMutex.Lock()
+		defer finalSnapshotsMutex.Unlock()
+		if finalSnapshots[id] == snapshot {
+			delete(finalSnapshots, id)
+		}
+	})
+	finalSnapshots[id] = snapshot
+}
+
+// deferRemoval removes the monitoring group after the grace period, unless
+// another container creates group at the same path in the meantime.
+// Released is called once the group is removed.
+func deferRemoval(path string, containerName string, released func()) {
+	pendingRemovalsMutex.Lock()
+	defer pendingRemovalsMutex.Unlock()
+
+	if previous, ok := pendingRemovals[path]; ok {
+		previous.timer.Stop()
+	}
+	removal := &pendingRemoval{containerName: containerName, released: released}
+	removal.timer = afterFunc(*destroyGracePeriod, func() {
+		pendingRemovalsMutex.Lock()
+		defer pendingRemovalsMutex.Unlock()
+		if pendingRemovals[path] != removal {
+			return
+		}
+		delete(pendingRemovals, path)
+		removal.remove(path)
+	})
+	pendingRemovals[path] = removal
+}
+
+func (r *pendingRemoval) remove(path string) {
+	err := os.RemoveAll(path)
+	if err != nil {
+		klog.Warningf("Unable to remove monitoring group %q of destroyed container %q: %v", path, r.containerName, err)
+		return
+	}
+	r.released()
+}
+
+// removePendingGroups removes right away monitoring groups whose removal
+// has been deferred, so their RMIDs are not left behind when cAdvisor stops.
+func removePendingGroups() {
+	pendingRemovalsMutex.Lock()
+	defer pendingRemovalsMutex.Unlock()
+
+	for path, removal := range pendingRemovals {
+		removal.timer.Stop()
+		removal.remove(path)
+	}
+	pendingRemovals = map[string]*pendingRemoval{}
+}
+
+// cancelRemoval cancels deferred removal of monitoring group at the path,
+// because it is going to be used by another container.
+func cancelRemoval(path string) {
+	pendingRemovalsMutex.Lock()
+	defer pendingRemovalsMutex.Unlock()
+
+	if removal, ok := pendingRemovals[path]; ok {
+		removal.timer.Stop()
+		delete(pendingRemovals, path)
+	}
+}
+
+// removeStaleGroups removes monitoring groups of containers and shared
+// monitoring groups from the default and all other control groups. Groups
+// are left in resctrl filesystem when cAdvisor does not stop cleanly.
+func removeStaleGroups() {
+	files, err := ioutil.ReadDir(rootResctrl)
+	if err != nil {
+		klog.Warningf("Unable to read resctrl filesystem at %q: %v", rootResctrl, err)
+		return
+	}
+	controlGroups := []string{rootResctrl}
+	for _, file := range files {
+		if !file.IsDir() || file.Name() == infoDirName || file.Name() == monDataDirName || file.Name() == monGroupsDirName {
+			continue
+		}
+		controlGroups = append(controlGroups, filepath.Join(rootResctrl, file.Name()))
+	}
+
+	for _, controlGroup := range controlGroups {
+		monGroups := filepath.Join(controlGroup, monGroupsDirName)
+		groups, err := ioutil.ReadDir(monGroups)
+		if err != nil {
+			klog.Warningf("Unable to read monitoring groups at %q: %v", monGroups, err)
+			continue
+		}
+		for _, group := range groups {
+			if !group.IsDir() || !isStaleGroupName(group.Name()) {
+				continue
+			}
+			path := filepath.Join(monGroups, group.Name())
+			err = os.RemoveAll(path)
+			if err != nil {
+				klog.Warningf("Unable to remove stale monitoring group %q: %v", path, err)
+				continue
+			}
+			klog.V(4).Infof("Removed stale monitoring group %q", path)
+		}
+	}
+}
+
+// isStaleGroupName checks if the monitoring group is created by cAdvisor
+// for containers, so it is not used by anyone once cAdvisor starts.
+func isStaleGroupName(name string) bool {
+	return strings.HasPrefix(name, monitoringGroupPrefix+"-") || strings.HasPrefix(name, sharedMonitoringGroupPrefix)
+}
@@ -0,0 +1,204 @@
+// +build linux
+
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Policies of removing monitoring groups of destroyed containers.
+package resctrl
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	info "github.com/google/cadvisor/info/v1"
+)
+
+// mockDestroyPolicy sets destroy policy and collects functions scheduled
+// after grace period instead of running them.
+func mockDestroyPolicy(policy string, scheduled *[]func()) func() {
+	*destroyPolicy = policy
+	afterFunc = func(d time.Duration, f func()) *time.Timer {
+		*scheduled = append(*scheduled, f)
+		return time.NewTimer(time.Hour)
+	}
+	return func() {
+		*destroyPolicy = destroyImmediate
+		afterFunc = time.AfterFunc
+		pendingRemovals = map[string]*pendingRemoval{}
+		finalSnapshots = map[string]*finalSnapshot{}
+	}
+}
+
+func TestCollectorDestroyImmediate(t *testing.T) {
+	defer mockResctrl(t)()
+	var scheduled []func()
+	defer mockDestroyPolicy(destroyImmediate, &scheduled)()
+	mount := &mountID{dev: 1, ino: 1}
+
+	collector := newMockCollector("/container", []int{1}, mount)
+	assert.NoError(t, collector.setup())
+	path := collector.resctrlPath
+
+	collector.Destroy()
+	_, err := os.Stat(path)
+	assert.True(t, os.IsNotExist(err))
+	assert.Empty(t, scheduled)
+	_, ok := FinalStats("/container")
+	assert.False(t, ok)
+}
+
+func TestCollectorDestroyDeferred(t *testing.T) {
+	defer mockResctrl(t)()
+	var scheduled []func()
+	defer mockDestroyPolicy(destroyDeferred, &scheduled)()
+	mount := &mountID{dev: 1, ino: 1}
+	r := newRecycler()
+
+	collector := newMockCollector("/container", []int{1}, mount)
+	collector.recycler = r
+	assert.NoError(t, collector.setup())
+	path := collector.resctrlPath
+
+	// Group is kept for the grace period.
+	collector.Destroy()
+	_, err := os.Stat(path)
+	assert.NoError(t, err)
+	assert.Len(t, scheduled, 1)
+	assert.Equal(t, uint64(0), r.releasedGroups())
+
+	// And removed after it.
+	scheduled[0]()
+	_, err = os.Stat(path)
+	assert.True(t, os.IsNotExist(err))
+	assert.Equal(t, uint64(1), r.releasedGroups())
+}
+
+func TestCollectorDestroyDeferredRestarted(t *testing.T) {
+	defer mockResctrl(t)()
+	var scheduled []func()
+	defer mockDestroyPolicy(destroyDeferred, &scheduled)()
+	mount := &mountID{dev: 1, ino: 1}
+
+	collector := newMockCollector("/container", []int{1}, mount)
+	assert.NoError(t, collector.setup())
+	collector.Destroy()
+
+	// Container started again within the grace period takes over its group.
+	restarted := newMockCollector("/container", []int{2}, mount)
+	assert.NoError(t, restarted.setup())
+	scheduled[0]()
+	tasks, err := readTasks(restarted.resctrlPath)
+	assert.NoError(t, err)
+	assert.Equal(t, map[int]struct{}{2: {}}, tasks)
+
+	restarted.Destroy()
+	assert.Len(t, scheduled, 2)
+}
+
+func TestManagerDestroyRemovesPendingGroups(t *testing.T) {
+	defer mockResctrl(t)()
+	var scheduled []func()
+	defer mockDestroyPolicy(destroyDeferred, &scheduled)()
+	mount := &mountID{dev: 1, ino: 1}
+	r := newRecycler()
+
+	collector := newMockCollector("/container", []int{1}, mount)
+	collector.recycler = r
+	assert.NoError(t, collector.setup())
+	path := collector.resctrlPath
+	collector.Destroy()
+
+	// Group is not left behind when cAdvisor stops within the grace period.
+	m := &manager{recycler: r}
+	m.Destroy()
+	_, err := os.Stat(path)
+	assert.True(t, os.IsNotExist(err))
+	assert.Equal(t, uint64(1), r.releasedGroups())
+	assert.Empty(t, pendingRemovals)
+
+	// Timer that fires anyway does nothing.
+	scheduled[0]()
+	assert.Equal(t, uint64(1), r.releasedGroups())
+}
+
+func TestRemoveStaleGroups(t *testing.T) {
+	defer mockResctrl(t)()
+	controlGroup := filepath.Join(rootResctrl, "group")
+	groups := []string{
+		filepath.Join(rootResctrl, monGroupsDirName, "cadvisor-docker-1"),
+		filepath.Join(rootResctrl, monGroupsDirName, "cadvisor_shared-pod"),
+		filepath.Join(controlGroup, monGroupsDirName, "cadvisor-docker-2"),
+	}
+	kept := []string{
+		filepath.Join(rootResctrl, monGroupsDirName, systemMonitoringGroupName),
+		filepath.Join(rootResctrl, monGroupsDirName, "other"),
+		filepath.Join(controlGroup, monGroupsDirName, "other"),
+	}
+	for _, path := range append(groups, kept...) {
+		assert.NoError(t, os.MkdirAll(path, os.ModePerm))
+	}
+
+	removeStaleGroups()
+	for _, path := range groups {
+		_, err := os.Stat(path)
+		assert.True(t, os.IsNotExist(err), path)
+	}
+	for _, path := range kept {
+		_, err := os.Stat(path)
+		assert.NoError(t, err, path)
+	}
+}
+
+func TestCollectorDestroyFinalSnapshot(t *testing.T) {
+	defer mockResctrl(t)()
+	var scheduled []func()
+	defer mockDestroyPolicy(destroyFinalSnapshot, &scheduled)()
+	mount := &mountID{dev: 1, ino: 1}
+
+	collector := newMockCollector("/container", []int{1, 2}, mount)
+	assert.NoError(t, collector.setup())
+	path := collector.resctrlPath
+	mockMonData(t, path, "mon_L3_00", 10, 5, 512)
+	assert.NoError(t, collector.UpdateStats(&info.ContainerStats{}))
+	// Counters increase after the last update.
+	mockMonData(t, path, "mon_L3_00", 100, 50, 1024)
+
+	// Group is removed, but its final statistics are kept.
+	collector.Destroy()
+	_, err := os.Stat(path)
+	assert.True(t, os.IsNotExist(err))
+	stats, ok := FinalStats("/container")
+	assert.True(t, ok)
+	assert.Equal(t, uint64(2), stats.TaskCount)
+	assert.Equal(t, []info.MemoryBandwidthStats{{TotalBytes: 100, LocalBytes: 50}}, stats.MemoryBandwidth)
+	assert.Equal(t, []info.CacheStats{{LLCOccupancy: 1024}}, stats.Cache)
+
+	// Statistics are dropped after the grace period.
+	assert.Len(t, scheduled, 1)
+	scheduled[0]()
+	_, ok = FinalStats("/container")
+	assert.False(t, ok)
+}
+
+func TestValidateDestroyPolicy(t *testing.T) {
+	assert.NoError(t, validateDestroyPolicy(destroyImmediate, 0))
+	assert.NoError(t, validateDestroyPolicy(destroyDeferred, time.Minute))
+	assert.NoError(t, validateDestroyPolicy(destroyFinalSnapshot, time.Minute))
+	assert.Error(t, validateDestroyPolicy(destroyDeferred, 0))
+	assert.Error(t, validateDestroyPolicy("retain", time.Minute))
+}
@@ -0,0 +1,211 @@
+// +build linux
+
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Mapping of resctrl monitoring domains to CPUs.
+package resctrl
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"k8s.io/klog/v2"
+)
+
+const (
+	l3DomainPrefix = "mon_L3_"
+	l3CacheLevel   = 3
+)
+
+// Mapping of L3 cache ids to CPUs. It is read once as topology of caches
+// does not change.
+var l3DomainCPUs = &domainCPUs{}
+
+// Ids of monitoring domains that statistics are reported for, nil if all
+// of them are reported.
+var reportedDomains map[uint64]struct{}
+
+// domainCPUs holds CPUs of each monitoring domain. Monitoring domains are
+// L3 caches, which usually correspond to NUMA nodes.
+type domainCPUs struct {
+	mu     sync.Mutex
+	loaded bool
+	cpus   map[uint64][]int
+}
+
+// get returns CPUs of monitoring domain that monitoring data is read from
+// directory domainDirName of, e.g. mon_L3_01. Nil is returned if the CPUs
+// are not known.
+func (d *domainCPUs) get(domainDirName string) []int {
+	id, ok := l3CacheID(domainDirName)
+	if !ok {
+		return nil
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if !d.loaded {
+		var err error
+		d.cpus, err = readL3CacheCPUs(cpuSysfsPath)
+		if err != nil {
+			klog.Warningf("Unable to read CPUs of resctrl monitoring domains: %v", err)
+		}
+		d.loaded = true
+	}
+	return d.cpus[id]
+}
+
+// l3CacheID returns id of L3 cache that monitoring data is read from
+// directory domainDirName of, e.g. 1 for mon_L3_01.
+func l3CacheID(domainDirName string) (uint64, bool) {
+	if !strings.HasPrefix(domainDirName, l3DomainPrefix) {
+		return 0, false
+	}
+	id, err := strconv.ParseUint(strings.TrimPrefix(domainDirName, l3DomainPrefix), 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return id, true
+}
+
+// readL3CacheCPUs reads CPUs that share each L3 cache from sysfs.
+func readL3CacheCPUs(path string) (map[uint64][]int, error) {
+	cacheDirs, err := filepath.Glob(filepath.Join(path, "cpu[0-9]*", "cache", "index[0-9]*"))
+	if err != nil {
+		return nil, err
+	}
+
+	cpus := map[uint64][]int{}
+	for _, cacheDir := range cacheDirs {
+		level, err := readInfoValue(cacheDir, "level")
+		if err != nil {
+			return nil, err
+		}
+		if level != l3CacheLevel {
+			continue
+		}
+		id, err := readInfoValue(cacheDir, "id")
+		if err != nil {
+			return nil, err
+		}
+		cpuDirName := filepath.Base(filepath.Dir(filepath.Dir(cacheDir)))
+		cpu, err := strconv.Atoi(strings.TrimPrefix(cpuDirName, "cpu"))
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse CPU of %q: %w", cacheDir, err)
+		}
+		cpus[id] = append(cpus[id], cpu)
+	}
+	for _, domainCPUs := range cpus {
+		sort.Ints(domainCPUs)
+	}
+	return cpus, nil
+}
+
+// isReportedDomain checks if statistics of monitoring domain that data is
+// read from directory domainDirName of are reported.
+func isReportedDomain(domainDirName string) bool {
+	if reportedDomains == nil {
+		return true
+	}
+	id, ok := l3CacheID(domainDirName)
+	if !ok {
+		return false
+	}
+	_, ok = reportedDomains[id]
+	return ok
+}
+
+// parseNUMANodes parses comma-separated list of NUMA node ids.
+func parseNUMANodes(list string) ([]int, error) {
+	nodes := []int{}
+	for _, item := range strings.Split(list, ",") {
+		node, err := strconv.Atoi(strings.TrimSpace(item))
+		if err != nil || node < 0 {
+			return nil, fmt.Errorf("invalid NUMA node %q in %q", item, list)
+		}
+		nodes = append(nodes, node)
+	}
+	return nodes, nil
+}
+
+// numaNodeDomains returns ids of monitoring domains that CPUs of the NUMA
+// nodes belong to. Nodes that do not exist or have no CPUs in any
+// monitoring domain, e.g. nodes with memory only, are an error.
+func numaNodeDomains(path string, nodes []int) (map[uint64]struct{}, error) {
+	nodeCPUs, err := readNUMANodeCPUs(path)
+	if err != nil {
+		return nil, err
+	}
+	l3CPUs, err := readL3CacheCPUs(path)
+	if err != nil {
+		return nil, err
+	}
+	cpuDomains := map[int]uint64{}
+	for id, cpus := range l3CPUs {
+		for _, cpu := range cpus {
+			cpuDomains[cpu] = id
+		}
+	}
+
+	domains := map[uint64]struct{}{}
+	for _, node := range nodes {
+		cpus, ok := nodeCPUs[node]
+		if !ok {
+			available := make([]int, 0, len(nodeCPUs))
+			for node := range nodeCPUs {
+				available = append(available, node)
+			}
+			sort.Ints(available)
+			return nil, fmt.Errorf("NUMA node %d does not exist, available nodes: %v", node, available)
+		}
+		found := false
+		for _, cpu := range cpus {
+			if id, ok := cpuDomains[cpu]; ok {
+				domains[id] = struct{}{}
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("no resctrl monitoring domain contains CPUs of NUMA node %d", node)
+		}
+	}
+	return domains, nil
+}
+
+// readNUMANodeCPUs reads CPUs of each NUMA node from sysfs.
+func readNUMANodeCPUs(path string) (map[int][]int, error) {
+	nodeDirs, err := filepath.Glob(filepath.Join(path, "cpu[0-9]*", "node[0-9]*"))
+	if err != nil {
+		return nil, err
+	}
+
+	cpus := map[int][]int{}
+	for _, nodeDir := range nodeDirs {
+		node, err := strconv.Atoi(strings.TrimPrefix(filepath.Base(nodeDir), "node"))
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse NUMA node of %q: %w", nodeDir, err)
+		}
+		cpu, err := strconv.Atoi(strings.TrimPrefix(filepath.Base(filepath.Dir(nodeDir)), "cpu"))
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse CPU of %q: %w", nodeDir, err)
+		}
+		cpus[node] = append(cpus[node], cpu)
+	}
+	return cpus, nil
+}
@@ -0,0 +1,127 @@
+// +build linux
+
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Mapping of resctrl monitoring domains to CPUs.
+package resctrl
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	info "github.com/google/cadvisor/info/v1"
+)
+
+// mockCaches creates fake sysfs CPU directory where each CPU has L2 cache
+// of its own and L3 cache with given id.
+func mockCaches(t *testing.T, path string, cpuToL3 map[int]int) {
+	for cpu, l3 := range cpuToL3 {
+		for index, cache := range []struct{ level, id int }{{2, cpu}, {3, l3}} {
+			cacheDir := filepath.Join(path, fmt.Sprintf("cpu%d", cpu), "cache", fmt.Sprintf("index%d", index))
+			assert.NoError(t, os.MkdirAll(cacheDir, os.ModePerm))
+			assert.NoError(t, ioutil.WriteFile(filepath.Join(cacheDir, "level"), []byte(fmt.Sprintf("%d\n", cache.level)), 0644))
+			assert.NoError(t, ioutil.WriteFile(filepath.Join(cacheDir, "id"), []byte(fmt.Sprintf("%d\n", cache.id)), 0644))
+		}
+	}
+}
+
+func TestReadL3CacheCPUs(t *testing.T) {
+	path, err := ioutil.TempDir("", "cpu")
+	assert.NoError(t, err)
+	defer os.RemoveAll(path)
+	// Two sockets with SMT siblings numbered after all the cores.
+	mockCaches(t, path, map[int]int{0: 0, 1: 0, 2: 1, 3: 1, 4: 0, 5: 0, 6: 1, 7: 1})
+
+	cpus, err := readL3CacheCPUs(path)
+	assert.NoError(t, err)
+	assert.Equal(t, map[uint64][]int{0: {0, 1, 4, 5}, 1: {2, 3, 6, 7}}, cpus)
+}
+
+func TestCollectorUpdateStatsDomainCPUs(t *testing.T) {
+	defer mockResctrl(t)()
+	path, err := ioutil.TempDir("", "cpu")
+	assert.NoError(t, err)
+	defer os.RemoveAll(path)
+	mockCaches(t, path, map[int]int{0: 0, 1: 1, 2: 0, 3: 1})
+	cpuSysfsPath = path
+	mount := &mountID{dev: 1, ino: 1}
+
+	collector := newMockCollector("/container", []int{1}, mount)
+	err = collector.setup()
+	assert.NoError(t, err)
+	mockMonData(t, collector.resctrlPath, "mon_L3_00", 100, 50, 1024)
+	mockMonData(t, collector.resctrlPath, "mon_L3_01", 200, 150, 2048)
+
+	stats := &info.ContainerStats{}
+	err = collector.UpdateStats(stats)
+	assert.NoError(t, err)
+	assert.Equal(t, []info.MemoryBandwidthStats{
+		{TotalBytes: 100, LocalBytes: 50, CPUs: []int{0, 2}},
+		{TotalBytes: 200, LocalBytes: 150, CPUs: []int{1, 3}},
+	}, stats.Resctrl.MemoryBandwidth)
+}
+
+func TestCollectorUpdateStatsNUMANodes(t *testing.T) {
+	defer mockResctrl(t)()
+	path, err := ioutil.TempDir("", "cpu")
+	assert.NoError(t, err)
+	defer os.RemoveAll(path)
+	// Four sockets with single NUMA node each.
+	mockCaches(t, path, map[int]int{0: 0, 1: 1, 2: 2, 3: 3})
+	for cpu := 0; cpu < 4; cpu++ {
+		assert.NoError(t, os.MkdirAll(filepath.Join(path, fmt.Sprintf("cpu%d", cpu), fmt.Sprintf("node%d", cpu)), os.ModePerm))
+	}
+	cpuSysfsPath = path
+
+	_, err = numaNodeDomains(path, []int{1, 4})
+	assert.EqualError(t, err, "NUMA node 4 does not exist, available nodes: [0 1 2 3]")
+	reportedDomains, err = numaNodeDomains(path, []int{1, 3})
+	assert.NoError(t, err)
+
+	mount := &mountID{dev: 1, ino: 1}
+	collector := newMockCollector("/container", []int{1}, mount)
+	err = collector.setup()
+	assert.NoError(t, err)
+	mockMonData(t, collector.resctrlPath, "mon_L3_00", 100, 50, 1024)
+	mockMonData(t, collector.resctrlPath, "mon_L3_01", 200, 150, 2048)
+	mockMonData(t, collector.resctrlPath, "mon_L3_02", 300, 250, 3072)
+	mockMonData(t, collector.resctrlPath, "mon_L3_03", 400, 350, 4096)
+
+	stats := &info.ContainerStats{}
+	err = collector.UpdateStats(stats)
+	assert.NoError(t, err)
+	assert.Equal(t, []info.MemoryBandwidthStats{
+		{TotalBytes: 200, LocalBytes: 150, CPUs: []int{1}},
+		{TotalBytes: 400, LocalBytes: 350, CPUs: []int{3}},
+	}, stats.Resctrl.MemoryBandwidth)
+	assert.Equal(t, []info.CacheStats{
+		{LLCOccupancy: 2048, CacheID: 1},
+		{LLCOccupancy: 4096, CacheID: 3},
+	}, stats.Resctrl.Cache)
+}
+
+func TestParseNUMANodes(t *testing.T) {
+	nodes, err := parseNUMANodes("0, 2")
+	assert.NoError(t, err)
+	assert.Equal(t, []int{0, 2}, nodes)
+
+	_, err = parseNUMANodes("0,x")
+	assert.EqualError(t, err, `invalid NUMA node "x" in "0,x"`)
+}
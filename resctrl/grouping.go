@@ -0,0 +1,148 @@
+// +build linux
+
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Monitoring groups shared by containers.
+package resctrl
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"k8s.io/klog/v2"
+)
+
+const sharedMonitoringGroupPrefix = "cadvisor_shared-"
+
+// GroupingFunc returns key of monitoring group shared by all the containers
+// with the same key, e.g. value of tenant label of the container. Container
+// is monitored by its own monitoring group if empty key is returned.
+type GroupingFunc func(containerName string, labels map[string]string) string
+
+var (
+	registeredGroupingFunc GroupingFunc
+	groupingFuncMutex      sync.Mutex
+
+	// Shared monitoring groups by key.
+	sharedGroups      = map[string]*sharedGroup{}
+	sharedGroupsMutex sync.Mutex
+)
+
+// sharedGroup is monitoring group that tasks of several containers are
+// assigned to. It is removed when the last of the containers is destroyed.
+type sharedGroup struct {
+	path             string
+	controlGroupPath string
+	mountID          mountID
+	refs             int
+}
+
+// RegisterGroupingFunc registers function that assigns containers, for
+// which collectors are created afterwards, to shared monitoring groups.
+func RegisterGroupingFunc(grouping GroupingFunc) {
+	groupingFuncMutex.Lock()
+	defer groupingFuncMutex.Unlock()
+	registeredGroupingFunc = grouping
+}
+
+// groupKey returns key of shared monitoring group of the container or empty
+// key if the container is not grouped.
+func groupKey(containerName string, labels map[string]string) string {
+	groupingFuncMutex.Lock()
+	grouping := registeredGroupingFunc
+	groupingFuncMutex.Unlock()
+
+	if grouping == nil || containerName == rootContainer {
+		return ""
+	}
+	return grouping(containerName, labels)
+}
+
+// sharedMonitoringGroupName returns name of monitoring group shared by
+// containers with the key.
+func sharedMonitoringGroupName(key string) string {
+	return sharedMonitoringGroupPrefix + strings.Replace(key, "/", "-", -1)
+}
+
+// acquireSharedGroup returns path of the shared monitoring group of the
+// container, creating the group if the container is the first one in it.
+// The group is created again when resctrl filesystem has been remounted.
+func (c *collector) acquireSharedGroup(controlGroupPath string) (string, error) {
+	sharedGroupsMutex.Lock()
+	defer sharedGroupsMutex.Unlock()
+
+	group, ok := sharedGroups[c.groupKey]
+	if ok && group.refs > 0 {
+		if group.controlGroupPath != controlGroupPath {
+			return "", fmt.Errorf("container %q belongs to control group %q, while monitoring group %q shared with it is in %q", c.id, controlGroupPath, group.path, group.controlGroupPath)
+		}
+		if group.mountID != c.mountID {
+			err := os.Mkdir(group.path, os.ModePerm)
+			if err != nil && !os.IsExist(err) {
+				return "", fmt.Errorf("unable to create shared monitoring group %q for container %q: %w", group.path, c.id, err)
+			}
+			group.mountID = c.mountID
+		}
+		if !c.sharesGroup {
+			group.refs++
+			c.sharesGroup = true
+		}
+		return group.path, nil
+	}
+
+	path := filepath.Join(controlGroupPath, monGroupsDirName, sharedMonitoringGroupName(c.groupKey))
+	cancelRemoval(path)
+	err := os.Mkdir(path, os.ModePerm)
+	if err != nil && !os.IsExist(err) {
+		return "", fmt.Errorf("unable to create shared monitoring group %q for container %q: %w", path, c.id, err)
+	}
+	// Tasks of any container with the key may be left in the group, so it
+	// is reused regardless of its tasks.
+	if err != nil && !*reuseMonitoringGroups {
+		klog.V(4).Infof("Shared monitoring group %q already exists, recreating it", path)
+		err = c.recreateMonitoringGroup(path)
+		if err != nil {
+			return "", err
+		}
+	}
+	sharedGroups[c.groupKey] = &sharedGroup{path: path, controlGroupPath: controlGroupPath, mountID: c.mountID, refs: 1}
+	c.sharesGroup = true
+	return path, nil
+}
+
+// releaseSharedGroup removes the container from its shared monitoring group
+// and returns true if the container was the last one in it.
+func (c *collector) releaseSharedGroup() bool {
+	sharedGroupsMutex.Lock()
+	defer sharedGroupsMutex.Unlock()
+
+	if !c.sharesGroup {
+		return false
+	}
+	c.sharesGroup = false
+	group, ok := sharedGroups[c.groupKey]
+	if !ok {
+		return false
+	}
+	group.refs--
+	if group.refs > 0 {
+		return false
+	}
+	delete(sharedGroups, c.groupKey)
+	return true
+}
@@ -0,0 +1,102 @@
+// +build linux
+
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Monitoring groups shared by containers.
+package resctrl
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	info "github.com/google/cadvisor/info/v1"
+)
+
+func mockGroupingByLabel(label string) func() {
+	RegisterGroupingFunc(func(containerName string, labels map[string]string) string {
+		return labels[label]
+	})
+	return func() {
+		RegisterGroupingFunc(nil)
+		sharedGroups = map[string]*sharedGroup{}
+	}
+}
+
+func TestCollectorSharedMonitoringGroup(t *testing.T) {
+	defer mockResctrl(t)()
+	defer mockGroupingByLabel("tenant")()
+	mount := &mountID{dev: 1, ino: 1}
+
+	first := newMockCollector("/first", []int{1}, mount)
+	first.groupKey = groupKey("/first", map[string]string{"tenant": "blue"})
+	second := newMockCollector("/second", []int{2}, mount)
+	second.groupKey = groupKey("/second", map[string]string{"tenant": "blue"})
+	other := newMockCollector("/other", []int{3}, mount)
+	other.groupKey = groupKey("/other", map[string]string{"team": "red"})
+	for _, collector := range []*collector{first, second, other} {
+		assert.NoError(t, collector.setup())
+	}
+
+	// Containers with the same label value share monitoring group.
+	sharedPath := filepath.Join(rootResctrl, monGroupsDirName, "cadvisor_shared-blue")
+	assert.Equal(t, sharedPath, first.resctrlPath)
+	assert.Equal(t, sharedPath, second.resctrlPath)
+	tasks, err := readTasks(sharedPath)
+	assert.NoError(t, err)
+	assert.Equal(t, map[int]struct{}{1: {}, 2: {}}, tasks)
+	// Container without the label has its own group.
+	assert.Equal(t, filepath.Join(rootResctrl, monGroupsDirName, "cadvisor-other"), other.resctrlPath)
+
+	// Both containers report statistics of the whole group.
+	mockMonData(t, sharedPath, "mon_L3_00", 100, 50, 1024)
+	for _, collector := range []*collector{first, second} {
+		stats := &info.ContainerStats{}
+		assert.NoError(t, collector.UpdateStats(stats))
+		assert.Equal(t, uint64(2), stats.Resctrl.TaskCount)
+		assert.Equal(t, []info.CacheStats{{LLCOccupancy: 1024}}, stats.Resctrl.Cache)
+	}
+
+	// Group is removed with the last of its containers.
+	first.Destroy()
+	_, err = os.Stat(sharedPath)
+	assert.NoError(t, err)
+	second.Destroy()
+	_, err = os.Stat(sharedPath)
+	assert.True(t, os.IsNotExist(err))
+	other.Destroy()
+}
+
+func TestCollectorSharedMonitoringGroupRecreated(t *testing.T) {
+	defer mockResctrl(t)()
+	defer mockGroupingByLabel("tenant")()
+	mount := &mountID{dev: 1, ino: 1}
+
+	first := newMockCollector("/first", []int{1}, mount)
+	first.groupKey = "blue"
+	assert.NoError(t, first.setup())
+	first.Destroy()
+
+	// Group created after the previous one was removed starts empty.
+	second := newMockCollector("/second", []int{2}, mount)
+	second.groupKey = "blue"
+	assert.NoError(t, second.setup())
+	tasks, err := readTasks(second.resctrlPath)
+	assert.NoError(t, err)
+	assert.Equal(t, map[int]struct{}{2: {}}, tasks)
+	second.Destroy()
+}
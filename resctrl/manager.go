@@ -18,31 +18,166 @@
 package resctrl
 
 import (
-	"os"
+	"flag"
+	"time"
 
+	info "github.com/google/cadvisor/info/v1"
 	"github.com/google/cadvisor/stats"
 
 	"github.com/opencontainers/runc/libcontainer/intelrdt"
 )
 
+var systemMonitoringGroup = flag.Bool("resctrl_system_monitoring_group", false, "Monitor tasks of the default resctrl control group that do not belong to any container in a dedicated monitoring group and report them separately for the root container. The group uses one additional RMID.")
+
+var memoryBandwidthRate = flag.Bool("resctrl_memory_bandwidth_rate", false, "Report memory bandwidth in bytes per second since the previous measurement alongside cumulative number of bytes.")
+
+var estimatedCacheLineFetches = flag.Bool("resctrl_estimated_cache_line_fetches", false, "Report estimate of last level cache misses per second derived from local memory bandwidth, assuming 64 byte cache lines, for platforms without a direct counter.")
+
+var transientErrorsThreshold = flag.Int("resctrl_transient_errors_threshold", 3, "Number of consecutive transient failures of reading resctrl monitoring counters, e.g. when counter is unavailable, after which an error is reported. Previous values are reported until then.")
+
+var pidsFallbackInterval = flag.Duration("resctrl_pids_fallback_interval", 30*time.Second, "Minimum interval between scans of /proc that discover tasks of a container when they cannot be read from its cgroup. Tasks found by the previous scan are used in between. Zero disables the fallback.")
+
+var reuseMonitoringGroups = flag.Bool("resctrl_reuse_monitoring_groups", false, "Reuse existing resctrl monitoring group of a container, e.g. after the container is restarted, instead of creating a new one. Monitoring groups are not removed when containers are stopped.")
+
+var recycleMonitoringGroups = flag.Bool("resctrl_recycle_monitoring_groups", false, "When no RMID is free for monitoring group of a new container, recycle RMID of the container with the lowest memory bandwidth. The container that loses its monitoring group is not monitored until monitoring group of another container is removed.")
+
+var destroyPolicy = flag.String("resctrl_destroy_policy", destroyImmediate, "What happens to resctrl monitoring group of a container when the container is destroyed: \"immediate\" removes the group, \"deferred\" removes it after --resctrl_destroy_grace_period, so final counters can be read from resctrl filesystem, \"final_snapshot\" reads its counters once more, keeps them for --resctrl_destroy_grace_period and removes the group.")
+
+var destroyGracePeriod = flag.Duration("resctrl_destroy_grace_period", time.Minute, "Time for which monitoring group or final statistics of destroyed container are kept with \"deferred\" or \"final_snapshot\" --resctrl_destroy_policy.")
+
+var numaNodes = flag.String("resctrl_numa_nodes", "", "Comma-separated list of NUMA node ids that resctrl monitoring statistics are reported for, e.g. \"0,2\". Statistics of monitoring domains (L3 caches) that CPUs of the nodes belong to are reported. All nodes are reported if empty.")
+
+// Manager is responsible for creating resctrl collectors. As opposed to
+// stats.Manager it needs container's cgroup path to find tasks that have
+// to be monitored.
+type Manager interface {
+	Destroy()
+	GetCollector(containerName string, cgroupPath string, labels map[string]string) (Collector, error)
+}
+
+// Collector of resctrl statistics of a container. Besides UpdateStats,
+// which does both, programs that embed cAdvisor can assign new tasks of the
+// container to its monitoring group and read monitoring counters at
+// independent intervals.
+type Collector interface {
+	stats.Collector
+
+	// RefreshTasks assigns to the monitoring group tasks that have been
+	// started in the container since tasks were refreshed last time.
+	RefreshTasks() error
+
+	// ReadCounters reads monitoring counters of the group without
+	// assigning new tasks of the container to it.
+	ReadCounters(stats *info.ContainerStats) error
+
+	// CreationTime returns time when the monitoring group of the container
+	// was created.
+	CreationTime() time.Time
+
+	// Age returns time elapsed since the monitoring group of the container
+	// was created.
+	Age() time.Duration
+}
+
 type manager struct {
-	id string
-	stats.NoopDestroy
+	// Recycler of RMIDs, nil if recycling is disabled.
+	recycler *recycler
 }
 
-func (m manager) GetCollector(resctrlPath string) (stats.Collector, error) {
-	if _, err := os.Stat(resctrlPath); err != nil {
-		return &stats.NoopCollector{}, err
+// Destroy removes monitoring groups of destroyed containers that are kept
+// for the grace period, as nothing would remove them after cAdvisor stops.
+func (m *manager) Destroy() {
+	removePendingGroups()
+}
+
+func (m *manager) GetCollector(containerName string, cgroupPath string, labels map[string]string) (Collector, error) {
+	collector := newCollector(containerName, cgroupPath)
+	collector.groupKey = groupKey(containerName, labels)
+	collector.recycler = m.recycler
+	err := collector.setup()
+	if err != nil {
+		return &NoopCollector{}, err
 	}
-	collector := newCollector(m.id, resctrlPath)
 	return collector, nil
 }
 
-func NewManager(id string) (stats.Manager, error) {
+func NewManager() (Manager, error) {
+	if !intelrdt.IsMBMEnabled() && !intelrdt.IsCMTEnabled() {
+		return &NoopManager{}, nil
+	}
+	err := validateDestroyPolicy(*destroyPolicy, *destroyGracePeriod)
+	if err != nil {
+		return &NoopManager{}, err
+	}
+
+	root, err := intelrdt.GetIntelRdtPath("")
+	if err != nil {
+		return &NoopManager{}, err
+	}
+	rootResctrl = root
+	enabledMBM = intelrdt.IsMBMEnabled()
+	enabledCMT = intelrdt.IsCMTEnabled()
+	if *numaNodes != "" {
+		nodes, err := parseNUMANodes(*numaNodes)
+		if err != nil {
+			return &NoopManager{}, err
+		}
+		reportedDomains, err = numaNodeDomains(cpuSysfsPath, nodes)
+		if err != nil {
+			return &NoopManager{}, err
+		}
+	}
+
+	// Allocation expressed in MBps by software controller is not reported.
+	if intelrdt.IsMbaEnabled() && !intelrdt.IsMbaScEnabled() {
+		mountID, err := getMountID(rootResctrl)
+		if err != nil {
+			return &NoopManager{}, err
+		}
+		_, err = cachedInfo.getMBAInfo(mountID)
+		if err != nil {
+			return &NoopManager{}, err
+		}
+		enabledMBA = true
+	}
 
-	if intelrdt.IsMBMEnabled() || intelrdt.IsCMTEnabled() {
-		return &manager{id: id}, nil
+	// Groups left by cAdvisor that has not stopped cleanly would hold their
+	// RMIDs until containers with the same names are started again.
+	if !*reuseMonitoringGroups {
+		removeStaleGroups()
 	}
 
-	return &stats.NoopManager{}, nil
+	m := &manager{}
+	if *recycleMonitoringGroups {
+		m.recycler = newRecycler()
+	}
+	return m, nil
+}
+
+type NoopManager struct {
+	stats.NoopDestroy
+}
+
+func (m *NoopManager) GetCollector(containerName string, cgroupPath string, labels map[string]string) (Collector, error) {
+	return &NoopCollector{}, nil
+}
+
+type NoopCollector struct {
+	stats.NoopCollector
+}
+
+func (c *NoopCollector) RefreshTasks() error {
+	return nil
+}
+
+func (c *NoopCollector) ReadCounters(stats *info.ContainerStats) error {
+	return nil
+}
+
+func (c *NoopCollector) CreationTime() time.Time {
+	return time.Time{}
+}
+
+func (c *NoopCollector) Age() time.Duration {
+	return 0
 }
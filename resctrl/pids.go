@@ -0,0 +1,79 @@
+// +build linux
+
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Fallback discovery of tasks of a container.
+package resctrl
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const cgroupFileName = "cgroup"
+
+// Path of procfs, which is scanned when tasks of a container cannot be read
+// from its cgroup.
+var procPath = "/proc"
+
+// findPids returns tasks which belong to cgroup of the container in any
+// hierarchy according to /proc/<pid>/cgroup. Name of the container is path
+// of its cgroup relative to root of the hierarchy. All the tasks in the
+// system are read, so it is much more expensive than reading cgroup.procs.
+func findPids(containerName string) ([]int, error) {
+	proc, err := os.Open(procPath)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read %q: %w", procPath, err)
+	}
+	defer proc.Close()
+	names, err := proc.Readdirnames(-1)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read %q: %w", procPath, err)
+	}
+
+	pids := []int{}
+	for _, name := range names {
+		pid, err := strconv.Atoi(name)
+		if err != nil {
+			continue
+		}
+		cgroups, err := ioutil.ReadFile(filepath.Join(procPath, name, cgroupFileName))
+		if err != nil {
+			// Task might have exited in the meantime.
+			continue
+		}
+		if belongsToCgroup(string(cgroups), containerName) {
+			pids = append(pids, pid)
+		}
+	}
+	return pids, nil
+}
+
+// belongsToCgroup checks if content of /proc/<pid>/cgroup contains the cgroup
+// in any hierarchy.
+func belongsToCgroup(cgroups string, cgroupPath string) bool {
+	for _, line := range strings.Split(cgroups, "\n") {
+		// Each line has format hierarchy-ID:controller-list:cgroup-path.
+		fields := strings.SplitN(line, ":", 3)
+		if len(fields) == 3 && fields[2] == cgroupPath {
+			return true
+		}
+	}
+	return false
+}
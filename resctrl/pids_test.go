@@ -0,0 +1,56 @@
+// +build linux
+
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Fallback discovery of tasks of a container.
+package resctrl
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFindPids(t *testing.T) {
+	path, err := ioutil.TempDir("", "proc")
+	assert.NoError(t, err)
+	defer os.RemoveAll(path)
+	originalProcPath := procPath
+	procPath = path
+	defer func() { procPath = originalProcPath }()
+
+	for name, cgroups := range map[string]string{
+		// cgroup v1
+		"1": "12:cpu,cpuacct:/docker/container\n4:memory:/docker/container\n1:name=systemd:/docker/container\n",
+		// cgroup v2
+		"2": "0::/docker/container\n",
+		"3": "0::/docker/container/child\n",
+		"4": "0::/\n",
+		// Not a task.
+		"self": "0::/docker/container\n",
+	} {
+		assert.NoError(t, os.Mkdir(filepath.Join(path, name), os.ModePerm))
+		assert.NoError(t, ioutil.WriteFile(filepath.Join(path, name, cgroupFileName), []byte(cgroups), 0644))
+	}
+	// Task that exited before its cgroups were read.
+	assert.NoError(t, os.Mkdir(filepath.Join(path, "5"), os.ModePerm))
+
+	pids, err := findPids("/docker/container")
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []int{1, 2}, pids)
+}
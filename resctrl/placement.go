@@ -0,0 +1,140 @@
+// +build linux
+
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Placement of monitoring groups of containers.
+package resctrl
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/sys/unix"
+)
+
+// Placement describes where tasks of a container run. It is passed to
+// PlacementHook before monitoring group of the container is created.
+type Placement struct {
+	// Name of the container.
+	ContainerName string
+
+	// Path of control group that tasks of the container belong to.
+	// Monitoring group is created within it.
+	ControlGroupPath string
+
+	// CPUs that the first task of the container is allowed to run on.
+	CPUs []int
+
+	// NUMA nodes of the CPUs.
+	NUMANodes []int
+}
+
+// PlacementHook returns name of monitoring group that tasks of the
+// container are assigned to. Default name is used if empty name is
+// returned. Name has to be unique for each container.
+type PlacementHook func(placement Placement) (string, error)
+
+var (
+	registeredPlacementHook PlacementHook
+	placementHookMutex      sync.Mutex
+)
+
+// Handle for mocking purposes.
+var cpuSysfsPath = "/sys/devices/system/cpu"
+
+// RegisterPlacementHook registers hook that is invoked before monitoring
+// groups of containers are created by collectors created afterwards.
+func RegisterPlacementHook(hook PlacementHook) {
+	placementHookMutex.Lock()
+	defer placementHookMutex.Unlock()
+	registeredPlacementHook = hook
+}
+
+// placeMonitoringGroup returns name of monitoring group of the container
+// chosen by placement hook.
+func (c *collector) placeMonitoringGroup(controlGroupPath string, pids []int) (string, error) {
+	placement := Placement{
+		ContainerName:    c.id,
+		ControlGroupPath: controlGroupPath,
+	}
+	if len(pids) > 0 {
+		cpus, err := c.getCPUs(pids[0])
+		if err != nil {
+			return "", fmt.Errorf("unable to get CPUs of container %q: %w", c.id, err)
+		}
+		placement.CPUs = cpus
+		placement.NUMANodes, err = getNUMANodes(cpus)
+		if err != nil {
+			return "", fmt.Errorf("unable to get NUMA nodes of container %q: %w", c.id, err)
+		}
+	}
+
+	name, err := c.placementHook(placement)
+	if err != nil {
+		return "", fmt.Errorf("placement hook failed for container %q: %w", c.id, err)
+	}
+	if name == "" {
+		return monitoringGroupName(c.id), nil
+	}
+	if name == "." || name == ".." || strings.Contains(name, "/") {
+		return "", fmt.Errorf("placement hook returned invalid monitoring group name %q for container %q", name, c.id)
+	}
+	return name, nil
+}
+
+// getCPUAffinity returns CPUs that the task is allowed to run on.
+func getCPUAffinity(pid int) ([]int, error) {
+	set := unix.CPUSet{}
+	err := unix.SchedGetaffinity(pid, &set)
+	if err != nil {
+		return nil, err
+	}
+	cpus := make([]int, 0, set.Count())
+	for cpu := 0; len(cpus) < set.Count(); cpu++ {
+		if set.IsSet(cpu) {
+			cpus = append(cpus, cpu)
+		}
+	}
+	return cpus, nil
+}
+
+// getNUMANodes returns sorted NUMA nodes that the CPUs belong to.
+func getNUMANodes(cpus []int) ([]int, error) {
+	nodes := map[int]struct{}{}
+	for _, cpu := range cpus {
+		paths, err := filepath.Glob(filepath.Join(cpuSysfsPath, fmt.Sprintf("cpu%d", cpu), "node*"))
+		if err != nil {
+			return nil, err
+		}
+		for _, path := range paths {
+			node, err := strconv.Atoi(strings.TrimPrefix(filepath.Base(path), "node"))
+			if err != nil {
+				continue
+			}
+			nodes[node] = struct{}{}
+		}
+	}
+
+	sorted := make([]int, 0, len(nodes))
+	for node := range nodes {
+		sorted = append(sorted, node)
+	}
+	sort.Ints(sorted)
+	return sorted, nil
+}
@@ -0,0 +1,112 @@
+// +build linux
+
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Placement of monitoring groups of containers.
+package resctrl
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// mockCPUTopology creates fake sysfs CPU directory with CPUs assigned to
+// NUMA nodes and returns function that removes it.
+func mockCPUTopology(t *testing.T, cpuToNode map[int]int) func() {
+	root, err := ioutil.TempDir("", "cpu")
+	assert.NoError(t, err)
+	for cpu, node := range cpuToNode {
+		assert.NoError(t, os.MkdirAll(filepath.Join(root, fmt.Sprintf("cpu%d", cpu), fmt.Sprintf("node%d", node)), os.ModePerm))
+	}
+	cpuSysfsPath = root
+
+	return func() {
+		os.RemoveAll(root)
+		cpuSysfsPath = "/sys/devices/system/cpu"
+	}
+}
+
+func TestCollectorSetupPlacementHook(t *testing.T) {
+	defer mockResctrl(t)()
+	defer mockCPUTopology(t, map[int]int{0: 0, 1: 0, 2: 1, 3: 1})()
+	mount := &mountID{dev: 1, ino: 1}
+
+	var placements []Placement
+	collector := newMockCollector("/container", []int{1, 2}, mount)
+	collector.getCPUs = func(pid int) ([]int, error) {
+		assert.Equal(t, 1, pid)
+		return []int{2, 3}, nil
+	}
+	collector.placementHook = func(placement Placement) (string, error) {
+		placements = append(placements, placement)
+		return fmt.Sprintf("node%d-container", placement.NUMANodes[0]), nil
+	}
+	err := collector.setup()
+	assert.NoError(t, err)
+
+	assert.Equal(t, []Placement{{
+		ContainerName:    "/container",
+		ControlGroupPath: rootResctrl,
+		CPUs:             []int{2, 3},
+		NUMANodes:        []int{1},
+	}}, placements)
+	expectedPath := filepath.Join(rootResctrl, monGroupsDirName, "node1-container")
+	assert.Equal(t, expectedPath, collector.resctrlPath)
+	tasks, err := readTasks(expectedPath)
+	assert.NoError(t, err)
+	assert.Equal(t, map[int]struct{}{1: {}, 2: {}}, tasks)
+}
+
+func TestCollectorSetupPlacementHookDefault(t *testing.T) {
+	defer mockResctrl(t)()
+	defer mockCPUTopology(t, map[int]int{0: 0})()
+	mount := &mountID{dev: 1, ino: 1}
+
+	collector := newMockCollector("/container", []int{1}, mount)
+	collector.getCPUs = func(pid int) ([]int, error) {
+		return []int{0}, nil
+	}
+	collector.placementHook = func(placement Placement) (string, error) {
+		return "", nil
+	}
+	err := collector.setup()
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join(rootResctrl, monGroupsDirName, "cadvisor-container"), collector.resctrlPath)
+}
+
+func TestCollectorSetupPlacementHookInvalidName(t *testing.T) {
+	defer mockResctrl(t)()
+	mount := &mountID{dev: 1, ino: 1}
+
+	collector := newMockCollector("/container", nil, mount)
+	collector.placementHook = func(placement Placement) (string, error) {
+		return "../container", nil
+	}
+	err := collector.setup()
+	assert.Error(t, err)
+}
+
+func TestGetNUMANodes(t *testing.T) {
+	defer mockCPUTopology(t, map[int]int{0: 0, 1: 1, 2: 0, 3: 1})()
+
+	nodes, err := getNUMANodes([]int{3, 2, 0})
+	assert.NoError(t, err)
+	assert.Equal(t, []int{0, 1}, nodes)
+}
@@ -0,0 +1,230 @@
+// +build linux
+
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Recycling of RMIDs of monitoring groups.
+package resctrl
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"golang.org/x/sys/unix"
+	"k8s.io/klog/v2"
+
+	info "github.com/google/cadvisor/info/v1"
+)
+
+// recycler tracks activity of containers that are monitored by their own
+// monitoring groups, so that RMID of the least active of them can be taken
+// over by a new container when RMIDs are exhausted.
+type recycler struct {
+	mu     sync.Mutex
+	groups map[*collector]*groupActivity
+	// Sequence number of the next registered group.
+	nextSeq uint64
+	// Number of monitoring groups removed by destroyed containers. Container
+	// that lost its group waits for it to change before trying to create
+	// the group again, so it does not take back RMID it has just given away.
+	released uint64
+}
+
+// groupActivity is activity of a monitoring group measured most recently.
+type groupActivity struct {
+	// Memory bandwidth in bytes per second or, if memory bandwidth is not
+	// monitored, occupancy of last level cache in bytes.
+	activity float64
+	measured bool
+	// Groups with the same activity are recycled in order of registration.
+	seq uint64
+}
+
+func newRecycler() *recycler {
+	return &recycler{groups: map[*collector]*groupActivity{}}
+}
+
+// register starts tracking activity of monitoring group of the collector.
+func (r *recycler) register(c *collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.groups[c] = &groupActivity{seq: r.nextSeq}
+	r.nextSeq++
+}
+
+// unregister stops tracking activity of the collector. Released is true
+// when monitoring group of the collector has been removed, so its RMID is
+// going to be free.
+func (r *recycler) unregister(c *collector, released bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.groups[c]; !ok {
+		return
+	}
+	delete(r.groups, c)
+	if released {
+		r.released++
+	}
+}
+
+// release records that monitoring group that is not tracked anymore has
+// been removed, e.g. after grace period of destroyed container.
+func (r *recycler) release() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.released++
+}
+
+// releasedGroups returns number of monitoring groups released so far.
+func (r *recycler) releasedGroups() uint64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.released
+}
+
+// observe records activity of monitoring group of the collector.
+func (r *recycler) observe(c *collector, activity float64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	group, ok := r.groups[c]
+	if !ok {
+		return
+	}
+	group.activity = activity
+	group.measured = true
+}
+
+// leastActive picks and stops tracking the collector whose monitoring group
+// has the lowest activity. Groups that have not been measured yet are not
+// picked, so a container does not lose its group before it has a chance to
+// show its activity. Nil is returned if there is no such group.
+func (r *recycler) leastActive() *collector {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var victim *collector
+	var victimGroup *groupActivity
+	for c, group := range r.groups {
+		if !group.measured {
+			continue
+		}
+		if victimGroup == nil || group.activity < victimGroup.activity ||
+			(group.activity == victimGroup.activity && group.seq < victimGroup.seq) {
+			victim, victimGroup = c, group
+		}
+	}
+	if victim != nil {
+		delete(r.groups, victim)
+	}
+	return victim
+}
+
+// recycle removes monitoring group of the least active container, so that
+// its RMID can be used by the new container. It must be called before the
+// new container is registered, because it locks the other collector.
+func (r *recycler) recycle(newContainer string) error {
+	victim := r.leastActive()
+	if victim == nil {
+		return fmt.Errorf("no monitoring group can be recycled for container %q", newContainer)
+	}
+	return victim.evict(newContainer, r.releasedGroups())
+}
+
+// evict removes monitoring group of the container. The container is not
+// monitored until it creates its group again after another group has
+// been released.
+func (c *collector) evict(newContainer string, released uint64) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.resctrlPath == "" {
+		return fmt.Errorf("monitoring group of container %q has already been removed", c.id)
+	}
+	err := os.RemoveAll(c.resctrlPath)
+	if err != nil {
+		return fmt.Errorf("unable to recycle monitoring group %q of container %q: %w", c.resctrlPath, c.id, err)
+	}
+	klog.Warningf("RMIDs are exhausted, monitoring group %q of container %q has been recycled for container %q, container %q is not monitored until an RMID is released", c.resctrlPath, c.id, newContainer, c.id)
+	c.resctrlPath = ""
+	c.evicted = true
+	c.waitForRelease = true
+	c.evictedReleased = released
+	c.lastStats = info.ResctrlStats{}
+	c.lastStatsTime = time.Time{}
+	c.transientFailures = 0
+	return nil
+}
+
+// regainMonitoringGroup creates monitoring group of evicted container again,
+// after another group has been released if the container has lost its group
+// to recycling. It returns false if the container is still not monitored.
+func (c *collector) regainMonitoringGroup() (bool, error) {
+	if c.waitForRelease && c.recycler.releasedGroups() == c.evictedReleased {
+		return false, nil
+	}
+	err := c.prepareMonitoringGroup()
+	if isExhausted(err) {
+		// Released RMID has been taken by another container.
+		c.evictedReleased = c.recycler.releasedGroups()
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	klog.Infof("Monitoring group for container %q has been created again at %q", c.id, c.resctrlPath)
+	c.evicted = false
+	if c.groupKey == "" {
+		c.recycler.register(c)
+	}
+	return true, nil
+}
+
+// isExhausted checks if monitoring group could not be created because there
+// is no free RMID. Kernel returns EBUSY when RMIDs that are not used anymore
+// are still waiting for their cache occupancy to drop.
+func isExhausted(err error) bool {
+	return errors.Is(err, unix.ENOSPC) || errors.Is(err, unix.EBUSY)
+}
+
+// measureActivity returns memory bandwidth of monitoring group in bytes per
+// second since the previous measurement or, if memory bandwidth is not
+// monitored, occupancy of last level cache. False is returned if activity
+// cannot be determined.
+func measureActivity(current info.ResctrlStats, previous info.ResctrlStats, elapsed time.Duration) (float64, bool) {
+	if len(current.MemoryBandwidth) == 0 {
+		if len(current.Cache) == 0 {
+			return 0, false
+		}
+		occupancy := 0.0
+		for _, cache := range current.Cache {
+			occupancy += float64(cache.LLCOccupancy)
+		}
+		return occupancy, true
+	}
+
+	if len(current.MemoryBandwidth) != len(previous.MemoryBandwidth) || elapsed <= 0 {
+		return 0, false
+	}
+	bytes := 0.0
+	for i := range current.MemoryBandwidth {
+		if current.MemoryBandwidth[i].TotalBytes < previous.MemoryBandwidth[i].TotalBytes {
+			return 0, false
+		}
+		bytes += float64(current.MemoryBandwidth[i].TotalBytes - previous.MemoryBandwidth[i].TotalBytes)
+	}
+	return bytes / elapsed.Seconds(), true
+}
@@ -0,0 +1,159 @@
+// +build linux
+
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Recycling of RMIDs of monitoring groups.
+package resctrl
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/sys/unix"
+
+	info "github.com/google/cadvisor/info/v1"
+)
+
+func TestRecyclerLeastActive(t *testing.T) {
+	r := newRecycler()
+	busy := &collector{id: "/busy"}
+	idle := &collector{id: "/idle"}
+	alsoIdle := &collector{id: "/also-idle"}
+	unmeasured := &collector{id: "/unmeasured"}
+	for _, c := range []*collector{busy, idle, alsoIdle, unmeasured} {
+		r.register(c)
+	}
+	r.observe(busy, 1e9)
+	r.observe(alsoIdle, 1e3)
+	r.observe(idle, 1e3)
+
+	// The least active groups are recycled first, older one of equally
+	// active groups goes first.
+	assert.Equal(t, idle, r.leastActive())
+	assert.Equal(t, alsoIdle, r.leastActive())
+	assert.Equal(t, busy, r.leastActive())
+	// Group that has not been measured yet is never recycled.
+	assert.Nil(t, r.leastActive())
+
+	r.observe(unmeasured, 0)
+	assert.Equal(t, unmeasured, r.leastActive())
+	assert.Nil(t, r.leastActive())
+}
+
+func TestMeasureActivity(t *testing.T) {
+	previous := info.ResctrlStats{MemoryBandwidth: []info.MemoryBandwidthStats{{TotalBytes: 1000}, {TotalBytes: 2000}}}
+	current := info.ResctrlStats{MemoryBandwidth: []info.MemoryBandwidthStats{{TotalBytes: 3000}, {TotalBytes: 4000}}}
+	activity, ok := measureActivity(current, previous, 2*time.Second)
+	assert.True(t, ok)
+	assert.Equal(t, 2000.0, activity)
+
+	_, ok = measureActivity(current, info.ResctrlStats{}, 2*time.Second)
+	assert.False(t, ok)
+	_, ok = measureActivity(previous, current, 2*time.Second)
+	assert.False(t, ok)
+
+	// Cache occupancy is used when memory bandwidth is not monitored.
+	activity, ok = measureActivity(info.ResctrlStats{Cache: []info.CacheStats{{LLCOccupancy: 1024}, {LLCOccupancy: 2048}}}, info.ResctrlStats{}, 0)
+	assert.True(t, ok)
+	assert.Equal(t, 3072.0, activity)
+}
+
+func TestCollectorRecycling(t *testing.T) {
+	defer mockResctrl(t)()
+	mount := &mountID{dev: 1, ino: 1}
+	r := newRecycler()
+	currentTime := time.Date(2020, 10, 1, 12, 0, 0, 0, time.UTC)
+
+	// There are RMIDs for two monitoring groups only.
+	newLimitedCollector := func(id string, pids []int) *collector {
+		c := newMockCollector(id, pids, mount)
+		c.recycler = r
+		c.now = func() time.Time {
+			return currentTime
+		}
+		c.mkdir = func(path string, perm os.FileMode) error {
+			groups, err := ioutil.ReadDir(filepath.Dir(path))
+			if err != nil {
+				return err
+			}
+			if len(groups) >= 2 {
+				return &os.PathError{Op: "mkdir", Path: path, Err: unix.ENOSPC}
+			}
+			return os.Mkdir(path, perm)
+		}
+		return c
+	}
+	busy := newLimitedCollector("/busy", []int{1})
+	idle := newLimitedCollector("/idle", []int{2})
+	assert.NoError(t, busy.setup())
+	assert.NoError(t, idle.setup())
+
+	// Synthetic activity of the containers.
+	busyPath, idlePath := busy.resctrlPath, idle.resctrlPath
+	update := func(busyBytes, idleBytes uint64) {
+		mockMonData(t, busyPath, "mon_L3_00", busyBytes, busyBytes, 1024)
+		mockMonData(t, idlePath, "mon_L3_00", idleBytes, idleBytes, 1024)
+		for _, c := range []*collector{busy, idle} {
+			assert.NoError(t, c.UpdateStats(&info.ContainerStats{}))
+		}
+		currentTime = currentTime.Add(time.Second)
+	}
+	update(0, 0)
+	update(1e9, 1e3)
+
+	// RMID of the least active container is recycled for the new one.
+	fresh := newLimitedCollector("/fresh", []int{3})
+	assert.NoError(t, fresh.setup())
+	assert.Equal(t, filepath.Join(rootResctrl, monGroupsDirName, "cadvisor-fresh"), fresh.resctrlPath)
+	_, err := os.Stat(idlePath)
+	assert.True(t, os.IsNotExist(err))
+	assert.Equal(t, busyPath, busy.resctrlPath)
+
+	// Container that lost its group is not monitored.
+	stats := &info.ContainerStats{}
+	assert.NoError(t, idle.UpdateStats(stats))
+	assert.Equal(t, info.ResctrlStats{}, stats.Resctrl)
+	assert.True(t, idle.evicted)
+
+	// Groups that have not been measured yet are not recycled.
+	another := newLimitedCollector("/another", []int{4})
+	assert.NoError(t, another.setup())
+	assert.True(t, busy.evicted)
+	assert.Equal(t, filepath.Join(rootResctrl, monGroupsDirName, "cadvisor-fresh"), fresh.resctrlPath)
+	last := newLimitedCollector("/last", []int{5})
+	assert.Error(t, last.setup())
+
+	// Container is monitored again once an RMID is released, but it does
+	// not take back the RMID it has just lost.
+	another.Destroy()
+	assert.NoError(t, idle.RefreshTasks())
+	assert.False(t, idle.evicted)
+	assert.Equal(t, idlePath, idle.resctrlPath)
+	tasks, err := readTasks(idlePath)
+	assert.NoError(t, err)
+	assert.Equal(t, map[int]struct{}{2: {}}, tasks)
+	mockMonData(t, idlePath, "mon_L3_00", 0, 0, 1024)
+	assert.NoError(t, idle.ReadCounters(&info.ContainerStats{}))
+	assert.NoError(t, busy.UpdateStats(&info.ContainerStats{}))
+	assert.True(t, busy.evicted)
+
+	for _, c := range []*collector{busy, idle, fresh} {
+		c.Destroy()
+	}
+}
@@ -0,0 +1,475 @@
+// +build linux
+
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Utilities for handling resctrl filesystem.
+package resctrl
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sys/unix"
+
+	info "github.com/google/cadvisor/info/v1"
+)
+
+const (
+	rootContainer         = "/"
+	monitoringGroupPrefix = "cadvisor"
+	infoDirName           = "info"
+	monDataDirName        = "mon_data"
+	monGroupsDirName      = "mon_groups"
+	tasksFileName         = "tasks"
+	llcOccupancyFileName  = "llc_occupancy"
+	mbmLocalBytesFileName = "mbm_local_bytes"
+	mbmTotalBytesFileName = "mbm_total_bytes"
+	mbmEventPrefix        = "mbm_"
+	monHWIDFileName       = "mon_hw_id"
+	unavailable           = "Unavailable"
+	schemataFileName      = "schemata"
+	mbInfoDirName         = "MB"
+	delayLinearFileName   = "delay_linear"
+	bandwidthGranFileName = "bandwidth_gran"
+	mbSchemataPrefix      = "MB:"
+	maxMemoryBandwidth    = 100
+)
+
+// systemMonitoringGroupName is name of monitoring group of tasks that do
+// not belong to any container. It does not collide with names of groups
+// of containers which always start with monitoringGroupPrefix and "-".
+const systemMonitoringGroupName = monitoringGroupPrefix + "_system"
+
+// cacheLineSize is size of cache line in bytes assumed when estimating
+// cache misses from memory bandwidth. It is 64 on x86 platforms that
+// support resctrl monitoring.
+const cacheLineSize = 64
+
+// errUnavailable is returned when monitoring counter is temporarily
+// unavailable, e.g. when RMID is being recycled.
+var errUnavailable = errors.New("counter is unavailable")
+
+var (
+	// Path where resctrl filesystem is mounted.
+	rootResctrl = ""
+	// Indicates if MBM (Memory Bandwidth Monitoring) is enabled.
+	enabledMBM = false
+	// Indicates if CMT (Cache Monitoring Technology) is enabled.
+	enabledCMT = false
+	// Indicates if MBA (Memory Bandwidth Allocation) is enabled.
+	enabledMBA = false
+	// Parsed info directory of resctrl filesystem.
+	cachedInfo = &infoCache{}
+)
+
+// infoCache holds parsed content of info directory of resctrl filesystem.
+// Info directory changes only when the filesystem is remounted so it is
+// read again only when mount changes.
+type infoCache struct {
+	mu      sync.Mutex
+	valid   bool
+	mountID mountID
+	mba     mbaInfo
+}
+
+// getMBAInfo returns MBA representation used by the platform for the
+// resctrl filesystem mount.
+func (i *infoCache) getMBAInfo(mountID mountID) (mbaInfo, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if i.valid && i.mountID == mountID {
+		return i.mba, nil
+	}
+	mba, err := readMBAInfo(rootResctrl)
+	if err != nil {
+		return mbaInfo{}, err
+	}
+	i.mba = mba
+	i.mountID = mountID
+	i.valid = true
+	return mba, nil
+}
+
+// mbaInfo describes how the platform expresses memory bandwidth allocation.
+type mbaInfo struct {
+	// Indicates if delay values are linear. Non-linear delay values
+	// cannot be converted to percentage of memory bandwidth.
+	delayLinear bool
+	// Granularity in which memory bandwidth is allocated.
+	bandwidthGran uint64
+}
+
+// mbaDomain is a single entry of MB line in the schemata file.
+type mbaDomain struct {
+	id    uint64
+	value uint64
+}
+
+// mountID identifies particular mount of resctrl filesystem. It changes
+// when the filesystem is remounted.
+type mountID struct {
+	dev uint64
+	ino uint64
+}
+
+func getMountID(path string) (mountID, error) {
+	var stat unix.Stat_t
+	err := unix.Stat(path, &stat)
+	if err != nil {
+		return mountID{}, fmt.Errorf("unable to stat resctrl filesystem at %q: %w", path, err)
+	}
+	return mountID{dev: uint64(stat.Dev), ino: stat.Ino}, nil
+}
+
+// monitoringGroupName returns name of monitoring group that is created
+// by cAdvisor for the container.
+func monitoringGroupName(containerName string) string {
+	return monitoringGroupPrefix + strings.Replace(containerName, "/", "-", -1)
+}
+
+// findControlGroup returns path of control group (CTRL_MON) that the task
+// belongs to. Monitoring group has to be created within it, otherwise
+// assigning the task to monitoring group would fail.
+func findControlGroup(pid int) (string, error) {
+	files, err := ioutil.ReadDir(rootResctrl)
+	if err != nil {
+		return "", fmt.Errorf("unable to read resctrl filesystem at %q: %w", rootResctrl, err)
+	}
+	for _, file := range files {
+		if !file.IsDir() || file.Name() == infoDirName || file.Name() == monDataDirName || file.Name() == monGroupsDirName {
+			continue
+		}
+		path := filepath.Join(rootResctrl, file.Name())
+		tasks, err := readTasks(path)
+		if err != nil {
+			return "", err
+		}
+		if _, ok := tasks[pid]; ok {
+			return path, nil
+		}
+	}
+	// Task which is not assigned to any control group belongs to the default one.
+	return rootResctrl, nil
+}
+
+// readTasks returns set of tasks assigned to the resctrl group.
+func readTasks(path string) (map[int]struct{}, error) {
+	tasksFile, err := os.Open(filepath.Join(path, tasksFileName))
+	if err != nil {
+		return nil, fmt.Errorf("unable to read tasks of resctrl group %q: %w", path, err)
+	}
+	defer tasksFile.Close()
+
+	tasks := map[int]struct{}{}
+	scanner := bufio.NewScanner(tasksFile)
+	for scanner.Scan() {
+		pid, err := strconv.Atoi(strings.TrimSpace(scanner.Text()))
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse tasks of resctrl group %q: %w", path, err)
+		}
+		tasks[pid] = struct{}{}
+	}
+	return tasks, scanner.Err()
+}
+
+// writeTasks assigns tasks to the resctrl group and returns number of
+// tasks that have been assigned. Kernel accepts single task per write.
+func writeTasks(path string, pids []int) (int, error) {
+	tasksFile, err := os.OpenFile(filepath.Join(path, tasksFileName), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return 0, fmt.Errorf("unable to open tasks of resctrl group %q: %w", path, err)
+	}
+	defer tasksFile.Close()
+
+	assigned := 0
+	for _, pid := range pids {
+		_, err = tasksFile.WriteString(fmt.Sprintf("%d\n", pid))
+		if err != nil {
+			// Task might have exited in the meantime.
+			if errors.Is(err, unix.ESRCH) {
+				continue
+			}
+			return assigned, fmt.Errorf("unable to assign task %d to resctrl group %q: %w", pid, path, err)
+		}
+		assigned++
+	}
+	return assigned, nil
+}
+
+// getStats reads monitoring statistics from mon_data directory of the
+// resctrl group.
+func getStats(path string) (info.ResctrlStats, error) {
+	stats := info.ResctrlStats{}
+	monDataPath := filepath.Join(path, monDataDirName)
+	domains, err := ioutil.ReadDir(monDataPath)
+	if err != nil {
+		return stats, fmt.Errorf("unable to read monitoring data of resctrl group %q: %w", path, err)
+	}
+
+	if enabledMBM {
+		stats.MemoryBandwidth = make([]info.MemoryBandwidthStats, 0, len(domains))
+	}
+	if enabledCMT {
+		stats.Cache = make([]info.CacheStats, 0, len(domains))
+	}
+
+	for _, domain := range domains {
+		if !domain.IsDir() || !isReportedDomain(domain.Name()) {
+			continue
+		}
+		domainPath := filepath.Join(monDataPath, domain.Name())
+
+		if enabledMBM {
+			totalBytes, err := readStat(domainPath, mbmTotalBytesFileName)
+			if err != nil {
+				return stats, err
+			}
+			localBytes, err := readStat(domainPath, mbmLocalBytesFileName)
+			if err != nil {
+				return stats, err
+			}
+			events, err := readOtherMBMEvents(domainPath)
+			if err != nil {
+				return stats, err
+			}
+			stats.MemoryBandwidth = append(stats.MemoryBandwidth,
+				info.MemoryBandwidthStats{
+					TotalBytes: totalBytes,
+					LocalBytes: localBytes,
+					CPUs:       l3DomainCPUs.get(domain.Name()),
+					Events:     events,
+				})
+		}
+
+		if enabledCMT {
+			llcOccupancy, err := readStat(domainPath, llcOccupancyFileName)
+			if err != nil {
+				return stats, err
+			}
+			cacheID, _ := l3CacheID(domain.Name())
+			stats.Cache = append(stats.Cache, info.CacheStats{LLCOccupancy: llcOccupancy, CacheID: cacheID})
+		}
+	}
+
+	return stats, nil
+}
+
+// readOtherMBMEvents reads counters of MBM events of the domain, other than
+// mbm_total_bytes and mbm_local_bytes, which are exposed by the platform as
+// mbm_* files. Nil is returned if there are none.
+func readOtherMBMEvents(domainPath string) (map[string]uint64, error) {
+	files, err := ioutil.ReadDir(domainPath)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read monitoring data from %q: %w", domainPath, err)
+	}
+	var events map[string]uint64
+	for _, file := range files {
+		name := file.Name()
+		if file.IsDir() || !strings.HasPrefix(name, mbmEventPrefix) || name == mbmTotalBytesFileName || name == mbmLocalBytesFileName {
+			continue
+		}
+		value, err := readStat(domainPath, name)
+		if err != nil {
+			return nil, err
+		}
+		if events == nil {
+			events = map[string]uint64{}
+		}
+		events[name] = value
+	}
+	return events, nil
+}
+
+// readRMID reads RMID of the monitoring group. It returns nil if resctrl
+// filesystem does not expose RMIDs.
+func readRMID(path string) (*uint64, error) {
+	content, err := ioutil.ReadFile(filepath.Join(path, monHWIDFileName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("unable to read RMID of resctrl group %q: %w", path, err)
+	}
+	rmid, err := strconv.ParseUint(strings.TrimSpace(string(content)), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse RMID of resctrl group %q: %w", path, err)
+	}
+	return &rmid, nil
+}
+
+// readStat reads single monitoring counter. Counter that is not supported
+// by the platform is reported as zero.
+func readStat(path string, name string) (uint64, error) {
+	content, err := ioutil.ReadFile(filepath.Join(path, name))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("unable to read %q from %q: %w", name, path, err)
+	}
+
+	value := strings.TrimSpace(string(content))
+	if value == unavailable {
+		return 0, fmt.Errorf("unable to read %q from %q: %w", name, path, errUnavailable)
+	}
+	stat, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("unable to parse %q from %q: %w", name, path, err)
+	}
+	return stat, nil
+}
+
+// addMemoryBandwidthRate sets increase of memory bandwidth counters per
+// second since the previous measurement of each domain. Rate is not set
+// if there is no previous measurement or counters went backwards, e.g.
+// after the monitoring group has been recreated.
+func addMemoryBandwidthRate(current []info.MemoryBandwidthStats, previous []info.MemoryBandwidthStats, elapsed time.Duration) {
+	if len(current) != len(previous) || elapsed <= 0 {
+		return
+	}
+	for i := range current {
+		if current[i].TotalBytes >= previous[i].TotalBytes {
+			current[i].TotalBytesPerSecond = uint64(float64(current[i].TotalBytes-previous[i].TotalBytes) / elapsed.Seconds())
+		}
+		if current[i].LocalBytes >= previous[i].LocalBytes {
+			current[i].LocalBytesPerSecond = uint64(float64(current[i].LocalBytes-previous[i].LocalBytes) / elapsed.Seconds())
+		}
+	}
+}
+
+// addCacheLineFetchRate sets estimate of cache lines fetched from local
+// memory per second since the previous measurement of each domain. Every
+// miss of the last level cache that is served by local memory fetches one
+// cache line, so the estimate approximates the miss rate. It overestimates
+// it when hardware prefetchers fetch lines that are not used and it ignores
+// misses served by remote memory. Estimate is not set if there is no
+// previous measurement or counter went backwards.
+func addCacheLineFetchRate(current []info.MemoryBandwidthStats, previous []info.MemoryBandwidthStats, elapsed time.Duration) {
+	if len(current) != len(previous) || elapsed <= 0 {
+		return
+	}
+	for i := range current {
+		if current[i].LocalBytes >= previous[i].LocalBytes {
+			lines := float64(current[i].LocalBytes-previous[i].LocalBytes) / cacheLineSize
+			current[i].EstimatedCacheLineFetchesPerSecond = uint64(lines / elapsed.Seconds())
+		}
+	}
+}
+
+// isTransient checks if reading monitoring counters may succeed when it
+// is retried.
+func isTransient(err error) bool {
+	return errors.Is(err, errUnavailable) || errors.Is(err, unix.EBUSY) || errors.Is(err, unix.EAGAIN)
+}
+
+// readMBAInfo reads MBA representation from info directory of resctrl
+// filesystem mounted at path.
+func readMBAInfo(path string) (mbaInfo, error) {
+	mbInfoPath := filepath.Join(path, infoDirName, mbInfoDirName)
+	delayLinear, err := readInfoValue(mbInfoPath, delayLinearFileName)
+	if err != nil {
+		return mbaInfo{}, err
+	}
+	bandwidthGran, err := readInfoValue(mbInfoPath, bandwidthGranFileName)
+	if err != nil {
+		return mbaInfo{}, err
+	}
+	return mbaInfo{delayLinear: delayLinear == 1, bandwidthGran: bandwidthGran}, nil
+}
+
+func readInfoValue(path string, name string) (uint64, error) {
+	content, err := ioutil.ReadFile(filepath.Join(path, name))
+	if err != nil {
+		return 0, fmt.Errorf("unable to read %q from %q: %w", name, path, err)
+	}
+	value, err := strconv.ParseUint(strings.TrimSpace(string(content)), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("unable to parse %q from %q: %w", name, path, err)
+	}
+	return value, nil
+}
+
+// parseMBSchemata parses MB line of the schemata file, e.g. "MB:0= 70;1=100".
+func parseMBSchemata(schemata string) ([]mbaDomain, error) {
+	for _, line := range strings.Split(schemata, "\n") {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, mbSchemataPrefix) {
+			continue
+		}
+
+		entries := strings.Split(strings.TrimPrefix(line, mbSchemataPrefix), ";")
+		domains := make([]mbaDomain, 0, len(entries))
+		for _, entry := range entries {
+			fields := strings.Split(entry, "=")
+			if len(fields) != 2 {
+				return nil, fmt.Errorf("unexpected MB schemata entry %q", entry)
+			}
+			id, err := strconv.ParseUint(strings.TrimSpace(fields[0]), 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("unable to parse domain of MB schemata entry %q: %w", entry, err)
+			}
+			value, err := strconv.ParseUint(strings.TrimSpace(fields[1]), 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("unable to parse value of MB schemata entry %q: %w", entry, err)
+			}
+			domains = append(domains, mbaDomain{id: id, value: value})
+		}
+		return domains, nil
+	}
+	return nil, fmt.Errorf("schemata does not contain %q line", mbSchemataPrefix)
+}
+
+// getMBAStats reads memory bandwidth allocation of the control group and
+// reports it in representation used by the platform.
+func getMBAStats(path string, mba mbaInfo) ([]info.MemoryBandwidthAllocationStats, error) {
+	schemata, err := ioutil.ReadFile(filepath.Join(path, schemataFileName))
+	if err != nil {
+		return nil, fmt.Errorf("unable to read schemata of resctrl group %q: %w", path, err)
+	}
+	domains, err := parseMBSchemata(string(schemata))
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse schemata of resctrl group %q: %w", path, err)
+	}
+
+	stats := make([]info.MemoryBandwidthAllocationStats, 0, len(domains))
+	for _, domain := range domains {
+		stat := info.MemoryBandwidthAllocationStats{
+			Domain:      domain.id,
+			DelayLinear: mba.delayLinear,
+		}
+		bandwidth := domain.value
+		if mba.bandwidthGran > 0 {
+			// Hardware allocates bandwidth in steps of granularity.
+			bandwidth -= bandwidth % mba.bandwidthGran
+		}
+		if bandwidth < maxMemoryBandwidth {
+			stat.Delay = maxMemoryBandwidth - bandwidth
+		}
+		if mba.delayLinear {
+			stat.BandwidthPercentage = bandwidth
+		}
+		stats = append(stats, stat)
+	}
+	return stats, nil
+}
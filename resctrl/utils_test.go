@@ -0,0 +1,201 @@
+// +build linux
+
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Utilities for handling resctrl filesystem.
+package resctrl
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	info "github.com/google/cadvisor/info/v1"
+)
+
+func mockMBAInfo(t *testing.T, root string, delayLinear string, bandwidthGran string) {
+	mbInfoPath := filepath.Join(root, infoDirName, mbInfoDirName)
+	assert.NoError(t, os.MkdirAll(mbInfoPath, os.ModePerm))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(mbInfoPath, delayLinearFileName), []byte(delayLinear), 0644))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(mbInfoPath, bandwidthGranFileName), []byte(bandwidthGran), 0644))
+}
+
+func TestParseMBSchemata(t *testing.T) {
+	domains, err := parseMBSchemata("    L3:0=fffff;1=fffff\n    MB:0= 70;1=100\n")
+	assert.NoError(t, err)
+	assert.Equal(t, []mbaDomain{{id: 0, value: 70}, {id: 1, value: 100}}, domains)
+
+	_, err = parseMBSchemata("L3:0=fffff;1=fffff\n")
+	assert.Error(t, err)
+
+	_, err = parseMBSchemata("MB:0=70;1\n")
+	assert.Error(t, err)
+
+	_, err = parseMBSchemata("MB:0=seventy\n")
+	assert.Error(t, err)
+}
+
+func TestReadMBAInfo(t *testing.T) {
+	defer mockResctrl(t)()
+
+	_, err := readMBAInfo(rootResctrl)
+	assert.Error(t, err)
+
+	mockMBAInfo(t, rootResctrl, "1\n", "10\n")
+	mba, err := readMBAInfo(rootResctrl)
+	assert.NoError(t, err)
+	assert.Equal(t, mbaInfo{delayLinear: true, bandwidthGran: 10}, mba)
+
+	mockMBAInfo(t, rootResctrl, "0\n", "10\n")
+	mba, err = readMBAInfo(rootResctrl)
+	assert.NoError(t, err)
+	assert.Equal(t, mbaInfo{delayLinear: false, bandwidthGran: 10}, mba)
+}
+
+func TestGetMBAStatsLinear(t *testing.T) {
+	defer mockResctrl(t)()
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(rootResctrl, schemataFileName), []byte("MB:0= 70;1=100\n"), 0644))
+
+	stats, err := getMBAStats(rootResctrl, mbaInfo{delayLinear: true, bandwidthGran: 10})
+	assert.NoError(t, err)
+	assert.Equal(t, []info.MemoryBandwidthAllocationStats{
+		{Domain: 0, BandwidthPercentage: 70, Delay: 30, DelayLinear: true},
+		{Domain: 1, BandwidthPercentage: 100, Delay: 0, DelayLinear: true},
+	}, stats)
+}
+
+func TestGetMBAStatsDelay(t *testing.T) {
+	defer mockResctrl(t)()
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(rootResctrl, schemataFileName), []byte("MB:0= 75;1=100\n"), 0644))
+
+	stats, err := getMBAStats(rootResctrl, mbaInfo{delayLinear: false, bandwidthGran: 10})
+	assert.NoError(t, err)
+	// Non-linear delay values cannot be expressed as a percentage of bandwidth.
+	assert.Equal(t, []info.MemoryBandwidthAllocationStats{
+		{Domain: 0, Delay: 30, DelayLinear: false},
+		{Domain: 1, Delay: 0, DelayLinear: false},
+	}, stats)
+}
+
+func TestGetMBAStatsMissingSchemata(t *testing.T) {
+	defer mockResctrl(t)()
+
+	_, err := getMBAStats(rootResctrl, mbaInfo{delayLinear: true, bandwidthGran: 10})
+	assert.Error(t, err)
+}
+
+func TestReadStatUnavailable(t *testing.T) {
+	path, err := ioutil.TempDir("", "mon_data")
+	assert.NoError(t, err)
+	defer os.RemoveAll(path)
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(path, mbmTotalBytesFileName), []byte("Unavailable\n"), 0644))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(path, mbmLocalBytesFileName), []byte("Error\n"), 0644))
+
+	_, err = readStat(path, mbmTotalBytesFileName)
+	assert.Error(t, err)
+	assert.True(t, isTransient(err))
+
+	_, err = readStat(path, mbmLocalBytesFileName)
+	assert.Error(t, err)
+	assert.False(t, isTransient(err))
+}
+
+func TestAddCacheLineFetchRate(t *testing.T) {
+	previous := []info.MemoryBandwidthStats{
+		{TotalBytes: 1000, LocalBytes: 6400},
+		{TotalBytes: 1000, LocalBytes: 64000},
+	}
+	current := []info.MemoryBandwidthStats{
+		// 128000 bytes in 2 seconds is 1000 cache lines per second.
+		{TotalBytes: 2000, LocalBytes: 6400 + 128000},
+		// Counter went backwards.
+		{TotalBytes: 2000, LocalBytes: 640},
+	}
+
+	addCacheLineFetchRate(current, previous, 2*time.Second)
+	assert.Equal(t, uint64(1000), current[0].EstimatedCacheLineFetchesPerSecond)
+	assert.Equal(t, uint64(0), current[1].EstimatedCacheLineFetchesPerSecond)
+
+	// Estimate is not known without previous measurement.
+	current = []info.MemoryBandwidthStats{{LocalBytes: 6400}}
+	addCacheLineFetchRate(current, nil, 2*time.Second)
+	assert.Equal(t, uint64(0), current[0].EstimatedCacheLineFetchesPerSecond)
+}
+
+func TestGetStatsCacheIDs(t *testing.T) {
+	path, err := ioutil.TempDir("", "resctrl")
+	assert.NoError(t, err)
+	defer os.RemoveAll(path)
+	// Two sockets with L3 caches that are not numbered consecutively.
+	for name, occupancy := range map[string]string{"mon_L3_00": "1024\n", "mon_L3_02": "2048\n"} {
+		domainPath := filepath.Join(path, monDataDirName, name)
+		assert.NoError(t, os.MkdirAll(domainPath, os.ModePerm))
+		assert.NoError(t, ioutil.WriteFile(filepath.Join(domainPath, llcOccupancyFileName), []byte(occupancy), 0644))
+	}
+
+	originalCMT, originalMBM := enabledCMT, enabledMBM
+	defer func() {
+		enabledCMT, enabledMBM = originalCMT, originalMBM
+	}()
+	enabledCMT, enabledMBM = true, false
+
+	stats, err := getStats(path)
+	assert.NoError(t, err)
+	assert.Equal(t, []info.CacheStats{
+		{LLCOccupancy: 1024, CacheID: 0},
+		{LLCOccupancy: 2048, CacheID: 2},
+	}, stats.Cache)
+}
+
+func TestGetStatsOtherMBMEvents(t *testing.T) {
+	path, err := ioutil.TempDir("", "resctrl")
+	assert.NoError(t, err)
+	defer os.RemoveAll(path)
+	// The second domain exposes an MBM event type without dedicated field.
+	for name, files := range map[string]map[string]string{
+		"mon_L3_00": {mbmTotalBytesFileName: "100\n", mbmLocalBytesFileName: "50\n"},
+		"mon_L3_01": {mbmTotalBytesFileName: "200\n", mbmLocalBytesFileName: "150\n", "mbm_remote_bytes": "50\n", llcOccupancyFileName: "1024\n"},
+	} {
+		domainPath := filepath.Join(path, monDataDirName, name)
+		assert.NoError(t, os.MkdirAll(domainPath, os.ModePerm))
+		for file, content := range files {
+			assert.NoError(t, ioutil.WriteFile(filepath.Join(domainPath, file), []byte(content), 0644))
+		}
+	}
+
+	originalCMT, originalMBM := enabledCMT, enabledMBM
+	defer func() {
+		enabledCMT, enabledMBM = originalCMT, originalMBM
+	}()
+	enabledCMT, enabledMBM = false, true
+
+	stats, err := getStats(path)
+	assert.NoError(t, err)
+	assert.Len(t, stats.MemoryBandwidth, 2)
+	assert.Equal(t, uint64(100), stats.MemoryBandwidth[0].TotalBytes)
+	assert.Nil(t, stats.MemoryBandwidth[0].Events)
+	assert.Equal(t, uint64(200), stats.MemoryBandwidth[1].TotalBytes)
+	assert.Equal(t, uint64(150), stats.MemoryBandwidth[1].LocalBytes)
+	assert.Equal(t, map[string]uint64{"mbm_remote_bytes": 50}, stats.MemoryBandwidth[1].Events)
+
+	// Unavailable counter of the extra event is a transient failure.
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(path, monDataDirName, "mon_L3_01", "mbm_remote_bytes"), []byte("Unavailable\n"), 0644))
+	_, err = getStats(path)
+	assert.True(t, isTransient(err))
+}
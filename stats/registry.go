@@ -0,0 +1,119 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Registry of Collectors of a container.
+package stats
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	v1 "github.com/google/cadvisor/info/v1"
+)
+
+// Registry holds Collectors of a single container, e.g. perf and resctrl
+// collectors, and manages them as one Collector.
+type Registry struct {
+	lock       sync.Mutex
+	collectors []namedCollector
+}
+
+type namedCollector struct {
+	name      string
+	collector Collector
+}
+
+var _ Collector = &Registry{}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// Register adds the collector to the registry. Name is used to attribute
+// errors to the collector.
+func (r *Registry) Register(name string, collector Collector) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.collectors = append(r.collectors, namedCollector{name: name, collector: collector})
+}
+
+// UpdateStats updates stats with every registered collector in order of
+// registration. Failure of a collector does not stop the others. Errors
+// are returned together as Errors, each prefixed with name of its collector.
+func (r *Registry) UpdateStats(stats *v1.ContainerStats) error {
+	var errs Errors
+	for _, c := range r.registered() {
+		err := c.collector.UpdateStats(stats)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", c.name, err))
+		}
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+// Destroy destroys every registered collector.
+func (r *Registry) Destroy() {
+	for _, c := range r.registered() {
+		c.collector.Destroy()
+	}
+}
+
+func (r *Registry) registered() []namedCollector {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return append([]namedCollector{}, r.collectors...)
+}
+
+// Errors are errors of multiple collectors. They are formatted the same way
+// as errors joined by errors.Join of Go 1.20. errors.Is and errors.As find
+// error of any collector there, also with Go versions that do not unwrap
+// multiple errors.
+type Errors []error
+
+func (e Errors) Error() string {
+	messages := make([]string, 0, len(e))
+	for _, err := range e {
+		messages = append(messages, err.Error())
+	}
+	return strings.Join(messages, "\n")
+}
+
+func (e Errors) Unwrap() []error {
+	return e
+}
+
+// Is reports whether error of any collector matches the target.
+func (e Errors) Is(target error) bool {
+	for _, err := range e {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// As finds the first error of collectors that matches the target.
+func (e Errors) As(target interface{}) bool {
+	for _, err := range e {
+		if errors.As(err, target) {
+			return true
+		}
+	}
+	return false
+}
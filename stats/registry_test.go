@@ -0,0 +1,98 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stats
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	v1 "github.com/google/cadvisor/info/v1"
+)
+
+type stubCollector struct {
+	update    func(*v1.ContainerStats)
+	err       error
+	destroyed bool
+}
+
+func (s *stubCollector) UpdateStats(stats *v1.ContainerStats) error {
+	if s.update != nil {
+		s.update(stats)
+	}
+	return s.err
+}
+
+func (s *stubCollector) Destroy() {
+	s.destroyed = true
+}
+
+func TestRegistry(t *testing.T) {
+	errPerf := errors.New("unable to read perf events")
+	errResctrl := errors.New("monitoring group has been removed")
+	perf := &stubCollector{
+		update: func(stats *v1.ContainerStats) {
+			stats.PerfStats = []v1.PerfStat{{PerfValue: v1.PerfValue{Name: "instructions", Value: 42}}}
+		},
+	}
+	resctrl := &stubCollector{
+		update: func(stats *v1.ContainerStats) {
+			stats.Resctrl.MemoryBandwidth = []v1.MemoryBandwidthStats{{TotalBytes: 1024}}
+		},
+	}
+	registry := NewRegistry()
+	registry.Register("perf", perf)
+	registry.Register("resctrl", resctrl)
+
+	// Stats of all the collectors are combined.
+	stats := &v1.ContainerStats{}
+	err := registry.UpdateStats(stats)
+	assert.NoError(t, err)
+	assert.Len(t, stats.PerfStats, 1)
+	assert.Len(t, stats.Resctrl.MemoryBandwidth, 1)
+
+	// Failing collector does not stop the other one.
+	perf.err = errPerf
+	stats = &v1.ContainerStats{}
+	err = registry.UpdateStats(stats)
+	assert.EqualError(t, err, "perf: unable to read perf events")
+	assert.Len(t, stats.Resctrl.MemoryBandwidth, 1)
+
+	// Errors are attributed to their collectors.
+	resctrl.err = errResctrl
+	err = registry.UpdateStats(&v1.ContainerStats{})
+	assert.EqualError(t, err, "perf: unable to read perf events\nresctrl: monitoring group has been removed")
+	var errs Errors
+	assert.True(t, errors.As(err, &errs))
+	assert.Len(t, errs, 2)
+	assert.True(t, errors.Is(errs[0], errPerf))
+	assert.True(t, errors.Is(errs[1], errResctrl))
+	assert.True(t, errors.Is(err, errPerf))
+	assert.True(t, errors.Is(err, errResctrl))
+	assert.False(t, errors.Is(err, errors.New("unable to read perf events")))
+	var pathErr *os.PathError
+	assert.False(t, errors.As(err, &pathErr))
+	resctrl.err = &os.PathError{Op: "open", Path: "mon_data", Err: os.ErrNotExist}
+	err = registry.UpdateStats(&v1.ContainerStats{})
+	assert.True(t, errors.As(err, &pathErr))
+	assert.Equal(t, "mon_data", pathErr.Path)
+	assert.True(t, errors.Is(err, os.ErrNotExist))
+
+	registry.Destroy()
+	assert.True(t, perf.destroyed)
+	assert.True(t, resctrl.destroyed)
+}